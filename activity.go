@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- 処理中の要求と最近のイベントの記録 ---
+// Botとの接続が切れている間も SWSC が何をしているかを確認できるよう、
+// 処理中の要求 (startServer / stopServer など) と最近のイベントをメモリ上に記録します。
+// 記録した内容はローカル管理API (admin_api.go) から参照されます。
+
+// recentEventsCapacity は、保持する最近のイベントの最大件数です (古いものから破棄されます)。
+const recentEventsCapacity = 200
+
+// 要求の受信元 (inflightRequest.Source)
+const (
+	requestSourceWebSocket = "websocket" // Bot から WebSocket 経由で受信した要求
+	requestSourceLocal     = "local"     // CLI やローカル管理APIからの要求 (local_requests.go)
+)
+
+// inflightRequest は、処理中の要求1件の情報です。
+type inflightRequest struct {
+	RequestID  string    `json:"requestId"`
+	Type       string    `json:"type"`                 // 要求の種類 (例: "startServer")
+	Source     string    `json:"source"`               // 要求の受信元 (websocket / local)
+	ServerName string    `json:"serverName,omitempty"` // 対象のサーバー構成名 (ペイロードに含まれる場合)
+	StartedAt  time.Time `json:"startedAt"`
+	Status     string    `json:"status,omitempty"`  // 最後に通知した進捗状況 (statusUpdate の status)
+	Message    string    `json:"message,omitempty"` // 最後に通知した進捗メッセージ
+}
+
+// activityEvent は、最近のイベント1件の情報です。
+type activityEvent struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"` // イベントの種類 (例: "serverCrashDetected", "requestCompleted", "websocketConnected")
+	ServerName string    `json:"serverName,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+	Message    string    `json:"message,omitempty"`
+}
+
+var (
+	// inflightRequests は、処理中の要求のマップです (キー: RequestID)。
+	inflightRequests = make(map[string]*inflightRequest)
+	// recentEvents は、最近のイベントのリングバッファです (recentEventsNext が次の書き込み位置)。
+	recentEvents     = make([]activityEvent, 0, recentEventsCapacity)
+	recentEventsNext int
+	// activityMutex は、inflightRequests と recentEvents を保護するためのミューテックスです。
+	activityMutex sync.Mutex
+)
+
+// beginRequest は、要求の処理開始を記録します。要求ハンドラを呼び出す直前に呼び出されます。
+// 最終応答 (response / error) が sendMessage を通過した時点で、自動的に処理中の要求から取り除かれます。
+// Args:
+//
+//	requestID (string): 要求ID。空の場合は記録しません。
+//	requestType (string): 要求の種類 (例: "startServer")。
+//	source (string): 要求の受信元 (requestSourceWebSocket / requestSourceLocal)。
+//	payload (json.RawMessage): 要求ペイロード。"name" フィールドがあれば対象サーバー名として記録します。
+func beginRequest(requestID string, requestType string, source string, payload json.RawMessage) {
+	if requestID == "" {
+		return
+	}
+	var target struct {
+		Name string `json:"name"`
+	}
+	_ = json.Unmarshal(payload, &target) // サーバー名を含まない要求もあるため、失敗は無視
+
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+	inflightRequests[requestID] = &inflightRequest{
+		RequestID:  requestID,
+		Type:       requestType,
+		Source:     source,
+		ServerName: target.Name,
+		StartedAt:  time.Now(),
+	}
+}
+
+// observeOutgoingMessage は、送信されるメッセージから要求の進捗と完了を記録します。
+// sendMessage の先頭で、WebSocket宛て・ローカル要求宛てを問わず全てのメッセージに対して呼び出されます。
+func observeOutgoingMessage(msg WsMessage) {
+	if msg.RequestID == "" {
+		return
+	}
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+	request, ok := inflightRequests[msg.RequestID]
+	if !ok {
+		return
+	}
+
+	switch msg.Type {
+	case "statusUpdate":
+		var update StatusUpdatePayload
+		// 重複した要求への処理中通知 (idempotency.go) は元の要求の進捗ではないため記録しない
+		if err := json.Unmarshal(msg.Payload, &update); err == nil && update.Status != duplicateInProgressStatus {
+			request.Status = update.Status
+			request.Message = update.Message
+		}
+	case "response", "error":
+		delete(inflightRequests, msg.RequestID)
+		var result struct {
+			Success bool   `json:"success"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(msg.Payload, &result)
+		outcome := "失敗"
+		if msg.Type == "response" && result.Success {
+			outcome = "成功"
+		}
+		appendEventLocked(activityEvent{
+			Time:       time.Now(),
+			Type:       "requestCompleted",
+			ServerName: request.ServerName,
+			RequestID:  request.RequestID,
+			Message:    fmt.Sprintf("%s (%s, %v): %s", request.Type, outcome, time.Since(request.StartedAt).Round(time.Millisecond), result.Message),
+		})
+	}
+}
+
+// recordEvent は、最近のイベントを1件記録します。
+func recordEvent(eventType string, serverName string, message string) {
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+	appendEventLocked(activityEvent{Time: time.Now(), Type: eventType, ServerName: serverName, Message: message})
+}
+
+// recordServerEvent は、Botに送信するサーバーイベント (serverEvent) を最近のイベントとして記録します。
+func recordServerEvent(eventType string, payload json.RawMessage) {
+	var event struct {
+		ServerName string `json:"serverName"`
+		Message    string `json:"message"`
+		Error      string `json:"error"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		processLog.warnf("イベントの記録に失敗しました (Type=%s): %v", eventType, err)
+		return
+	}
+	message := event.Message
+	if message == "" {
+		message = event.Error
+	}
+	recordEvent(eventType, event.ServerName, message)
+}
+
+// appendEventLocked は、リングバッファにイベントを追加します。activityMutex をロックした状態で呼び出してください。
+func appendEventLocked(event activityEvent) {
+	if len(recentEvents) < recentEventsCapacity {
+		recentEvents = append(recentEvents, event)
+		return
+	}
+	recentEvents[recentEventsNext] = event
+	recentEventsNext = (recentEventsNext + 1) % recentEventsCapacity
+}
+
+// getInflightRequests は、処理中の要求のリストを開始時刻の古い順に返します。
+func getInflightRequests() []inflightRequest {
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+	requests := make([]inflightRequest, 0, len(inflightRequests))
+	for _, request := range inflightRequests {
+		requests = append(requests, *request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].StartedAt.Before(requests[j].StartedAt) })
+	return requests
+}
+
+// getRecentEvents は、最近のイベントを新しい順に最大 limit 件返します (limit が0以下なら全件)。
+func getRecentEvents(limit int) []activityEvent {
+	activityMutex.Lock()
+	defer activityMutex.Unlock()
+	count := len(recentEvents)
+	if limit <= 0 || limit > count {
+		limit = count
+	}
+	events := make([]activityEvent, 0, limit)
+	for i := 0; i < limit; i++ {
+		// 最後に書き込んだ位置から遡って取り出す
+		index := (recentEventsNext - 1 - i + 2*count) % count
+		if count < recentEventsCapacity {
+			index = count - 1 - i
+		}
+		events = append(events, recentEvents[index])
+	}
+	return events
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- ローカル管理API (HTTP) ---
+// Botとの接続が切れている間も SWSC の状態を確認・操作できるよう、WebSocket クライアントと並行して
+// ローカルの HTTP サーバーを起動します (ADMIN_API_ENABLED=true の場合のみ)。
+// デフォルトではローカルホストのみで待ち受け、WebSocket 用とは別の認証トークン (ADMIN_API_TOKEN) で保護します。
+// サーバーの起動・停止は WebSocket 経由と同じ要求ハンドラをローカル要求として実行します (local_requests.go)。
+//
+// エンドポイント (全て Authorization: Bearer <ADMIN_API_TOKEN> が必要):
+//
+//	GET  /api/status               SWSC 全体の状態
+//	GET  /api/servers              実行中のサーバー
+//	GET  /api/ports                ポートプールと使用中のポート
+//	GET  /api/requests             処理中の要求
+//	GET  /api/steamcmd             SteamCMD 実行キューの状態
+//	GET  /api/events?limit=N       最近のイベント (新しい順)
+//	GET  /api/backups?name=NAME    バックアップの一覧 (name を省略すると全構成名、backup.go)
+//	GET  /api/schedules?name=NAME  定期的な再起動・停止・起動の予定 (name を省略すると全構成名、schedule.go)
+//	POST /api/servers/{name}/start サーバーを起動 (本文: {"config": "<XML>"} または XML そのもの)
+//	POST /api/servers/{name}/stop  サーバーを停止 (本文: {"confirmed": true} など、省略可)
+
+const (
+	// adminAPIRequestTimeout は、管理APIからの起動・停止要求の最終応答を待つ最大時間です。
+	// ワークショップアイテムのダウンロードに時間がかかる場合があるため、長めに設定しています。
+	adminAPIRequestTimeout = 30 * time.Minute
+	// adminAPIMaxBodyBytes は、管理APIが受け付ける要求本文の最大サイズです。
+	adminAPIMaxBodyBytes = 8 << 20
+)
+
+// processStartedAt は、SWSC プロセスの起動時刻です (稼働時間の表示に使用)。
+var processStartedAt = time.Now()
+
+// startAdminAPI は、設定で有効になっていればローカル管理APIの HTTP サーバーをバックグラウンドで起動します。
+// 待ち受けに失敗しても WebSocket クライアントの動作は継続するため、エラーはログに記録するのみとします。
+// 待ち受けアドレスの変更は SWSC の再起動後に反映されます (認証トークンは再読み込みで即座に反映)。
+func startAdminAPI() {
+	settings := currentConfig().AdminAPI
+	if !settings.Enabled {
+		return
+	}
+	if host, _, err := net.SplitHostPort(settings.Addr); err == nil {
+		if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+			adminLog.warnf("ローカルホスト以外のアドレス (%s) で待ち受けます。ファイアウォール等でアクセスを制限してください。", settings.Addr)
+		}
+	}
+
+	listener, err := net.Listen("tcp", settings.Addr)
+	if err != nil {
+		adminLog.errorf("%s での待ち受けに失敗しました。管理APIは無効になります: %v", settings.Addr, err)
+		return
+	}
+	server := &http.Server{
+		Handler:           newAdminAPIHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	adminLog.infof("http://%s で待ち受けを開始しました。", listener.Addr())
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			adminLog.errorf("HTTP サーバーが停止しました: %v", err)
+		}
+	}()
+}
+
+// newAdminAPIHandler は、管理APIのルーティングと認証を設定した http.Handler を返します。
+func newAdminAPIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", handleAdminStatus)
+	mux.HandleFunc("GET /api/servers", handleAdminServers)
+	mux.HandleFunc("GET /api/ports", handleAdminPorts)
+	mux.HandleFunc("GET /api/requests", handleAdminRequests)
+	mux.HandleFunc("GET /api/steamcmd", handleAdminSteamCmd)
+	mux.HandleFunc("GET /api/events", handleAdminEvents)
+	mux.HandleFunc("GET /api/outbox", handleAdminOutbox)
+	mux.HandleFunc("GET /api/backups", handleAdminBackups)
+	mux.HandleFunc("GET /api/schedules", handleAdminSchedules)
+	mux.HandleFunc("GET /api/stats", handleAdminStats)
+	mux.HandleFunc("POST /api/servers/{name}/start", handleAdminStartServer)
+	mux.HandleFunc("POST /api/servers/{name}/stop", handleAdminStopServer)
+	return requireAdminToken(mux)
+}
+
+// requireAdminToken は、Authorization ヘッダーのトークンを検証するミドルウェアです。
+// トークンは設定の再読み込みで変更される可能性があるため、要求ごとに現在の設定と比較します。
+func requireAdminToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expected := currentConfig().AdminAPI.Token
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			adminLog.warnf("認証失敗: %s %s (接続元: %s)", r.Method, r.URL.Path, r.RemoteAddr)
+			writeAdminError(w, http.StatusUnauthorized, "認証トークンが正しくありません。")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// --- 参照系エンドポイント ---
+
+// adminServerInfo は、/api/servers で返す実行中サーバー1件の情報です。
+type adminServerInfo struct {
+	Name          string       `json:"name"`
+	Pid           int          `json:"pid"`
+	Port          int          `json:"port"`
+	StartedAt     time.Time    `json:"startedAt"`
+	UptimeSeconds int64        `json:"uptimeSeconds"`
+	Players       int          `json:"players"`         // ゲームサーバーの出力から追跡しているプレイヤー数 (players.go)
+	Stats         *ServerStats `json:"stats,omitempty"` // 最後に取得したリソース使用量 (stats.go)
+}
+
+// handleAdminStatus は、SWSC 全体の状態を返します。
+func handleAdminStatus(w http.ResponseWriter, r *http.Request) {
+	cfg := currentConfig()
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"pid":              os.Getpid(),
+		"startedAt":        processStartedAt,
+		"uptimeSeconds":    int64(time.Since(processStartedAt).Seconds()),
+		"configFile":       cfg.ConfigFilePath,
+		"launcher":         cfg.Launcher.Name(),
+		"websocket":        map[string]interface{}{"url": cfg.WsURL, "connected": isWebSocketConnected(), "reconnect": getReconnectStatus(), "heartbeat": getHeartbeatStatus(), "protocol": getBotProtocol(), "writer": getWriterStats()}, // reconnect.go, heartbeat.go, protocol.go, ws_writer.go
+		"runningServers":   len(getRunningProcesses()),
+		"maxServers":       portPoolCapacity(cfg.PortPools), // port_manager.go
+		"inflightRequests": len(getInflightRequests()),      // activity.go
+		"outboxQueued":     getOutboxState().Queued,         // outbox.go
+	})
+}
+
+// handleAdminServers は、実行中のサーバーの一覧を構成名順で返します。
+func handleAdminServers(w http.ResponseWriter, r *http.Request) {
+	servers := []adminServerInfo{}
+	stats := getServerStats()                       // stats.go
+	for name, info := range getRunningProcesses() { // process_manager.go
+		var serverStats *ServerStats
+		if entry, ok := stats[name]; ok {
+			serverStats = &entry
+		}
+		servers = append(servers, adminServerInfo{
+			Name:          name,
+			Pid:           info.Process.Pid,
+			Port:          info.Port,
+			StartedAt:     info.StartedAt,
+			UptimeSeconds: int64(time.Since(info.StartedAt).Seconds()),
+			Players:       getPlayerCount(name),
+			Stats:         serverStats,
+		})
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"servers": servers})
+}
+
+// handleAdminPorts は、ポートプールと使用中のポートを返します。
+func handleAdminPorts(w http.ResponseWriter, r *http.Request) {
+	pools := currentConfig().PortPools
+	used := getCurrentlyUsedPorts() // port_manager.go
+	sort.Ints(used)
+	capacity := portPoolCapacity(pools) // syncStatus の maxServers と同じ値
+	free := capacity
+	for _, port := range used {
+		if isPortInPools(port, pools) {
+			free--
+		}
+	}
+	if free < 0 {
+		free = 0
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{
+		"pools":    pools,
+		"capacity": capacity,
+		"used":     used,
+		"free":     free,
+	})
+}
+
+// handleAdminRequests は、処理中の要求を返します。
+func handleAdminRequests(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"requests": getInflightRequests()})
+}
+
+// handleAdminSteamCmd は、SteamCMD 実行キューの状態を返します。
+func handleAdminSteamCmd(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, getSteamCmdQueueState()) // steamcmd_queue.go
+}
+
+// handleAdminEvents は、最近のイベントを新しい順に返します (クエリ limit で件数を指定、デフォルト50件)。
+func handleAdminEvents(w http.ResponseWriter, r *http.Request) {
+	limit := 50
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("limit ('%s') は1以上の数値で指定してください。", value))
+			return
+		}
+		limit = parsed
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"events": getRecentEvents(limit)})
+}
+
+// handleAdminOutbox は、WebSocket 切断中に送信できず再送を待っているメッセージの状態を返します。
+func handleAdminOutbox(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, getOutboxState()) // outbox.go
+}
+
+// handleAdminBackups は、バックアップの一覧を返します (クエリ name で構成名を指定、省略時は全構成名)。
+func handleAdminBackups(w http.ResponseWriter, r *http.Request) {
+	var backups []BackupInfo
+	var err error
+	if name := r.URL.Query().Get("name"); name == "" {
+		backups, err = listAllBackups() // backup.go
+	} else if err = validateBackupPathComponent("構成名", name); err != nil {
+		writeAdminError(w, http.StatusBadRequest, err.Error())
+		return
+	} else {
+		backups, err = listServerBackups(name)
+	}
+	if err != nil {
+		writeAdminError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"backups": backups})
+}
+
+// handleAdminSchedules は、予定の一覧を次の実行時刻の早い順に返します (?name= で構成名を指定)。
+func handleAdminSchedules(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, map[string]interface{}{"schedules": listSchedules(r.URL.Query().Get("name"))}) // schedule.go
+}
+
+// handleAdminStats は、実行中のサーバーのリソース使用量を構成名順で返します (serverStats と同じ形式)。
+func handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	stats := getServerStats() // stats.go
+	servers := make([]ServerStats, 0, len(stats))
+	for _, entry := range stats {
+		servers = append(servers, entry)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].ServerName < servers[j].ServerName })
+	writeAdminJSON(w, http.StatusOK, ServerStatsPayload{
+		SampledAt:       time.Now(),
+		IntervalSeconds: currentConfig().Stats.Interval.Seconds(),
+		Backend:         statsBackend.Name(),
+		Servers:         servers,
+	})
+}
+
+// --- 操作系エンドポイント ---
+
+// handleAdminStartServer は、サーバーの起動要求を handleStartServerProcess で処理し、最終応答を返します。
+// 本文は {"config": "<XML>"} 形式の JSON、または Content-Type が XML の場合は設定ファイルの内容そのものを受け付けます。
+// JSON の場合は backupId / saveName を指定して、バックアップから復元してから起動できます (backup.go)。
+func handleAdminStartServer(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, adminAPIMaxBodyBytes))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("要求本文の読み込みに失敗しました: %v", err))
+		return
+	}
+
+	payload := StartServerPayload{Name: name}
+	if strings.Contains(r.Header.Get("Content-Type"), "xml") {
+		payload.Config = string(body)
+	} else if err := json.Unmarshal(body, &payload); err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("要求本文の JSON が不正です: %v", err))
+		return
+	}
+	payload.Name = name // パスの構成名を優先
+	if payload.Config == "" && payload.BackupID == "" && payload.SaveName == "" {
+		writeAdminError(w, http.StatusBadRequest, "サーバー設定 (config) またはバックアップ (backupId / saveName) が指定されていません。")
+		return
+	}
+
+	adminLog.infof("サーバー起動要求: '%s' (接続元: %s)", name, r.RemoteAddr)
+	runAdminRequest(w, "startServer", handleStartServerProcess, payload) // process_manager.go
+}
+
+// handleAdminStopServer は、サーバーの停止要求を handleStopServerProcess で処理し、最終応答を返します。
+// 本文は省略可能で、{"confirmed": true} を指定するとプレイヤー数の確認を省略します。
+func handleAdminStopServer(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, adminAPIMaxBodyBytes))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("要求本文の読み込みに失敗しました: %v", err))
+		return
+	}
+
+	payload := StopServerPayload{Name: name}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("要求本文の JSON が不正です: %v", err))
+			return
+		}
+	}
+	payload.Name = name
+
+	adminLog.infof("サーバー停止要求: '%s' (接続元: %s)", name, r.RemoteAddr)
+	runAdminRequest(w, "stopServer", handleStopServerProcess, payload) // process_manager.go
+}
+
+// runAdminRequest は、要求ハンドラをローカル要求として実行し、途中の進捗通知と最終応答を返します。
+// 応答の HTTP ステータスは、成功なら 200、失敗応答なら 409、エラー応答なら 400、タイムアウトなら 504 です。
+func runAdminRequest(w http.ResponseWriter, requestType string, handler func(string, json.RawMessage), payload interface{}) {
+	requestID := newLocalRequestID("admin") // local_requests.go
+	updates := []json.RawMessage{}          // onUpdate は runLocalRequest を呼び出したゴルーチンで実行されるため、ロックは不要
+	final, err := runLocalRequest(requestID, requestType, handler, payload, adminAPIRequestTimeout, func(msg WsMessage) {
+		updates = append(updates, msg.Payload)
+	})
+	if err != nil {
+		writeAdminJSON(w, http.StatusGatewayTimeout, map[string]interface{}{"requestId": requestID, "error": err.Error(), "updates": updates})
+		return
+	}
+
+	status := http.StatusOK
+	switch {
+	case final.Type == "error":
+		status = http.StatusBadRequest
+	case !responseSucceeded(final): // cli.go
+		status = http.StatusConflict
+	}
+	writeAdminJSON(w, status, map[string]interface{}{
+		"requestId": requestID,
+		"type":      final.Type,
+		"result":    final.Payload,
+		"updates":   updates,
+	})
+}
+
+// --- ヘルパー ---
+
+// writeAdminJSON は、値を JSON として HTTP 応答に書き込みます。
+func writeAdminJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(value); err != nil {
+		adminLog.errorf("応答の書き込みに失敗しました: %v", err)
+	}
+}
+
+// writeAdminError は、エラーメッセージを {"error": "..."} 形式の JSON で返します。
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeAdminJSON(w, status, map[string]string{"error": message})
+}
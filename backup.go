@@ -0,0 +1,829 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- ワールドデータのバックアップ ---
+// stopServer はサーバー停止後に設定ディレクトリ (./config/<name>) を削除するため、ゲームサーバーが書き込んだ
+// セーブデータやログも一緒に失われます。削除する前にディレクトリ全体を zip に固め、
+// バックアップディレクトリ (BACKUP_DIR、省略時は ./backups) の <name>/<バックアップID>.zip に保存します。
+// アーカイブにはマニフェスト (swsc_backup.json) を含め、各ファイルのサイズと SHA-256 を記録します。
+// 復元時はマニフェストと照合し、一致しないアーカイブは展開しません。
+//
+// BACKUP_INTERVAL を設定すると、実行中のサーバーも定期的にバックアップします。
+// 古いバックアップは、サーバー構成名ごとに BACKUP_KEEP 件を超えたもの・BACKUP_MAX_AGE を過ぎたものから削除します
+// (最新の1件は常に残します)。Bot からは listBackups / restoreBackup 要求で一覧の取得と復元ができます。
+
+// backupManifestName は、アーカイブ内のマニフェストのファイル名です。
+const backupManifestName = "swsc_backup.json"
+
+// backupManifestVersion は、マニフェストの形式のバージョンです。
+const backupManifestVersion = 1
+
+// backupIDTimeFormat は、バックアップIDに含める作成時刻の形式です (例: 20261018-123456-stop)。
+const backupIDTimeFormat = "20060102-150405"
+
+// backupSchedulerPollInterval は、定期バックアップが無効な場合に設定の変更を確認する間隔です。
+const backupSchedulerPollInterval = time.Minute
+
+// バックアップの作成理由 (バックアップIDの末尾と BackupInfo.Reason)
+const (
+	backupReasonStop       = "stop"       // stopServer で設定ディレクトリを削除する前
+	backupReasonScheduled  = "scheduled"  // BACKUP_INTERVAL による定期バックアップ
+	backupReasonPreRestore = "prerestore" // 復元で既存の設定ディレクトリを置き換える前
+)
+
+// errBackupNotFound は、指定したバックアップが存在しないことを示すエラーです。
+var errBackupNotFound = errors.New("バックアップが見つかりません")
+
+// errBackupCorrupted は、アーカイブの内容がマニフェストと一致しないことを示すエラーです。
+var errBackupCorrupted = errors.New("バックアップの整合性を確認できません")
+
+// backupManifest は、アーカイブに含めるマニフェストです。
+type backupManifest struct {
+	Version    int               `json:"version"`
+	ServerName string            `json:"serverName"`
+	BackupID   string            `json:"backupId"`
+	Reason     string            `json:"reason"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	Files      []backupFileEntry `json:"files"`
+}
+
+// backupFileEntry は、マニフェストに記録するファイル1件の情報です。
+type backupFileEntry struct {
+	Path   string `json:"path"` // 設定ディレクトリからの相対パス (区切り文字は "/")
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+var (
+	// backupLocks は、サーバー構成名ごとのバックアップ操作 (作成・削除・復元) のロックです。
+	backupLocks = make(map[string]*sync.Mutex)
+	// backupLocksMutex は、backupLocks を保護するためのミューテックスです。
+	backupLocksMutex sync.Mutex
+)
+
+// lockServerBackups は、サーバー構成名のバックアップ操作のロックを取得し、解放する関数を返します。
+// 複数の構成名を指定した場合は、互いに逆の順序でロックを待ち合わないよう、名前順にすべてのロックを取得します。
+func lockServerBackups(names ...string) func() {
+	sorted := append([]string(nil), names...)
+	sort.Strings(sorted)
+	locks := make([]*sync.Mutex, 0, len(sorted))
+	backupLocksMutex.Lock()
+	for i, name := range sorted {
+		if i > 0 && name == sorted[i-1] {
+			continue
+		}
+		lock, ok := backupLocks[name]
+		if !ok {
+			lock = &sync.Mutex{}
+			backupLocks[name] = lock
+		}
+		locks = append(locks, lock)
+	}
+	backupLocksMutex.Unlock()
+	for _, lock := range locks {
+		lock.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+		}
+	}
+}
+
+// validateBackupPathComponent は、構成名やバックアップIDがディレクトリ名・ファイル名として安全かを確認します。
+// Bot から受け取った値でバックアップディレクトリの外を参照しないようにするためのものです。
+func validateBackupPathComponent(kind string, value string) error {
+	if value == "" {
+		return fmt.Errorf("%sが指定されていません", kind)
+	}
+	if value == "." || value == ".." || strings.ContainsAny(value, `/\:`) || filepath.Base(value) != value {
+		return fmt.Errorf("%s '%s' は使用できません", kind, value)
+	}
+	return nil
+}
+
+// serverBackupDir は、サーバー構成名のバックアップを保存するディレクトリを返します。
+func serverBackupDir(name string) string {
+	return filepath.Join(currentConfig().Backup.Dir, name)
+}
+
+// backupArchivePath は、バックアップIDのアーカイブのパスを返します。
+func backupArchivePath(name string, backupID string) string {
+	return filepath.Join(serverBackupDir(name), backupID+".zip")
+}
+
+// --- バックアップの作成 ---
+
+// createServerBackup は、サーバーの設定ディレクトリ全体をアーカイブに保存し、古いバックアップを削除します。
+// Args:
+//
+//	name (string): サーバー構成名。
+//	reason (string): 作成理由 (backupReason*)。
+//
+// Returns:
+//
+//	BackupInfo: 作成したバックアップの情報。
+//	error: 設定ディレクトリがない場合、またはアーカイブの作成に失敗した場合のエラー。
+func createServerBackup(name string, reason string) (BackupInfo, error) {
+	if err := validateBackupPathComponent("構成名", name); err != nil {
+		return BackupInfo{}, err
+	}
+	unlock := lockServerBackups(name)
+	defer unlock()
+
+	info, err := writeBackupArchive(name, reason)
+	if err != nil {
+		metricBackups.inc(reason, "failure") // metrics.go
+		return BackupInfo{}, err
+	}
+	metricBackups.inc(reason, "success")
+	backupLog.withServer(name).infof("バックアップを作成しました: %s (%d ファイル, %d バイト)", info.BackupID, info.Files, info.Size)
+	pruneServerBackups(name)
+	return info, nil
+}
+
+// writeBackupArchive は、設定ディレクトリを一時ファイルにアーカイブし、完成後にバックアップIDの名前に変更します。
+// 呼び出し元で lockServerBackups を取得しておく必要があります。
+func writeBackupArchive(name string, reason string) (BackupInfo, error) {
+	sourceDir := filepath.Join(configBaseDir, name)
+	if stat, err := os.Stat(sourceDir); err != nil || !stat.IsDir() {
+		return BackupInfo{}, fmt.Errorf("設定ディレクトリ '%s' がありません", sourceDir)
+	}
+
+	targetDir := serverBackupDir(name)
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return BackupInfo{}, fmt.Errorf("バックアップディレクトリ作成失敗 (%s): %w", targetDir, err)
+	}
+
+	// 同じ秒に複数作成した場合も上書きしないよう、番号を付けて一意にする
+	createdAt := time.Now()
+	backupID := fmt.Sprintf("%s-%s", createdAt.Format(backupIDTimeFormat), reason)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(backupArchivePath(name, backupID)); os.IsNotExist(err) {
+			break
+		}
+		backupID = fmt.Sprintf("%s-%s-%d", createdAt.Format(backupIDTimeFormat), reason, i)
+	}
+	archivePath := backupArchivePath(name, backupID)
+
+	tmpPath := archivePath + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("バックアップファイル作成失敗 (%s): %w", tmpPath, err)
+	}
+	manifest := backupManifest{
+		Version:    backupManifestVersion,
+		ServerName: name,
+		BackupID:   backupID,
+		Reason:     reason,
+		CreatedAt:  createdAt,
+		Files:      []backupFileEntry{},
+	}
+	archiveErr := writeBackupZip(file, sourceDir, &manifest)
+	if syncErr := file.Sync(); archiveErr == nil && syncErr != nil {
+		archiveErr = syncErr
+	}
+	if closeErr := file.Close(); archiveErr == nil && closeErr != nil {
+		archiveErr = closeErr
+	}
+	if archiveErr != nil {
+		_ = os.Remove(tmpPath)
+		return BackupInfo{}, fmt.Errorf("アーカイブ作成失敗 (%s): %w", sourceDir, archiveErr)
+	}
+	if err := os.Rename(tmpPath, archivePath); err != nil {
+		_ = os.Remove(tmpPath)
+		return BackupInfo{}, fmt.Errorf("バックアップファイルの名前変更失敗 (%s): %w", archivePath, err)
+	}
+	return backupInfoFromManifest(manifest, archivePath), nil
+}
+
+// writeBackupZip は、設定ディレクトリ内のファイルを zip に書き込み、最後にマニフェストを追加します。
+func writeBackupZip(w io.Writer, sourceDir string, manifest *backupManifest) error {
+	zipWriter := zip.NewWriter(w)
+	walkErr := filepath.WalkDir(sourceDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(sourceDir, filePath)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == serverStateFileName {
+			return nil // 実行中のプロセスの情報 (server_state.go) は復元しても意味がないため含めない
+		}
+		if !entry.Type().IsRegular() {
+			// シンボリックリンクなどはディレクトリの外を指す可能性があるため含めない
+			backupLog.warnf("通常のファイルではないためバックアップに含めません: %s", filePath)
+			return nil
+		}
+		fileEntry, err := addFileToBackupZip(zipWriter, filePath, relPath)
+		if err != nil {
+			return err
+		}
+		manifest.Files = append(manifest.Files, fileEntry)
+		return nil
+	})
+	if walkErr != nil {
+		zipWriter.Close()
+		return walkErr
+	}
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		zipWriter.Close()
+		return fmt.Errorf("マニフェストのエンコード失敗: %w", err)
+	}
+	manifestWriter, err := zipWriter.CreateHeader(&zip.FileHeader{Name: backupManifestName, Method: zip.Deflate, Modified: manifest.CreatedAt})
+	if err != nil {
+		zipWriter.Close()
+		return err
+	}
+	if _, err := manifestWriter.Write(manifestBytes); err != nil {
+		zipWriter.Close()
+		return err
+	}
+	return zipWriter.Close()
+}
+
+// addFileToBackupZip は、ファイル1件を zip に追加し、サイズと SHA-256 を返します。
+func addFileToBackupZip(zipWriter *zip.Writer, filePath string, relPath string) (backupFileEntry, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return backupFileEntry{}, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return backupFileEntry{}, err
+	}
+	header, err := zip.FileInfoHeader(stat)
+	if err != nil {
+		return backupFileEntry{}, err
+	}
+	header.Name = relPath
+	header.Method = zip.Deflate
+	entryWriter, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return backupFileEntry{}, err
+	}
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(entryWriter, hash), file)
+	if err != nil {
+		return backupFileEntry{}, fmt.Errorf("'%s' の読み込み失敗: %w", filePath, err)
+	}
+	return backupFileEntry{Path: relPath, Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// backupInfoFromManifest は、マニフェストとアーカイブのパスから BackupInfo を作成します。
+func backupInfoFromManifest(manifest backupManifest, archivePath string) BackupInfo {
+	info := BackupInfo{
+		BackupID:   manifest.BackupID,
+		ServerName: manifest.ServerName,
+		Reason:     manifest.Reason,
+		CreatedAt:  manifest.CreatedAt,
+		Files:      len(manifest.Files),
+	}
+	if stat, err := os.Stat(archivePath); err == nil {
+		info.Size = stat.Size()
+	}
+	return info
+}
+
+// backupBeforeRemove は、stopServer で設定ディレクトリを削除する前にバックアップを作成します。
+// Args:
+//
+//	logger (*subsystemLogger): ログの出力先 (要求IDなどを付与したロガー)。
+//	name (string): サーバー構成名。
+//
+// Returns:
+//
+//	string: 作成したバックアップID (バックアップが無効な場合は空)。
+//	error: バックアップに失敗した場合のエラー。この場合、呼び出し元は設定ディレクトリを削除しないでください。
+func backupBeforeRemove(logger *subsystemLogger, name string) (string, error) {
+	if !currentConfig().Backup.Enabled {
+		return "", nil
+	}
+	if _, err := os.Stat(filepath.Join(configBaseDir, name)); os.IsNotExist(err) {
+		return "", nil // 削除するものがない
+	}
+	info, err := createServerBackup(name, backupReasonStop)
+	if err != nil {
+		logger.errorf("停止時のバックアップに失敗しました: %v", err)
+		return "", err
+	}
+	return info.BackupID, nil
+}
+
+// --- バックアップの一覧と削除 ---
+
+// readBackupManifest は、アーカイブからマニフェストを読み込みます。
+func readBackupManifest(reader *zip.Reader) (backupManifest, error) {
+	var manifest backupManifest
+	for _, entry := range reader.File {
+		if entry.Name != backupManifestName {
+			continue
+		}
+		entryReader, err := entry.Open()
+		if err != nil {
+			return manifest, err
+		}
+		defer entryReader.Close()
+		if err := json.NewDecoder(entryReader).Decode(&manifest); err != nil {
+			return manifest, fmt.Errorf("%w: マニフェストを解析できません: %v", errBackupCorrupted, err)
+		}
+		return manifest, nil
+	}
+	return manifest, fmt.Errorf("%w: マニフェスト (%s) がありません", errBackupCorrupted, backupManifestName)
+}
+
+// listServerBackups は、サーバー構成名のバックアップを新しい順に返します。
+// マニフェストを読めないアーカイブは一覧に含めません。
+func listServerBackups(name string) ([]BackupInfo, error) {
+	dir := serverBackupDir(name)
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("バックアップディレクトリ読み込み失敗 (%s): %w", dir, err)
+	}
+
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".zip" {
+			continue
+		}
+		archivePath := filepath.Join(dir, entry.Name())
+		reader, err := zip.OpenReader(archivePath)
+		if err != nil {
+			backupLog.warnf("バックアップを開けません (%s): %v", archivePath, err)
+			continue
+		}
+		manifest, err := readBackupManifest(&reader.Reader)
+		reader.Close()
+		if err != nil {
+			backupLog.warnf("バックアップのマニフェストを読めません (%s): %v", archivePath, err)
+			continue
+		}
+		manifest.BackupID = strings.TrimSuffix(entry.Name(), ".zip") // ファイル名を正とする
+		manifest.ServerName = name
+		backups = append(backups, backupInfoFromManifest(manifest, archivePath))
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].CreatedAt.After(backups[j].CreatedAt) })
+	return backups, nil
+}
+
+// listAllBackups は、全サーバー構成名のバックアップを、構成名順・新しい順に返します。
+func listAllBackups() ([]BackupInfo, error) {
+	dir := currentConfig().Backup.Dir
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []BackupInfo{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("バックアップディレクトリ読み込み失敗 (%s): %w", dir, err)
+	}
+	backups := []BackupInfo{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		serverBackups, err := listServerBackups(entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, serverBackups...)
+	}
+	return backups, nil
+}
+
+// pruneServerBackups は、保持件数 (BACKUP_KEEP) と保持期間 (BACKUP_MAX_AGE) を超えた古いバックアップを削除します。
+// 最新のバックアップは、保持期間を過ぎていても削除しません。呼び出し元で lockServerBackups を取得しておく必要があります。
+func pruneServerBackups(name string) {
+	settings := currentConfig().Backup
+	if settings.Keep == 0 && settings.MaxAge == 0 {
+		return
+	}
+	backups, err := listServerBackups(name)
+	if err != nil {
+		backupLog.withServer(name).warnf("古いバックアップの確認に失敗しました: %v", err)
+		return
+	}
+	now := time.Now()
+	for i, backup := range backups {
+		if i == 0 {
+			continue
+		}
+		overCount := settings.Keep > 0 && i >= settings.Keep
+		expired := settings.MaxAge > 0 && now.Sub(backup.CreatedAt) > settings.MaxAge
+		if !overCount && !expired {
+			continue
+		}
+		archivePath := backupArchivePath(name, backup.BackupID)
+		if err := os.Remove(archivePath); err != nil {
+			backupLog.withServer(name).warnf("古いバックアップの削除に失敗しました (%s): %v", archivePath, err)
+			continue
+		}
+		backupLog.withServer(name).infof("古いバックアップを削除しました: %s", backup.BackupID)
+	}
+}
+
+// --- バックアップの復元 ---
+
+// replaceWithStagingDir は、検証済みの一時ディレクトリで展開先のディレクトリを置き換えます。
+func replaceWithStagingDir(stagingDir string, destDir string) error {
+	if err := os.RemoveAll(destDir); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return fmt.Errorf("既存の設定ディレクトリ削除失敗 (%s): %w", destDir, err)
+	}
+	if err := os.Rename(stagingDir, destDir); err != nil {
+		_ = os.RemoveAll(stagingDir)
+		return fmt.Errorf("展開したディレクトリの名前変更失敗 (%s): %w", destDir, err)
+	}
+	return nil
+}
+
+// unpackServerBackup は、バックアップを一時ディレクトリに展開し、全ファイルをマニフェストと照合します。
+// 照合に失敗した場合は一時ディレクトリを削除します。
+func unpackServerBackup(name string, backupID string, tmpDir string) (backupManifest, error) {
+	archivePath := backupArchivePath(name, backupID)
+	reader, err := zip.OpenReader(archivePath)
+	if os.IsNotExist(err) {
+		return backupManifest{}, fmt.Errorf("%w: %s/%s", errBackupNotFound, name, backupID)
+	}
+	if err != nil {
+		return backupManifest{}, fmt.Errorf("%w: アーカイブを開けません (%s): %v", errBackupCorrupted, archivePath, err)
+	}
+	defer reader.Close()
+
+	manifest, err := readBackupManifest(&reader.Reader)
+	if err != nil {
+		return backupManifest{}, err
+	}
+	expected := make(map[string]backupFileEntry, len(manifest.Files))
+	for _, entry := range manifest.Files {
+		expected[entry.Path] = entry
+	}
+
+	_ = os.RemoveAll(tmpDir) // 前回の中断で残ったものは破棄する
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return backupManifest{}, fmt.Errorf("展開先ディレクトリ作成失敗 (%s): %w", tmpDir, err)
+	}
+	extractErr := func() error {
+		extracted := make(map[string]bool, len(manifest.Files))
+		for _, entry := range reader.File {
+			if entry.Name == backupManifestName || strings.HasSuffix(entry.Name, "/") {
+				continue
+			}
+			fileEntry, ok := expected[entry.Name]
+			if !ok {
+				return fmt.Errorf("%w: マニフェストにないファイルが含まれています: %s", errBackupCorrupted, entry.Name)
+			}
+			if err := extractBackupEntry(entry, tmpDir, fileEntry); err != nil {
+				return err
+			}
+			extracted[entry.Name] = true
+		}
+		for _, fileEntry := range manifest.Files {
+			if !extracted[fileEntry.Path] {
+				return fmt.Errorf("%w: ファイルが欠けています: %s", errBackupCorrupted, fileEntry.Path)
+			}
+		}
+		return nil
+	}()
+	if extractErr != nil {
+		_ = os.RemoveAll(tmpDir)
+		return backupManifest{}, extractErr
+	}
+	manifest.BackupID = backupID
+	return manifest, nil
+}
+
+// extractBackupEntry は、アーカイブ内のファイル1件を展開し、サイズと SHA-256 をマニフェストと照合します。
+func extractBackupEntry(entry *zip.File, destDir string, expected backupFileEntry) error {
+	// "../" などで展開先の外に書き込まないよう、パスを検証する (Zip Slip 対策)
+	// Windows では "\" もパス区切りとして解釈されるため、"\" を含む名前は拒否する
+	cleanName := path.Clean(entry.Name)
+	if strings.Contains(entry.Name, "\\") || path.IsAbs(cleanName) || cleanName == ".." || strings.HasPrefix(cleanName, "../") || strings.Contains(cleanName, ":") {
+		return fmt.Errorf("%w: 不正なパスが含まれています: %s", errBackupCorrupted, entry.Name)
+	}
+	targetPath := filepath.Join(destDir, filepath.FromSlash(cleanName))
+	// 結合後のパスが展開先ディレクトリの内側にあることを確認する
+	relPath, err := filepath.Rel(destDir, targetPath)
+	if err != nil || relPath == "." || filepath.IsAbs(relPath) || relPath == ".." || strings.HasPrefix(relPath, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("%w: 展開先の外を指すパスが含まれています: %s", errBackupCorrupted, entry.Name)
+	}
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0755); err != nil {
+		return err
+	}
+
+	entryReader, err := entry.Open()
+	if err != nil {
+		return fmt.Errorf("%w: '%s' を開けません: %v", errBackupCorrupted, entry.Name, err)
+	}
+	defer entryReader.Close()
+	file, err := os.OpenFile(targetPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	size, copyErr := io.Copy(io.MultiWriter(file, hash), entryReader)
+	closeErr := file.Close()
+	if copyErr != nil {
+		return fmt.Errorf("%w: '%s' を展開できません: %v", errBackupCorrupted, entry.Name, copyErr)
+	}
+	if closeErr != nil {
+		return closeErr
+	}
+	if size != expected.Size || hex.EncodeToString(hash.Sum(nil)) != expected.SHA256 {
+		return fmt.Errorf("%w: '%s' の内容がマニフェストと一致しません", errBackupCorrupted, entry.Name)
+	}
+	if !entry.Modified.IsZero() {
+		_ = os.Chtimes(targetPath, entry.Modified, entry.Modified)
+	}
+	return nil
+}
+
+// restoreServerBackup は、停止中のサーバーの設定ディレクトリをバックアップから復元します。
+// バックアップの検証に成功した後、既存の設定ディレクトリがある場合は、置き換える前にそのディレクトリもバックアップします。
+// Args:
+//
+//	name (string): 復元先のサーバー構成名。
+//	sourceName (string): バックアップを作成したサーバー構成名 (通常は name と同じ)。
+//	backupID (string): 復元するバックアップID。
+//
+// Returns:
+//
+//	backupManifest: 復元したバックアップのマニフェスト。
+//	string: 置き換える前に作成したバックアップID (既存の設定ディレクトリがなかった場合は空)。
+//	error: サーバーが実行中の場合、またはバックアップの検証・展開に失敗した場合のエラー。
+func restoreServerBackup(name string, sourceName string, backupID string) (backupManifest, string, error) {
+	if err := validateBackupPathComponent("構成名", name); err != nil {
+		return backupManifest{}, "", err
+	}
+	if err := validateBackupPathComponent("構成名", sourceName); err != nil {
+		return backupManifest{}, "", err
+	}
+	if err := validateBackupPathComponent("バックアップID", backupID); err != nil {
+		return backupManifest{}, "", err
+	}
+
+	// 復元先のロックは起動処理 (process_manager.go) と共有しているため、ロックを取得してから実行中かどうかを確認する。
+	// 展開中のアーカイブが保持期間の整理 (pruneServerBackups) で削除されないよう、復元元のロックも取得する。
+	unlock := lockServerBackups(name, sourceName)
+	defer unlock()
+	if _, running := getRunningProcesses()[name]; running { // process_manager.go
+		return backupManifest{}, "", fmt.Errorf("サーバー '%s' は実行中です。停止してから復元してください", name)
+	}
+
+	destDir := filepath.Join(configBaseDir, name)
+	stagingDir := destDir + ".restore-tmp"
+	manifest, err := unpackServerBackup(sourceName, backupID, stagingDir)
+	if err != nil {
+		metricBackupRestores.inc("failure") // metrics.go
+		return backupManifest{}, "", err
+	}
+
+	preRestoreID := ""
+	if _, err := os.Stat(destDir); err == nil {
+		info, err := writeBackupArchive(name, backupReasonPreRestore)
+		if err != nil {
+			_ = os.RemoveAll(stagingDir)
+			metricBackups.inc(backupReasonPreRestore, "failure")
+			metricBackupRestores.inc("failure")
+			return backupManifest{}, "", fmt.Errorf("既存の設定ディレクトリのバックアップに失敗したため復元を中止しました: %w", err)
+		}
+		metricBackups.inc(backupReasonPreRestore, "success")
+		preRestoreID = info.BackupID
+	}
+
+	if err := replaceWithStagingDir(stagingDir, destDir); err != nil {
+		metricBackupRestores.inc("failure")
+		return backupManifest{}, preRestoreID, err
+	}
+	metricBackupRestores.inc("success")
+	pruneServerBackups(name)
+	return manifest, preRestoreID, nil
+}
+
+// latestBackupID は、サーバー構成名の最新のバックアップIDを返します。
+func latestBackupID(name string) (string, error) {
+	if err := validateBackupPathComponent("構成名", name); err != nil {
+		return "", err
+	}
+	backups, err := listServerBackups(name)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", fmt.Errorf("%w: 構成名 '%s' のバックアップはありません", errBackupNotFound, name)
+	}
+	return backups[0].BackupID, nil
+}
+
+// restoreBackupForStart は、startServer 要求で BackupID または SaveName が指定されている場合に、
+// 設定ファイルを適用する前にバックアップを検証して設定ディレクトリ (./config/<name>) に展開します。
+// Args:
+//
+//	logger (*subsystemLogger): ログの出力先 (要求IDなどを付与したロガー)。
+//	requestID (string): 進捗通知に使用する要求ID。
+//	data (StartServerPayload): startServer 要求のペイロード。
+//
+// Returns:
+//
+//	string: 復元したバックアップID (復元を要求されていない場合は空)。
+//	error: バックアップが見つからない場合、整合性を確認できない場合、またはサーバーが実行中の場合のエラー。
+func restoreBackupForStart(logger *subsystemLogger, requestID string, data StartServerPayload) (string, error) {
+	if data.BackupID == "" && data.SaveName == "" {
+		return "", nil
+	}
+	sourceName := data.SaveName
+	if sourceName == "" {
+		sourceName = data.Name
+	}
+	backupID := data.BackupID
+	if backupID == "" {
+		var err error
+		if backupID, err = latestBackupID(sourceName); err != nil {
+			return "", err
+		}
+	}
+
+	logger.infof("起動前にバックアップ '%s/%s' を復元します...", sourceName, backupID)
+	sendStatusUpdate(requestID, "backup_restore_start", fmt.Sprintf("バックアップ '%s' を復元しています...", backupID)) // websocket_client.go
+	manifest, preRestoreID, err := restoreServerBackup(data.Name, sourceName, backupID)
+	if err != nil {
+		return "", err
+	}
+	message := fmt.Sprintf("バックアップ '%s' を復元しました (%d ファイル)。", backupID, len(manifest.Files))
+	if preRestoreID != "" {
+		message += fmt.Sprintf(" 復元前の内容はバックアップ '%s' に保存しました。", preRestoreID)
+	}
+	logger.infof("%s", message)
+	sendStatusUpdate(requestID, "backup_restore_complete", message)
+	recordEvent("backupRestored", data.Name, message) // activity.go
+	return backupID, nil
+}
+
+// loadRestoredServerConfig は、復元した設定ディレクトリの設定ファイルを読み込み、
+// ワークショップの配置パスを Workshop ID に戻した設定を返します (startServer で config が省略された場合に使用)。
+func loadRestoredServerConfig(logger *subsystemLogger, name string) (string, error) {
+	configFilePath := filepath.Join(configBaseDir, name, "server_config.xml")
+	content, err := os.ReadFile(configFilePath)
+	if err != nil {
+		return "", fmt.Errorf("バックアップに設定ファイルがありません (%s): %w", configFilePath, err)
+	}
+	restoredXml, unknownPaths, err := restoreWorkshopIDsInXML(string(content)) // xml_manager.go
+	if err != nil {
+		return "", fmt.Errorf("バックアップの設定ファイルを解析できません: %w", err)
+	}
+	if len(unknownPaths) > 0 {
+		logger.warnf("バックアップの設定ファイルに Workshop ID に戻せないパスが %d 件あります: %v", len(unknownPaths), unknownPaths)
+	}
+	return restoredXml, nil
+}
+
+// --- 定期バックアップ ---
+
+// startBackupScheduler は、BACKUP_INTERVAL ごとに実行中の全サーバーをバックアップするゴルーチンを開始します。
+// 間隔は毎回現在の設定から取得するため、設定の再読み込みで変更・有効化できます。
+func startBackupScheduler() {
+	go func() {
+		for {
+			interval := currentConfig().Backup.Interval
+			if interval == 0 {
+				time.Sleep(backupSchedulerPollInterval)
+				continue
+			}
+			time.Sleep(interval)
+			if currentConfig().Backup.Interval == 0 {
+				continue // 待機中に無効化された
+			}
+			runScheduledBackups()
+		}
+	}()
+}
+
+// runScheduledBackups は、実行中の全サーバーのバックアップを作成します。
+// 実行中のサーバーはファイルを書き込んでいる途中の可能性があるため、停止時のバックアップより整合性は劣ります。
+func runScheduledBackups() {
+	procs := getRunningProcesses() // process_manager.go
+	if len(procs) == 0 {
+		return
+	}
+	backupLog.infof("定期バックアップを開始します (%d サーバー)", len(procs))
+	for name := range procs {
+		if _, err := createServerBackup(name, backupReasonScheduled); err != nil {
+			backupLog.withServer(name).errorf("定期バックアップに失敗しました: %v", err)
+		}
+	}
+}
+
+// --- listBackups / restoreBackup 要求 ---
+
+// handleListBackupsRequest は、Bot からの listBackups 要求を処理し、バックアップの一覧を応答します。
+// Args:
+//
+//	requestID (string): 要求ID。
+//	payload (json.RawMessage): ListBackupsPayload の JSON (name を省略すると全サーバー構成名)。
+func handleListBackupsRequest(requestID string, payload json.RawMessage) {
+	reqLog := backupLog.withRequest(requestID)
+	var data ListBackupsPayload
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &data); err != nil {
+			reqLog.errorf("listBackupsペイロードのデコード失敗: %v", err)
+			sendErrorResponse(requestID, fmt.Sprintf("不正なバックアップ一覧要求ペイロード: %v", err)) // websocket_client.go
+			return
+		}
+	}
+
+	var backups []BackupInfo
+	var err error
+	if data.Name == "" {
+		backups, err = listAllBackups()
+	} else if err = validateBackupPathComponent("構成名", data.Name); err == nil {
+		backups, err = listServerBackups(data.Name)
+	}
+	if err != nil {
+		reqLog.errorf("バックアップ一覧の取得に失敗しました: %v", err)
+		sendResponse(requestID, false, fmt.Sprintf("バックアップ一覧の取得に失敗しました: %v", err), "")
+		return
+	}
+	reqLog.infof("バックアップ一覧を応答します (%d 件)", len(backups))
+	sendBackupListResponse(requestID, fmt.Sprintf("バックアップは %d 件あります。", len(backups)), backups) // websocket_client.go
+}
+
+// handleRestoreBackupRequest は、Bot からの restoreBackup 要求を処理し、停止中のサーバーの設定ディレクトリを復元します。
+// Args:
+//
+//	requestID (string): 要求ID。
+//	payload (json.RawMessage): RestoreBackupPayload の JSON。
+func handleRestoreBackupRequest(requestID string, payload json.RawMessage) {
+	reqLog := backupLog.withRequest(requestID)
+	var data RestoreBackupPayload
+	if err := json.Unmarshal(payload, &data); err != nil {
+		reqLog.errorf("restoreBackupペイロードのデコード失敗: %v", err)
+		sendErrorResponse(requestID, fmt.Sprintf("不正なバックアップ復元要求ペイロード: %v", err))
+		return
+	}
+	reqLog = reqLog.withServer(data.Name)
+	reqLog.infof("バックアップの復元要求を受信: %s", data.BackupID)
+
+	manifest, preRestoreID, err := restoreServerBackup(data.Name, data.Name, data.BackupID)
+	if err != nil {
+		reqLog.errorf("バックアップの復元に失敗しました: %v", err)
+		sendResponse(requestID, false, fmt.Sprintf("バックアップ '%s' の復元に失敗しました: %v", data.BackupID, err), "")
+		return
+	}
+	message := fmt.Sprintf("バックアップ '%s' をサーバー '%s' の設定ディレクトリに復元しました (%d ファイル)。", manifest.BackupID, data.Name, len(manifest.Files))
+	if preRestoreID != "" {
+		message += fmt.Sprintf(" 復元前の内容はバックアップ '%s' に保存しました。", preRestoreID)
+	}
+	reqLog.infof("%s", message)
+	recordEvent("backupRestored", data.Name, message) // activity.go
+	sendResponse(requestID, true, message, "")
+}
+
+// formatBackupSettings は、バックアップの設定をログ表示用の文字列にします。
+func formatBackupSettings(settings BackupSettings) string {
+	if !settings.Enabled && settings.Interval == 0 {
+		return "無効"
+	}
+	parts := []string{"保存先=" + settings.Dir}
+	if settings.Enabled {
+		parts = append(parts, "停止時=有効")
+	} else {
+		parts = append(parts, "停止時=無効")
+	}
+	if settings.Interval > 0 {
+		parts = append(parts, fmt.Sprintf("定期=%v ごと", settings.Interval))
+	}
+	if settings.Keep > 0 {
+		parts = append(parts, fmt.Sprintf("保持=%d 件", settings.Keep))
+	}
+	if settings.MaxAge > 0 {
+		parts = append(parts, fmt.Sprintf("保持期間=%v", settings.MaxAge))
+	}
+	return strings.Join(parts, ", ")
+}
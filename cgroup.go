@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- cgroup v2 によるリソース制限 ---
+// 巨大なビークルなどで1台のゲームサーバーがホストのメモリを使い切り、他のサーバーまで巻き込んで停止することを防ぐため、
+// Linux では各サーバーを専用の cgroup (v2) で起動し、メモリ・CPU・プロセス数を制限します。
+//   - 上限は CGROUP_MEMORY_MAX / CGROUP_CPU_MAX / CGROUP_PIDS_MAX (設定ファイルの cgroup) で指定し、
+//     servers.<構成名>.limits で構成名ごとに変更できます。上限がすべて 0 (無制限) のサーバーには cgroup を使用しません
+//   - cgroup は CGROUP_ROOT の下に構成名で作成します。SWSC が CGROUP_ROOT に書き込めるよう、
+//     systemd の Delegate=yes などで memory / cpu / pids コントローラーを委譲しておく必要があります
+//   - プロセスは clone3 の CLONE_INTO_CGROUP (exec.Cmd の UseCgroupFD) で起動時から cgroup に入るため、
+//     Wine などの子プロセスも含めて制限されます
+//   - メモリの上限を超えて OOM killer に強制終了された場合は、serverCrashDetected の reason を "oomKilled" として通知します
+// Linux 以外の OS では上限は無視されます (設定の読み込み時に警告します)。
+
+// cgroupPeriod は、cpu.max の期間 (マイクロ秒) です。CPU の上限 (コア数) にこの値を掛けた時間を、期間ごとに使用できます。
+const cgroupPeriod = 100000
+
+// cgroupRemoveRetries は、プロセス終了後に cgroup の削除を試みる回数です (終了直後は削除できない場合があるため)。
+const cgroupRemoveRetries = 5
+
+// errCgroupUnsupported は、この OS では cgroup を使用できないことを示すエラーです。
+var errCgroupUnsupported = errors.New("この OS では cgroup を使用できません")
+
+// crashReasonCrashed と crashReasonOOMKilled は、serverCrashDetected の reason の値です。
+const (
+	crashReasonCrashed   = "crashed"   // 予期せぬ終了 (クラッシュ、終了コードでの終了など)
+	crashReasonOOMKilled = "oomKilled" // メモリの上限を超えて OOM killer により強制終了された
+)
+
+// serverCgroup は、ゲームサーバー1プロセス分の cgroup です。
+type serverCgroup struct {
+	Path         string
+	Limits       ResourceLimits
+	pid          int
+	baseOOMKills int      // 作成時の memory.events の oom_kill の値 (再利用した cgroup の過去の値を除くため)
+	dir          *os.File // 起動時に UseCgroupFD で渡すディレクトリ (起動後に閉じる)
+}
+
+var (
+	// serverCgroups は、実行中のサーバーの cgroup です (キー: サーバー構成名)。
+	serverCgroups = make(map[string]*serverCgroup)
+	// serverCgroupsMutex は、serverCgroups を保護するためのミューテックスです。
+	serverCgroupsMutex sync.Mutex
+)
+
+// enabled は、いずれかの上限が設定されているかどうかを返します。
+func (l ResourceLimits) enabled() bool {
+	return l.MemoryMax > 0 || l.CPUMax > 0 || l.PidsMax > 0
+}
+
+// limitsFor は、サーバー構成名に適用するリソースの上限を返します。
+func limitsFor(name string) ResourceLimits {
+	cfg := currentConfig()
+	if settings, ok := cfg.ServerOverrides[name]; ok {
+		return settings.Limits
+	}
+	return cfg.Cgroup.Limits
+}
+
+// prepareServerCgroup は、サーバーの cgroup を作成して上限を書き込み、起動するコマンドが cgroup に入るよう設定します。
+// startServerProcess から、プロセスの起動前に呼び出されます。
+// Returns:
+//
+//	*serverCgroup: 作成した cgroup (上限が設定されていない場合や Linux 以外の場合は nil)。
+//	error: cgroup を作成できない場合のエラー (上限を守れないため、サーバーは起動しません)。
+func prepareServerCgroup(name string, cmd *exec.Cmd) (*serverCgroup, error) {
+	limits := limitsFor(name)
+	if !limits.enabled() || runtime.GOOS != "linux" {
+		return nil, nil
+	}
+	root := currentConfig().Cgroup.Root
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("cgroup '%s' を作成できません: %w", root, err)
+	}
+	// 子 cgroup で上限を使えるよう、親 cgroup で必要なコントローラーを有効にする
+	var controllers []string
+	if limits.MemoryMax > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if limits.CPUMax > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if limits.PidsMax > 0 {
+		controllers = append(controllers, "+pids")
+	}
+	if err := os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0644); err != nil {
+		return nil, fmt.Errorf("cgroup '%s' でコントローラー (%s) を有効にできません (委譲されているか確認してください): %w", root, strings.Join(controllers, " "), err)
+	}
+
+	cg := &serverCgroup{Path: filepath.Join(root, name), Limits: limits}
+	// 前回の cgroup が残っていれば削除して作り直す (Wine の子プロセスなどが残っている場合は削除できないため、そのまま使う)
+	_ = os.Remove(cg.Path)
+	if err := os.Mkdir(cg.Path, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("cgroup '%s' を作成できません: %w", cg.Path, err)
+	}
+	if err := cg.writeLimits(); err != nil {
+		return nil, err
+	}
+	cg.baseOOMKills, _ = cg.oomKills()
+
+	dir, err := os.Open(cg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cgroup '%s' を開けません: %w", cg.Path, err)
+	}
+	if err := setCgroupFD(cmd, dir.Fd()); err != nil { // cgroup_linux.go
+		dir.Close()
+		return nil, err
+	}
+	cg.dir = dir
+	return cg, nil
+}
+
+// writeLimits は、cgroup の memory.max / cpu.max / pids.max に上限を書き込みます。無制限の項目には "max" を書き込みます。
+func (cg *serverCgroup) writeLimits() error {
+	memory, cpu, pids := "max", "max", "max"
+	if cg.Limits.MemoryMax > 0 {
+		memory = strconv.FormatUint(cg.Limits.MemoryMax, 10)
+	}
+	if cg.Limits.CPUMax > 0 {
+		cpu = strconv.Itoa(int(cg.Limits.CPUMax * cgroupPeriod))
+	}
+	if cg.Limits.PidsMax > 0 {
+		pids = strconv.Itoa(cg.Limits.PidsMax)
+	}
+	files := []struct {
+		name  string
+		value string
+		set   bool
+	}{
+		{"memory.max", memory, cg.Limits.MemoryMax > 0},
+		{"cpu.max", fmt.Sprintf("%s %d", cpu, cgroupPeriod), cg.Limits.CPUMax > 0},
+		{"pids.max", pids, cg.Limits.PidsMax > 0},
+	}
+	for _, file := range files {
+		err := os.WriteFile(filepath.Join(cg.Path, file.name), []byte(file.value), 0644)
+		if err != nil && file.set {
+			return fmt.Errorf("cgroup '%s' の %s に書き込めません: %w", cg.Path, file.name, err)
+		}
+	}
+	return nil
+}
+
+// oomKills は、cgroup の memory.events から OOM killer に強制終了されたプロセスの累計数を返します。
+func (cg *serverCgroup) oomKills() (int, error) {
+	file, err := os.Open(filepath.Join(cg.Path, "memory.events"))
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "oom_kill "); ok {
+			return strconv.Atoi(strings.TrimSpace(value))
+		}
+	}
+	return 0, scanner.Err()
+}
+
+// attachServerCgroup は、起動したプロセスの cgroup を記録します。startServerProcess から、プロセスの起動後に呼び出されます。
+// 起動に失敗した場合 (pid が 0) は cgroup を削除します。
+func attachServerCgroup(name string, cg *serverCgroup, pid int) {
+	if cg == nil {
+		return
+	}
+	cg.dir.Close() // 起動後は不要
+	if pid == 0 {
+		_ = os.Remove(cg.Path)
+		return
+	}
+	cg.pid = pid
+	serverCgroupsMutex.Lock()
+	serverCgroups[name] = cg
+	serverCgroupsMutex.Unlock()
+	processLog.withServer(name).infof("cgroup '%s' でリソースを制限して起動しました (%s)", cg.Path, formatResourceLimits(cg.Limits))
+}
+
+// releaseServerCgroup は、終了したプロセスの cgroup を削除し、OOM killer に強制終了されたかどうかを返します。
+// waitForProcessExit から、プロセスの終了後 (再起動の前) に呼び出されます。
+// 同じ構成名で既に新しいプロセスが起動している場合は、その cgroup には触れません。
+// Returns:
+//
+//	bool: プロセスの実行中に OOM killer が発生した場合は true。
+//	ResourceLimits: プロセスに適用していた上限。
+func releaseServerCgroup(name string, pid int) (bool, ResourceLimits) {
+	serverCgroupsMutex.Lock()
+	cg := serverCgroups[name]
+	if cg == nil || cg.pid != pid {
+		serverCgroupsMutex.Unlock()
+		return false, ResourceLimits{}
+	}
+	delete(serverCgroups, name)
+	serverCgroupsMutex.Unlock()
+
+	srvLog := processLog.withServer(name)
+	oomKilled := false
+	if cg.Limits.MemoryMax > 0 { // memory.events はメモリの上限を設定した場合のみ存在する
+		kills, err := cg.oomKills()
+		if err != nil {
+			srvLog.warnf("cgroup '%s' の OOM の発生状況を読み取れません: %v", cg.Path, err)
+		}
+		oomKilled = kills > cg.baseOOMKills
+	}
+
+	// プロセスの終了直後は cgroup が空になっていない場合があるため、少し待って再試行する
+	var err error
+	for i := 0; i < cgroupRemoveRetries; i++ {
+		if err = os.Remove(cg.Path); err == nil || os.IsNotExist(err) {
+			return oomKilled, cg.Limits
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	srvLog.warnf("cgroup '%s' を削除できません (子プロセスが残っている可能性があります。次回の起動時に再利用します): %v", cg.Path, err)
+	return oomKilled, cg.Limits
+}
+
+// parseMemoryLimit は、メモリの上限の文字列 (例: "8G", "512M", "1073741824") をバイト数に変換します。
+// 単位は K / M / G / T (1024 の累乗、"Gi" や "GB" も可) です。"0" と "max" は無制限 (0) です。
+func parseMemoryLimit(value string) (uint64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == "0" || strings.EqualFold(value, "max") {
+		return 0, nil
+	}
+	number := strings.TrimRight(strings.ToUpper(value), "IB")
+	multiplier := uint64(1)
+	if number != "" {
+		if index := strings.IndexByte("KMGT", number[len(number)-1]); index >= 0 {
+			multiplier = 1 << (10 * (index + 1))
+			number = number[:len(number)-1]
+		}
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("メモリの上限は 8G や 512M のように指定してください: %s", value)
+	}
+	return uint64(size * float64(multiplier)), nil
+}
+
+// parseCPULimit は、CPU の上限 (コア数、例: "2", "1.5") を変換します。"0" と "max" は無制限 (0) です。
+func parseCPULimit(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "max") {
+		return 0, nil
+	}
+	cores, err := strconv.ParseFloat(value, 64)
+	if err != nil || cores < 0 {
+		return 0, fmt.Errorf("CPU の上限はコア数で指定してください (例: 2、1.5): %s", value)
+	}
+	if cores > 0 && cores*cgroupPeriod < 1000 {
+		return 0, fmt.Errorf("CPU の上限は 0.01 コア以上で指定してください: %s", value)
+	}
+	return cores, nil
+}
+
+// formatMemoryLimit は、メモリの上限をログ表示用の文字列にします (例: "8GiB")。
+func formatMemoryLimit(bytes uint64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(bytes)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64) + units[unit]
+}
+
+// formatResourceLimits は、リソースの上限をログ表示用の文字列にします。
+func formatResourceLimits(limits ResourceLimits) string {
+	if !limits.enabled() {
+		return "無制限"
+	}
+	var parts []string
+	if limits.MemoryMax > 0 {
+		parts = append(parts, "メモリ="+formatMemoryLimit(limits.MemoryMax))
+	}
+	if limits.CPUMax > 0 {
+		parts = append(parts, fmt.Sprintf("CPU=%gコア", limits.CPUMax))
+	}
+	if limits.PidsMax > 0 {
+		parts = append(parts, fmt.Sprintf("プロセス・スレッド数=%d", limits.PidsMax))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatCgroupSettings は、cgroup によるリソース制限の設定をログ表示用の文字列にします。
+func formatCgroupSettings(settings CgroupSettings) string {
+	text := fmt.Sprintf("%s (cgroup: %s)", formatResourceLimits(settings.Limits), settings.Root)
+	if runtime.GOOS != "linux" {
+		text += " ※ Linux 以外では cgroup を使用できないため、上限は無視されます"
+	}
+	return text
+}
@@ -0,0 +1,18 @@
+//go:build linux
+
+package main
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setCgroupFD は、コマンドのプロセスを起動時から cgroup に入れるよう設定します (clone3 の CLONE_INTO_CGROUP)。
+func setCgroupFD(cmd *exec.Cmd, fd uintptr) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd)
+	return nil
+}
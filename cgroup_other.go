@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "os/exec"
+
+// setCgroupFD は、Linux 以外では cgroup を使用できないため、常にエラーを返します。
+func setCgroupFD(cmd *exec.Cmd, fd uintptr) error {
+	return errCgroupUnsupported
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// --- CLI サブコマンド ---
+// Bot に接続せずにローカルでサーバーを操作するためのサブコマンドです (デバッグや Bot を使わないホスト向け)。
+// 引数なしで起動した場合は従来通り WebSocket クライアントとして常駐します。
+// 各サブコマンドの結果は標準出力に JSON (1行1オブジェクト) で出力し、ログは標準エラー出力に出力します。
+
+// cliUsage は、サブコマンドの使い方です。
+const cliUsage = `使い方:
+  swsc                                      WebSocketクライアントとして常駐します (従来の動作)
+  swsc start <name> --config <file.xml>     サーバーをフォアグラウンドで起動します (Ctrl+C で停止し、設定を出力)
+            [--backup <id>] [--save <name>] バックアップから復元してから起動します (--config は省略可)
+  swsc stop <name>                          swsc start で起動したサーバーを停止し、設定を出力します
+  swsc list                                 起動中のサーバーを一覧表示します
+  swsc validate-config                      設定ファイルと環境変数を検証します
+  swsc download [--type playlist|mod] [--steamcmd <profile>] <id>...
+                                            ワークショップアイテムをダウンロード/更新します
+`
+
+// cliStopTimeout は、swsc stop でサーバーの終了を待つ最大時間です。
+const cliStopTimeout = 30 * time.Second
+
+// runCLI は、サブコマンドを実行し、プロセスの終了コードを返します。
+// Args:
+//
+//	args ([]string): コマンドライン引数 (プログラム名を除く)。
+//
+// Returns:
+//
+//	int: 終了コード (0: 成功, 1: 失敗, 2: 使い方の誤り)。
+func runCLI(args []string) int {
+	runMode = ownerModeCLI // server_state.go
+	switch args[0] {
+	case "start":
+		return runStartCommand(args[1:])
+	case "stop":
+		return runStopCommand(args[1:])
+	case "list":
+		return runListCommand(args[1:])
+	case "validate-config":
+		return runValidateConfigCommand(args[1:])
+	case "download":
+		return runDownloadCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "不明なサブコマンドです: %s\n\n%s", args[0], cliUsage)
+		return 2
+	}
+}
+
+// --- サブコマンドの実装 ---
+
+// runStartCommand は "swsc start <name> --config <file.xml> [--backup <id>] [--save <name>]" を実行します。
+// handleStartServerProcess をローカル要求として実行し、起動後はフォアグラウンドでサーバーを監視します。
+// Ctrl+C (または SIGTERM) を受け取ると handleStopServerProcess で停止し、返却された設定を出力します。
+func runStartCommand(args []string) int {
+	flags := flag.NewFlagSet("start", flag.ContinueOnError)
+	configPath := flags.String("config", "", "サーバー設定ファイル (server_config.xml) のパス")
+	backupID := flags.String("backup", "", "起動前に復元するバックアップID")
+	saveName := flags.String("save", "", "復元するバックアップの構成名 (--backup を省略すると最新のバックアップ)")
+	positional, err := parseInterspersedFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	restoring := *backupID != "" || *saveName != ""
+	if len(positional) != 1 || (*configPath == "" && !restoring) {
+		fmt.Fprintf(os.Stderr, "swsc start には構成名と --config (または --backup / --save) の指定が必要です。\n\n%s", cliUsage)
+		return 2
+	}
+	name := positional[0]
+
+	var configContent []byte
+	if *configPath != "" {
+		configContent, err = os.ReadFile(*configPath)
+		if err != nil {
+			printCLIError(fmt.Sprintf("設定ファイル '%s' の読み込みに失敗しました: %v", *configPath, err))
+			return 1
+		}
+	}
+	if !initializeClient() { // main.go
+		return 1
+	}
+
+	// クラッシュ後の再起動に失敗した場合は、監視を終了する
+	restartFailed := make(chan struct{})
+	var restartFailedOnce sync.Once
+	setLocalEventObserver(func(msg WsMessage) {
+		printCLIMessage(msg)
+		var result ServerRestartResultPayload
+		if msg.Type == "serverEvent" && json.Unmarshal(msg.Payload, &result) == nil &&
+			result.EventType == "serverRestartResult" && result.ServerName == name && !result.Success {
+			restartFailedOnce.Do(func() { close(restartFailed) })
+		}
+	})
+	defer setLocalEventObserver(nil)
+
+	startResp, err := runLocalRequest(newLocalRequestID("cli"), "startServer", handleStartServerProcess,
+		StartServerPayload{Name: name, Config: string(configContent), BackupID: *backupID, SaveName: *saveName}, 0, printCLIMessage) // local_requests.go
+	if err != nil {
+		printCLIError(err.Error())
+		return 1
+	}
+	printCLIMessage(startResp)
+	if !responseSucceeded(startResp) {
+		return 1
+	}
+
+	cliLog.infof("サーバー '%s' を起動しました。Ctrl+C で停止します。", name)
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case <-signals:
+		cliLog.infof("停止シグナルを受信しました。サーバー '%s' を停止します...", name)
+	case <-restartFailed:
+		cliLog.infof("サーバー '%s' が終了し、再起動されなかったため監視を終了します。", name)
+		return 1
+	}
+
+	stopResp, err := runLocalRequest(newLocalRequestID("cli"), "stopServer", handleStopServerProcess,
+		StopServerPayload{Name: name, Confirmed: true}, 2*time.Minute, printCLIMessage)
+	if err != nil {
+		printCLIError(err.Error())
+		return 1
+	}
+	printCLIMessage(stopResp)
+	if !responseSucceeded(stopResp) {
+		return 1
+	}
+	return 0
+}
+
+// runStopCommand は "swsc stop <name>" を実行します。
+// 状態ファイルから swsc start で起動したサーバーを特定し、監視中の swsc start プロセスとサーバーを終了させた後、
+// stopServer と同じ形式で Workshop ID に戻した設定を出力し、設定ディレクトリを削除します。
+// 常駐中の SWSC (Bot 接続) が管理しているサーバーは、二重管理を避けるため停止しません。
+func runStopCommand(args []string) int {
+	flags := flag.NewFlagSet("stop", flag.ContinueOnError)
+	positional, err := parseInterspersedFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "swsc stop には構成名の指定が必要です。\n\n%s", cliUsage)
+		return 2
+	}
+	name := positional[0]
+
+	state, err := readServerStateFile(name) // server_state.go
+	if err != nil {
+		printCLIError(fmt.Sprintf("サーバー '%s' は実行されていません: %v", name, err))
+		return 1
+	}
+	if state.OwnerMode == ownerModeDaemon && isProcessAlive(state.OwnerPid) {
+		printCLIError(fmt.Sprintf("サーバー '%s' は常駐中の SWSC (PID: %d) が管理しています。Bot から停止してください。", name, state.OwnerPid))
+		return 1
+	}
+
+	// 監視中の swsc start を先に終了させる (終了を検知して再起動されるのを防ぐ)
+	if state.OwnerPid != os.Getpid() && isProcessAlive(state.OwnerPid) {
+		cliLog.infof("サーバー '%s' を監視している SWSC (PID: %d) を終了します...", name, state.OwnerPid)
+		if err := killProcess(state.OwnerPid); err != nil {
+			cliLog.warnf("SWSC (PID: %d) の終了に失敗しました: %v", state.OwnerPid, err)
+		}
+	}
+	if isProcessAlive(state.Pid) {
+		cliLog.infof("サーバー '%s' (PID: %d) を停止します...", name, state.Pid)
+		if err := killProcess(state.Pid); err != nil {
+			cliLog.warnf("サーバー (PID: %d) の停止に失敗しました: %v", state.Pid, err)
+		}
+		deadline := time.Now().Add(cliStopTimeout)
+		for isProcessAlive(state.Pid) {
+			if time.Now().After(deadline) {
+				printCLIError(fmt.Sprintf("サーバー '%s' (PID: %d) が %v 以内に終了しませんでした。", name, state.Pid, cliStopTimeout))
+				return 1
+			}
+			time.Sleep(200 * time.Millisecond)
+		}
+	}
+
+	stopLog := cliLog.withServer(name)
+	message, config, unknownPaths := readStoppedServerConfig(stopLog, name) // process_manager.go
+	removeServerConfigDir(stopLog, name)
+	printCLIResponse(ResponsePayload{Success: true, Message: message, Config: config, UnknownPaths: unknownPaths})
+	return 0
+}
+
+// runListCommand は "swsc list" を実行し、状態ファイルが残っているサーバーを一覧表示します。
+func runListCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "swsc list に引数は指定できません。\n\n%s", cliUsage)
+		return 2
+	}
+
+	type listedServer struct {
+		serverState
+		Alive      bool `json:"alive"`      // ゲームサーバープロセスが存在するか
+		OwnerAlive bool `json:"ownerAlive"` // 管理している SWSC が存在するか
+	}
+	servers := []listedServer{}
+	for _, state := range listServerStateFiles() { // server_state.go
+		servers = append(servers, listedServer{
+			serverState: state,
+			Alive:       isProcessAlive(state.Pid),
+			OwnerAlive:  isProcessAlive(state.OwnerPid),
+		})
+	}
+	printCLIJSON(map[string]interface{}{"servers": servers})
+	return 0
+}
+
+// runValidateConfigCommand は "swsc validate-config" を実行し、設定の検証結果を出力します。
+// 検証エラーがあれば全件を出力し、終了コード 1 を返します。
+func runValidateConfigCommand(args []string) int {
+	if len(args) > 0 {
+		fmt.Fprintf(os.Stderr, "swsc validate-config に引数は指定できません。\n\n%s", cliUsage)
+		return 2
+	}
+
+	loadDotEnv()              // config_reload.go
+	cfg, err := buildConfig() // config.go
+	result := struct {
+		Valid      bool     `json:"valid"`
+		ConfigFile string   `json:"configFile,omitempty"`
+		Errors     []string `json:"errors,omitempty"`
+	}{Valid: err == nil}
+	if err != nil {
+		result.Errors = splitJoinedErrors(err)
+	} else {
+		result.ConfigFile = cfg.ConfigFilePath
+	}
+	printCLIJSON(result)
+	if !result.Valid {
+		return 1
+	}
+	return 0
+}
+
+// runDownloadCommand は "swsc download [--type playlist|mod] [--steamcmd <profile>] <id>..." を実行し、
+// DownloadWorkshopItems でワークショップアイテムをダウンロード/更新した結果を出力します。
+func runDownloadCommand(args []string) int {
+	flags := flag.NewFlagSet("download", flag.ContinueOnError)
+	itemType := flags.String("type", "playlist", "アイテムの種類 (playlist または mod)")
+	profileName := flags.String("steamcmd", "", "使用する SteamCMD プロファイル (steamcmd.profiles、省略時は既定の設定)")
+	ids, err := parseInterspersedFlags(flags, args)
+	if err != nil {
+		return 2
+	}
+	if len(ids) == 0 {
+		fmt.Fprintf(os.Stderr, "swsc download にはワークショップIDの指定が必要です。\n\n%s", cliUsage)
+		return 2
+	}
+	for _, id := range ids {
+		if !workshopIDRegex.MatchString(id) { // xml_manager.go
+			printCLIError(fmt.Sprintf("ワークショップID '%s' が不正です (数字のみで指定してください)。", id))
+			return 2
+		}
+	}
+
+	var playlistIDs, modIDs []string
+	switch *itemType {
+	case "playlist":
+		playlistIDs = ids
+	case "mod":
+		modIDs = ids
+	default:
+		fmt.Fprintf(os.Stderr, "--type には playlist または mod を指定してください: %s\n", *itemType)
+		return 2
+	}
+
+	if !loadConfigForCLI() {
+		return 1
+	}
+	cfg := currentConfig()
+	steamCmd := cfg.defaultSteamCmdProfile()
+	if *profileName != "" {
+		profile, ok := cfg.SteamCmdProfiles[*profileName]
+		if !ok {
+			printCLIError(fmt.Sprintf("SteamCMD プロファイル '%s' が設定ファイル (steamcmd.profiles) にありません。", *profileName))
+			return 2
+		}
+		steamCmd = profile
+	}
+	successfulPlaylistIDs, successfulModIDs, downloadErr := DownloadWorkshopItems(playlistIDs, modIDs,
+		steamCmd.WorkshopPlaylistsDir, steamCmd.WorkshopModsDir, steamCmd.AppID, steamCmd.Path, steamCmd.Login) // steamcmd_manager.go
+
+	result := struct {
+		Success       bool     `json:"success"`
+		Type          string   `json:"type"`
+		SucceededIDs  []string `json:"succeededIDs"`
+		FailedItemIDs []string `json:"failedItemIDs"`
+		Error         string   `json:"error,omitempty"`
+	}{
+		Type:          *itemType,
+		SucceededIDs:  append(successfulPlaylistIDs, successfulModIDs...),
+		FailedItemIDs: calculateFailedIDs(playlistIDs, modIDs, successfulPlaylistIDs, successfulModIDs), // process_manager.go
+	}
+	if downloadErr != nil {
+		result.Error = downloadErr.Error()
+	}
+	result.Success = downloadErr == nil && len(result.FailedItemIDs) == 0
+	printCLIJSON(result)
+	if !result.Success {
+		return 1
+	}
+	return 0
+}
+
+// --- CLI ヘルパー ---
+
+// loadConfigForCLI は、設定を読み込みます。失敗した場合は検証エラーを JSON で出力して false を返します。
+func loadConfigForCLI() bool {
+	if err := LoadConfig(); err != nil {
+		printCLIJSON(map[string]interface{}{"success": false, "errors": splitJoinedErrors(err)})
+		return false
+	}
+	return true
+}
+
+// parseInterspersedFlags は、位置引数とフラグが混在した引数 (例: "start test --config a.xml") を解析し、位置引数を返します。
+// 標準の flag パッケージは最初の位置引数で解析を止めるため、位置引数を取り除きながら繰り返し解析します。
+func parseInterspersedFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// splitJoinedErrors は、errors.Join で結合されたエラーを個々のメッセージのリストに分解します。
+func splitJoinedErrors(err error) []string {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		var messages []string
+		for _, e := range joined.Unwrap() {
+			messages = append(messages, splitJoinedErrors(e)...)
+		}
+		return messages
+	}
+	return strings.Split(err.Error(), "\n")
+}
+
+// killProcess は、指定されたPIDのプロセスを強制終了します。
+func killProcess(pid int) error {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	return process.Kill()
+}
+
+// responseSucceeded は、最終応答メッセージが成功を示しているかどうかを判定します。
+func responseSucceeded(msg WsMessage) bool {
+	if msg.Type != "response" {
+		return false
+	}
+	var payload ResponsePayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		return false
+	}
+	return payload.Success
+}
+
+// cliOutputMutex は、複数のゴルーチンからの標準出力への書き込みが混ざらないようにするためのミューテックスです。
+var cliOutputMutex sync.Mutex
+
+// printCLIJSON は、値を1行の JSON として標準出力に出力します。
+func printCLIJSON(value interface{}) {
+	cliOutputMutex.Lock()
+	defer cliOutputMutex.Unlock()
+	if err := json.NewEncoder(os.Stdout).Encode(value); err != nil {
+		cliLog.errorf("出力のエンコードに失敗しました: %v", err)
+	}
+}
+
+// printCLIMessage は、ハンドラが送信したメッセージを WebSocket と同じ形式で出力します。
+func printCLIMessage(msg WsMessage) {
+	printCLIJSON(msg)
+}
+
+// printCLIResponse は、CLI で直接組み立てた応答を "response" メッセージとして出力します。
+func printCLIResponse(payload ResponsePayload) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		cliLog.errorf("応答ペイロードのエンコードに失敗しました: %v", err)
+		return
+	}
+	printCLIMessage(WsMessage{Type: "response", Payload: payloadBytes})
+}
+
+// printCLIError は、エラーを "error" メッセージとして出力します。
+func printCLIError(message string) {
+	payloadBytes, _ := json.Marshal(ErrorResponsePayload{Message: message})
+	printCLIMessage(WsMessage{Type: "error", Payload: payloadBytes})
+}
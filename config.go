@@ -1,20 +1,30 @@
 package main
 
 import (
-	"log"      // ログ出力用
-	"os"       // 環境変数アクセス、ファイル操作用
-	"strconv"  // 文字列から数値への変換用
-	"time"     // time.Duration (ReconnectDelay) の定義用
-
-	// .env ファイルから環境変数を読み込むためのライブラリ (インストールが必要: go get github.com/joho/godotenv)
-	"github.com/joho/godotenv"
+	"errors"        // 複数の検証エラーをまとめて返すため
+	"fmt"           // エラーメッセージ生成用
+	"log/slog"      // ログレベル (slog.Level) の定義用
+	"maps"          // サブシステムごとのログレベルの結合用
+	"net"           // 管理APIの待ち受けアドレス検証用
+	"os"            // 環境変数アクセス、ファイル操作用
+	"path/filepath" // 絶対パス判定用
+	"regexp"        // プレイヤーの参加・退出の正規表現の検証用
+	"slices"        // 設定値のログ出力の並べ替え用
+	"strconv"       // 文字列から数値への変換用
+	"strings"       // メトリクスのパス検証用
+	"sync"          // 設定の再読み込み時の排他制御用
+	"time"          // time.Duration (ReconnectDelay 等) の定義用
 )
 
 // --- 定数定義 ---
 
 const (
-	// WebSocket再接続試行までの待機時間
+	// WebSocket再接続試行までの待機時間 (初回、失敗が続くと倍々に延長される。reconnect.go)
 	ReconnectDelay = 5 * time.Second
+	// WebSocket再接続試行までの待機時間の上限
+	ReconnectMaxDelay = 5 * time.Minute
+	// この時間以上続いた接続が切断された場合は、再接続の待機時間を初期値に戻す
+	ReconnectStableAfter = 1 * time.Minute
 	// サーバー設定ファイル (server_config.xml) が格納されるベースディレクトリ
 	configBaseDir = "./config"
 	// WebSocketサーバーが認証トークンを拒否した際のクローズコード (RFC 6455 Policy Violation)
@@ -24,21 +34,273 @@ const (
 	wsURLEnvKey                       = "WS_URL"                         // WebSocketサーバーのURL
 	serverExePathEnvKey               = "SERVER_EXE_PATH"                // ゲームサーバー実行ファイルのパス
 	tokenEnvKey                       = "TOKEN"                          // WebSocket接続認証用トークン
+	tokenFileEnvKey                   = "TOKEN_FILE"                     // 認証トークンを読み込むファイル (所有者のみ読み書き可能であること)
+	tokenFDEnvKey                     = "TOKEN_FD"                       // 認証トークンを読み込むファイルディスクリプタ番号
+	tokenKeyringEnvKey                = "TOKEN_KEYRING"                  // 認証トークンを OS のキーリングに保管するかどうか (true / false)
+	tokenKeyringAccountEnvKey         = "TOKEN_KEYRING_ACCOUNT"          // キーリングのアカウント名 (1台で複数の SWSC を動かす場合に指定)
 	minPortEnvKey                     = "MIN_PORT"                       // 使用するポート番号の最小値
 	maxPortEnvKey                     = "MAX_PORT"                       // 使用するポート番号の最大値
 	workshopPlaylistsInstallDirEnvKey = "WORKSHOP_PLAYLISTS_INSTALL_DIR" // ワークショップのプレイリスト(アドオン)をインストールするディレクトリパス
 	workshopModsInstallDirEnvKey      = "WORKSHOP_MODS_INSTALL_DIR"      // ワークショップのModをインストールするディレクトリパス
 	steamCmdPathEnvKey                = "STEAMCMD_PATH"                  // SteamCMD実行ファイルのパス
 	gameAppIDEnvKey                   = "GAME_APPID"                     // 対象ゲームのSteam App ID
+	steamCmdLoginEnvKey               = "STEAMCMD_LOGIN"                 // SteamCMD のログインユーザー名 (省略時は anonymous、資格情報はキャッシュ済みであること)
+	serverLauncherEnvKey              = "SERVER_LAUNCHER"                // ゲームサーバーの起動方式 (native / wine / custom)
+	winePathEnvKey                    = "WINE_PATH"                      // Wine 実行ファイルのパス (起動方式 wine の場合)
+	winePrefixEnvKey                  = "WINE_PREFIX"                    // WINEPREFIX (起動方式 wine の場合)
+	serverLauncherCommandEnvKey       = "SERVER_LAUNCHER_COMMAND"        // ラッパーコマンド (起動方式 custom の場合)
+	serverLauncherPathStyleEnvKey     = "SERVER_LAUNCHER_PATH_STYLE"     // ゲームに渡すパスの形式 (起動方式 custom の場合)
+	serverLauncherDriveEnvKey         = "SERVER_LAUNCHER_DRIVE"          // Windows形式のパスに変換する際のドライブレター
+	restartEnabledEnvKey              = "RESTART_ENABLED"                // クラッシュ時に自動再起動するかどうか (true / false)
+	restartMaxAttemptsEnvKey          = "RESTART_MAX_ATTEMPTS"           // 再起動回数の上限 (RESTART_WINDOW 内、0 は無制限)
+	restartWindowEnvKey               = "RESTART_WINDOW"                 // 再起動回数を数える期間 (例: 10m)
+	restartDelayEnvKey                = "RESTART_DELAY"                  // クラッシュ検出から再起動までの待機時間 (例: 5s)
+	adminAPIEnabledEnvKey             = "ADMIN_API_ENABLED"              // ローカル管理API (HTTP) を有効にするかどうか (true / false)
+	adminAPIAddrEnvKey                = "ADMIN_API_ADDR"                 // ローカル管理APIの待ち受けアドレス (例: 127.0.0.1:8770)
+	adminAPITokenEnvKey               = "ADMIN_API_TOKEN"                // ローカル管理APIの認証トークン (WebSocket用の TOKEN とは別)
+	metricsEnabledEnvKey              = "METRICS_ENABLED"                // Prometheus メトリクスを公開するかどうか (true / false)
+	metricsAddrEnvKey                 = "METRICS_ADDR"                   // メトリクスの待ち受けアドレス (例: 127.0.0.1:9770)
+	metricsPathEnvKey                 = "METRICS_PATH"                   // メトリクスのパス (例: /metrics)
+	logLevelEnvKey                    = "LOG_LEVEL"                      // 全体のログレベル (debug / info / warn / error)
+	logLevelsEnvKey                   = "LOG_LEVELS"                     // サブシステムごとのログレベル (例: steamcmd=warn,websocket=debug)
+	logFormatEnvKey                   = "LOG_FORMAT"                     // ログの出力形式 (text / json)
+	outboxMaxMessagesEnvKey           = "OUTBOX_MAX_MESSAGES"            // 切断中に保持する送信待ちメッセージの上限件数
+	outboxMaxAgeEnvKey                = "OUTBOX_MAX_AGE"                 // 送信待ちメッセージの保持期間 (例: 15m)
+	outboxPathEnvKey                  = "OUTBOX_PATH"                    // 送信待ちメッセージの保存先ファイル (省略時はメモリのみ)
+	heartbeatIntervalEnvKey           = "HEARTBEAT_INTERVAL"             // WebSocket の Ping 送信間隔 (例: 30s、0 で無効)
+	heartbeatTimeoutEnvKey            = "HEARTBEAT_TIMEOUT"              // Pong を待つ猶予時間 (例: 10s)
+	requestCacheTTLEnvKey             = "REQUEST_CACHE_TTL"              // 重複要求を検出するため処理済みの要求IDを記録しておく期間 (例: 10m、0 で無効)
+	signingKeyEnvKey                  = "SIGNING_KEY"                    // メッセージ署名 (HMAC-SHA256) の共有鍵 (省略時は署名なし)
+	signingMaxSkewEnvKey              = "SIGNING_MAX_SKEW"               // 署名付きメッセージのタイムスタンプの許容誤差 (例: 5m)
+	wsTLSCAFileEnvKey                 = "WS_TLS_CA_FILE"                 // WebSocket 接続で信頼する CA 証明書 (PEM) のパス
+	wsTLSCertFileEnvKey               = "WS_TLS_CERT_FILE"               // 相互 TLS のクライアント証明書 (PEM) のパス
+	wsTLSKeyFileEnvKey                = "WS_TLS_KEY_FILE"                // 相互 TLS のクライアント秘密鍵 (PEM) のパス
+	wsTLSPinnedSPKIEnvKey             = "WS_TLS_PINNED_SPKI"             // ピン留めするサーバー公開鍵の SHA-256 ハッシュ (Base64、カンマ区切り)
+	wsTLSMinVersionEnvKey             = "WS_TLS_MIN_VERSION"             // 最小 TLS バージョン (1.2 / 1.3)
+	backupEnabledEnvKey               = "BACKUP_ENABLED"                 // stopServer で設定ディレクトリを削除する前にバックアップするかどうか (true / false)
+	backupDirEnvKey                   = "BACKUP_DIR"                     // バックアップの保存先ディレクトリ
+	backupIntervalEnvKey              = "BACKUP_INTERVAL"                // 実行中のサーバーを定期的にバックアップする間隔 (例: 1h、0 で無効)
+	backupKeepEnvKey                  = "BACKUP_KEEP"                    // サーバー構成名ごとに保持するバックアップの件数 (0 は無制限)
+	backupMaxAgeEnvKey                = "BACKUP_MAX_AGE"                 // バックアップの保持期間 (例: 168h、0 は無期限)
+	crashDirEnvKey                    = "CRASH_DIR"                      // クラッシュダンプとログの保存先ディレクトリ
+	crashLogLinesEnvKey               = "CRASH_LOG_LINES"                // クラッシュ時に保存するコンソール出力の末尾の行数 (0 で保存しない)
+	crashKeepEnvKey                   = "CRASH_KEEP"                     // サーバー構成名ごとに保持するクラッシュフォルダの件数 (0 は無制限)
+	playerJoinPatternEnvKey           = "PLAYER_JOIN_PATTERN"            // プレイヤーの参加を表すゲームサーバーの出力の正規表現
+	playerLeavePatternEnvKey          = "PLAYER_LEAVE_PATTERN"           // プレイヤーの退出を表すゲームサーバーの出力の正規表現
+	idleStopTimeoutEnvKey             = "IDLE_STOP_TIMEOUT"              // プレイヤーが0人の状態がこの時間続いたサーバーを停止する (例: 30m、0 で無効)
+	schedulePathEnvKey                = "SCHEDULE_PATH"                  // サーバーの定期的な再起動・停止・起動の予定の保存先ファイル
+	scheduleWarningsEnvKey            = "SCHEDULE_WARNINGS"              // 予定された操作を予告するタイミング (例: 10m,1m、カンマ区切り)
+	statsIntervalEnvKey               = "STATS_INTERVAL"                 // サーバープロセスのリソース使用量を取得して serverStats で送信する間隔 (例: 30s、0 で無効)
+	cgroupRootEnvKey                  = "CGROUP_ROOT"                    // サーバーごとの cgroup (v2) を作成する親 cgroup のディレクトリ (Linux のみ)
+	cgroupMemoryMaxEnvKey             = "CGROUP_MEMORY_MAX"              // サーバーごとのメモリの上限 (例: 8G、0 で無制限)
+	cgroupCPUMaxEnvKey                = "CGROUP_CPU_MAX"                 // サーバーごとの CPU の上限 (コア数、例: 2、1.5、0 で無制限)
+	cgroupPidsMaxEnvKey               = "CGROUP_PIDS_MAX"                // サーバーごとのプロセス・スレッド数の上限 (0 で無制限)
 )
 
 const (
-	fallBackGameAppID = "573090"
-	fallBackWsURL     = "wss://sw-server.makkii.jp"
+	fallBackGameAppID    = "573090"
+	fallBackSteamLogin   = "anonymous"
+	fallBackWsURL        = "wss://sw-server.makkii.jp"
+	fallBackAdminAPIAddr = "127.0.0.1:8770" // 管理APIはデフォルトでローカルホストのみで待ち受ける
+	fallBackMetricsAddr  = "127.0.0.1:9770"
+	fallBackMetricsPath  = "/metrics"
+	fallBackOutboxMax    = 500
+	fallBackOutboxMaxAge = 15 * time.Minute
+	fallBackHeartbeat    = 30 * time.Second
+	fallBackHeartbeatTTL = 10 * time.Second
+	fallBackRequestCache = 10 * time.Minute
+	fallBackSigningSkew  = 5 * time.Minute
+	fallBackTLSVersion   = "1.2"
+	fallBackBackupDir    = "./backups"
+	fallBackBackupKeep   = 10
+	fallBackCrashDir     = "./crashes"
+	fallBackCrashLines   = 100
+	fallBackCrashKeep    = 20
+	fallBackSchedulePath = "./swsc_schedules.json"
+	// プレイヤーの参加・退出の行の既定の正規表現 (例: "Player joined: name" / "Client disconnected: name")
+	fallBackPlayerJoinPattern  = `(?i)\b(?:player|client|peer)\b.*?\b(?:joined|connected)\b[\s:]*(?P<name>.*?)\s*$`
+	fallBackPlayerLeavePattern = `(?i)\b(?:player|client|peer)\b.*?\b(?:left|disconnected|kicked|timed out)\b[\s:]*(?P<name>.*?)\s*$`
+	fallBackScheduleWarn       = "10m,1m"
+	fallBackStatsInterval      = 30 * time.Second
+	fallBackCgroupRoot         = "/sys/fs/cgroup/swsc"
 )
 
+// PortRange は、ゲームサーバーに割り当てるポートの範囲 (両端を含む) です。
+type PortRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// RestartPolicy は、サーバーがクラッシュした際の自動再起動ポリシーです。
+type RestartPolicy struct {
+	// Enabled が false の場合、クラッシュしても再起動しません。
+	Enabled bool `json:"enabled"`
+	// MaxAttempts は、Window の期間内に許可する再起動回数の上限です (0 は無制限)。
+	MaxAttempts int `json:"maxAttempts"`
+	// Window は、再起動回数を数える期間です。
+	Window time.Duration `json:"window"`
+	// Delay は、クラッシュ検出から再起動を試みるまでの待機時間です。
+	Delay time.Duration `json:"delay"`
+}
+
+// defaultRestartPolicy は、設定ファイルと環境変数のどちらにも指定がない場合の再起動ポリシーです (従来通り即座に無制限で再起動)。
+var defaultRestartPolicy = RestartPolicy{Enabled: true, MaxAttempts: 0, Window: 10 * time.Minute, Delay: 0}
+
+// ServerSettings は、サーバー構成名ごとに上書きされた設定です。
+type ServerSettings struct {
+	Restart     RestartPolicy
+	IdleTimeout time.Duration  // プレイヤーが0人の状態が続いた場合に停止するまでの時間 (0 は無効、players.go)
+	Limits      ResourceLimits // cgroup によるリソースの上限 (cgroup.go)
+	SteamCmd    string         // 使用する SteamCMD プロファイル名 (空の場合は既定の設定)
+}
+
+// SteamCmdProfile は、ワークショップアイテムのダウンロードに使用する SteamCMD の設定一式です。
+// 既定の設定 (steamcmd セクションと環境変数) と、steamcmd.profiles の名前付きプロファイルがあります。
+type SteamCmdProfile struct {
+	Name                 string // プロファイル名 (既定の設定は空)
+	Path                 string // SteamCMD 実行ファイルのパス
+	Login                string // ログインユーザー名 (anonymous 以外はキャッシュ済みの資格情報を使用)
+	AppID                string // ワークショップアイテムを取得するゲームの App ID
+	WorkshopPlaylistsDir string // プレイリストの配置先
+	WorkshopModsDir      string // MOD の配置先
+}
+
+// PlayerSettings は、プレイヤー数の追跡と無人時の自動停止 (players.go) の設定です。
+type PlayerSettings struct {
+	JoinPattern  string        // プレイヤーの参加を表す行の正規表現 (検証済み)
+	LeavePattern string        // プレイヤーの退出を表す行の正規表現 (検証済み)
+	IdleTimeout  time.Duration // 構成名ごとの指定がない場合の自動停止までの時間 (0 は無効)
+}
+
+// AdminAPISettings は、ローカル管理API (admin_api.go) の設定です。
+type AdminAPISettings struct {
+	Enabled bool
+	Addr    string // 待ち受けアドレス (host:port)
+	Token   string // 認証トークン (Authorization: Bearer <token>)
+}
+
+// MetricsSettings は、Prometheus メトリクス (metrics.go) の設定です。
+type MetricsSettings struct {
+	Enabled bool
+	Addr    string // 待ち受けアドレス (host:port)
+	Path    string // メトリクスを公開するパス
+}
+
+// OutboxSettings は、WebSocket 切断中の送信待ちキュー (outbox.go) の設定です。
+type OutboxSettings struct {
+	MaxMessages int           // 保持する上限件数 (超えた場合は古いものから破棄)
+	MaxAge      time.Duration // 保持期間 (過ぎたものは再送せずに破棄、0 は無期限)
+	Path        string        // 保存先ファイル (空の場合はメモリのみ)
+}
+
+// HeartbeatSettings は、WebSocket のハートビート (heartbeat.go) の設定です。
+type HeartbeatSettings struct {
+	Interval time.Duration // Ping の送信間隔 (0 の場合はハートビート無効)
+	Timeout  time.Duration // 送信間隔に加えて Pong やメッセージを待つ猶予時間
+}
+
+// TokenSourceSettings は、認証トークンの読み込み元 (token_store.go) の設定です。
+type TokenSourceSettings struct {
+	Kind    string // tokenSourceEnv / tokenSourceFile / tokenSourceFD / tokenSourceKeyring
+	Path    string // TOKEN_FILE のパス (キーリングの場合は、キーリングを使用できない場合の保管先)
+	FD      int    // TOKEN_FD のファイルディスクリプタ番号
+	Account string // キーリングのアカウント名
+}
+
+// TLSSettings は、WebSocket 接続の TLS (tls_config.go) の設定です。
+type TLSSettings struct {
+	CAFile     string   // 信頼する CA 証明書 (空の場合はシステムの CA のみ)
+	CertFile   string   // クライアント証明書 (空の場合は提示しない)
+	KeyFile    string   // クライアント秘密鍵
+	PinnedSPKI []string // ピン留めするサーバー公開鍵の SHA-256 ハッシュ (Base64)
+	MinVersion uint16   // 最小 TLS バージョン (tls.VersionTLS12 など)
+}
+
+// SigningSettings は、メッセージ署名 (signing.go) の設定です。
+type SigningSettings struct {
+	Key     string        // HMAC-SHA256 の共有鍵 (空の場合は署名なし)
+	MaxSkew time.Duration // タイムスタンプの許容誤差 (この範囲内でノンスの重複を検出する)
+}
+
+// BackupSettings は、サーバーの設定ディレクトリのバックアップ (backup.go) の設定です。
+type BackupSettings struct {
+	Enabled  bool          // stopServer で設定ディレクトリを削除する前にバックアップするかどうか
+	Dir      string        // 保存先ディレクトリ (絶対パス)
+	Interval time.Duration // 実行中のサーバーの定期バックアップの間隔 (0 は無効)
+	Keep     int           // サーバー構成名ごとに保持する件数 (0 は無制限)
+	MaxAge   time.Duration // 保持期間 (0 は無期限)
+}
+
+// CrashSettings は、クラッシュ情報の収集 (crash.go) の設定です。
+type CrashSettings struct {
+	Dir      string // 保存先ディレクトリ (絶対パス)
+	LogLines int    // 保存するコンソール出力の末尾の行数 (0 は保存しない)
+	Keep     int    // サーバー構成名ごとに保持する件数 (0 は無制限)
+}
+
+// ScheduleSettings は、サーバーの定期的な再起動・停止・起動 (schedule.go) の設定です。
+type ScheduleSettings struct {
+	Path     string          // 予定の保存先ファイル (絶対パス)
+	Warnings []time.Duration // 予定ごとに指定がない場合に予告するタイミング (実行の何分前か)
+}
+
+// StatsSettings は、サーバープロセスのリソース使用量の取得 (stats.go) の設定です。
+type StatsSettings struct {
+	Interval time.Duration // 取得して serverStats メッセージで送信する間隔 (0 は無効)
+}
+
+// ResourceLimits は、ゲームサーバープロセスのリソースの上限 (cgroup.go) です。各値の 0 は無制限です。
+type ResourceLimits struct {
+	MemoryMax uint64  // メモリの上限 (バイト)
+	CPUMax    float64 // CPU の上限 (コア数、例: 1.5)
+	PidsMax   int     // プロセス・スレッド数の上限
+}
+
+// CgroupSettings は、cgroup v2 によるリソース制限 (cgroup.go) の設定です。
+type CgroupSettings struct {
+	Root   string         // サーバーごとの cgroup を作成する親 cgroup のディレクトリ
+	Limits ResourceLimits // 構成名ごとの指定がない場合の上限
+}
+
+// minSigningKeyLength は、署名鍵の最小の長さ (バイト) です。
+const minSigningKeyLength = 16
+
+// AppConfig は、設定ファイルと環境変数から組み立てた設定値一式です。
+// buildConfig で検証済みの値が生成され、applyConfig でグローバル設定変数に反映されます。
+type AppConfig struct {
+	ConfigFilePath              string // 読み込んだ設定ファイルのパス (なければ空)
+	WsURL                       string
+	ServerExePath               string
+	AuthToken                   string
+	TokenSource                 TokenSourceSettings
+	PortPools                   []PortRange
+	WorkshopPlaylistsInstallDir string
+	WorkshopModsInstallDir      string
+	SteamCmdPath                string
+	GameAppID                   string
+	SteamCmdLogin               string
+	SteamCmdProfiles            map[string]SteamCmdProfile // 名前付きの SteamCMD プロファイル (キー: プロファイル名)
+	Launcher                    ServerLauncher
+	DefaultRestartPolicy        RestartPolicy
+	ServerOverrides             map[string]ServerSettings
+	AdminAPI                    AdminAPISettings
+	Metrics                     MetricsSettings
+	Logging                     LoggingSettings // logger.go
+	Outbox                      OutboxSettings
+	Heartbeat                   HeartbeatSettings
+	RequestCacheTTL             time.Duration // 処理済みの要求IDを記録しておく期間 (idempotency.go、0 は無効)
+	Signing                     SigningSettings
+	TLS                         TLSSettings
+	Backup                      BackupSettings
+	Crash                       CrashSettings
+	Schedule                    ScheduleSettings
+	Players                     PlayerSettings
+	Stats                       StatsSettings
+	Cgroup                      CgroupSettings
+}
+
 // --- グローバル設定変数 ---
-// LoadConfig() によって環境変数から読み込まれた値が格納される
+// LoadConfig() によって設定ファイルと環境変数から読み込まれた値が格納される
 
 var (
 	// WebSocketサーバーの接続先URL
@@ -47,10 +309,12 @@ var (
 	ServerExePath string
 	// WebSocket接続時に使用する認証トークン
 	AuthToken string
-	// ゲームサーバーが使用するポート番号の範囲 (最小値)
+	// ゲームサーバーが使用するポート番号の範囲 (全ポートプールの最小値)
 	MinPort int
-	// ゲームサーバーが使用するポート番号の範囲 (最大値)
+	// ゲームサーバーが使用するポート番号の範囲 (全ポートプールの最大値)
 	MaxPort int
+	// ゲームサーバーに割り当てるポートプールのリスト (port_manager.go で使用)
+	PortPools []PortRange
 	// SteamCMDがワークショップのプレイリストを配置するディレクトリ
 	WorkshopPlaylistsInstallDir string
 	// SteamCMDがワークショップのMODを配置するディレクトリ
@@ -59,126 +323,1134 @@ var (
 	SteamCmdPath string
 	// SteamCMDがワークショップアイテムをダウンロードする対象のゲームApp ID
 	GameAppID string
+	// ゲームサーバーの起動方式 (launcher.go)
+	ActiveLauncher ServerLauncher
+	// サーバー構成名ごとの上書き設定がない場合に使う再起動ポリシー
+	DefaultRestartPolicy RestartPolicy
+	// サーバー構成名ごとの上書き設定 (キー: 構成名)
+	ServerOverrides map[string]ServerSettings
+	// 読み込んだ設定ファイルのパス (設定ファイルを使っていない場合は空)
+	LoadedConfigFile string
+
+	// currentAppConfig は、現在適用されている設定値一式です (設定の再読み込みで差し替えられます)。
+	// 他のゴルーチンから読み取る場合は currentConfig() を使用してください。
+	currentAppConfig *AppConfig
+	// configMutex は、設定の再読み込み中にグローバル設定変数と currentAppConfig を保護するためのミューテックスです。
+	configMutex sync.RWMutex
 )
 
-// LoadConfig は、アプリケーション起動時に環境変数から設定値を読み込み、検証する関数。
-// 必須の設定値が不足しているか、形式が無効な場合は log.Fatalf でプログラムを終了させる。
-func LoadConfig() {
+// LoadConfig は、アプリケーション起動時に設定ファイルと環境変数から設定値を読み込み、検証する関数。
+// 設定ファイル (YAML / TOML) の値を基本とし、同じ項目の環境変数が設定されていれば環境変数の値を優先する。
+// 検証エラーは最初の1件で止めず、全て収集してまとめて返す (呼び出し元でプログラムを終了させる)。
+// Returns:
+//
+//	error: 設定ファイルの読み込みに失敗した場合、または検証エラーがあった場合のエラー (errors.Join で結合済み)。
+func LoadConfig() error {
 	// 1. .env ファイルの読み込み試行
 	// カレントディレクトリに .env ファイルがあれば、その内容を環境変数として読み込む。
 	// ファイルが存在しなくてもエラーにはせず、環境変数が直接設定されていればそちらを優先する。
-	err := godotenv.Load()
+	loadDotEnv() // config_reload.go
+	configLog.infof("設定ファイルと環境変数を読み込み、検証します...")
+
+	// 2. 設定値の組み立てと検証
+	cfg, err := buildConfig()
 	if err != nil {
-		// .env ファイルが見つからない場合は通常動作なので、エラーログは出さない。
-		// 読み込み自体に失敗した場合（例: フォーマットエラー）はログを出しても良いが、必須ではない。
-		// log.Printf("[設定] .envファイルの読み込み中にエラーが発生しました (無視されます): %v", err)
+		return err
 	}
-	log.Println("[設定] 環境変数を読み込み、検証します...")
 
-	// 2. 各設定値を環境変数から読み込み、必須チェックと型検証を行う
+	// 3. グローバル設定変数への反映と読み込み完了ログの出力
+	applyConfig(cfg)
+	logConfig(cfg)
+	return nil
+}
+
+// buildConfig は、設定ファイルと環境変数から AppConfig を組み立て、検証します。
+// グローバル設定変数は変更しません。
+// Returns:
+//
+//	*AppConfig: 検証済みの設定値。エラーがある場合は nil。
+//	error: 全ての検証エラーを errors.Join で結合したエラー。
+func buildConfig() (*AppConfig, error) {
+	var errs []error // 検証エラーの収集用
+	cfg := &AppConfig{}
 
-	// WebSocket URL の読み込みと必須チェック
-	WsURL = os.Getenv(wsURLEnvKey)
-	if WsURL == "" {
-		WsURL = fallBackWsURL
+	// 設定ファイルの検索と読み込み (設定ファイルは任意)
+	file := &fileConfig{}
+	configFilePath, err := findConfigFile() // config_file.go
+	if err != nil {
+		return nil, err
+	}
+	if configFilePath != "" {
+		file, err = readConfigFile(configFilePath) // config_file.go
+		if err != nil {
+			// 設定ファイル自体が読めない場合は、以降の検証が無意味なのでここで返す
+			return nil, err
+		}
+		cfg.ConfigFilePath = configFilePath
+	}
+
+	// WebSocket URL の読み込み (未指定ならデフォルト)
+	cfg.WsURL = settingValue(wsURLEnvKey, file.WebSocket.URL)
+	if cfg.WsURL == "" {
+		cfg.WsURL = fallBackWsURL
 	}
 
 	// サーバー実行ファイルパスの読み込みと必須チェック
-	ServerExePath = os.Getenv(serverExePathEnvKey)
-	if ServerExePath == "" {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' が設定されていません。", serverExePathEnvKey)
+	cfg.ServerExePath = settingValue(serverExePathEnvKey, file.Server.ExePath)
+	if cfg.ServerExePath == "" {
+		errs = append(errs, fmt.Errorf("'%s' (server.exe_path) が設定されていません", serverExePathEnvKey))
+	} else if _, err := os.Stat(cfg.ServerExePath); os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("'%s' で指定されたファイル '%s' が見つかりません", serverExePathEnvKey, cfg.ServerExePath))
 	}
-	// ファイルの存在確認
-	if _, err := os.Stat(ServerExePath); os.IsNotExist(err) {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' で指定されたファイル '%s' が見つかりません。", serverExePathEnvKey, ServerExePath)
+
+	// 認証トークンの読み込み元の決定と、トークンの読み込み・必須チェック (token_store.go)
+	tokenSource, tokenErrs := buildTokenSourceSettings(file.WebSocket)
+	cfg.TokenSource = tokenSource
+	errs = append(errs, tokenErrs...)
+	if len(tokenErrs) == 0 {
+		token, err := loadAuthToken(tokenSource, settingValue(tokenEnvKey, file.WebSocket.Token))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("認証トークンを %s から読み込めません: %w", formatTokenSource(tokenSource), err))
+		} else if token == "" {
+			errs = append(errs, fmt.Errorf("'%s' (websocket.token) が設定されていません (%s / %s / %s でも指定できます)",
+				tokenEnvKey, tokenFileEnvKey, tokenFDEnvKey, tokenKeyringEnvKey))
+		}
+		cfg.AuthToken = token
 	}
 
-	// 認証トークンの読み込みと必須チェック
-	AuthToken = os.Getenv(tokenEnvKey)
-	if AuthToken == "" {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' が設定されていません。", tokenEnvKey)
+	// ポートプールの読み込みと検証
+	pools, poolErrs := buildPortPools(file.Ports)
+	cfg.PortPools = pools
+	errs = append(errs, poolErrs...)
+
+	// ワークショップ プレイリスト / MOD ディレクトリの読み込みと必須チェック
+	cfg.WorkshopPlaylistsInstallDir = settingValue(workshopPlaylistsInstallDirEnvKey, file.SteamCmd.WorkshopPlaylistsDir)
+	errs = append(errs, validateAbsDir(workshopPlaylistsInstallDirEnvKey, "steamcmd.workshop_playlists_dir", cfg.WorkshopPlaylistsInstallDir)...)
+	cfg.WorkshopModsInstallDir = settingValue(workshopModsInstallDirEnvKey, file.SteamCmd.WorkshopModsDir)
+	errs = append(errs, validateAbsDir(workshopModsInstallDirEnvKey, "steamcmd.workshop_mods_dir", cfg.WorkshopModsInstallDir)...)
+
+	// SteamCMD パスの読み込みと必須チェック
+	cfg.SteamCmdPath = settingValue(steamCmdPathEnvKey, file.SteamCmd.Path)
+	if cfg.SteamCmdPath == "" {
+		errs = append(errs, fmt.Errorf("'%s' (steamcmd.path) が設定されていません", steamCmdPathEnvKey))
+	} else if _, err := os.Stat(cfg.SteamCmdPath); os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("'%s' で指定されたファイル '%s' が見つかりません", steamCmdPathEnvKey, cfg.SteamCmdPath))
 	}
 
-	// 最小ポート番号の読み込み、必須チェック、数値変換チェック
-	minPortStr := os.Getenv(minPortEnvKey)
-	if minPortStr == "" {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' が設定されていません。", minPortEnvKey)
+	// ゲーム App ID の読み込み (未指定または数値でなければデフォルト)
+	cfg.GameAppID = settingValue(gameAppIDEnvKey, file.SteamCmd.AppID)
+	if _, err := strconv.Atoi(cfg.GameAppID); err != nil { // App IDが数値形式であるかを確認
+		cfg.GameAppID = fallBackGameAppID
 	}
-	MinPort, err = strconv.Atoi(minPortStr) // 文字列を整数(int)に変換
+	cfg.SteamCmdLogin = settingValue(steamCmdLoginEnvKey, file.SteamCmd.Login)
+	if cfg.SteamCmdLogin == "" {
+		cfg.SteamCmdLogin = fallBackSteamLogin
+	}
+
+	// 名前付きの SteamCMD プロファイルの読み込みと検証 (未指定の項目は既定の設定を引き継ぐ)
+	profiles, profileErrs := buildSteamCmdProfiles(cfg.defaultSteamCmdProfile(), file.SteamCmd.Profiles)
+	cfg.SteamCmdProfiles = profiles
+	errs = append(errs, profileErrs...)
+
+	// ゲームサーバーの起動方式の読み込みと検証
+	launcher := file.Server.Launcher
+	cfg.Launcher, err = newServerLauncher(
+		settingValue(serverLauncherEnvKey, launcher.Type),
+		settingValue(winePathEnvKey, launcher.WinePath),
+		settingValue(winePrefixEnvKey, launcher.WinePrefix),
+		settingValue(serverLauncherCommandEnvKey, launcher.Command),
+		settingValue(serverLauncherPathStyleEnvKey, launcher.PathStyle),
+		settingValue(serverLauncherDriveEnvKey, launcher.Drive),
+	) // launcher.go
 	if err != nil {
-		// Atoi でエラーが発生した場合 (文字列が数値でない場合)
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' ('%s') が有効な数値ではありません: %v", minPortEnvKey, minPortStr, err)
+		errs = append(errs, fmt.Errorf("起動方式の設定 ('%s', server.launcher) が不正です: %w", serverLauncherEnvKey, err))
 	}
 
-	// 最大ポート番号の読み込み、必須チェック、数値変換チェック
-	maxPortStr := os.Getenv(maxPortEnvKey)
-	if maxPortStr == "" {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' が設定されていません。", maxPortEnvKey)
+	// 再起動ポリシーの読み込みと検証 (設定ファイル -> 環境変数 の順に上書き)
+	policy, policyErrs := mergeRestartPolicy(defaultRestartPolicy, file.Restart, "restart")
+	errs = append(errs, policyErrs...)
+	policy, policyErrs = applyRestartPolicyEnv(policy)
+	errs = append(errs, policyErrs...)
+	cfg.DefaultRestartPolicy = policy
+
+	// プレイヤー数の追跡と無人時の自動停止の読み込みと検証
+	players, playerErrs := buildPlayerSettings(file.Players)
+	cfg.Players = players
+	errs = append(errs, playerErrs...)
+
+	// cgroup によるリソース制限の読み込みと検証
+	cgroup, cgroupErrs := buildCgroupSettings(file.Cgroup)
+	cfg.Cgroup = cgroup
+	errs = append(errs, cgroupErrs...)
+
+	// サーバー構成名ごとの上書き設定 (未指定の項目は全体の設定を引き継ぐ)
+	cfg.ServerOverrides = make(map[string]ServerSettings, len(file.Servers))
+	for name, serverFile := range file.Servers {
+		serverPolicy, serverErrs := mergeRestartPolicy(cfg.DefaultRestartPolicy, serverFile.Restart, fmt.Sprintf("servers.%s.restart", name))
+		errs = append(errs, serverErrs...)
+		// 環境変数 RESTART_* はサーバーごとの設定よりも優先する (値のエラーは全体の設定で報告済み)
+		serverPolicy, _ = applyRestartPolicyEnv(serverPolicy)
+		idleTimeout := cfg.Players.IdleTimeout
+		if serverFile.IdleTimeout != "" {
+			timeout, err := parsePositiveDuration(serverFile.IdleTimeout)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("servers.%s.idle_timeout が不正です: %w", name, err))
+			}
+			idleTimeout = timeout
+		}
+		limits, limitErrs := mergeResourceLimits(cfg.Cgroup.Limits, serverFile.Limits, fmt.Sprintf("servers.%s.limits", name))
+		errs = append(errs, limitErrs...)
+		if _, ok := cfg.SteamCmdProfiles[serverFile.SteamCmd]; serverFile.SteamCmd != "" && !ok {
+			errs = append(errs, fmt.Errorf("servers.%s.steamcmd で指定された SteamCMD プロファイル '%s' が steamcmd.profiles にありません", name, serverFile.SteamCmd))
+		}
+		cfg.ServerOverrides[name] = ServerSettings{Restart: serverPolicy, IdleTimeout: idleTimeout, Limits: limits, SteamCmd: serverFile.SteamCmd}
 	}
-	MaxPort, err = strconv.Atoi(maxPortStr) // 文字列を整数(int)に変換
-	if err != nil {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' ('%s') が有効な数値ではありません: %v", maxPortEnvKey, maxPortStr, err)
+
+	// ローカル管理APIの読み込みと検証
+	adminAPI, adminErrs := buildAdminAPISettings(file.AdminAPI)
+	cfg.AdminAPI = adminAPI
+	errs = append(errs, adminErrs...)
+
+	// Prometheus メトリクスの読み込みと検証
+	metrics, metricsErrs := buildMetricsSettings(file.Metrics)
+	cfg.Metrics = metrics
+	errs = append(errs, metricsErrs...)
+
+	// ログ出力設定の読み込みと検証
+	logging, loggingErrs := buildLoggingSettings(file.Logging)
+	cfg.Logging = logging
+	errs = append(errs, loggingErrs...)
+
+	// WebSocket ハートビートの読み込みと検証
+	heartbeat, heartbeatErrs := buildHeartbeatSettings(file.WebSocket)
+	cfg.Heartbeat = heartbeat
+	errs = append(errs, heartbeatErrs...)
+
+	// 重複要求の検出期間の読み込みと検証
+	requestCacheTTL, requestCacheErrs := buildRequestCacheTTL(file.WebSocket)
+	cfg.RequestCacheTTL = requestCacheTTL
+	errs = append(errs, requestCacheErrs...)
+
+	// WebSocket 接続の TLS 設定の読み込みと検証
+	tlsSettings, tlsErrs := buildTLSSettings(file.WebSocket.TLS)
+	cfg.TLS = tlsSettings
+	errs = append(errs, tlsErrs...)
+
+	// メッセージ署名の読み込みと検証
+	signing, signingErrs := buildSigningSettings(file.Signing)
+	cfg.Signing = signing
+	errs = append(errs, signingErrs...)
+
+	// 送信待ちキューの読み込みと検証
+	outbox, outboxErrs := buildOutboxSettings(file.Outbox)
+	cfg.Outbox = outbox
+	errs = append(errs, outboxErrs...)
+
+	// バックアップの読み込みと検証
+	backup, backupErrs := buildBackupSettings(file.Backup)
+	cfg.Backup = backup
+	errs = append(errs, backupErrs...)
+
+	// クラッシュ情報の収集の読み込みと検証
+	crash, crashErrs := buildCrashSettings(file.Crash)
+	cfg.Crash = crash
+	errs = append(errs, crashErrs...)
+
+	// 予定の読み込みと検証
+	schedule, scheduleErrs := buildScheduleSettings(file.Schedule)
+	cfg.Schedule = schedule
+	errs = append(errs, scheduleErrs...)
+
+	// リソース使用量の取得設定の読み込みと検証
+	stats, statsErrs := buildStatsSettings(file.Stats)
+	cfg.Stats = stats
+	errs = append(errs, statsErrs...)
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
 	}
+	return cfg, nil
+}
+
+// applyConfig は、検証済みの設定値をグローバル設定変数に反映します。
+func applyConfig(cfg *AppConfig) {
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	currentAppConfig = cfg
+	LoadedConfigFile = cfg.ConfigFilePath
+	WsURL = cfg.WsURL
+	ServerExePath = cfg.ServerExePath
+	AuthToken = cfg.AuthToken
+	PortPools = cfg.PortPools
+	MinPort, MaxPort = portPoolBounds(cfg.PortPools)
+	WorkshopPlaylistsInstallDir = cfg.WorkshopPlaylistsInstallDir
+	WorkshopModsInstallDir = cfg.WorkshopModsInstallDir
+	SteamCmdPath = cfg.SteamCmdPath
+	GameAppID = cfg.GameAppID
+	ActiveLauncher = cfg.Launcher
+	DefaultRestartPolicy = cfg.DefaultRestartPolicy
+	ServerOverrides = cfg.ServerOverrides
+	applyLoggingSettings(cfg.Logging) // logger.go
+}
 
-	// ワークショップ プレイリスト ディレクトリの読み込みと必須チェック
-	WorkshopPlaylistsInstallDir = os.Getenv(workshopPlaylistsInstallDirEnvKey)
-	if WorkshopPlaylistsInstallDir == "" {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' が設定されていません。", workshopPlaylistsInstallDirEnvKey)
+// logConfig は、読み込んだ設定値をコンソールに出力します (トークン自体はセキュリティのため出力しない)。
+func logConfig(cfg *AppConfig) {
+	configLog.infof("設定の読み込みと検証が完了しました:")
+	if cfg.ConfigFilePath != "" {
+		configLog.infof("設定ファイル: %s", cfg.ConfigFilePath)
 	}
-	// パスが有効かどうかの簡易チェック
-	if !filepath.IsAbs(WorkshopPlaylistsInstallDir) {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' ('%s') は絶対パスで指定する必要があります。", workshopPlaylistsInstallDirEnvKey, WorkshopPlaylistsInstallDir)
+	if cfg.WsURL != fallBackWsURL {
+		configLog.infof("WebSocket URL (%s): %s", wsURLEnvKey, cfg.WsURL)
+	}
+	configLog.infof("サーバー実行ファイルパス (%s): %s", serverExePathEnvKey, cfg.ServerExePath)
+	configLog.infof("認証トークン (%s): 設定済み", formatTokenSource(cfg.TokenSource)) // 値自体は表示しない (token_store.go)
+	for _, pool := range cfg.PortPools {
+		configLog.infof("ポート範囲: %d - %d", pool.Min, pool.Max)
+	}
+	configLog.infof("ワークショップ プレイリスト ディレクトリ (%s): %s", workshopPlaylistsInstallDirEnvKey, cfg.WorkshopPlaylistsInstallDir)
+	configLog.infof("ワークショップ MOD ディレクトリ (%s): %s", workshopModsInstallDirEnvKey, cfg.WorkshopModsInstallDir)
+	configLog.infof("SteamCMD パス (%s): %s", steamCmdPathEnvKey, cfg.SteamCmdPath)
+	if cfg.GameAppID != fallBackGameAppID {
+		configLog.infof("ゲーム App ID (%s): %s", gameAppIDEnvKey, cfg.GameAppID)
+	}
+	if cfg.SteamCmdLogin != fallBackSteamLogin {
+		configLog.infof("SteamCMD ログイン (%s): %s", steamCmdLoginEnvKey, cfg.SteamCmdLogin)
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.SteamCmdProfiles)) {
+		configLog.infof("SteamCMD プロファイル '%s': %s", name, formatSteamCmdProfile(cfg.SteamCmdProfiles[name]))
+	}
+	configLog.infof("起動方式 (%s): %s", serverLauncherEnvKey, cfg.Launcher.Name())
+	configLog.infof("再起動ポリシー: 有効=%v, 上限=%d回/%v, 待機=%v",
+		cfg.DefaultRestartPolicy.Enabled, cfg.DefaultRestartPolicy.MaxAttempts, cfg.DefaultRestartPolicy.Window, cfg.DefaultRestartPolicy.Delay)
+	for name, settings := range cfg.ServerOverrides {
+		configLog.infof("サーバー '%s' の再起動ポリシー: 有効=%v, 上限=%d回/%v, 待機=%v",
+			name, settings.Restart.Enabled, settings.Restart.MaxAttempts, settings.Restart.Window, settings.Restart.Delay)
+		if settings.IdleTimeout != cfg.Players.IdleTimeout {
+			configLog.infof("サーバー '%s' の無人時の自動停止: %s", name, formatIdleTimeout(settings.IdleTimeout)) // players.go
+		}
+		if settings.Limits != cfg.Cgroup.Limits {
+			configLog.infof("サーバー '%s' のリソースの上限: %s", name, formatResourceLimits(settings.Limits)) // cgroup.go
+		}
+		if settings.SteamCmd != "" {
+			configLog.infof("サーバー '%s' の SteamCMD プロファイル: %s", name, settings.SteamCmd)
+		}
+	}
+	configLog.infof("無人時の自動停止 (%s): %s", idleStopTimeoutEnvKey, formatIdleTimeout(cfg.Players.IdleTimeout))
+	configLog.infof("リソース使用量 (%s): %s", statsIntervalEnvKey, formatStatsSettings(cfg.Stats)) // stats.go
+	configLog.infof("リソースの上限: %s", formatCgroupSettings(cfg.Cgroup))                         // cgroup.go
+	if cfg.AdminAPI.Enabled {
+		configLog.infof("ローカル管理API (%s): %s (認証トークン設定済み)", adminAPIAddrEnvKey, cfg.AdminAPI.Addr)
+	}
+	if cfg.Metrics.Enabled {
+		configLog.infof("Prometheus メトリクス (%s): %s%s", metricsAddrEnvKey, cfg.Metrics.Addr, cfg.Metrics.Path)
+	}
+	configLog.infof("WebSocket の TLS 設定: %s", formatTLSSettings(cfg.TLS)) // tls_config.go
+	if cfg.Heartbeat.Interval > 0 {
+		configLog.infof("ハートビート (%s / %s): 間隔=%v, 猶予=%v", heartbeatIntervalEnvKey, heartbeatTimeoutEnvKey, cfg.Heartbeat.Interval, cfg.Heartbeat.Timeout)
+	} else {
+		configLog.infof("ハートビート (%s): 無効", heartbeatIntervalEnvKey)
+	}
+	if cfg.RequestCacheTTL > 0 {
+		configLog.infof("重複要求の検出 (%s): 処理済みの要求IDを %v 記録", requestCacheTTLEnvKey, cfg.RequestCacheTTL)
+	} else {
+		configLog.infof("重複要求の検出 (%s): 無効", requestCacheTTLEnvKey)
+	}
+	if cfg.Signing.Key != "" {
+		configLog.infof("メッセージ署名 (%s): 有効 (タイムスタンプの許容誤差: %v)", signingKeyEnvKey, cfg.Signing.MaxSkew)
+	}
+	configLog.infof("送信待ちキュー: %s", formatOutboxSettings(cfg.Outbox)) // outbox.go
+	configLog.infof("バックアップ: %s", formatBackupSettings(cfg.Backup))  // backup.go
+	configLog.infof("クラッシュ情報: %s", formatCrashSettings(cfg.Crash))   // crash.go
+	configLog.infof("予定: %s", formatScheduleSettings(cfg.Schedule))  // schedule.go
+	configLog.infof("ログ出力 (%s / %s): %s, %s", logFormatEnvKey, logLevelEnvKey, cfg.Logging.Format, cfg.Logging.Level)
+	if len(cfg.Logging.SubsystemLevels) > 0 {
+		configLog.infof("サブシステムごとのログレベル (%s): %s", logLevelsEnvKey, formatSubsystemLevels(cfg.Logging.SubsystemLevels))
 	}
+}
 
-	// ワークショップ MOD ディレクトリの読み込みと必須チェック
-	WorkshopModsInstallDir = os.Getenv(workshopModsInstallDirEnvKey)
-	if WorkshopModsInstallDir == "" {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' が設定されていません。", workshopModsInstallDirEnvKey)
+// currentConfig は、現在適用されている設定値一式を返します。
+// 返された AppConfig は変更されないため、ロックなしで参照できます。
+func currentConfig() *AppConfig {
+	configMutex.RLock()
+	defer configMutex.RUnlock()
+	return currentAppConfig
+}
+
+// restartPolicyFor は、指定されたサーバー構成名に適用する再起動ポリシーを返します。
+// 構成名ごとの上書き設定があればそれを、なければ全体の設定を返します。
+func restartPolicyFor(name string) RestartPolicy {
+	cfg := currentConfig()
+	if settings, ok := cfg.ServerOverrides[name]; ok {
+		return settings.Restart
+	}
+	return cfg.DefaultRestartPolicy
+}
+
+// --- 設定値の読み込み・検証ヘルパー ---
+
+// settingValue は、環境変数が設定されていればその値を、なければ設定ファイルの値を返します。
+func settingValue(envKey string, fileValue string) string {
+	if value := os.Getenv(envKey); value != "" {
+		return value
+	}
+	return fileValue
+}
+
+// validateAbsDir は、ディレクトリパスの必須チェックと絶対パスチェックを行います。
+func validateAbsDir(envKey string, fileKey string, path string) []error {
+	if path == "" {
+		return []error{fmt.Errorf("'%s' (%s) が設定されていません", envKey, fileKey)}
 	}
 	// パスが有効かどうかの簡易チェック
-	if !filepath.IsAbs(WorkshopModsInstallDir) {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' ('%s') は絶対パスで指定する必要があります。", workshopModsInstallDirEnvKey, WorkshopModsInstallDir)
+	if !filepath.IsAbs(path) {
+		return []error{fmt.Errorf("'%s' ('%s') は絶対パスで指定する必要があります", envKey, path)}
 	}
+	return nil
+}
 
-	// SteamCMD パスの読み込みと必須チェック
-	SteamCmdPath = os.Getenv(steamCmdPathEnvKey)
-	if SteamCmdPath == "" {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' が設定されていません。", steamCmdPathEnvKey)
-	}
-	// ファイルの存在確認
-	if _, err := os.Stat(SteamCmdPath); os.IsNotExist(err) {
-		log.Fatalf("[設定] 致命的エラー: 環境変数 '%s' で指定されたファイル '%s' が見つかりません。", steamCmdPathEnvKey, SteamCmdPath)
-	}
-
-	// ゲーム App ID の読み込み、必須チェック、数値変換チェック
-	GameAppID = os.Getenv(gameAppIDEnvKey)
-	if GameAppID == "" {
-		GameAppID = fallBackGameAppID
-	}
-	if _, err := strconv.Atoi(GameAppID); err != nil { // App IDが数値形式であるかを確認
-		GameAppID = fallBackGameAppID
-	}
-
-	// 3. ポート範囲の論理的な検証
-	// 最小ポートが最大ポートより大きい場合は不正
-	if MinPort > MaxPort {
-		log.Fatalf("[設定] 致命的エラー: ポート範囲が無効です。最小ポート (%d) が最大ポート (%d) より大きいです。", MinPort, MaxPort)
-	}
-	// 一般的にウェルノウンポート(0-1023)は避け、最大ポート番号(65535)を超えないようにする
-	if MinPort < 1024 || MaxPort > 65535 {
-		// 警告ではなく致命的エラーとして扱う
-		log.Fatalf("[設定] 致命的エラー: 指定されたポート範囲 (%d-%d) が不正です。1024から65535の間で指定してください。", MinPort, MaxPort)
-	}
-
-	// 4. 読み込み完了ログの出力
-	// 読み込んだ設定値をコンソールに出力する (トークン自体はセキュリティのため出力しない)
-	log.Println("[設定] 設定の読み込みと検証が完了しました:")
-	if WsURL != fallBackWsURL {log.Printf("  WebSocket URL (%s): %s", wsURLEnvKey, WsURL)}
-	log.Printf("  サーバー実行ファイルパス (%s): %s", serverExePathEnvKey, ServerExePath)
-	log.Printf("  認証トークン (%s): 設定済み", tokenEnvKey) // 値自体は表示しない
-	log.Printf("  ポート範囲 (%s-%s): %d - %d", minPortEnvKey, maxPortEnvKey, MinPort, MaxPort)
-	log.Printf("  ワークショップ プレイリスト ディレクトリ (%s): %s", workshopPlaylistsInstallDirEnvKey, WorkshopPlaylistsInstallDir)
-	log.Printf("  ワークショップ MOD ディレクトリ (%s): %s", workshopModsInstallDirEnvKey, WorkshopModsInstallDir)
-	log.Printf("  SteamCMD パス (%s): %s", steamCmdPathEnvKey, SteamCmdPath)
-	if GameAppID != fallBackGameAppID {log.Printf("  ゲーム App ID (%s): %s", gameAppIDEnvKey, GameAppID)}
-}
\ No newline at end of file
+// buildPortPools は、設定ファイルと環境変数からポートプールのリストを組み立て、検証します。
+// 環境変数 MIN_PORT / MAX_PORT が設定されている場合は、設定ファイルの min / max を上書きします。
+func buildPortPools(ports filePortsConfig) ([]PortRange, []error) {
+	var errs []error
+
+	minPort, maxPort := ports.Min, ports.Max
+	if value := os.Getenv(minPortEnvKey); value != "" {
+		parsed, err := strconv.Atoi(value) // 文字列を整数(int)に変換
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') が有効な数値ではありません: %v", minPortEnvKey, value, err))
+		}
+		minPort = parsed
+	}
+	if value := os.Getenv(maxPortEnvKey); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') が有効な数値ではありません: %v", maxPortEnvKey, value, err))
+		}
+		maxPort = parsed
+	}
+
+	var pools []PortRange
+	if minPort != 0 || maxPort != 0 {
+		pools = append(pools, PortRange{Min: minPort, Max: maxPort})
+	}
+	for _, pool := range ports.Pools {
+		pools = append(pools, PortRange{Min: pool.Min, Max: pool.Max})
+	}
+	if len(pools) == 0 && len(errs) == 0 {
+		errs = append(errs, fmt.Errorf("ポート範囲が設定されていません ('%s' / '%s' または ports.min / ports.max / ports.pools)", minPortEnvKey, maxPortEnvKey))
+	}
+
+	for i, pool := range pools {
+		// 最小ポートが最大ポートより大きい場合は不正
+		if pool.Min > pool.Max {
+			errs = append(errs, fmt.Errorf("ポート範囲が無効です。最小ポート (%d) が最大ポート (%d) より大きいです", pool.Min, pool.Max))
+		}
+		// 一般的にウェルノウンポート(0-1023)は避け、最大ポート番号(65535)を超えないようにする
+		if pool.Min < 1024 || pool.Max > 65535 {
+			errs = append(errs, fmt.Errorf("指定されたポート範囲 (%d-%d) が不正です。1024から65535の間で指定してください", pool.Min, pool.Max))
+		}
+		// ポートプール同士の重複チェック
+		for _, other := range pools[:i] {
+			if pool.Min <= other.Max && other.Min <= pool.Max {
+				errs = append(errs, fmt.Errorf("ポート範囲 (%d-%d) と (%d-%d) が重複しています", pool.Min, pool.Max, other.Min, other.Max))
+			}
+		}
+	}
+	return pools, errs
+}
+
+// buildAdminAPISettings は、設定ファイルと環境変数からローカル管理APIの設定を組み立て、検証します。
+// 有効な場合は、管理APIからサーバーを起動・停止できるため認証トークンを必須とします。
+func buildAdminAPISettings(file fileAdminAPIConfig) (AdminAPISettings, []error) {
+	var errs []error
+	settings := AdminAPISettings{
+		Enabled: file.Enabled,
+		Addr:    settingValue(adminAPIAddrEnvKey, file.Addr),
+		Token:   settingValue(adminAPITokenEnvKey, file.Token),
+	}
+	if value := os.Getenv(adminAPIEnabledEnvKey); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') は true / false で指定してください", adminAPIEnabledEnvKey, value))
+		}
+		settings.Enabled = enabled
+	}
+	if settings.Addr == "" {
+		settings.Addr = fallBackAdminAPIAddr
+	}
+	if !settings.Enabled {
+		return settings, errs
+	}
+	if _, _, err := net.SplitHostPort(settings.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("'%s' ('%s', admin_api.addr) は host:port の形式で指定してください: %v", adminAPIAddrEnvKey, settings.Addr, err))
+	}
+	if settings.Token == "" {
+		errs = append(errs, fmt.Errorf("ローカル管理APIを有効にする場合は '%s' (admin_api.token) を設定してください", adminAPITokenEnvKey))
+	}
+	return settings, errs
+}
+
+// buildMetricsSettings は、設定ファイルと環境変数から Prometheus メトリクスの設定を組み立て、検証します。
+func buildMetricsSettings(file fileMetricsConfig) (MetricsSettings, []error) {
+	var errs []error
+	settings := MetricsSettings{
+		Enabled: file.Enabled,
+		Addr:    settingValue(metricsAddrEnvKey, file.Addr),
+		Path:    settingValue(metricsPathEnvKey, file.Path),
+	}
+	if value := os.Getenv(metricsEnabledEnvKey); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') は true / false で指定してください", metricsEnabledEnvKey, value))
+		}
+		settings.Enabled = enabled
+	}
+	if settings.Addr == "" {
+		settings.Addr = fallBackMetricsAddr
+	}
+	if settings.Path == "" {
+		settings.Path = fallBackMetricsPath
+	}
+	if !settings.Enabled {
+		return settings, errs
+	}
+	if _, _, err := net.SplitHostPort(settings.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("'%s' ('%s', metrics.addr) は host:port の形式で指定してください: %v", metricsAddrEnvKey, settings.Addr, err))
+	}
+	if !strings.HasPrefix(settings.Path, "/") || strings.ContainsAny(settings.Path, " {}") {
+		errs = append(errs, fmt.Errorf("'%s' ('%s', metrics.path) は '/' から始まるパスで指定してください", metricsPathEnvKey, settings.Path))
+	}
+	return settings, errs
+}
+
+// buildLoggingSettings は、設定ファイルと環境変数からログ出力の設定を組み立て、検証します。
+// サブシステムごとのレベルは設定ファイル (logging.levels) を読み込んだ後、LOG_LEVELS の指定で上書きします。
+func buildLoggingSettings(file fileLoggingConfig) (LoggingSettings, []error) {
+	var errs []error
+	settings := LoggingSettings{
+		Format:          settingValue(logFormatEnvKey, file.Format),
+		Level:           defaultLoggingSettings.Level,
+		SubsystemLevels: make(map[string]slog.Level),
+	}
+	if settings.Format == "" {
+		settings.Format = defaultLoggingSettings.Format
+	}
+	if settings.Format != logFormatText && settings.Format != logFormatJSON {
+		errs = append(errs, fmt.Errorf("'%s' ('%s', logging.format) は text / json のいずれかで指定してください", logFormatEnvKey, settings.Format))
+	}
+	if value := settingValue(logLevelEnvKey, file.Level); value != "" {
+		level, err := parseLogLevel(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (logging.level): %w", logLevelEnvKey, err))
+		}
+		settings.Level = level
+	}
+	for subsystem, value := range file.Levels {
+		levels, levelErrs := parseSubsystemLevels(subsystem + "=" + value)
+		for _, err := range levelErrs {
+			errs = append(errs, fmt.Errorf("logging.levels: %w", err))
+		}
+		maps.Copy(settings.SubsystemLevels, levels)
+	}
+	if value := os.Getenv(logLevelsEnvKey); value != "" {
+		levels, levelErrs := parseSubsystemLevels(value)
+		for _, err := range levelErrs {
+			errs = append(errs, fmt.Errorf("'%s': %w", logLevelsEnvKey, err))
+		}
+		maps.Copy(settings.SubsystemLevels, levels)
+	}
+	return settings, errs
+}
+
+// buildHeartbeatSettings は、設定ファイルと環境変数から WebSocket ハートビートの設定を組み立て、検証します。
+func buildHeartbeatSettings(file fileWebSocketConfig) (HeartbeatSettings, []error) {
+	var errs []error
+	settings := HeartbeatSettings{Interval: fallBackHeartbeat, Timeout: fallBackHeartbeatTTL}
+	if value := settingValue(heartbeatIntervalEnvKey, file.HeartbeatInterval); value != "" {
+		interval, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (websocket.heartbeat_interval) が不正です: %w", heartbeatIntervalEnvKey, err))
+		}
+		settings.Interval = interval
+	}
+	if value := settingValue(heartbeatTimeoutEnvKey, file.HeartbeatTimeout); value != "" {
+		timeout, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (websocket.heartbeat_timeout) が不正です: %w", heartbeatTimeoutEnvKey, err))
+		} else if timeout == 0 {
+			errs = append(errs, fmt.Errorf("'%s' (websocket.heartbeat_timeout) は0より大きい期間で指定してください", heartbeatTimeoutEnvKey))
+		}
+		settings.Timeout = timeout
+	}
+	return settings, errs
+}
+
+// buildRequestCacheTTL は、設定ファイルと環境変数から重複要求の検出期間を読み込み、検証します。
+func buildRequestCacheTTL(file fileWebSocketConfig) (time.Duration, []error) {
+	value := settingValue(requestCacheTTLEnvKey, file.RequestCacheTTL)
+	if value == "" {
+		return fallBackRequestCache, nil
+	}
+	ttl, err := parsePositiveDuration(value)
+	if err != nil {
+		return fallBackRequestCache, []error{fmt.Errorf("'%s' (websocket.request_cache_ttl) が不正です: %w", requestCacheTTLEnvKey, err)}
+	}
+	return ttl, nil
+}
+
+// buildTokenSourceSettings は、設定ファイルと環境変数から認証トークンの読み込み元を決定します。
+// キーリング (TOKEN_KEYRING) > ファイル (TOKEN_FILE) > ファイルディスクリプタ (TOKEN_FD) > TOKEN の順に優先します。
+func buildTokenSourceSettings(file fileWebSocketConfig) (TokenSourceSettings, []error) {
+	var errs []error
+	settings := TokenSourceSettings{
+		Kind:    tokenSourceEnv,
+		Path:    settingValue(tokenFileEnvKey, file.TokenFile),
+		Account: settingValue(tokenKeyringAccountEnvKey, file.TokenKeyringAccount),
+	}
+	if settings.Account == "" {
+		settings.Account = "default"
+	}
+
+	useKeyring := file.TokenKeyring
+	if value := os.Getenv(tokenKeyringEnvKey); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') は true / false で指定してください", tokenKeyringEnvKey, value))
+		}
+		useKeyring = enabled
+	}
+
+	switch {
+	case useKeyring:
+		settings.Kind = tokenSourceKeyring
+		if settings.Path == "" {
+			settings.Path = defaultTokenFallbackFile // token_store.go
+		}
+	case settings.Path != "":
+		settings.Kind = tokenSourceFile
+	case os.Getenv(tokenFDEnvKey) != "":
+		value := os.Getenv(tokenFDEnvKey)
+		fd, err := strconv.Atoi(value)
+		if err != nil || fd < 0 {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') はファイルディスクリプタ番号 (0以上の整数) で指定してください", tokenFDEnvKey, value))
+		}
+		settings.Kind = tokenSourceFD
+		settings.FD = fd
+	}
+	return settings, errs
+}
+
+// buildTLSSettings は、設定ファイルと環境変数から WebSocket 接続の TLS 設定を組み立て、
+// 証明書ファイルを実際に読み込んで検証します。
+func buildTLSSettings(file fileTLSConfig) (TLSSettings, []error) {
+	var errs []error
+	settings := TLSSettings{
+		CAFile:   settingValue(wsTLSCAFileEnvKey, file.CAFile),
+		CertFile: settingValue(wsTLSCertFileEnvKey, file.CertFile),
+		KeyFile:  settingValue(wsTLSKeyFileEnvKey, file.KeyFile),
+	}
+
+	versionValue := settingValue(wsTLSMinVersionEnvKey, file.MinVersion)
+	if versionValue == "" {
+		versionValue = fallBackTLSVersion
+	}
+	version, err := parseTLSVersion(versionValue) // tls_config.go
+	if err != nil {
+		errs = append(errs, fmt.Errorf("'%s' (websocket.tls.min_version) が不正です: %w", wsTLSMinVersionEnvKey, err))
+	}
+	settings.MinVersion = version
+
+	pinValues := file.PinnedSPKI
+	if value := os.Getenv(wsTLSPinnedSPKIEnvKey); value != "" {
+		pinValues = strings.Split(value, ",")
+	}
+	for _, value := range pinValues {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		pin, err := parseSPKIPin(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (websocket.tls.pinned_spki) が不正です: %w", wsTLSPinnedSPKIEnvKey, err))
+			continue
+		}
+		settings.PinnedSPKI = append(settings.PinnedSPKI, pin)
+	}
+
+	if (settings.CertFile == "") != (settings.KeyFile == "") {
+		errs = append(errs, fmt.Errorf("クライアント証明書を使用する場合は '%s' (websocket.tls.cert_file) と '%s' (websocket.tls.key_file) の両方を設定してください",
+			wsTLSCertFileEnvKey, wsTLSKeyFileEnvKey))
+	} else if _, err := buildWebSocketTLSConfig(settings); err != nil {
+		// 証明書ファイルが読み込めるかを起動時・再読み込み時に確認する
+		errs = append(errs, err)
+	}
+	return settings, errs
+}
+
+// buildSigningSettings は、設定ファイルと環境変数からメッセージ署名の設定を組み立て、検証します。
+func buildSigningSettings(file fileSigningConfig) (SigningSettings, []error) {
+	var errs []error
+	settings := SigningSettings{
+		Key:     settingValue(signingKeyEnvKey, file.Key),
+		MaxSkew: fallBackSigningSkew,
+	}
+	if settings.Key != "" && len(settings.Key) < minSigningKeyLength {
+		errs = append(errs, fmt.Errorf("'%s' (signing.key) は %d 文字以上で指定してください", signingKeyEnvKey, minSigningKeyLength))
+	}
+	if value := settingValue(signingMaxSkewEnvKey, file.MaxSkew); value != "" {
+		skew, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (signing.max_skew) が不正です: %w", signingMaxSkewEnvKey, err))
+		} else if skew == 0 {
+			errs = append(errs, fmt.Errorf("'%s' (signing.max_skew) は0より大きい期間で指定してください", signingMaxSkewEnvKey))
+		}
+		settings.MaxSkew = skew
+	}
+	return settings, errs
+}
+
+// buildOutboxSettings は、設定ファイルと環境変数から送信待ちキューの設定を組み立て、検証します。
+func buildOutboxSettings(file fileOutboxConfig) (OutboxSettings, []error) {
+	var errs []error
+	settings := OutboxSettings{
+		MaxMessages: fallBackOutboxMax,
+		MaxAge:      fallBackOutboxMaxAge,
+		Path:        settingValue(outboxPathEnvKey, file.Path),
+	}
+	if file.MaxMessages != nil {
+		settings.MaxMessages = *file.MaxMessages
+	}
+	if value := os.Getenv(outboxMaxMessagesEnvKey); value != "" {
+		maxMessages, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') が有効な数値ではありません: %v", outboxMaxMessagesEnvKey, value, err))
+		}
+		settings.MaxMessages = maxMessages
+	}
+	if settings.MaxMessages < 1 {
+		errs = append(errs, fmt.Errorf("'%s' (outbox.max_messages) は1以上で指定してください", outboxMaxMessagesEnvKey))
+	}
+	if value := settingValue(outboxMaxAgeEnvKey, file.MaxAge); value != "" {
+		maxAge, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (outbox.max_age) が不正です: %w", outboxMaxAgeEnvKey, err))
+		}
+		settings.MaxAge = maxAge
+	}
+	if settings.Path != "" {
+		if absPath, err := filepath.Abs(settings.Path); err == nil {
+			settings.Path = absPath // 作業ディレクトリに依存しないよう絶対パスにする
+		}
+	}
+	return settings, errs
+}
+
+// buildBackupSettings は、設定ファイルと環境変数からバックアップの設定を組み立て、検証します。
+func buildBackupSettings(file fileBackupConfig) (BackupSettings, []error) {
+	var errs []error
+	settings := BackupSettings{
+		Enabled: true,
+		Dir:     settingValue(backupDirEnvKey, file.Dir),
+		Keep:    fallBackBackupKeep,
+	}
+	if file.Enabled != nil {
+		settings.Enabled = *file.Enabled
+	}
+	if value := os.Getenv(backupEnabledEnvKey); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') は true / false で指定してください", backupEnabledEnvKey, value))
+		}
+		settings.Enabled = enabled
+	}
+	if settings.Dir == "" {
+		settings.Dir = fallBackBackupDir
+	}
+	if absPath, err := filepath.Abs(settings.Dir); err == nil {
+		settings.Dir = absPath // 作業ディレクトリに依存しないよう絶対パスにする
+	}
+	if value := settingValue(backupIntervalEnvKey, file.Interval); value != "" {
+		interval, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (backup.interval) が不正です: %w", backupIntervalEnvKey, err))
+		} else if interval > 0 && interval < time.Minute {
+			errs = append(errs, fmt.Errorf("'%s' (backup.interval) は1分以上で指定してください", backupIntervalEnvKey))
+		}
+		settings.Interval = interval
+	}
+	if file.Keep != nil {
+		settings.Keep = *file.Keep
+	}
+	if value := os.Getenv(backupKeepEnvKey); value != "" {
+		keep, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') が有効な数値ではありません: %v", backupKeepEnvKey, value, err))
+		}
+		settings.Keep = keep
+	}
+	if settings.Keep < 0 {
+		errs = append(errs, fmt.Errorf("'%s' (backup.keep) は0以上で指定してください", backupKeepEnvKey))
+	}
+	if value := settingValue(backupMaxAgeEnvKey, file.MaxAge); value != "" {
+		maxAge, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (backup.max_age) が不正です: %w", backupMaxAgeEnvKey, err))
+		}
+		settings.MaxAge = maxAge
+	}
+	return settings, errs
+}
+
+// buildCrashSettings は、設定ファイルと環境変数からクラッシュ情報の収集の設定を組み立て、検証します。
+func buildCrashSettings(file fileCrashConfig) (CrashSettings, []error) {
+	var errs []error
+	settings := CrashSettings{
+		Dir:      settingValue(crashDirEnvKey, file.Dir),
+		LogLines: fallBackCrashLines,
+		Keep:     fallBackCrashKeep,
+	}
+	if settings.Dir == "" {
+		settings.Dir = fallBackCrashDir
+	}
+	if absPath, err := filepath.Abs(settings.Dir); err == nil {
+		settings.Dir = absPath // 作業ディレクトリに依存しないよう絶対パスにする
+	}
+	if file.LogLines != nil {
+		settings.LogLines = *file.LogLines
+	}
+	if value := os.Getenv(crashLogLinesEnvKey); value != "" {
+		lines, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') が有効な数値ではありません: %v", crashLogLinesEnvKey, value, err))
+		}
+		settings.LogLines = lines
+	}
+	if settings.LogLines < 0 {
+		errs = append(errs, fmt.Errorf("'%s' (crash.log_lines) は0以上で指定してください", crashLogLinesEnvKey))
+	}
+	if file.Keep != nil {
+		settings.Keep = *file.Keep
+	}
+	if value := os.Getenv(crashKeepEnvKey); value != "" {
+		keep, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') が有効な数値ではありません: %v", crashKeepEnvKey, value, err))
+		}
+		settings.Keep = keep
+	}
+	if settings.Keep < 0 {
+		errs = append(errs, fmt.Errorf("'%s' (crash.keep) は0以上で指定してください", crashKeepEnvKey))
+	}
+	return settings, errs
+}
+
+// buildPlayerSettings は、設定ファイルと環境変数からプレイヤー数の追跡と無人時の自動停止の設定を組み立て、検証します。
+func buildPlayerSettings(file filePlayersConfig) (PlayerSettings, []error) {
+	var errs []error
+	settings := PlayerSettings{
+		JoinPattern:  settingValue(playerJoinPatternEnvKey, file.JoinPattern),
+		LeavePattern: settingValue(playerLeavePatternEnvKey, file.LeavePattern),
+	}
+	if settings.JoinPattern == "" {
+		settings.JoinPattern = fallBackPlayerJoinPattern
+	}
+	if settings.LeavePattern == "" {
+		settings.LeavePattern = fallBackPlayerLeavePattern
+	}
+	if _, err := regexp.Compile(settings.JoinPattern); err != nil {
+		errs = append(errs, fmt.Errorf("'%s' (players.join_pattern) の正規表現が不正です: %w", playerJoinPatternEnvKey, err))
+	}
+	if _, err := regexp.Compile(settings.LeavePattern); err != nil {
+		errs = append(errs, fmt.Errorf("'%s' (players.leave_pattern) の正規表現が不正です: %w", playerLeavePatternEnvKey, err))
+	}
+	if value := settingValue(idleStopTimeoutEnvKey, file.IdleTimeout); value != "" {
+		timeout, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (players.idle_timeout) が不正です: %w", idleStopTimeoutEnvKey, err))
+		}
+		settings.IdleTimeout = timeout
+	}
+	return settings, errs
+}
+
+// buildScheduleSettings は、設定ファイルと環境変数から予定の設定を組み立て、検証します。
+func buildScheduleSettings(file fileScheduleConfig) (ScheduleSettings, []error) {
+	var errs []error
+	settings := ScheduleSettings{Path: settingValue(schedulePathEnvKey, file.Path)}
+	if settings.Path == "" {
+		settings.Path = fallBackSchedulePath
+	}
+	if absPath, err := filepath.Abs(settings.Path); err == nil {
+		settings.Path = absPath // 作業ディレクトリに依存しないよう絶対パスにする
+	}
+
+	warnValues := file.Warnings
+	if value := os.Getenv(scheduleWarningsEnvKey); value != "" {
+		warnValues = strings.Split(value, ",")
+	} else if warnValues == nil {
+		warnValues = strings.Split(fallBackScheduleWarn, ",")
+	}
+	for _, value := range warnValues {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		lead, err := parsePositiveDuration(value)
+		if err != nil || lead == 0 {
+			errs = append(errs, fmt.Errorf("'%s' (schedule.warnings) の '%s' が不正です (例: 10m)", scheduleWarningsEnvKey, value))
+			continue
+		}
+		settings.Warnings = append(settings.Warnings, lead)
+	}
+	return settings, errs
+}
+
+// buildStatsSettings は、設定ファイルと環境変数からリソース使用量の取得の設定を組み立て、検証します。
+func buildStatsSettings(file fileStatsConfig) (StatsSettings, []error) {
+	var errs []error
+	settings := StatsSettings{Interval: fallBackStatsInterval}
+	if value := settingValue(statsIntervalEnvKey, file.Interval); value != "" {
+		interval, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (stats.interval) が不正です: %w", statsIntervalEnvKey, err))
+		}
+		settings.Interval = interval
+	}
+	return settings, errs
+}
+
+// buildCgroupSettings は、設定ファイルと環境変数から cgroup によるリソース制限の設定を組み立て、検証します。
+func buildCgroupSettings(file fileCgroupConfig) (CgroupSettings, []error) {
+	settings := CgroupSettings{Root: settingValue(cgroupRootEnvKey, file.Root)}
+	if settings.Root == "" {
+		settings.Root = fallBackCgroupRoot
+	}
+	var errs []error
+	if !filepath.IsAbs(settings.Root) {
+		errs = append(errs, fmt.Errorf("'%s' (cgroup.root) は絶対パスで指定してください: %s", cgroupRootEnvKey, settings.Root))
+	}
+	if value := settingValue(cgroupMemoryMaxEnvKey, file.Memory); value != "" {
+		memory, err := parseMemoryLimit(value) // cgroup.go
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (cgroup.memory) が不正です: %w", cgroupMemoryMaxEnvKey, err))
+		}
+		settings.Limits.MemoryMax = memory
+	}
+	if value := settingValue(cgroupCPUMaxEnvKey, file.CPU); value != "" {
+		cpu, err := parseCPULimit(value) // cgroup.go
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (cgroup.cpu) が不正です: %w", cgroupCPUMaxEnvKey, err))
+		}
+		settings.Limits.CPUMax = cpu
+	}
+	pids := file.Pids
+	if value := os.Getenv(cgroupPidsMaxEnvKey); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' (cgroup.pids) が不正です: %s", cgroupPidsMaxEnvKey, value))
+		}
+		pids = &parsed
+	}
+	if pids != nil {
+		if *pids < 0 {
+			errs = append(errs, fmt.Errorf("'%s' (cgroup.pids) は 0 以上で指定してください: %d", cgroupPidsMaxEnvKey, *pids))
+		} else {
+			settings.Limits.PidsMax = *pids
+		}
+	}
+	return settings, errs
+}
+
+// mergeResourceLimits は、設定ファイルで指定された項目だけを base に上書きしたリソースの上限を返します。
+// Args:
+//
+//	base (ResourceLimits): 指定がない項目に使う上限。
+//	file (fileLimitsConfig): 設定ファイルの値。
+//	label (string): エラーメッセージに使う設定ファイル上の位置 (例: "servers.pvp_server.limits")。
+func mergeResourceLimits(base ResourceLimits, file fileLimitsConfig, label string) (ResourceLimits, []error) {
+	var errs []error
+	limits := base
+	if file.Memory != "" {
+		memory, err := parseMemoryLimit(file.Memory) // cgroup.go
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.memory が不正です: %w", label, err))
+		}
+		limits.MemoryMax = memory
+	}
+	if file.CPU != "" {
+		cpu, err := parseCPULimit(file.CPU) // cgroup.go
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.cpu が不正です: %w", label, err))
+		}
+		limits.CPUMax = cpu
+	}
+	if file.Pids != nil {
+		if *file.Pids < 0 {
+			errs = append(errs, fmt.Errorf("%s.pids は 0 以上で指定してください: %d", label, *file.Pids))
+		} else {
+			limits.PidsMax = *file.Pids
+		}
+	}
+	return limits, errs
+}
+
+// portPoolBounds は、全ポートプールを含む最小ポートと最大ポートを返します。
+func portPoolBounds(pools []PortRange) (int, int) {
+	if len(pools) == 0 {
+		return 0, 0
+	}
+	minPort, maxPort := pools[0].Min, pools[0].Max
+	for _, pool := range pools[1:] {
+		if pool.Min < minPort {
+			minPort = pool.Min
+		}
+		if pool.Max > maxPort {
+			maxPort = pool.Max
+		}
+	}
+	return minPort, maxPort
+}
+
+// defaultSteamCmdProfile は、steamcmd セクションと環境変数から読み込んだ既定の SteamCMD の設定を返します。
+func (cfg *AppConfig) defaultSteamCmdProfile() SteamCmdProfile {
+	return SteamCmdProfile{
+		Path:                 cfg.SteamCmdPath,
+		Login:                cfg.SteamCmdLogin,
+		AppID:                cfg.GameAppID,
+		WorkshopPlaylistsDir: cfg.WorkshopPlaylistsInstallDir,
+		WorkshopModsDir:      cfg.WorkshopModsInstallDir,
+	}
+}
+
+// steamCmdProfileFor は、サーバー構成名で使用する SteamCMD の設定を返します。
+// servers.<name>.steamcmd でプロファイルを選択していない場合は、既定の設定を返します。
+func (cfg *AppConfig) steamCmdProfileFor(name string) SteamCmdProfile {
+	if settings, ok := cfg.ServerOverrides[name]; ok && settings.SteamCmd != "" {
+		if profile, ok := cfg.SteamCmdProfiles[settings.SteamCmd]; ok {
+			return profile
+		}
+	}
+	return cfg.defaultSteamCmdProfile()
+}
+
+// buildSteamCmdProfiles は、steamcmd.profiles の名前付きプロファイルを、既定の設定に上書きして読み込みます。
+// 環境変数 (STEAMCMD_PATH 等) は既定の設定にのみ適用され、プロファイルで指定した項目はプロファイルの値を使います。
+func buildSteamCmdProfiles(base SteamCmdProfile, files map[string]fileSteamCmdConfig) (map[string]SteamCmdProfile, []error) {
+	var errs []error
+	profiles := make(map[string]SteamCmdProfile, len(files))
+	for name, file := range files {
+		key := fmt.Sprintf("steamcmd.profiles.%s", name)
+		profile := base
+		profile.Name = name
+		if len(file.Profiles) > 0 {
+			errs = append(errs, fmt.Errorf("%s.profiles は指定できません (プロファイルは入れ子にできません)", key))
+		}
+		if file.Path != "" {
+			profile.Path = file.Path
+			if _, err := os.Stat(file.Path); os.IsNotExist(err) {
+				errs = append(errs, fmt.Errorf("%s.path で指定されたファイル '%s' が見つかりません", key, file.Path))
+			}
+		}
+		if file.Login != "" {
+			profile.Login = file.Login
+		}
+		if file.AppID != "" {
+			if _, err := strconv.Atoi(file.AppID); err != nil {
+				errs = append(errs, fmt.Errorf("%s.app_id ('%s') は数値で指定してください", key, file.AppID))
+			}
+			profile.AppID = file.AppID
+		}
+		if file.WorkshopPlaylistsDir != "" {
+			profile.WorkshopPlaylistsDir = file.WorkshopPlaylistsDir
+			errs = append(errs, validateAbsDir(key+".workshop_playlists_dir", key+".workshop_playlists_dir", file.WorkshopPlaylistsDir)...)
+		}
+		if file.WorkshopModsDir != "" {
+			profile.WorkshopModsDir = file.WorkshopModsDir
+			errs = append(errs, validateAbsDir(key+".workshop_mods_dir", key+".workshop_mods_dir", file.WorkshopModsDir)...)
+		}
+		profiles[name] = profile
+	}
+	return profiles, errs
+}
+
+// formatSteamCmdProfile は、SteamCMD プロファイルをログ表示用の文字列にします。
+func formatSteamCmdProfile(profile SteamCmdProfile) string {
+	return fmt.Sprintf("パス=%s, ログイン=%s, App ID=%s, プレイリスト=%s, MOD=%s",
+		profile.Path, profile.Login, profile.AppID, profile.WorkshopPlaylistsDir, profile.WorkshopModsDir)
+}
+
+// mergeRestartPolicy は、base の再起動ポリシーに設定ファイルで指定された項目を上書きしたポリシーを返します。
+// fileKey はエラーメッセージ用の設定ファイル上のキー名です。
+func mergeRestartPolicy(base RestartPolicy, file fileRestartPolicy, fileKey string) (RestartPolicy, []error) {
+	var errs []error
+	policy := base
+	if file.Enabled != nil {
+		policy.Enabled = *file.Enabled
+	}
+	if file.MaxAttempts != nil {
+		if *file.MaxAttempts < 0 {
+			errs = append(errs, fmt.Errorf("%s.max_attempts (%d) は0以上で指定してください", fileKey, *file.MaxAttempts))
+		}
+		policy.MaxAttempts = *file.MaxAttempts
+	}
+	if file.Window != "" {
+		window, err := parsePositiveDuration(file.Window)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.window が不正です: %w", fileKey, err))
+		}
+		policy.Window = window
+	}
+	if file.Delay != "" {
+		delay, err := parsePositiveDuration(file.Delay)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s.delay が不正です: %w", fileKey, err))
+		}
+		policy.Delay = delay
+	}
+	return policy, errs
+}
+
+// applyRestartPolicyEnv は、環境変数 RESTART_* で指定された項目を再起動ポリシーに上書きします。
+func applyRestartPolicyEnv(policy RestartPolicy) (RestartPolicy, []error) {
+	var errs []error
+	if value := os.Getenv(restartEnabledEnvKey); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') は true / false で指定してください", restartEnabledEnvKey, value))
+		}
+		policy.Enabled = enabled
+	}
+	if value := os.Getenv(restartMaxAttemptsEnvKey); value != "" {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts < 0 {
+			errs = append(errs, fmt.Errorf("'%s' ('%s') は0以上の数値で指定してください", restartMaxAttemptsEnvKey, value))
+		}
+		policy.MaxAttempts = attempts
+	}
+	if value := os.Getenv(restartWindowEnvKey); value != "" {
+		window, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' が不正です: %w", restartWindowEnvKey, err))
+		}
+		policy.Window = window
+	}
+	if value := os.Getenv(restartDelayEnvKey); value != "" {
+		delay, err := parsePositiveDuration(value)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("'%s' が不正です: %w", restartDelayEnvKey, err))
+		}
+		policy.Delay = delay
+	}
+	return policy, errs
+}
+
+// parsePositiveDuration は、"10m" のような期間文字列を解析します。負の値はエラーとします。
+func parsePositiveDuration(value string) (time.Duration, error) {
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("期間 '%s' を解析できません (例: 30s, 10m, 1h): %w", value, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("期間 '%s' に負の値は指定できません", value)
+	}
+	return duration, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// --- 構造化設定ファイル (YAML / TOML) ---
+// .env の平坦な環境変数では表現しにくい設定 (複数のポートプール、再起動ポリシー、サーバーごとの上書き設定など) を
+// 記述するための設定ファイルの構造を定義します。
+// 設定ファイルの値は LoadConfig で読み込まれ、同名の環境変数が設定されている場合は環境変数の値が優先されます。
+
+// 設定ファイルのパスを指定する環境変数名
+const configFileEnvKey = "SWSC_CONFIG_FILE"
+
+// defaultConfigFileNames は、SWSC_CONFIG_FILE が未指定の場合にカレントディレクトリから探す設定ファイル名です (先に見つかったものを使用)。
+var defaultConfigFileNames = []string{"swsc.yaml", "swsc.yml", "swsc.toml"}
+
+// fileConfig は、設定ファイル全体の構造です。
+type fileConfig struct {
+	WebSocket fileWebSocketConfig         `yaml:"websocket" toml:"websocket"`
+	Server    fileServerProcessConfig     `yaml:"server" toml:"server"`
+	Ports     filePortsConfig             `yaml:"ports" toml:"ports"`
+	Restart   fileRestartPolicy           `yaml:"restart" toml:"restart"`
+	SteamCmd  fileSteamCmdConfig          `yaml:"steamcmd" toml:"steamcmd"`
+	Servers   map[string]fileServerConfig `yaml:"servers" toml:"servers"` // キー: サーバー構成名
+	AdminAPI  fileAdminAPIConfig          `yaml:"admin_api" toml:"admin_api"`
+	Metrics   fileMetricsConfig           `yaml:"metrics" toml:"metrics"`
+	Logging   fileLoggingConfig           `yaml:"logging" toml:"logging"`
+	Outbox    fileOutboxConfig            `yaml:"outbox" toml:"outbox"`
+	Signing   fileSigningConfig           `yaml:"signing" toml:"signing"`
+	Backup    fileBackupConfig            `yaml:"backup" toml:"backup"`
+	Crash     fileCrashConfig             `yaml:"crash" toml:"crash"`
+	Schedule  fileScheduleConfig          `yaml:"schedule" toml:"schedule"`
+	Players   filePlayersConfig           `yaml:"players" toml:"players"`
+	Stats     fileStatsConfig             `yaml:"stats" toml:"stats"`
+	Cgroup    fileCgroupConfig            `yaml:"cgroup" toml:"cgroup"`
+}
+
+// fileWebSocketConfig は、Bot/WebSocketサーバーへの接続設定です。
+type fileWebSocketConfig struct {
+	URL                 string        `yaml:"url" toml:"url"`                                     // WS_URL
+	Token               string        `yaml:"token" toml:"token"`                                 // TOKEN
+	TokenFile           string        `yaml:"token_file" toml:"token_file"`                       // TOKEN_FILE
+	TokenKeyring        bool          `yaml:"token_keyring" toml:"token_keyring"`                 // TOKEN_KEYRING
+	TokenKeyringAccount string        `yaml:"token_keyring_account" toml:"token_keyring_account"` // TOKEN_KEYRING_ACCOUNT
+	HeartbeatInterval   string        `yaml:"heartbeat_interval" toml:"heartbeat_interval"`       // HEARTBEAT_INTERVAL (例: 30s)
+	HeartbeatTimeout    string        `yaml:"heartbeat_timeout" toml:"heartbeat_timeout"`         // HEARTBEAT_TIMEOUT (例: 10s)
+	RequestCacheTTL     string        `yaml:"request_cache_ttl" toml:"request_cache_ttl"`         // REQUEST_CACHE_TTL (例: 10m)
+	TLS                 fileTLSConfig `yaml:"tls" toml:"tls"`
+}
+
+// fileTLSConfig は、WebSocket 接続の TLS (tls_config.go) の設定です。
+type fileTLSConfig struct {
+	CAFile     string   `yaml:"ca_file" toml:"ca_file"`         // WS_TLS_CA_FILE
+	CertFile   string   `yaml:"cert_file" toml:"cert_file"`     // WS_TLS_CERT_FILE
+	KeyFile    string   `yaml:"key_file" toml:"key_file"`       // WS_TLS_KEY_FILE
+	PinnedSPKI []string `yaml:"pinned_spki" toml:"pinned_spki"` // WS_TLS_PINNED_SPKI (カンマ区切り)
+	MinVersion string   `yaml:"min_version" toml:"min_version"` // WS_TLS_MIN_VERSION (例: 1.2)
+}
+
+// fileServerProcessConfig は、ゲームサーバー実行ファイルとその起動方式の設定です。
+type fileServerProcessConfig struct {
+	ExePath  string             `yaml:"exe_path" toml:"exe_path"` // SERVER_EXE_PATH
+	Launcher fileLauncherConfig `yaml:"launcher" toml:"launcher"`
+}
+
+// fileLauncherConfig は、起動方式 (launcher.go) の設定です。
+type fileLauncherConfig struct {
+	Type       string `yaml:"type" toml:"type"`               // SERVER_LAUNCHER
+	WinePath   string `yaml:"wine_path" toml:"wine_path"`     // WINE_PATH
+	WinePrefix string `yaml:"wine_prefix" toml:"wine_prefix"` // WINE_PREFIX
+	Command    string `yaml:"command" toml:"command"`         // SERVER_LAUNCHER_COMMAND
+	PathStyle  string `yaml:"path_style" toml:"path_style"`   // SERVER_LAUNCHER_PATH_STYLE
+	Drive      string `yaml:"drive" toml:"drive"`             // SERVER_LAUNCHER_DRIVE
+}
+
+// filePortsConfig は、ゲームサーバーに割り当てるポートの設定です。
+// min/max で単一の範囲を、pools で複数の範囲を指定できます (両方指定した場合は両方が使われます)。
+type filePortsConfig struct {
+	Min   int             `yaml:"min" toml:"min"` // MIN_PORT
+	Max   int             `yaml:"max" toml:"max"` // MAX_PORT
+	Pools []filePortRange `yaml:"pools" toml:"pools"`
+}
+
+// filePortRange は、ポートプール1つ分の範囲です。
+type filePortRange struct {
+	Min int `yaml:"min" toml:"min"`
+	Max int `yaml:"max" toml:"max"`
+}
+
+// fileRestartPolicy は、クラッシュ時の自動再起動ポリシーです。
+// 未指定の項目はデフォルト値 (または上位の設定) を引き継ぐため、ポインタ/空文字列で未指定を表します。
+type fileRestartPolicy struct {
+	Enabled     *bool  `yaml:"enabled" toml:"enabled"`           // RESTART_ENABLED
+	MaxAttempts *int   `yaml:"max_attempts" toml:"max_attempts"` // RESTART_MAX_ATTEMPTS (0 は無制限)
+	Window      string `yaml:"window" toml:"window"`             // RESTART_WINDOW (例: "10m")
+	Delay       string `yaml:"delay" toml:"delay"`               // RESTART_DELAY (例: "5s")
+}
+
+// fileSteamCmdConfig は、SteamCMD とワークショップアイテムの配置先の設定です。
+type fileSteamCmdConfig struct {
+	Path                 string `yaml:"path" toml:"path"`                                     // STEAMCMD_PATH
+	AppID                string `yaml:"app_id" toml:"app_id"`                                 // GAME_APPID
+	WorkshopPlaylistsDir string `yaml:"workshop_playlists_dir" toml:"workshop_playlists_dir"` // WORKSHOP_PLAYLISTS_INSTALL_DIR
+	WorkshopModsDir      string `yaml:"workshop_mods_dir" toml:"workshop_mods_dir"`           // WORKSHOP_MODS_INSTALL_DIR
+	Login                string `yaml:"login" toml:"login"`                                   // STEAMCMD_LOGIN (省略時は anonymous)
+
+	// Profiles は、名前付きの SteamCMD プロファイルです (キー: プロファイル名)。
+	// servers.<name>.steamcmd で選択し、未指定の項目は上の既定の設定を引き継ぎます。
+	Profiles map[string]fileSteamCmdConfig `yaml:"profiles" toml:"profiles"`
+}
+
+// fileAdminAPIConfig は、ローカル管理API (admin_api.go) の設定です。
+type fileAdminAPIConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"` // ADMIN_API_ENABLED
+	Addr    string `yaml:"addr" toml:"addr"`       // ADMIN_API_ADDR
+	Token   string `yaml:"token" toml:"token"`     // ADMIN_API_TOKEN
+}
+
+// fileMetricsConfig は、Prometheus メトリクス (metrics.go) の設定です。
+type fileMetricsConfig struct {
+	Enabled bool   `yaml:"enabled" toml:"enabled"` // METRICS_ENABLED
+	Addr    string `yaml:"addr" toml:"addr"`       // METRICS_ADDR
+	Path    string `yaml:"path" toml:"path"`       // METRICS_PATH
+}
+
+// fileLoggingConfig は、ログ出力 (logger.go) の設定です。
+type fileLoggingConfig struct {
+	Level  string            `yaml:"level" toml:"level"`   // LOG_LEVEL
+	Format string            `yaml:"format" toml:"format"` // LOG_FORMAT
+	Levels map[string]string `yaml:"levels" toml:"levels"` // LOG_LEVELS (キー: サブシステム名, 値: レベル)
+}
+
+// fileOutboxConfig は、WebSocket 切断中の送信待ちキュー (outbox.go) の設定です。
+type fileOutboxConfig struct {
+	MaxMessages *int   `yaml:"max_messages" toml:"max_messages"` // OUTBOX_MAX_MESSAGES
+	MaxAge      string `yaml:"max_age" toml:"max_age"`           // OUTBOX_MAX_AGE (例: 15m)
+	Path        string `yaml:"path" toml:"path"`                 // OUTBOX_PATH
+}
+
+// fileSigningConfig は、メッセージ署名 (signing.go) の設定です。
+type fileSigningConfig struct {
+	Key     string `yaml:"key" toml:"key"`           // SIGNING_KEY
+	MaxSkew string `yaml:"max_skew" toml:"max_skew"` // SIGNING_MAX_SKEW (例: 5m)
+}
+
+// fileBackupConfig は、サーバーの設定ディレクトリのバックアップ (backup.go) の設定です。
+type fileBackupConfig struct {
+	Enabled  *bool  `yaml:"enabled" toml:"enabled"`   // BACKUP_ENABLED (省略時は有効)
+	Dir      string `yaml:"dir" toml:"dir"`           // BACKUP_DIR
+	Interval string `yaml:"interval" toml:"interval"` // BACKUP_INTERVAL (例: 1h)
+	Keep     *int   `yaml:"keep" toml:"keep"`         // BACKUP_KEEP (0 は無制限)
+	MaxAge   string `yaml:"max_age" toml:"max_age"`   // BACKUP_MAX_AGE (例: 168h)
+}
+
+// filePlayersConfig は、プレイヤー数の追跡と無人時の自動停止 (players.go) の設定です。
+type filePlayersConfig struct {
+	JoinPattern  string `yaml:"join_pattern" toml:"join_pattern"`   // PLAYER_JOIN_PATTERN
+	LeavePattern string `yaml:"leave_pattern" toml:"leave_pattern"` // PLAYER_LEAVE_PATTERN
+	IdleTimeout  string `yaml:"idle_timeout" toml:"idle_timeout"`   // IDLE_STOP_TIMEOUT (例: "30m")
+}
+
+// fileCgroupConfig は、cgroup v2 によるリソース制限 (cgroup.go) の設定です。
+type fileCgroupConfig struct {
+	Root   string `yaml:"root" toml:"root"`     // CGROUP_ROOT
+	Memory string `yaml:"memory" toml:"memory"` // CGROUP_MEMORY_MAX (例: "8G")
+	CPU    string `yaml:"cpu" toml:"cpu"`       // CGROUP_CPU_MAX (コア数、例: "1.5")
+	Pids   *int   `yaml:"pids" toml:"pids"`     // CGROUP_PIDS_MAX
+}
+
+// fileLimitsConfig は、サーバー構成名ごとのリソースの上限 (cgroup.go) です。未指定の項目は cgroup の値を引き継ぎます。
+type fileLimitsConfig struct {
+	Memory string `yaml:"memory" toml:"memory"` // 例: "8G"、"0" で無制限
+	CPU    string `yaml:"cpu" toml:"cpu"`       // コア数 (例: "1.5")、"0" で無制限
+	Pids   *int   `yaml:"pids" toml:"pids"`     // 0 で無制限
+}
+
+// fileStatsConfig は、サーバープロセスのリソース使用量の取得 (stats.go) の設定です。
+type fileStatsConfig struct {
+	Interval string `yaml:"interval" toml:"interval"` // STATS_INTERVAL (例: "30s"、"0" で無効)
+}
+
+// fileScheduleConfig は、サーバーの定期的な再起動・停止・起動 (schedule.go) の設定です。
+type fileScheduleConfig struct {
+	Path     string   `yaml:"path" toml:"path"`         // SCHEDULE_PATH
+	Warnings []string `yaml:"warnings" toml:"warnings"` // SCHEDULE_WARNINGS (例: ["10m", "1m"]、[] で予告しない)
+}
+
+// fileCrashConfig は、クラッシュ情報の収集 (crash.go) の設定です。
+type fileCrashConfig struct {
+	Dir      string `yaml:"dir" toml:"dir"`             // CRASH_DIR
+	LogLines *int   `yaml:"log_lines" toml:"log_lines"` // CRASH_LOG_LINES
+	Keep     *int   `yaml:"keep" toml:"keep"`           // CRASH_KEEP (0 は無制限)
+}
+
+// fileServerConfig は、サーバー構成名ごとの上書き設定です。
+type fileServerConfig struct {
+	Restart     fileRestartPolicy `yaml:"restart" toml:"restart"`
+	IdleTimeout string            `yaml:"idle_timeout" toml:"idle_timeout"` // IDLE_STOP_TIMEOUT を構成名ごとに上書き (例: "30m"、"0" で無効)
+	Limits      fileLimitsConfig  `yaml:"limits" toml:"limits"`             // cgroup のリソースの上限を構成名ごとに上書き
+	SteamCmd    string            `yaml:"steamcmd" toml:"steamcmd"`         // 使用する SteamCMD プロファイル名 (steamcmd.profiles のキー)
+}
+
+// findConfigFile は、読み込む設定ファイルのパスを決定します。
+// SWSC_CONFIG_FILE が設定されていればそのパスを (存在しなければエラー)、
+// 未設定ならカレントディレクトリのデフォルト名のファイルを探します。見つからなければ空文字列を返します。
+func findConfigFile() (string, error) {
+	if path := os.Getenv(configFileEnvKey); path != "" {
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("環境変数 '%s' で指定された設定ファイル '%s' を開けません: %w", configFileEnvKey, path, err)
+		}
+		return path, nil
+	}
+	for _, name := range defaultConfigFileNames {
+		if _, err := os.Stat(name); err == nil {
+			return name, nil
+		}
+	}
+	return "", nil
+}
+
+// readConfigFile は、拡張子 (.yaml / .yml / .toml) に応じて設定ファイルを読み込みます。
+// 未知のキーはタイプミスの可能性が高いため、エラーとして扱います。
+// Args:
+//
+//	path (string): 設定ファイルのパス。
+//
+// Returns:
+//
+//	*fileConfig: 読み込んだ設定。
+//	error: 読み込み・解析に失敗した場合、または未知のキーが含まれていた場合のエラー。
+func readConfigFile(path string) (*fileConfig, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("設定ファイル '%s' の読み込み失敗: %w", path, err)
+	}
+
+	var cfg fileConfig
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)                                               // 未知のキーをエラーにする
+		if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) { // 空ファイルは io.EOF になるため許容
+			return nil, fmt.Errorf("設定ファイル '%s' (YAML) の解析失敗: %w", path, err)
+		}
+	case ".toml":
+		meta, err := toml.Decode(string(content), &cfg)
+		if err != nil {
+			return nil, fmt.Errorf("設定ファイル '%s' (TOML) の解析失敗: %w", path, err)
+		}
+		if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+			keys := make([]string, 0, len(undecoded))
+			for _, key := range undecoded {
+				keys = append(keys, key.String())
+			}
+			sort.Strings(keys)
+			return nil, fmt.Errorf("設定ファイル '%s' に未知のキーがあります: %s", path, strings.Join(keys, ", "))
+		}
+	default:
+		return nil, fmt.Errorf("設定ファイル '%s' の形式を判別できません (.yaml / .yml / .toml のいずれかを使用してください)", path)
+	}
+	return &cfg, nil
+}
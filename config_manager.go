@@ -4,7 +4,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io" // io.Reader用に追加
-	"log"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -55,7 +54,7 @@ func updateXmlPort(xmlString string, newPort int) (string, error) {
 			break // ファイル終端
 		}
 		if err != nil {
-			log.Printf("[設定管理] エラー: XMLトークンの読み取り失敗: %v", err)
+			xmlLog.errorf("XMLトークンの読み取り失敗: %v", err)
 			return "", fmt.Errorf("XMLトークンの読み取り失敗: %w", err)
 		}
 
@@ -70,7 +69,7 @@ func updateXmlPort(xmlString string, newPort int) (string, error) {
 						// port 属性を新しい値で追加
 						updatedAttrs = append(updatedAttrs, xml.Attr{Name: xml.Name{Local: "port"}, Value: newPortStr})
 						portAttrFound = true
-						log.Printf("[設定管理] XML内の port 属性を '%s' に更新します。", newPortStr)
+						xmlLog.infof("XML内の port 属性を '%s' に更新します。", newPortStr)
 					} else {
 						// 他の属性はそのまま保持
 						updatedAttrs = append(updatedAttrs, attr)
@@ -79,7 +78,7 @@ func updateXmlPort(xmlString string, newPort int) (string, error) {
 				// もし port 属性が元々なければ追加
 				if !portAttrFound {
 					updatedAttrs = append(updatedAttrs, xml.Attr{Name: xml.Name{Local: "port"}, Value: newPortStr})
-					log.Printf("[設定管理] XMLに port 属性 '%s' を追加します。", newPortStr)
+					xmlLog.infof("XMLに port 属性 '%s' を追加します。", newPortStr)
 				}
 				// 更新/追加された属性で開始タグをエンコード
 				err = encoder.EncodeToken(xml.StartElement{Name: se.Name, Attr: updatedAttrs})
@@ -93,20 +92,20 @@ func updateXmlPort(xmlString string, newPort int) (string, error) {
 		}
 
 		if err != nil {
-			log.Printf("[設定管理] エラー: XMLトークンの書き込み失敗: %v", err)
+			xmlLog.errorf("XMLトークンの書き込み失敗: %v", err)
 			return "", fmt.Errorf("XMLトークンの書き込み失敗: %w", err)
 		}
 	}
 
 	// エンコーダーのバッファをフラッシュ
 	if err := encoder.Flush(); err != nil {
-		log.Printf("[設定管理] エラー: XMLエンコーダーのフラッシュ失敗: %v", err)
+		xmlLog.errorf("XMLエンコーダーのフラッシュ失敗: %v", err)
 		return "", fmt.Errorf("XMLエンコーダーのフラッシュ失敗: %w", err)
 	}
 
 	finalXml := updatedXml.String()
-	log.Printf("[設定管理] ポート更新後のXML生成完了。")
-	// log.Printf("[設定管理] 更新後XML(一部):\n%s", finalXml[:min(500, len(finalXml))]) // デバッグ用
+	xmlLog.infof("ポート更新後のXML生成完了。")
+	// xmlLog.infof("更新後XML(一部):\n%s", finalXml[:min(500, len(finalXml))]) // デバッグ用
 
 	return finalXml, nil
 }
@@ -133,24 +132,24 @@ func saveConfigFile(configName string, xmlString string) error {
 	// ★ 絶対パスをログに出力
 	absConfigFilePath, pathErr := filepath.Abs(configFilePath)
 	if pathErr != nil {
-		log.Printf("[設定管理] 警告: 設定ファイルの絶対パス取得に失敗 (%s): %v", configFilePath, pathErr)
+		xmlLog.warnf("設定ファイルの絶対パス取得に失敗 (%s): %v", configFilePath, pathErr)
 		absConfigFilePath = configFilePath // 相対パスのままログに出す
 	}
-	log.Printf("[設定管理] 設定ファイルを保存します: %s", absConfigFilePath)
+	xmlLog.infof("設定ファイルを保存します: %s", absConfigFilePath)
 
 	// ディレクトリ作成
 	if err := os.MkdirAll(configDir, 0755); err != nil {
-		log.Printf("[設定管理] エラー: 設定ディレクトリ作成失敗 (%s): %v", configDir, err)
+		xmlLog.errorf("設定ディレクトリ作成失敗 (%s): %v", configDir, err)
 		return fmt.Errorf("設定ディレクトリ作成失敗: %w", err)
 	}
 
 	// ファイル書き込み
 	if err := os.WriteFile(absConfigFilePath, []byte(xmlString), 0644); err != nil {
 		// ★ エラーを詳細に出力
-		log.Printf("[設定管理] エラー: 設定ファイル書き込み失敗 (%s): %v", absConfigFilePath, err)
+		xmlLog.errorf("設定ファイル書き込み失敗 (%s): %v", absConfigFilePath, err)
 		return fmt.Errorf("設定ファイル書き込み失敗 (%s): %w", absConfigFilePath, err)
 	}
 
-	log.Printf("[設定管理] 設定ファイル保存成功: %s", absConfigFilePath)
+	xmlLog.infof("設定ファイル保存成功: %s", absConfigFilePath)
 	return nil
 }
\ No newline at end of file
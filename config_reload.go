@@ -0,0 +1,282 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/joho/godotenv"
+)
+
+// --- 設定の再読み込み (ホットリロード) ---
+// SIGHUP またはBotからの "reloadConfig" 要求で LoadConfig と同じ手順で設定を読み直し、
+// 実行中のサーバーに影響しない変更のみをその場で適用します。
+// 実行中のサーバーと矛盾する変更 (使用中のポートがポートプールから外れる等) が1つでも含まれる場合は、
+// 設定が中途半端に適用されるのを避けるため、再読み込み全体を拒否します。
+
+var (
+	// processEnvKeys は、起動時点でプロセスの環境変数として設定されていたキーです。
+	// これらは .env より優先されるため、再読み込み時も .env の値で上書きしません。
+	processEnvKeys map[string]bool
+	// dotEnvKeys は、.env ファイルから読み込んで環境変数に設定したキーです (再読み込みで削除されたキーを消すために使用)。
+	dotEnvKeys map[string]bool
+	// dotEnvMutex は、processEnvKeys と dotEnvKeys を保護するためのミューテックスです。
+	dotEnvMutex sync.Mutex
+	// reloadMutex は、設定の再読み込みが同時に実行されないようにするためのミューテックスです。
+	reloadMutex sync.Mutex
+)
+
+// loadDotEnv は、カレントディレクトリの .env ファイルを読み込み、環境変数に反映します。
+// godotenv.Load と異なり、再読み込み時には .env 由来の値を最新の内容で上書きし、削除されたキーは環境変数からも削除します。
+// プロセス起動時から設定されている環境変数は常に優先されます。
+func loadDotEnv() {
+	dotEnvMutex.Lock()
+	defer dotEnvMutex.Unlock()
+
+	// 初回呼び出し時に、プロセス本来の環境変数のキーを記録しておく
+	if processEnvKeys == nil {
+		processEnvKeys = make(map[string]bool)
+		for _, entry := range os.Environ() {
+			if key, _, ok := strings.Cut(entry, "="); ok {
+				processEnvKeys[key] = true
+			}
+		}
+	}
+
+	// .env ファイルが存在しない場合は通常動作なので、エラーログは出さない。
+	values, err := godotenv.Read()
+	if err != nil && !os.IsNotExist(err) {
+		configLog.warnf(".envファイルの読み込み中にエラーが発生しました (無視されます): %v", err)
+	}
+
+	newKeys := make(map[string]bool, len(values))
+	for key, value := range values {
+		if processEnvKeys[key] {
+			continue // プロセスの環境変数を優先
+		}
+		os.Setenv(key, value)
+		newKeys[key] = true
+	}
+	// 前回 .env から設定したが、今回の .env には存在しないキーを削除する
+	for key := range dotEnvKeys {
+		if !newKeys[key] {
+			os.Unsetenv(key)
+		}
+	}
+	dotEnvKeys = newKeys
+}
+
+// ReloadConfig は、設定ファイルと環境変数を読み直し、安全な変更のみであれば適用します。
+// Returns:
+//
+//	[]string: 適用された変更内容の説明のリスト (変更がなければ空)。
+//	error: 設定の検証エラー、または実行中のサーバーと矛盾する変更が含まれていたために拒否した場合のエラー。
+func ReloadConfig() ([]string, error) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+
+	configLog.infof("設定の再読み込みを開始します...")
+	loadDotEnv()
+	newCfg, err := buildConfig() // config.go
+	if err != nil {
+		configLog.errorf("再読み込み失敗: 設定の検証エラーのため、現在の設定を維持します: %v", err)
+		recordEvent("configReloadFailed", "", "設定の検証エラー") // activity.go
+		return nil, fmt.Errorf("設定の検証エラー: %w", err)
+	}
+	oldCfg := currentConfig()
+
+	changes, rejections := diffConfig(oldCfg, newCfg)
+	if len(rejections) > 0 {
+		for _, reason := range rejections {
+			configLog.infof("再読み込み拒否: %s", reason)
+		}
+		recordEvent("configReloadFailed", "", strings.Join(rejections, " / "))
+		return nil, fmt.Errorf("実行中のサーバーと矛盾する変更が含まれているため、再読み込みを拒否しました: %s", strings.Join(rejections, " / "))
+	}
+	if len(changes) == 0 {
+		configLog.infof("再読み込み完了: 設定に変更はありません。")
+		return changes, nil
+	}
+
+	applyConfig(newCfg) // config.go
+	for _, change := range changes {
+		configLog.infof("変更を適用しました: %s", change)
+	}
+	configLog.infof("再読み込み完了: %d 件の変更を適用しました。", len(changes))
+	recordEvent("configReloaded", "", strings.Join(changes, " / "))
+	return changes, nil
+}
+
+// diffConfig は、現在の設定と新しい設定を比較し、適用される変更内容と、拒否すべき変更の理由を返します。
+func diffConfig(oldCfg, newCfg *AppConfig) (changes []string, rejections []string) {
+	runningServers := getRunningServerNames() // process_manager.go
+
+	// ポートプール: 使用中のポートが新しいポートプールから外れる変更は拒否
+	if !reflect.DeepEqual(oldCfg.PortPools, newCfg.PortPools) {
+		var orphaned []string
+		for _, port := range getCurrentlyUsedPorts() { // port_manager.go
+			if !isPortInPools(port, newCfg.PortPools) {
+				orphaned = append(orphaned, fmt.Sprintf("%d", port))
+			}
+		}
+		if len(orphaned) > 0 {
+			rejections = append(rejections, fmt.Sprintf("使用中のポート (%s) が新しいポート範囲 (%s) に含まれていません", strings.Join(orphaned, ", "), formatPortPools(newCfg.PortPools)))
+		} else {
+			changes = append(changes, fmt.Sprintf("ポート範囲: %s -> %s", formatPortPools(oldCfg.PortPools), formatPortPools(newCfg.PortPools)))
+		}
+	}
+
+	// 実行ファイル / 起動方式: 実行中のサーバーがクラッシュ後に別の方式で再起動されると
+	// 設定ファイル内のMODパスの形式と食い違うため、実行中のサーバーがある間は拒否
+	exeChanged := oldCfg.ServerExePath != newCfg.ServerExePath
+	launcherChanged := !reflect.DeepEqual(oldCfg.Launcher, newCfg.Launcher)
+	if exeChanged || launcherChanged {
+		if len(runningServers) > 0 {
+			rejections = append(rejections, fmt.Sprintf("サーバー実行中 (%s) は実行ファイルと起動方式を変更できません", strings.Join(runningServers, ", ")))
+		} else {
+			if exeChanged {
+				changes = append(changes, fmt.Sprintf("サーバー実行ファイル: %s -> %s", oldCfg.ServerExePath, newCfg.ServerExePath))
+			}
+			if launcherChanged {
+				changes = append(changes, fmt.Sprintf("起動方式: %s -> %s", oldCfg.Launcher.Name(), newCfg.Launcher.Name()))
+			}
+		}
+	}
+
+	// 以下は次回の起動・ダウンロード・再接続から使われるため、常に安全に適用できる
+	if oldCfg.WorkshopPlaylistsInstallDir != newCfg.WorkshopPlaylistsInstallDir {
+		changes = append(changes, fmt.Sprintf("ワークショップ プレイリスト ディレクトリ: %s -> %s", oldCfg.WorkshopPlaylistsInstallDir, newCfg.WorkshopPlaylistsInstallDir))
+	}
+	if oldCfg.WorkshopModsInstallDir != newCfg.WorkshopModsInstallDir {
+		changes = append(changes, fmt.Sprintf("ワークショップ MOD ディレクトリ: %s -> %s", oldCfg.WorkshopModsInstallDir, newCfg.WorkshopModsInstallDir))
+	}
+	if oldCfg.SteamCmdPath != newCfg.SteamCmdPath {
+		changes = append(changes, fmt.Sprintf("SteamCMD パス: %s -> %s", oldCfg.SteamCmdPath, newCfg.SteamCmdPath))
+	}
+	if oldCfg.GameAppID != newCfg.GameAppID {
+		changes = append(changes, fmt.Sprintf("ゲーム App ID: %s -> %s", oldCfg.GameAppID, newCfg.GameAppID))
+	}
+	if oldCfg.SteamCmdLogin != newCfg.SteamCmdLogin {
+		changes = append(changes, fmt.Sprintf("SteamCMD ログイン: %s -> %s", oldCfg.SteamCmdLogin, newCfg.SteamCmdLogin))
+	}
+	if !reflect.DeepEqual(oldCfg.SteamCmdProfiles, newCfg.SteamCmdProfiles) {
+		changes = append(changes, "SteamCMD プロファイル")
+	}
+	if oldCfg.DefaultRestartPolicy != newCfg.DefaultRestartPolicy {
+		changes = append(changes, "再起動ポリシー")
+	}
+	if !reflect.DeepEqual(oldCfg.ServerOverrides, newCfg.ServerOverrides) {
+		changes = append(changes, "サーバーごとの上書き設定")
+	}
+	if oldCfg.WsURL != newCfg.WsURL {
+		changes = append(changes, fmt.Sprintf("WebSocket URL (次回の再接続から有効): %s -> %s", oldCfg.WsURL, newCfg.WsURL))
+	}
+	if oldCfg.Heartbeat != newCfg.Heartbeat {
+		changes = append(changes, fmt.Sprintf("ハートビート (次回の再接続から有効): 間隔=%v, 猶予=%v", newCfg.Heartbeat.Interval, newCfg.Heartbeat.Timeout))
+	}
+	if oldCfg.RequestCacheTTL != newCfg.RequestCacheTTL {
+		changes = append(changes, fmt.Sprintf("重複要求の検出期間: %v -> %v", oldCfg.RequestCacheTTL, newCfg.RequestCacheTTL))
+	}
+	if !reflect.DeepEqual(oldCfg.TLS, newCfg.TLS) {
+		changes = append(changes, fmt.Sprintf("WebSocket の TLS 設定 (次回の再接続から有効): %s", formatTLSSettings(newCfg.TLS)))
+	}
+	if oldCfg.TokenSource != newCfg.TokenSource {
+		changes = append(changes, fmt.Sprintf("認証トークンの読み込み元: %s", formatTokenSource(newCfg.TokenSource))) // token_store.go
+	}
+	if oldCfg.AuthToken != newCfg.AuthToken {
+		changes = append(changes, "認証トークン (次回の再接続から有効)") // 値自体は表示しない
+	}
+	if oldCfg.Signing.Key != newCfg.Signing.Key {
+		changes = append(changes, fmt.Sprintf("メッセージ署名鍵 (有効=%v)", newCfg.Signing.Key != "")) // 値自体は表示しない
+	}
+	if oldCfg.Signing.MaxSkew != newCfg.Signing.MaxSkew {
+		changes = append(changes, fmt.Sprintf("署名付きメッセージのタイムスタンプの許容誤差: %v -> %v", oldCfg.Signing.MaxSkew, newCfg.Signing.MaxSkew))
+	}
+	if oldCfg.AdminAPI.Token != newCfg.AdminAPI.Token {
+		changes = append(changes, "ローカル管理APIの認証トークン") // 値自体は表示しない
+	}
+	if oldCfg.AdminAPI.Enabled != newCfg.AdminAPI.Enabled || oldCfg.AdminAPI.Addr != newCfg.AdminAPI.Addr {
+		changes = append(changes, fmt.Sprintf("ローカル管理API (SWSC の再起動後に有効): 有効=%v, %s", newCfg.AdminAPI.Enabled, newCfg.AdminAPI.Addr))
+	}
+	if oldCfg.Metrics != newCfg.Metrics {
+		changes = append(changes, fmt.Sprintf("Prometheus メトリクス (SWSC の再起動後に有効): 有効=%v, %s%s", newCfg.Metrics.Enabled, newCfg.Metrics.Addr, newCfg.Metrics.Path))
+	}
+	if oldCfg.Outbox.MaxMessages != newCfg.Outbox.MaxMessages || oldCfg.Outbox.MaxAge != newCfg.Outbox.MaxAge {
+		changes = append(changes, fmt.Sprintf("送信待ちキュー: 上限=%d件, 保持期間=%v", newCfg.Outbox.MaxMessages, newCfg.Outbox.MaxAge))
+	}
+	if oldCfg.Backup != newCfg.Backup {
+		changes = append(changes, fmt.Sprintf("バックアップ: %s", formatBackupSettings(newCfg.Backup))) // backup.go
+	}
+	if oldCfg.Crash != newCfg.Crash {
+		changes = append(changes, fmt.Sprintf("クラッシュ情報: %s", formatCrashSettings(newCfg.Crash))) // crash.go
+	}
+	if oldCfg.Players != newCfg.Players {
+		changes = append(changes, fmt.Sprintf("無人時の自動停止: %s (参加・退出の正規表現の変更は次回の起動から有効)", formatIdleTimeout(newCfg.Players.IdleTimeout))) // players.go
+	}
+	if oldCfg.Cgroup != newCfg.Cgroup {
+		changes = append(changes, fmt.Sprintf("リソースの上限 (次回の起動から有効): %s", formatCgroupSettings(newCfg.Cgroup))) // cgroup.go
+	}
+	if oldCfg.Stats != newCfg.Stats {
+		changes = append(changes, fmt.Sprintf("リソース使用量: %s", formatStatsSettings(newCfg.Stats))) // stats.go
+	}
+	if !reflect.DeepEqual(oldCfg.Schedule, newCfg.Schedule) {
+		changes = append(changes, fmt.Sprintf("予定 (保存先の変更は次回の保存から有効): %s", formatScheduleSettings(newCfg.Schedule))) // schedule.go
+	}
+	if oldCfg.Outbox.Path != newCfg.Outbox.Path {
+		changes = append(changes, fmt.Sprintf("送信待ちキューの保存先: '%s' -> '%s'", oldCfg.Outbox.Path, newCfg.Outbox.Path))
+	}
+	if !reflect.DeepEqual(oldCfg.Logging, newCfg.Logging) {
+		changes = append(changes, fmt.Sprintf("ログ出力: 形式=%s, レベル=%s, サブシステム別=%s",
+			newCfg.Logging.Format, newCfg.Logging.Level, formatSubsystemLevels(newCfg.Logging.SubsystemLevels)))
+	}
+	if oldCfg.ConfigFilePath != newCfg.ConfigFilePath {
+		changes = append(changes, fmt.Sprintf("設定ファイル: '%s' -> '%s'", oldCfg.ConfigFilePath, newCfg.ConfigFilePath))
+	}
+	return changes, rejections
+}
+
+// watchReloadSignal は、SIGHUP を受信するたびに設定を再読み込みするゴルーチンです。
+// 再読み込みに成功した場合は、最新の状態をBotに通知するため syncStatus を送信します。
+func watchReloadSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	for range signals {
+		configLog.infof("SIGHUP を受信しました。")
+		if _, err := ReloadConfig(); err != nil {
+			continue // 詳細は ReloadConfig 内でログ出力済み
+		}
+		if err := sendSyncStatus(); err != nil { // websocket_client.go
+			configLog.warnf("再読み込み後の syncStatus 送信に失敗しました: %v", err)
+		}
+	}
+}
+
+// handleReloadConfigRequest は、WebSocket経由で受信した "reloadConfig" 要求を処理します。
+// 再読み込みの結果を応答し、成功した場合は syncStatus を送信します。
+// 引数:
+//
+//	requestID (string): Botからの要求ID。
+//	payload (json.RawMessage): "reloadConfig" 要求のペイロード (現在は未使用)。
+func handleReloadConfigRequest(requestID string, payload json.RawMessage) {
+	reqLog := configLog.withRequest(requestID)
+	reqLog.infof("要求受信")
+	changes, err := ReloadConfig()
+	if err != nil {
+		sendResponse(requestID, false, fmt.Sprintf("設定の再読み込みに失敗しました: %v", err), "") // websocket_client.go
+		return
+	}
+
+	message := "設定を再読み込みしました。変更はありません。"
+	if len(changes) > 0 {
+		message = fmt.Sprintf("設定を再読み込みし、%d 件の変更を適用しました: %s", len(changes), strings.Join(changes, " / "))
+	}
+	sendResponse(requestID, true, message, "")
+	if err := sendSyncStatus(); err != nil {
+		reqLog.warnf("syncStatus 送信に失敗しました: %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- クラッシュ情報の収集 ---
+// サーバーが予期せず終了した場合に、原因の調査に必要な情報をクラッシュごとのフォルダ
+// (CRASH_DIR、省略時は ./crashes の <構成名>/<検出時刻>/) にまとめます。
+//   - クラッシュダンプ: サーバーの作業ディレクトリ (実行ファイルのあるディレクトリ) と設定ディレクトリから、
+//     プロセスの起動後に作成・更新された .dmp ファイルを探して移動します。作業ディレクトリは全サーバーで共有のため、
+//     ミニダンプに記録された PID かファイル名の PID が終了したプロセスと一致するダンプのみを対象にします
+//   - コンソール出力の末尾: ゲームサーバーの stdout / stderr の最後の CRASH_LOG_LINES 行 (console.log)
+//   - メタデータ: PID・終了コード・起動時刻・ダンプの一覧など (crash.json)
+// ダンプはミニダンプとして解析し (minidump.go)、例外の種類と発生したモジュールの要約を添えます。
+// 収集した情報の概要 (ダンプのパスとサイズ、ダンプの要約、終了コード、ログの末尾) は serverCrashDetected イベントに含めて Bot に送信します。
+// 古いクラッシュフォルダは、サーバー構成名ごとに CRASH_KEEP 件を超えたものから削除します。
+
+// crashDumpExtension は、クラッシュダンプのファイル拡張子です。
+const crashDumpExtension = ".dmp"
+
+// crashMetadataFileName と crashConsoleFileName は、クラッシュフォルダに書き込むファイル名です。
+const (
+	crashMetadataFileName = "crash.json"
+	crashConsoleFileName  = "console.log"
+)
+
+// crashFolderTimeFormat は、クラッシュフォルダ名の形式です。
+const crashFolderTimeFormat = "20060102-150405"
+
+// consoleDrainTimeout は、プロセス終了後に残りのコンソール出力を読み終えるまで待つ最大時間です。
+const consoleDrainTimeout = 2 * time.Second
+
+// consoleTail は、ゲームサーバー1プロセス分のコンソール出力の末尾を保持するリングバッファです。
+type consoleTail struct {
+	mutex   sync.Mutex
+	lines   []string
+	next    int // 次に書き込む位置 (バッファが一杯になった後)
+	limit   int
+	readers sync.WaitGroup // stdout / stderr の読み取りゴルーチン
+}
+
+// newConsoleTail は、最大 limit 行を保持するコンソール出力のバッファを作成します。
+func newConsoleTail(limit int) *consoleTail {
+	return &consoleTail{lines: make([]string, 0, limit), limit: limit}
+}
+
+// add は、コンソール出力を1行追加します (stderr の行は "[stderr] " を付けて区別します)。
+func (t *consoleTail) add(stream string, line string) {
+	if t.limit <= 0 {
+		return
+	}
+	if stream == "stderr" {
+		line = "[stderr] " + line
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.lines) < t.limit {
+		t.lines = append(t.lines, line)
+		return
+	}
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % t.limit
+}
+
+// snapshot は、保持している行を古い順に返します。
+func (t *consoleTail) snapshot() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	lines := make([]string, 0, len(t.lines))
+	lines = append(lines, t.lines[t.next:]...)
+	lines = append(lines, t.lines[:t.next]...)
+	return lines
+}
+
+// waitDrained は、出力の読み取りゴルーチンが終了する (パイプが閉じられる) まで最大 timeout 待ちます。
+func (t *consoleTail) waitDrained(timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		t.readers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+var (
+	// consoleTails は、実行中のサーバーのコンソール出力のバッファです (キー: サーバー構成名)。
+	// 起動 (再起動を含む) のたびに新しいバッファに置き換えられます。
+	consoleTails = make(map[string]*consoleTail)
+	// consoleTailsMutex は、consoleTails を保護するためのミューテックスです。
+	consoleTailsMutex sync.Mutex
+)
+
+// startConsoleTail は、サーバーの新しいコンソール出力のバッファを登録して返します。startServerProcess から呼び出されます。
+func startConsoleTail(name string) *consoleTail {
+	tail := newConsoleTail(currentConfig().Crash.LogLines)
+	consoleTailsMutex.Lock()
+	consoleTails[name] = tail
+	consoleTailsMutex.Unlock()
+	return tail
+}
+
+// getConsoleTail は、サーバーのコンソール出力のバッファを返します (なければ nil)。
+func getConsoleTail(name string) *consoleTail {
+	consoleTailsMutex.Lock()
+	defer consoleTailsMutex.Unlock()
+	return consoleTails[name]
+}
+
+// crashDumpInfo は、クラッシュフォルダに移動したクラッシュダンプ1件の情報です。
+type crashDumpInfo struct {
+	Path         string    `json:"path"`         // 移動後のパス
+	OriginalPath string    `json:"originalPath"` // 見つかった場所
+	Size         int64     `json:"size"`
+	ModifiedAt   time.Time `json:"modifiedAt"`
+}
+
+// crashReport は、クラッシュフォルダに書き込むメタデータ (crash.json) です。
+type crashReport struct {
+	ServerName string          `json:"serverName"`
+	Pid        int             `json:"pid"`
+	ExitCode   int             `json:"exitCode"` // シグナルで終了した場合などは -1
+	ExitStatus string          `json:"exitStatus"`
+	Reason     string          `json:"reason"` // "crashed" または "oomKilled" (cgroup.go)
+	StartedAt  time.Time       `json:"startedAt"`
+	DetectedAt time.Time       `json:"detectedAt"`
+	Dir        string          `json:"dir"` // クラッシュフォルダ
+	Dumps      []crashDumpInfo `json:"dumps"`
+	// DumpSummary と Minidump は、Dumps の先頭のダンプを解析した結果です (minidump.go)。
+	DumpSummary string        `json:"dumpSummary,omitempty"`
+	Minidump    *minidumpInfo `json:"minidump,omitempty"`
+	LogTail     []string      `json:"-"` // console.log に書き込む
+}
+
+// collectCrashReport は、予期せず終了したサーバーのクラッシュダンプとコンソール出力をクラッシュフォルダにまとめます。
+// 収集に失敗しても再起動処理は続けるため、エラーはログに記録し、収集できた分だけを返します。
+// Args:
+//
+//	name (string): サーバー構成名。
+//	pid (int): 終了したプロセスのPID。
+//	startedAt (time.Time): プロセスを起動した時刻 (これ以降に作成・更新されたダンプを収集します)。
+//	exitCode (int): 終了コード。
+//	exitStatus (string): 終了状態の説明 (例: "exit status 3", "signal: segmentation fault")。
+//	reason (string): 終了の理由 ("crashed" または "oomKilled")。
+//
+// Returns:
+//
+//	crashReport: 収集したクラッシュ情報。
+func collectCrashReport(name string, pid int, startedAt time.Time, exitCode int, exitStatus string, reason string) crashReport {
+	srvLog := processLog.withServer(name)
+	settings := currentConfig().Crash
+	report := crashReport{
+		ServerName: name,
+		Pid:        pid,
+		ExitCode:   exitCode,
+		ExitStatus: exitStatus,
+		Reason:     reason,
+		StartedAt:  startedAt,
+		DetectedAt: time.Now(),
+		Dumps:      []crashDumpInfo{},
+	}
+
+	// 読み取りゴルーチンに残っている出力を取り込んでから、ログの末尾を取得する
+	if tail := getConsoleTail(name); tail != nil {
+		tail.waitDrained(consoleDrainTimeout)
+		report.LogTail = tail.snapshot()
+	}
+
+	report.Dir = filepath.Join(settings.Dir, name, report.DetectedAt.Format(crashFolderTimeFormat))
+	for i := 2; ; i++ {
+		if _, err := os.Stat(report.Dir); os.IsNotExist(err) {
+			break
+		}
+		report.Dir = filepath.Join(settings.Dir, name, fmt.Sprintf("%s-%d", report.DetectedAt.Format(crashFolderTimeFormat), i))
+	}
+	if err := os.MkdirAll(report.Dir, 0755); err != nil {
+		srvLog.errorf("クラッシュフォルダを作成できません (%s): %v", report.Dir, err)
+		report.Dir = ""
+		return report
+	}
+
+	for _, dumpPath := range findCrashDumps(name, pid, startedAt) {
+		dump, err := moveCrashDump(dumpPath, report.Dir)
+		if err != nil {
+			srvLog.warnf("クラッシュダンプを移動できません (%s): %v", dumpPath, err)
+			continue
+		}
+		srvLog.infof("クラッシュダンプを収集しました: %s (%d バイト)", dump.Path, dump.Size)
+		report.Dumps = append(report.Dumps, dump)
+	}
+	// 新しいダンプを先頭にする (イベントには先頭のダンプを含める)
+	sort.Slice(report.Dumps, func(i, j int) bool { return report.Dumps[i].ModifiedAt.After(report.Dumps[j].ModifiedAt) })
+
+	// 新しいダンプから順に、例外の種類と発生したモジュールを読み取る
+	// (解析できたダンプを先頭に移し、イベントのダンプと要約を一致させる)
+	for i, dump := range report.Dumps {
+		info, err := readMinidumpFile(dump.Path)
+		if err != nil {
+			srvLog.warnf("クラッシュダンプを解析できません (%s): %v", dump.Path, err)
+			continue
+		}
+		report.Minidump = info
+		report.DumpSummary = summarizeMinidump(info)
+		report.Dumps[0], report.Dumps[i] = report.Dumps[i], report.Dumps[0]
+		srvLog.infof("クラッシュダンプの解析結果: %s", report.DumpSummary)
+		break
+	}
+
+	consolePath := filepath.Join(report.Dir, crashConsoleFileName)
+	if err := os.WriteFile(consolePath, []byte(strings.Join(report.LogTail, "\n")+"\n"), 0644); err != nil {
+		srvLog.warnf("コンソール出力を保存できません (%s): %v", consolePath, err)
+	}
+	metadata, err := json.MarshalIndent(report, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(report.Dir, crashMetadataFileName), metadata, 0644)
+	}
+	if err != nil {
+		srvLog.warnf("クラッシュ情報を保存できません (%s): %v", report.Dir, err)
+	}
+	srvLog.infof("クラッシュ情報を保存しました: %s (ダンプ: %d 件, ログ: %d 行)", report.Dir, len(report.Dumps), len(report.LogTail))
+
+	pruneCrashFolders(name)
+	return report
+}
+
+// findCrashDumps は、サーバーの作業ディレクトリ (実行ファイルのあるディレクトリ、サブディレクトリは除く) と
+// 設定ディレクトリ (サブディレクトリを含む) から、プロセスの起動以降に作成・更新された .dmp ファイルを探します。
+// 作業ディレクトリは他のサーバーと共有しているため、終了したプロセスのものと確認できたダンプのみを返します。
+// 設定ディレクトリはサーバーごとのため、別のプロセスのものと分かるダンプ以外はすべて返します。
+func findCrashDumps(name string, pid int, since time.Time) []string {
+	var dumps []string
+	isNewDump := func(filePath string, entry fs.DirEntry) bool {
+		if entry.IsDir() || !strings.EqualFold(filepath.Ext(filePath), crashDumpExtension) {
+			return false
+		}
+		info, err := entry.Info()
+		return err == nil && info.Mode().IsRegular() && !info.ModTime().Before(since)
+	}
+
+	// 作業ディレクトリはゲームのインストール先のため、直下のみを探す
+	serverDir := filepath.Dir(currentConfig().ServerExePath)
+	if entries, err := os.ReadDir(serverDir); err == nil {
+		for _, entry := range entries {
+			filePath := filepath.Join(serverDir, entry.Name())
+			if isNewDump(filePath, entry) && crashDumpProcessMatch(filePath, pid) == dumpProcessMatched {
+				dumps = append(dumps, filePath)
+			}
+		}
+	}
+
+	configDir := filepath.Join(configBaseDir, name)
+	if absPath, err := filepath.Abs(configDir); err == nil {
+		configDir = absPath
+	}
+	_ = filepath.WalkDir(configDir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // 読めないディレクトリは飛ばす
+		}
+		if isNewDump(filePath, entry) && crashDumpProcessMatch(filePath, pid) != dumpProcessMismatched {
+			dumps = append(dumps, filePath)
+		}
+		return nil
+	})
+	return dumps
+}
+
+// dumpProcessMatch は、クラッシュダンプと終了したプロセスの照合結果です。
+type dumpProcessMatch int
+
+const (
+	dumpProcessUnknown    dumpProcessMatch = iota // PID を確認できない
+	dumpProcessMatched                            // 終了したプロセスのダンプ
+	dumpProcessMismatched                         // 別のプロセスのダンプ
+)
+
+// crashDumpProcessMatch は、クラッシュダンプが指定した PID のプロセスのものかどうかを判定します。
+// ミニダンプに PID が記録されていればそれを使い、記録されていない場合は
+// ファイル名に含まれる数字 (WER の LocalDumps の "server64.exe.1234.dmp" など) と照合します。
+func crashDumpProcessMatch(filePath string, pid int) dumpProcessMatch {
+	if info, err := readMinidumpFile(filePath); err == nil && info.ProcessID != 0 {
+		if int(info.ProcessID) == pid {
+			return dumpProcessMatched
+		}
+		return dumpProcessMismatched
+	}
+	baseName := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	isSeparator := func(r rune) bool { return r < '0' || r > '9' }
+	for _, field := range strings.FieldsFunc(baseName, isSeparator) {
+		if field == strconv.Itoa(pid) {
+			return dumpProcessMatched
+		}
+	}
+	return dumpProcessUnknown
+}
+
+// moveCrashDump は、クラッシュダンプをクラッシュフォルダに移動します。
+// 別のファイルシステムで名前を変更できない場合は、コピーしてから元のファイルを削除します。
+func moveCrashDump(sourcePath string, crashDir string) (crashDumpInfo, error) {
+	stat, err := os.Stat(sourcePath)
+	if err != nil {
+		return crashDumpInfo{}, err
+	}
+	// 作業ディレクトリと設定ディレクトリに同じ名前のダンプがある場合は番号を付けて区別する
+	baseName := filepath.Base(sourcePath)
+	targetPath := filepath.Join(crashDir, baseName)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(targetPath); os.IsNotExist(err) {
+			break
+		}
+		targetPath = filepath.Join(crashDir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(baseName, filepath.Ext(baseName)), i, filepath.Ext(baseName)))
+	}
+	if err := os.Rename(sourcePath, targetPath); err != nil {
+		if copyErr := copyCrashFile(sourcePath, targetPath); copyErr != nil {
+			return crashDumpInfo{}, errors.Join(err, copyErr)
+		}
+		if removeErr := os.Remove(sourcePath); removeErr != nil {
+			processLog.warnf("コピー元のクラッシュダンプを削除できません (%s): %v", sourcePath, removeErr)
+		}
+	}
+	return crashDumpInfo{Path: targetPath, OriginalPath: sourcePath, Size: stat.Size(), ModifiedAt: stat.ModTime()}, nil
+}
+
+// copyCrashFile は、ファイルをコピーします。
+func copyCrashFile(sourcePath string, targetPath string) error {
+	source, err := os.Open(sourcePath)
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	target, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		target.Close()
+		os.Remove(targetPath)
+		return err
+	}
+	return target.Close()
+}
+
+// pruneCrashFolders は、サーバー構成名のクラッシュフォルダのうち、新しいものから CRASH_KEEP 件を超えたものを削除します。
+func pruneCrashFolders(name string) {
+	keep := currentConfig().Crash.Keep
+	if keep == 0 {
+		return
+	}
+	serverDir := filepath.Join(currentConfig().Crash.Dir, name)
+	entries, err := os.ReadDir(serverDir)
+	if err != nil {
+		return
+	}
+	var folders []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			folders = append(folders, entry.Name())
+		}
+	}
+	// フォルダ名は検出時刻のため、名前の降順が新しい順になる
+	sort.Sort(sort.Reverse(sort.StringSlice(folders)))
+	for _, folder := range folders[min(keep, len(folders)):] {
+		folderPath := filepath.Join(serverDir, folder)
+		if err := os.RemoveAll(folderPath); err != nil {
+			processLog.withServer(name).warnf("古いクラッシュフォルダを削除できません (%s): %v", folderPath, err)
+			continue
+		}
+		processLog.withServer(name).infof("古いクラッシュフォルダを削除しました: %s", folderPath)
+	}
+}
+
+// formatCrashSettings は、クラッシュ情報の収集の設定をログ表示用の文字列にします。
+func formatCrashSettings(settings CrashSettings) string {
+	keep := "無制限"
+	if settings.Keep > 0 {
+		keep = fmt.Sprintf("%d 件", settings.Keep)
+	}
+	return fmt.Sprintf("保存先=%s, ログ=%d 行, 保持=%s", settings.Dir, settings.LogLines, keep)
+}
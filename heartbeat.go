@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- WebSocket ハートビート ---
+// NAT やロードバランサーの背後では、TCP 接続が片側だけ切れた状態 (ハーフオープン) になっても
+// ReadMessage がエラーを返さず、何時間も「接続中」のまま何も受信できないことがあります。
+// これを検出するため、一定間隔 (HEARTBEAT_INTERVAL) で SWSC から Ping を送信し、
+// 読み取り期限 (ハートビート間隔 + HEARTBEAT_TIMEOUT) までに Pong やメッセージを受信できなければ
+// 接続が失われたとみなして切断し、再接続します。
+// Ping の送信から Pong の受信までの往復時間 (レイテンシ) を記録し、管理APIとメトリクスで公開します。
+
+// errHeartbeatTimeout は、読み取り期限までに Pong やメッセージを受信できなかったことを示すエラーです。
+var errHeartbeatTimeout = errors.New("ハートビート応答なし")
+
+// heartbeatStatus は、ハートビートの状態です (管理APIの応答用)。
+type heartbeatStatus struct {
+	Enabled         bool       `json:"enabled"`
+	IntervalSeconds float64    `json:"intervalSeconds"`
+	TimeoutSeconds  float64    `json:"timeoutSeconds"`
+	LastPingAt      *time.Time `json:"lastPingAt,omitempty"`
+	LastPongAt      *time.Time `json:"lastPongAt,omitempty"`
+	LatencyMs       *float64   `json:"latencyMs,omitempty"` // 最後に計測した往復時間 (ミリ秒)
+	Timeouts        int        `json:"timeouts"`            // 応答がなく切断した回数
+}
+
+var (
+	// wsHeartbeat は、現在の接続のハートビートの状態です。
+	wsHeartbeat heartbeatStatus
+	// activeHeartbeat は、現在の接続で使用しているハートビートの設定です (設定の変更は次回の接続から有効)。
+	activeHeartbeat HeartbeatSettings
+	// heartbeatMutex は、wsHeartbeat と activeHeartbeat を保護するためのミューテックスです。
+	heartbeatMutex sync.Mutex
+)
+
+// startHeartbeat は、接続に読み取り期限と Pong ハンドラを設定し、Ping を定期送信するゴルーチンを開始します。
+// 接続確立直後に ConnectWebSocket から呼び出されます。返されたチャネルを閉じると Ping の送信を停止します。
+// Args:
+//
+//	currentConn (*websocket.Conn): ハートビートを行う WebSocket 接続。
+//	writer (*wsWriter): 接続の書き込み用ゴルーチン (ws_writer.go)。Ping はこれを通じて送信します。
+//
+// Returns:
+//
+//	chan struct{}: 接続終了時に閉じるチャネル。
+func startHeartbeat(currentConn *websocket.Conn, writer *wsWriter) chan struct{} {
+	settings := currentConfig().Heartbeat // 接続中は同じ設定を使う (変更は次回の接続から有効)
+	stop := make(chan struct{})
+
+	heartbeatMutex.Lock()
+	activeHeartbeat = settings
+	timeouts := wsHeartbeat.Timeouts
+	wsHeartbeat = heartbeatStatus{
+		Enabled:         settings.Interval > 0,
+		IntervalSeconds: settings.Interval.Seconds(),
+		TimeoutSeconds:  settings.Timeout.Seconds(),
+		Timeouts:        timeouts,
+	}
+	heartbeatMutex.Unlock()
+
+	if settings.Interval <= 0 {
+		return stop // ハートビート無効
+	}
+
+	extendReadDeadline(currentConn)
+	currentConn.SetPongHandler(func(appData string) error {
+		handlePong(appData)
+		extendReadDeadline(currentConn)
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(settings.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// Ping のデータに送信時刻を入れておき、Pong で往復時間を計算する
+				now := time.Now()
+				payload := []byte(strconv.FormatInt(now.UnixNano(), 10))
+				// 書き込み用ゴルーチンがメッセージより優先して書き込む。書き込みに失敗した場合は接続が閉じられる
+				if !writer.writeControl(websocket.PingMessage, payload, "ping") {
+					wsLog.warnf("Ping を送信できませんでした (応答がなければ読み取り期限の超過で切断されます)")
+					continue
+				}
+				heartbeatMutex.Lock()
+				wsHeartbeat.LastPingAt = &now
+				heartbeatMutex.Unlock()
+				wsLog.debugf("Ping送信")
+			}
+		}
+	}()
+	return stop
+}
+
+// extendReadDeadline は、接続の読み取り期限を「現在時刻 + ハートビート間隔 + 応答待ち時間」に延長します。
+// Pong・Ping・メッセージのいずれかを受信するたびに呼び出されます。ハートビートが無効な場合は何もしません。
+func extendReadDeadline(currentConn *websocket.Conn) {
+	heartbeatMutex.Lock()
+	settings := activeHeartbeat
+	heartbeatMutex.Unlock()
+	if settings.Interval <= 0 {
+		return
+	}
+	currentConn.SetReadDeadline(time.Now().Add(settings.Interval + settings.Timeout))
+}
+
+// handlePong は、Pong のデータ (Ping の送信時刻) から往復時間を計算して記録します。
+func handlePong(appData string) {
+	now := time.Now()
+	heartbeatMutex.Lock()
+	defer heartbeatMutex.Unlock()
+	wsHeartbeat.LastPongAt = &now
+	sentAt, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return // SWSC が送信した Ping 以外への Pong (サーバーからの一方的な Pong など)
+	}
+	latency := float64(now.Sub(time.Unix(0, sentAt)).Microseconds()) / 1000
+	wsHeartbeat.LatencyMs = &latency
+	wsLog.debugf("Pong受信 (往復時間: %.1fms)", latency)
+}
+
+// isHeartbeatTimeout は、読み取りエラーが読み取り期限の超過 (ハートビート応答なし) によるものかどうかを判定し、
+// その場合はタイムアウト回数を記録します。
+func isHeartbeatTimeout(err error) bool {
+	var netErr interface{ Timeout() bool }
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		return false
+	}
+	heartbeatMutex.Lock()
+	wsHeartbeat.Timeouts++
+	heartbeatMutex.Unlock()
+	return true
+}
+
+// getHeartbeatStatus は、ハートビートの状態のコピーを返します。
+func getHeartbeatStatus() heartbeatStatus {
+	heartbeatMutex.Lock()
+	defer heartbeatMutex.Unlock()
+	status := wsHeartbeat
+	if status.LatencyMs != nil {
+		latency := *status.LatencyMs
+		status.LatencyMs = &latency
+	}
+	return status
+}
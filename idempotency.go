@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// --- 要求IDによる重複要求の検出 ---
+// ネットワークの瞬断などで Bot が同じ要求 (startServer など) を再送すると、SteamCMD の実行や
+// 既存プロセスの停止を含む処理全体が再度実行されてしまいます。
+// これを防ぐため、最近受信した要求IDとその最終応答 (response / error) を一定時間 (REQUEST_CACHE_TTL) 記録し、
+// 同じ要求IDの要求を再度受信した場合は、処理を実行せずに次のように応答します。
+//   - 処理が完了している場合: 記録している最終応答をそのまま再送
+//   - 処理中の場合: 処理中であることを示す statusUpdate (status: "duplicate_request_in_progress")
+// 記録の対象は Bot から WebSocket 経由で受信した要求のみです (ローカル要求は要求IDを SWSC が発行するため重複しない)。
+
+// duplicateInProgressStatus は、処理中の要求と同じ要求IDの要求を受信した場合に返す statusUpdate の status です。
+const duplicateInProgressStatus = "duplicate_request_in_progress"
+
+// requestRecord は、受信した要求1件の記録です。
+type requestRecord struct {
+	Type        string
+	ReceivedAt  time.Time
+	CompletedAt time.Time  // 最終応答を送信した時刻 (処理中はゼロ値)
+	LastStatus  string     // 最後に送信した進捗メッセージ (statusUpdate の message)
+	Final       *WsMessage // 最終応答 (処理中は nil)
+}
+
+var (
+	// requestHistory は、最近受信した要求の記録です (キー: RequestID)。
+	requestHistory = make(map[string]*requestRecord)
+	// requestHistoryMutex は、requestHistory を保護するためのミューテックスです。
+	requestHistoryMutex sync.Mutex
+)
+
+// claimRequest は、要求IDを記録し、初めて受信した要求かどうかを返します。
+// readMessages で要求ハンドラを呼び出す前に呼び出されます。重複した要求の場合は、この関数内で応答を送信します。
+// Args:
+//
+//	requestID (string): 要求ID。空の場合は重複を判定できないため常に true を返します。
+//	requestType (string): 要求の種類 (例: "startServer")。
+//
+// Returns:
+//
+//	bool: 要求を処理してよい場合は true、重複した要求のため処理してはいけない場合は false。
+func claimRequest(requestID string, requestType string) bool {
+	ttl := currentConfig().RequestCacheTTL
+	if requestID == "" || ttl <= 0 {
+		return true
+	}
+
+	requestHistoryMutex.Lock()
+	now := time.Now()
+	for id, record := range requestHistory {
+		// 処理中の要求は完了するまで保持し、完了した要求は保持期間を過ぎたら破棄する
+		if record.Final != nil && now.Sub(record.CompletedAt) > ttl {
+			delete(requestHistory, id)
+		}
+	}
+	record, exists := requestHistory[requestID]
+	if !exists {
+		requestHistory[requestID] = &requestRecord{Type: requestType, ReceivedAt: now}
+		requestHistoryMutex.Unlock()
+		return true
+	}
+	duplicate := *record
+	requestHistoryMutex.Unlock()
+
+	replyDuplicateRequest(requestID, requestType, duplicate)
+	return false
+}
+
+// replyDuplicateRequest は、重複した要求に対して、記録している最終応答または処理中の通知を送信します。
+func replyDuplicateRequest(requestID string, requestType string, record requestRecord) {
+	reqLog := wsLog.withRequest(requestID)
+	if record.Type != requestType {
+		reqLog.warnf("要求IDが別の種類の要求 (%s) と重複しています。新しい要求 (%s) は実行しません。", record.Type, requestType)
+	}
+
+	if record.Final != nil {
+		metricDuplicateRequests.inc(requestType, "completed") // metrics.go
+		reqLog.infof("重複した要求 (%s) を受信しました。処理済みのため、前回の応答 (%s) を再送します (完了: %s)",
+			requestType, record.Final.Type, record.CompletedAt.Format("15:04:05"))
+		sendMessage(*record.Final)
+		return
+	}
+
+	metricDuplicateRequests.inc(requestType, "in_progress")
+	reqLog.infof("重複した要求 (%s) を受信しました。元の要求は処理中のため、再実行せずに処理中であることを通知します (受信: %s)",
+		requestType, record.ReceivedAt.Format("15:04:05"))
+	message := fmt.Sprintf("同じ要求 (%s) を処理中です (受信: %s)", record.Type, record.ReceivedAt.Format("15:04:05"))
+	if record.LastStatus != "" {
+		message += ": " + record.LastStatus
+	}
+	sendStatusUpdate(requestID, duplicateInProgressStatus, message)
+}
+
+// recordRequestResult は、送信されるメッセージから記録中の要求の進捗と最終応答を記録します。
+// sendMessage の先頭で observeOutgoingMessage とともに呼び出されます。
+func recordRequestResult(msg WsMessage) {
+	if msg.RequestID == "" {
+		return
+	}
+	requestHistoryMutex.Lock()
+	defer requestHistoryMutex.Unlock()
+	record, ok := requestHistory[msg.RequestID]
+	if !ok || record.Final != nil {
+		return // 記録対象外の要求、または再送した最終応答
+	}
+
+	switch msg.Type {
+	case "statusUpdate":
+		var update StatusUpdatePayload
+		if err := json.Unmarshal(msg.Payload, &update); err == nil && update.Status != duplicateInProgressStatus {
+			record.LastStatus = update.Message
+		}
+	case "response", "error":
+		final := msg
+		record.Final = &final
+		record.CompletedAt = time.Now()
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// --- ゲームサーバーの起動方式 (ランチャー) ---
+// Stormworks の専用サーバーは Windows 実行ファイルのため、Linux ホストでは Wine や独自のラッパー経由で起動します。
+// ランチャーは「どのように実行ファイルを起動するか」と「ホスト上のパスをゲームから見えるパスにどう変換するか」を担当します。
+
+// 起動方式 (SERVER_LAUNCHER) に指定できる値
+const (
+	launcherNative = "native" // 実行ファイルを直接起動する (Windowsホスト)
+	launcherWine   = "wine"   // Wine 経由で起動する (Linuxホスト)
+	launcherCustom = "custom" // 任意のラッパーコマンド経由で起動する
+)
+
+// ゲームに渡すパスの形式 (SERVER_LAUNCHER_PATH_STYLE) に指定できる値
+const (
+	pathStyleNative  = "native"  // ホストOSのパス形式をそのまま使う
+	pathStyleWindows = "windows" // ドライブレター付きのWindows形式に変換する (例: Z:\home\swsc\config\test)
+	pathStylePosix   = "posix"   // スラッシュ区切りのPOSIX形式に変換する
+)
+
+// defaultWineDrive は、Wine がホストのルート (/) を割り当てるデフォルトのドライブレターです。
+const defaultWineDrive = "Z:"
+
+// ServerLauncher は、ゲームサーバーの起動方式を表すインターフェースです。
+type ServerLauncher interface {
+	// Name は、ログ出力用の起動方式名を返します。
+	Name() string
+	// BuildCommand は、ゲームサーバー実行ファイルのパスと引数から起動コマンドを組み立てます。
+	BuildCommand(exePath string, args []string) *exec.Cmd
+	// GamePath は、ホスト上の絶対パスをゲームが解釈できる形式のパスに変換します。
+	GamePath(hostPath string) string
+}
+
+// nativeLauncher は、実行ファイルを直接起動するランチャーです。
+type nativeLauncher struct{}
+
+func (nativeLauncher) Name() string { return launcherNative }
+
+func (nativeLauncher) BuildCommand(exePath string, args []string) *exec.Cmd {
+	return exec.Command(exePath, args...)
+}
+
+func (nativeLauncher) GamePath(hostPath string) string {
+	// ホストとゲームが同じOS上で動くため、OSのパス区切り文字に正規化するだけでよい
+	return filepath.Clean(hostPath)
+}
+
+// wineLauncher は、Wine 経由で Windows 実行ファイルを起動するランチャーです。
+type wineLauncher struct {
+	winePath   string // wine 実行ファイルのパス (例: "wine", "/usr/bin/wine64")
+	winePrefix string // WINEPREFIX (空の場合は Wine のデフォルト)
+	drive      string // ホストのルート (/) が割り当てられているドライブレター (例: "Z:")
+}
+
+func (l wineLauncher) Name() string { return launcherWine }
+
+func (l wineLauncher) BuildCommand(exePath string, args []string) *exec.Cmd {
+	cmd := exec.Command(l.winePath, append([]string{exePath}, args...)...)
+	if l.winePrefix != "" {
+		cmd.Env = append(os.Environ(), "WINEPREFIX="+l.winePrefix)
+	}
+	return cmd
+}
+
+func (l wineLauncher) GamePath(hostPath string) string {
+	return toWindowsDrivePath(hostPath, l.drive)
+}
+
+// customLauncher は、任意のラッパーコマンド (例: プロトン、コンテナ起動スクリプト) 経由で起動するランチャーです。
+// 起動コマンドは「ラッパーコマンド ラッパー引数... 実行ファイル 引数...」の形になります。
+type customLauncher struct {
+	command   string   // ラッパーコマンド
+	args      []string // 実行ファイルの前に渡すラッパー引数
+	pathStyle string   // ゲームに渡すパスの形式 (pathStyleNative / pathStyleWindows / pathStylePosix)
+	drive     string   // pathStyleWindows の場合に使うドライブレター
+}
+
+func (l customLauncher) Name() string { return launcherCustom }
+
+func (l customLauncher) BuildCommand(exePath string, args []string) *exec.Cmd {
+	fullArgs := append(append([]string{}, l.args...), exePath)
+	return exec.Command(l.command, append(fullArgs, args...)...)
+}
+
+func (l customLauncher) GamePath(hostPath string) string {
+	switch l.pathStyle {
+	case pathStyleWindows:
+		return toWindowsDrivePath(hostPath, l.drive)
+	case pathStylePosix:
+		return filepath.ToSlash(filepath.Clean(hostPath))
+	default:
+		return filepath.Clean(hostPath)
+	}
+}
+
+// toWindowsDrivePath は、POSIX形式の絶対パスを Wine のドライブマッピングに従ったWindows形式のパスに変換します。
+// 例: "/home/swsc/config/test" -> "Z:\home\swsc\config\test"
+// 既にドライブレターを含むパスは、区切り文字のみ変換します。
+func toWindowsDrivePath(hostPath string, drive string) string {
+	slashPath := strings.ReplaceAll(hostPath, "\\", "/")
+	if len(slashPath) >= 2 && slashPath[1] == ':' {
+		// 既にWindows形式 (例: C:/...) の場合
+		return strings.ReplaceAll(slashPath, "/", "\\")
+	}
+	if !strings.HasPrefix(slashPath, "/") {
+		slashPath = "/" + slashPath
+	}
+	return drive + strings.ReplaceAll(slashPath, "/", "\\")
+}
+
+// newServerLauncher は、設定値から ServerLauncher を生成します。
+// Args:
+//
+//	kind (string): 起動方式 (launcherNative / launcherWine / launcherCustom)。空の場合は native。
+//	winePath (string): Wine 実行ファイルのパス (wine の場合のみ使用、空なら "wine")。
+//	winePrefix (string): WINEPREFIX (wine の場合のみ使用、空なら未設定)。
+//	customCommand (string): ラッパーコマンドと引数を空白区切りで指定した文字列 (custom の場合のみ使用)。
+//	pathStyle (string): ゲームに渡すパスの形式 (custom の場合のみ使用、空なら native)。
+//	drive (string): Windows形式に変換する場合のドライブレター (空なら "Z:")。
+//
+// Returns:
+//
+//	ServerLauncher: 生成されたランチャー。
+//	error: 設定値が不正な場合のエラー。
+func newServerLauncher(kind, winePath, winePrefix, customCommand, pathStyle, drive string) (ServerLauncher, error) {
+	if drive == "" {
+		drive = defaultWineDrive
+	}
+	if len(drive) != 2 || drive[1] != ':' {
+		return nil, fmt.Errorf("ドライブレターの形式が不正です: '%s' (例: Z:)", drive)
+	}
+
+	switch strings.ToLower(kind) {
+	case "", launcherNative:
+		return nativeLauncher{}, nil
+	case launcherWine:
+		if winePath == "" {
+			winePath = "wine"
+		}
+		return wineLauncher{winePath: winePath, winePrefix: winePrefix, drive: drive}, nil
+	case launcherCustom:
+		fields := strings.Fields(customCommand)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("起動方式 '%s' にはラッパーコマンドの指定が必要です", launcherCustom)
+		}
+		switch pathStyle {
+		case "":
+			pathStyle = pathStyleNative
+		case pathStyleNative, pathStyleWindows, pathStylePosix:
+		default:
+			return nil, fmt.Errorf("パス形式 '%s' は不正です (%s / %s / %s のいずれかを指定してください)", pathStyle, pathStyleNative, pathStyleWindows, pathStylePosix)
+		}
+		return customLauncher{command: fields[0], args: fields[1:], pathStyle: pathStyle, drive: drive}, nil
+	default:
+		return nil, fmt.Errorf("起動方式 '%s' は不正です (%s / %s / %s のいずれかを指定してください)", kind, launcherNative, launcherWine, launcherCustom)
+	}
+}
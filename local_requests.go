@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// --- ローカル要求の応答ルーティング ---
+// CLI などWebSocketを経由しない呼び出し元から、handleStartServerProcess などの既存の要求ハンドラを
+// そのまま再利用するための仕組みです。
+// ローカル要求として登録された RequestID 宛てのメッセージ (statusUpdate / response / error) は、
+// sendMessage でWebSocketに送信される代わりに、登録したチャネルに配送されます。
+
+// localRequestBufferSize は、ローカル要求1件あたりに保持できる未読メッセージ数です。
+const localRequestBufferSize = 64
+
+var (
+	// localRequests は、ローカル要求の RequestID と、その要求宛てのメッセージを受け取るチャネルの対応です。
+	localRequests map[string]chan WsMessage = make(map[string]chan WsMessage)
+	// localEventObserver は、RequestID を持たないメッセージ (serverEvent など) をローカルで受け取る関数です (未設定なら nil)。
+	localEventObserver func(WsMessage)
+	// localRequestsMutex は、localRequests と localEventObserver を保護するためのミューテックスです。
+	localRequestsMutex sync.Mutex
+	// localRequestSeq は、ローカル要求の RequestID を一意にするための連番です。
+	localRequestSeq atomic.Uint64
+)
+
+// newLocalRequestID は、ローカル要求用の一意な RequestID を生成します (例: "cli-1712345678-1")。
+func newLocalRequestID(prefix string) string {
+	return fmt.Sprintf("%s-%d-%d", prefix, time.Now().Unix(), localRequestSeq.Add(1))
+}
+
+// setLocalEventObserver は、RequestID を持たないメッセージを受け取る関数を設定します (nil で解除)。
+func setLocalEventObserver(observer func(WsMessage)) {
+	localRequestsMutex.Lock()
+	defer localRequestsMutex.Unlock()
+	localEventObserver = observer
+}
+
+// deliverLocalMessage は、メッセージがローカル要求宛てであれば登録されたチャネルに配送します。
+// sendMessage の先頭で呼び出され、配送した場合は true を返します (WebSocketには送信しません)。
+func deliverLocalMessage(msg WsMessage) bool {
+	localRequestsMutex.Lock()
+	ch, ok := localRequests[msg.RequestID]
+	observer := localEventObserver
+	localRequestsMutex.Unlock()
+
+	if msg.RequestID == "" {
+		if observer != nil {
+			observer(msg)
+		}
+		return false // イベントはWebSocket側にも送信を試みる
+	}
+	if !ok {
+		return false
+	}
+	select {
+	case ch <- msg:
+	default:
+		// 1件の要求で送られるメッセージ数はバッファより十分少ないため、通常ここには到達しない
+		mainLog.warnf("受信バッファが一杯のためメッセージを破棄しました (ReqID: %s, Type: %s)", msg.RequestID, msg.Type)
+	}
+	return true
+}
+
+// isFinalMessage は、メッセージが要求に対する最終的な応答 (response / error) かどうかを判定します。
+func isFinalMessage(msg WsMessage) bool {
+	return msg.Type == "response" || msg.Type == "error"
+}
+
+// runLocalRequest は、要求ハンドラをローカル要求として実行し、最終応答を受け取るまで待機します。
+// 途中の進捗通知 (statusUpdate) は onUpdate に渡されます (nil の場合は破棄)。
+// Args:
+//
+//	requestID (string): ローカル要求の RequestID (newLocalRequestID で生成)。
+//	requestType (string): 要求の種類 (例: "startServer")。処理中の要求の記録に使用します。
+//	handler (func): 実行する要求ハンドラ (例: handleStartServerProcess)。
+//	payload (interface{}): 要求ペイロード。JSONにエンコードしてハンドラに渡されます。
+//	timeout (time.Duration): 最終応答を待つ最大時間 (0 の場合は無制限)。
+//	onUpdate (func(WsMessage)): 進捗通知を受け取る関数。
+//
+// Returns:
+//
+//	WsMessage: 最終応答メッセージ (Type が "response" または "error")。
+//	error: ペイロードのエンコード失敗またはタイムアウトの場合のエラー。
+func runLocalRequest(requestID string, requestType string, handler func(string, json.RawMessage), payload interface{}, timeout time.Duration, onUpdate func(WsMessage)) (WsMessage, error) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return WsMessage{}, fmt.Errorf("ペイロードのエンコード失敗: %w", err)
+	}
+
+	ch := make(chan WsMessage, localRequestBufferSize)
+	localRequestsMutex.Lock()
+	localRequests[requestID] = ch
+	localRequestsMutex.Unlock()
+	defer func() {
+		localRequestsMutex.Lock()
+		delete(localRequests, requestID)
+		localRequestsMutex.Unlock()
+	}()
+
+	beginRequest(requestID, requestType, requestSourceLocal, payloadBytes) // activity.go
+	go handler(requestID, payloadBytes)
+
+	var timeoutCh <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timeoutCh = timer.C
+	}
+	for {
+		select {
+		case msg := <-ch:
+			if isFinalMessage(msg) {
+				return msg, nil
+			}
+			if onUpdate != nil {
+				onUpdate(msg)
+			}
+		case <-timeoutCh:
+			return WsMessage{}, fmt.Errorf("要求 '%s' の応答が %v 以内に返りませんでした", requestID, timeout)
+		}
+	}
+}
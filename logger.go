@@ -0,0 +1,333 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// --- 構造化ログ ---
+// log/slog を使い、全てのログにサブシステム名 (subsystem) と、必要に応じてサーバー構成名 (server) や
+// 要求ID (requestId) を構造化フィールドとして付与します。
+// 出力形式はテキスト (従来に近い人間向けの形式) と JSON (ログ基盤向け) から選択でき、
+// ログレベルは全体 (LOG_LEVEL) とサブシステムごと (LOG_LEVELS) に設定できます。
+// 例: LOG_LEVEL=info, LOG_LEVELS=steamcmd=warn,websocket=debug, LOG_FORMAT=json
+
+// サブシステム名 (ログの subsystem フィールド、LOG_LEVELS のキー)
+const (
+	subsystemMain       = "main"
+	subsystemConfig     = "config"
+	subsystemWebSocket  = "websocket"
+	subsystemProcess    = "process"
+	subsystemPort       = "port"
+	subsystemXML        = "xml"
+	subsystemSteamCmd   = "steamcmd"
+	subsystemGameServer = "gameserver"
+	subsystemAdminAPI   = "admin"
+	subsystemMetrics    = "metrics"
+	subsystemCLI        = "cli"
+	subsystemBackup     = "backup"
+	subsystemSchedule   = "schedule"
+)
+
+// subsystemTags は、テキスト形式で表示するサブシステムのタグです (従来のログのプレフィックスと同じ表記)。
+var subsystemTags = map[string]string{
+	subsystemMain:       "メイン",
+	subsystemConfig:     "設定",
+	subsystemWebSocket:  "WebSocket",
+	subsystemProcess:    "プロセス管理",
+	subsystemPort:       "ポート管理",
+	subsystemXML:        "XML管理",
+	subsystemSteamCmd:   "SteamCMD",
+	subsystemGameServer: "GameServer",
+	subsystemAdminAPI:   "管理API",
+	subsystemMetrics:    "メトリクス",
+	subsystemCLI:        "CLI",
+	subsystemBackup:     "バックアップ",
+	subsystemSchedule:   "スケジュール",
+}
+
+// ログ出力形式 (LOG_FORMAT)
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// LoggingSettings は、ログ出力の設定です。
+type LoggingSettings struct {
+	Format          string                // text / json
+	Level           slog.Level            // 全体のログレベル
+	SubsystemLevels map[string]slog.Level // サブシステムごとのログレベル (キー: サブシステム名)
+}
+
+// defaultLoggingSettings は、設定の読み込み前 (および未指定の場合) のログ設定です。
+var defaultLoggingSettings = LoggingSettings{Format: logFormatText, Level: slog.LevelInfo}
+
+var (
+	// activeLogging は、現在のログ設定です (設定の再読み込みでレベルが変更されます)。
+	activeLogging = defaultLoggingSettings
+	// logOutputHandler は、実際にログを書き出すハンドラです (出力形式に応じて差し替えられます)。
+	logOutputHandler slog.Handler = newTextLogHandler(os.Stderr)
+	// loggingMutex は、activeLogging と logOutputHandler を保護するためのミューテックスです。
+	loggingMutex sync.RWMutex
+)
+
+// 各サブシステムのロガー
+var (
+	mainLog       = newSubsystemLogger(subsystemMain)
+	configLog     = newSubsystemLogger(subsystemConfig)
+	wsLog         = newSubsystemLogger(subsystemWebSocket)
+	processLog    = newSubsystemLogger(subsystemProcess)
+	portLog       = newSubsystemLogger(subsystemPort)
+	xmlLog        = newSubsystemLogger(subsystemXML)
+	steamCmdLog   = newSubsystemLogger(subsystemSteamCmd)
+	gameServerLog = newSubsystemLogger(subsystemGameServer)
+	adminLog      = newSubsystemLogger(subsystemAdminAPI)
+	metricsLog    = newSubsystemLogger(subsystemMetrics)
+	cliLog        = newSubsystemLogger(subsystemCLI)
+	backupLog     = newSubsystemLogger(subsystemBackup)
+	scheduleLog   = newSubsystemLogger(subsystemSchedule)
+)
+
+// applyLoggingSettings は、ログ設定を反映します。設定の読み込み・再読み込み時に applyConfig から呼び出されます。
+func applyLoggingSettings(settings LoggingSettings) {
+	loggingMutex.Lock()
+	defer loggingMutex.Unlock()
+	if settings.Format != activeLogging.Format {
+		if settings.Format == logFormatJSON {
+			logOutputHandler = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+		} else {
+			logOutputHandler = newTextLogHandler(os.Stderr)
+		}
+	}
+	activeLogging = settings
+}
+
+// logLevelFor は、指定したサブシステムに適用するログレベルを返します。
+func logLevelFor(subsystem string) slog.Level {
+	loggingMutex.RLock()
+	defer loggingMutex.RUnlock()
+	if level, ok := activeLogging.SubsystemLevels[subsystem]; ok {
+		return level
+	}
+	return activeLogging.Level
+}
+
+// parseLogLevel は、"debug" / "info" / "warn" / "error" のログレベル文字列を解析します。
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return 0, fmt.Errorf("ログレベル '%s' が不正です (debug / info / warn / error のいずれかを指定してください)", value)
+	}
+	return level, nil
+}
+
+// parseSubsystemLevels は、"steamcmd=warn,websocket=debug" 形式のサブシステムごとのログレベル指定を解析します。
+func parseSubsystemLevels(value string) (map[string]slog.Level, []error) {
+	levels := make(map[string]slog.Level)
+	var errs []error
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		subsystem, levelText, ok := strings.Cut(entry, "=")
+		if !ok {
+			errs = append(errs, fmt.Errorf("'%s' は サブシステム=レベル の形式で指定してください", entry))
+			continue
+		}
+		subsystem = strings.TrimSpace(subsystem)
+		if _, known := subsystemTags[subsystem]; !known {
+			errs = append(errs, fmt.Errorf("不明なサブシステム '%s' です (指定可能: %s)", subsystem, strings.Join(knownSubsystems(), ", ")))
+			continue
+		}
+		level, err := parseLogLevel(levelText)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		levels[subsystem] = level
+	}
+	return levels, errs
+}
+
+// formatSubsystemLevels は、サブシステムごとのログレベルを "steamcmd=WARN,websocket=DEBUG" の形式で返します。
+func formatSubsystemLevels(levels map[string]slog.Level) string {
+	if len(levels) == 0 {
+		return "なし"
+	}
+	entries := make([]string, 0, len(levels))
+	for subsystem, level := range levels {
+		entries = append(entries, fmt.Sprintf("%s=%s", subsystem, level))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+// knownSubsystems は、サブシステム名の一覧を名前順で返します。
+func knownSubsystems() []string {
+	names := make([]string, 0, len(subsystemTags))
+	for name := range subsystemTags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// --- サブシステムロガー ---
+
+// subsystemLogger は、サブシステムと付加情報 (サーバー構成名、要求IDなど) を保持したロガーです。
+type subsystemLogger struct {
+	logger *slog.Logger
+}
+
+// newSubsystemLogger は、指定したサブシステムのロガーを作成します。
+func newSubsystemLogger(subsystem string) *subsystemLogger {
+	return &subsystemLogger{logger: slog.New(&subsystemHandler{subsystem: subsystem})}
+}
+
+// with は、任意のフィールド (キーと値の組) を付与したロガーを返します。
+func (l *subsystemLogger) with(args ...any) *subsystemLogger {
+	return &subsystemLogger{logger: l.logger.With(args...)}
+}
+
+// withServer は、サーバー構成名 (server フィールド) を付与したロガーを返します。
+func (l *subsystemLogger) withServer(name string) *subsystemLogger {
+	return l.with("server", name)
+}
+
+// withRequest は、要求ID (requestId フィールド) を付与したロガーを返します。
+func (l *subsystemLogger) withRequest(requestID string) *subsystemLogger {
+	return l.with("requestId", requestID)
+}
+
+// debugf は、デバッグレベルのログを出力します (SteamCMD の出力など、通常は不要な詳細情報)。
+func (l *subsystemLogger) debugf(format string, args ...any) {
+	l.logf(slog.LevelDebug, format, args...)
+}
+
+// infof は、情報レベルのログを出力します。
+func (l *subsystemLogger) infof(format string, args ...any) {
+	l.logf(slog.LevelInfo, format, args...)
+}
+
+// warnf は、警告レベルのログを出力します。
+func (l *subsystemLogger) warnf(format string, args ...any) {
+	l.logf(slog.LevelWarn, format, args...)
+}
+
+// errorf は、エラーレベルのログを出力します。
+func (l *subsystemLogger) errorf(format string, args ...any) {
+	l.logf(slog.LevelError, format, args...)
+}
+
+// logf は、レベルが有効な場合のみメッセージを整形して出力します。
+func (l *subsystemLogger) logf(level slog.Level, format string, args ...any) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	l.logger.Log(ctx, level, fmt.Sprintf(format, args...))
+}
+
+// --- slog ハンドラ ---
+
+// subsystemHandler は、サブシステムごとのログレベルで絞り込み、subsystem フィールドを付与して
+// 現在の出力ハンドラ (logOutputHandler) に渡す slog.Handler です。
+// 出力形式とログレベルは設定の再読み込みで変わるため、ログ出力のたびに現在の設定を参照します。
+type subsystemHandler struct {
+	subsystem string
+	attrs     []slog.Attr
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= logLevelFor(h.subsystem)
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(slog.String("subsystem", h.subsystem))
+	record.AddAttrs(h.attrs...)
+	loggingMutex.RLock()
+	handler := logOutputHandler
+	loggingMutex.RUnlock()
+	return handler.Handle(ctx, record)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	merged := make([]slog.Attr, 0, len(h.attrs)+len(attrs))
+	merged = append(merged, h.attrs...)
+	merged = append(merged, attrs...)
+	return &subsystemHandler{subsystem: h.subsystem, attrs: merged}
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h // グループは使用しない
+}
+
+// textLogHandler は、従来のログに近い形式で出力する slog.Handler です。
+// 例: 2024/01/02 15:04:05 INFO [プロセス管理] サーバー起動 server=test requestId=abc
+type textLogHandler struct {
+	mutex  *sync.Mutex
+	output io.Writer
+}
+
+// newTextLogHandler は、テキスト形式のハンドラを作成します。
+func newTextLogHandler(output io.Writer) *textLogHandler {
+	return &textLogHandler{mutex: &sync.Mutex{}, output: output}
+}
+
+func (h *textLogHandler) Enabled(context.Context, slog.Level) bool {
+	return true // レベルの判定は subsystemHandler で行う
+}
+
+func (h *textLogHandler) Handle(_ context.Context, record slog.Record) error {
+	var subsystem string
+	var fields []string
+	record.Attrs(func(attr slog.Attr) bool {
+		if attr.Key == "subsystem" {
+			subsystem = attr.Value.String()
+		} else {
+			fields = append(fields, fmt.Sprintf("%s=%s", attr.Key, quoteLogValue(attr.Value.String())))
+		}
+		return true
+	})
+	tag := subsystemTags[subsystem]
+	if tag == "" {
+		tag = subsystem
+	}
+
+	var line strings.Builder
+	line.WriteString(record.Time.Format("2006/01/02 15:04:05"))
+	fmt.Fprintf(&line, " %-5s [%s] %s", record.Level.String(), tag, record.Message)
+	for _, field := range fields {
+		line.WriteString(" ")
+		line.WriteString(field)
+	}
+	line.WriteString("\n")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	_, err := io.WriteString(h.output, line.String())
+	return err
+}
+
+func (h *textLogHandler) WithAttrs([]slog.Attr) slog.Handler {
+	return h // フィールドは subsystemHandler が Record に追加する
+}
+
+func (h *textLogHandler) WithGroup(string) slog.Handler {
+	return h
+}
+
+// quoteLogValue は、空白や引用符を含むフィールド値を引用符で囲みます。
+func quoteLogValue(value string) string {
+	if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+		return fmt.Sprintf("%q", value)
+	}
+	return value
+}
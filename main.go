@@ -1,23 +1,63 @@
 package main
 
 import (
-	"log"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket" // websocket をインポート
 )
 
 // --- 初期化 ---
-func init() {
+// initializeClient は、設定を読み込み、プロセスマネージャーを初期化します。
+// 常駐時と CLI の swsc start (cli.go) の両方から呼び出されます。
+// Returns:
+//
+//	bool: 初期化に成功した場合は true。設定の検証エラーは全件ログに出力されます。
+func initializeClient() bool {
 	// 設定読み込み (config.go の関数を呼び出し)
-	LoadConfig() // ★ 大文字に変更
+	// 検証エラーは全件まとめて返されるため、1件ずつ出力する
+	if err := LoadConfig(); err != nil {
+		for _, line := range strings.Split(err.Error(), "\n") {
+			configLog.errorf("%s", line)
+		}
+		return false
+	}
 	// プロセスマネージャー初期化 (process_manager.go の関数を呼び出し)
 	InitializeProcessManager() // ★ 大文字に変更
+	return true
 }
 
 // --- メイン処理 ---
 func main() {
-	log.Println("[メイン] ゲームサーバー管理クライアントを開始します...")
+	// サブコマンドが指定された場合は CLI として実行 (cli.go)
+	if len(os.Args) > 1 {
+		os.Exit(runCLI(os.Args[1:]))
+	}
+
+	if !initializeClient() {
+		configLog.errorf("設定の読み込みに失敗したため終了します。")
+		os.Exit(1)
+	}
+	mainLog.infof("ゲームサーバー管理クライアントを開始します...")
+
+	// 前回の終了時に送信できなかったメッセージを読み込み、再接続後に再送する (outbox.go)
+	loadOutbox()
+
+	// SIGHUP による設定の再読み込みを待ち受け (config_reload.go)
+	go watchReloadSignal()
+	// 定期バックアップ (BACKUP_INTERVAL が設定されている場合のみ実行、backup.go)
+	startBackupScheduler()
+	// サーバーの定期的な再起動・停止・起動 (保存された予定を読み込む、schedule.go)
+	startScheduler()
+	// プレイヤーがいないサーバーの自動停止 (IDLE_STOP_TIMEOUT が設定されている場合のみ停止、players.go)
+	startIdleMonitor()
+	// サーバープロセスのリソース使用量の定期取得と serverStats の送信を開始 (stats.go)
+	startStatsSampler()
+	// ローカル管理API (有効な場合のみ、admin_api.go)
+	startAdminAPI()
+	// Prometheus メトリクス (有効な場合のみ、metrics.go)
+	startMetricsServer()
 
 	// 無限ループで接続を試行
 	for {
@@ -26,17 +66,32 @@ func main() {
 		if err != nil {
 			// トークン拒否のエラーチェックを websocket.CloseError を使うように修正
 			if closeErr, ok := err.(*websocket.CloseError); ok && closeErr.Code == TokenRejectedCode { // ★ websocket.CloseError と大文字定数に変更
-				log.Println("[メイン] トークンが拒否されたため、終了します。")
+				mainLog.infof("トークンが拒否されたため、終了します。")
+				break
+			}
+			// 証明書の検証に失敗した場合も、再試行しても成功しないため終了する (tls_config.go)
+			if kind := classifyConnectError(err); kind == connectFailureCertificate {
+				recordFatalConnectError(err, kind) // reconnect.go
+				mainLog.errorf("証明書の検証に失敗したため、終了します。TLS の設定 (%s / %s / %s) を確認してください: %v",
+					wsTLSCAFileEnvKey, wsTLSCertFileEnvKey, wsTLSPinnedSPKIEnvKey, err)
 				break
 			}
-			// その他のエラー
-			log.Printf("[メイン] 接続失敗または切断: %v", err)
 		}
 
-		// 再接続待機 (config.go の定数を使用)
-		log.Printf("[メイン] %v 後に再接続します...", ReconnectDelay) // ★ 大文字に変更
-		time.Sleep(ReconnectDelay) // ★ 大文字に変更
+		// トークンの入れ替えなどで再接続が要求された場合は、待機せずに再接続する (reconnect.go)
+		if consumeImmediateReconnect() {
+			wsLog.infof("再接続が要求されたため、直ちに再接続します。")
+			metricWebSocketReconnects.inc() // metrics.go
+			continue
+		}
+
+		// 再接続待機 (失敗が続くほど待機時間を延長し、ジッターを加える。reconnect.go)
+		delay, kind := scheduleReconnect(err)
+		wsLog.with("failureKind", kind).warnf("接続失敗または切断 (%s): %v", connectFailureLabels[kind], err)
+		wsLog.infof("%v 後に再接続します (連続失敗: %d 回)...", delay.Round(time.Second), getReconnectStatus().ConsecutiveFailures)
+		time.Sleep(delay)
+		metricWebSocketReconnects.inc() // metrics.go
 	}
 
-	log.Println("[メイン] クライアントを終了します。")
+	mainLog.infof("クライアントを終了します。")
 }
\ No newline at end of file
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Prometheus メトリクス ---
+// Prometheus のテキスト形式 (text/plain; version=0.0.4) でメトリクスを公開します (METRICS_ENABLED=true の場合のみ)。
+// 外部ライブラリを増やさないよう、必要な種類 (カウンター / ゲージ / ヒストグラム) のみを最小限に実装しています。
+// カウンターとヒストグラムは各処理の中で更新し、ゲージ (実行中サーバー数など) は取得時に現在の状態から計算します。
+
+// metricCounterVec は、ラベル付きのカウンターです。
+type metricCounterVec struct {
+	name       string
+	help       string
+	labelNames []string
+	mutex      sync.Mutex
+	values     map[string]float64 // キー: ラベル値を "\xff" で連結した文字列
+}
+
+// newCounterVec は、ラベル付きのカウンターを作成します。
+func newCounterVec(name string, help string, labelNames ...string) *metricCounterVec {
+	counter := &metricCounterVec{name: name, help: help, labelNames: labelNames, values: make(map[string]float64)}
+	if len(labelNames) == 0 {
+		counter.values[""] = 0 // ラベルなしのカウンターは、まだ増えていなくても 0 として出力する
+	}
+	return counter
+}
+
+// inc は、指定したラベル値のカウンターを1増やします (ラベル値は labelNames と同じ順序で指定)。
+func (c *metricCounterVec) inc(labelValues ...string) {
+	c.add(1, labelValues...)
+}
+
+// add は、指定したラベル値のカウンターを delta 増やします。
+func (c *metricCounterVec) add(delta float64, labelValues ...string) {
+	if len(labelValues) != len(c.labelNames) {
+		metricsLog.warnf("%s のラベル数が一致しません (期待: %d, 指定: %d)", c.name, len(c.labelNames), len(labelValues))
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.values[strings.Join(labelValues, "\xff")] += delta
+}
+
+// write は、カウンターをテキスト形式で書き出します。
+func (c *metricCounterVec) write(w io.Writer) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	writeMetricHeader(w, c.name, c.help, "counter")
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var labelValues []string
+		if len(c.labelNames) > 0 {
+			labelValues = strings.Split(key, "\xff")
+		}
+		writeMetricSample(w, c.name, c.labelNames, labelValues, c.values[key])
+	}
+}
+
+// metricHistogram は、ラベルなしのヒストグラムです。
+type metricHistogram struct {
+	name    string
+	help    string
+	buckets []float64 // 各バケットの上限 (昇順)
+	mutex   sync.Mutex
+	counts  []uint64 // 各バケットに入った観測数 (累積ではない)
+	sum     float64
+	count   uint64
+}
+
+// newHistogram は、指定したバケット上限のヒストグラムを作成します。
+func newHistogram(name string, help string, buckets []float64) *metricHistogram {
+	return &metricHistogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// observe は、観測値を1件記録します。
+func (h *metricHistogram) observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, upper := range h.buckets {
+		if value <= upper {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// write は、ヒストグラムをテキスト形式で書き出します。
+func (h *metricHistogram) write(w io.Writer) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, upper := range h.buckets {
+		cumulative += h.counts[i]
+		writeMetricSample(w, h.name+"_bucket", []string{"le"}, []string{formatMetricValue(upper)}, float64(cumulative))
+	}
+	writeMetricSample(w, h.name+"_bucket", []string{"le"}, []string{"+Inf"}, float64(h.count))
+	writeMetricSample(w, h.name+"_sum", nil, nil, h.sum)
+	writeMetricSample(w, h.name+"_count", nil, nil, float64(h.count))
+}
+
+// --- メトリクス定義 ---
+
+var (
+	metricServerCrashes = newCounterVec("swsc_server_crashes_total",
+		"Number of unexpected game server exits (crashes) per server.", "server")
+	metricServerRestarts = newCounterVec("swsc_server_restarts_total",
+		"Number of automatic restart attempts after a crash per server and result (success, failure, skipped).", "server", "result")
+	metricSteamCmdRuns = newCounterVec("swsc_steamcmd_runs_total",
+		"Number of SteamCMD runs by result (success, error).", "result")
+	metricSteamCmdDuration = newHistogram("swsc_steamcmd_run_duration_seconds",
+		"Duration of SteamCMD runs including copying items to the install directories.",
+		[]float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600})
+	metricSteamCmdItems = newCounterVec("swsc_steamcmd_items_total",
+		"Number of workshop items processed by SteamCMD by item type (playlist, mod) and result (success, failure).", "type", "result")
+	metricWebSocketConnections = newCounterVec("swsc_websocket_connections_total",
+		"Number of successful WebSocket connections to the bot.")
+	metricWebSocketReconnects = newCounterVec("swsc_websocket_reconnects_total",
+		"Number of WebSocket reconnect attempts after a disconnect or connection failure.")
+	metricWebSocketFailures = newCounterVec("swsc_websocket_failures_total",
+		"Number of WebSocket connection failures and disconnects by kind (dns, tls, certificate, http_status, timeout, refused, disconnected, heartbeat, other).", "kind")
+	metricOutboxDropped = newCounterVec("swsc_outbox_dropped_total",
+		"Number of queued outbound messages dropped without delivery by reason (overflow, expired).", "reason")
+	metricWebSocketSendQueueFull = newCounterVec("swsc_websocket_send_queue_full_total",
+		"Number of times an outbound WebSocket message had to wait because the send queue was full.")
+	metricRejectedMessages = newCounterVec("swsc_websocket_rejected_messages_total",
+		"Number of bot requests rejected by message signature verification by reason (signature_missing, signature_invalid, signature_stale, signature_replayed).", "reason")
+	metricDuplicateRequests = newCounterVec("swsc_websocket_duplicate_requests_total",
+		"Number of duplicate requests received from the bot that were not executed again, by request type and state of the original request (completed, in_progress).", "type", "state")
+	metricBackups = newCounterVec("swsc_backups_total",
+		"Number of server directory backups by reason (stop, scheduled, prerestore) and result (success, failure).", "reason", "result")
+	metricBackupRestores = newCounterVec("swsc_backup_restores_total",
+		"Number of backup restores into a server config directory by result (success, failure).", "result")
+	metricServerOOMKills = newCounterVec("swsc_server_oom_kills_total",
+		"Number of game server processes killed by the OOM killer for exceeding their cgroup memory limit.", "server")
+	metricIdleStops = newCounterVec("swsc_idle_stops_total",
+		"Number of automatic stops of servers without players by result (success, failure, cancelled).", "result")
+	metricScheduledActions = newCounterVec("swsc_scheduled_actions_total",
+		"Number of scheduled server actions by action (restart, stop, start) and result (success, failure, skipped).", "action", "result")
+	metricWebSocketMessages = newCounterVec("swsc_websocket_messages_total",
+		"Number of WebSocket messages by direction (sent, received) and message type.", "direction", "type")
+)
+
+// recordSteamCmdItemMetrics は、DownloadWorkshopItems の要求アイテム数と成功アイテム数から、アイテムごとの成否を記録します。
+func recordSteamCmdItemMetrics(playlistIDs, modIDs, successfulPlaylistIDs, successfulModIDs []string) {
+	metricSteamCmdItems.add(float64(len(successfulPlaylistIDs)), "playlist", "success")
+	metricSteamCmdItems.add(float64(len(playlistIDs)-len(successfulPlaylistIDs)), "playlist", "failure")
+	metricSteamCmdItems.add(float64(len(successfulModIDs)), "mod", "success")
+	metricSteamCmdItems.add(float64(len(modIDs)-len(successfulModIDs)), "mod", "failure")
+}
+
+// --- HTTP エンドポイント ---
+
+// startMetricsServer は、設定で有効になっていればメトリクス用の HTTP サーバーをバックグラウンドで起動します。
+// 待ち受けに失敗しても WebSocket クライアントの動作は継続するため、エラーはログに記録するのみとします。
+func startMetricsServer() {
+	settings := currentConfig().Metrics
+	if !settings.Enabled {
+		return
+	}
+	listener, err := net.Listen("tcp", settings.Addr)
+	if err != nil {
+		metricsLog.errorf("%s での待ち受けに失敗しました。メトリクスは無効になります: %v", settings.Addr, err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET "+settings.Path, handleMetrics)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	metricsLog.infof("http://%s%s で待ち受けを開始しました。", listener.Addr(), settings.Path)
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			metricsLog.errorf("HTTP サーバーが停止しました: %v", err)
+		}
+	}()
+}
+
+// handleMetrics は、全てのメトリクスを Prometheus のテキスト形式で返します。
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(w)
+}
+
+// writeMetrics は、現在の状態から計算するゲージと、記録済みのカウンター/ヒストグラムを書き出します。
+func writeMetrics(w io.Writer) {
+	cfg := currentConfig()
+
+	// 実行中のサーバー
+	procs := getRunningProcesses() // process_manager.go
+	writeMetricHeader(w, "swsc_running_servers", "Number of game servers currently running.", "gauge")
+	writeMetricSample(w, "swsc_running_servers", nil, nil, float64(len(procs)))
+	names := make([]string, 0, len(procs))
+	for name := range procs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeMetricHeader(w, "swsc_server_uptime_seconds", "Seconds since the game server process was (re)started.", "gauge")
+	for _, name := range names {
+		writeMetricSample(w, "swsc_server_uptime_seconds", []string{"server", "port"},
+			[]string{name, strconv.Itoa(procs[name].Port)}, time.Since(procs[name].StartedAt).Seconds())
+	}
+
+	// リソース使用量 (最後に取得した値、stats.go)
+	stats := getServerStats()
+	writeStatsGauge := func(metricName string, help string, value func(ServerStats) float64) {
+		writeMetricHeader(w, metricName, help, "gauge")
+		for _, name := range names {
+			entry, ok := stats[name]
+			if !ok || entry.Error != "" || value(entry) < 0 {
+				continue // 取得できなかった値 (開いているファイルの数の -1 など) は出力しない
+			}
+			writeMetricSample(w, metricName, []string{"server"}, []string{name}, value(entry))
+		}
+	}
+	writeStatsGauge("swsc_server_cpu_percent", "CPU usage of the game server process since the previous sample (100 = one core).",
+		func(entry ServerStats) float64 { return entry.CPUPercent })
+	writeStatsGauge("swsc_server_memory_bytes", "Resident memory of the game server process.",
+		func(entry ServerStats) float64 { return float64(entry.MemoryBytes) })
+	writeStatsGauge("swsc_server_threads", "Number of threads of the game server process.",
+		func(entry ServerStats) float64 { return float64(entry.Threads) })
+	writeStatsGauge("swsc_server_open_files", "Number of open files (handles on Windows) of the game server process.",
+		func(entry ServerStats) float64 { return float64(entry.OpenFiles) })
+
+	// ポートプールの使用状況
+	usedPorts := getCurrentlyUsedPorts() // port_manager.go
+	writeMetricHeader(w, "swsc_port_pool_capacity", "Number of servers that can run in each port pool.", "gauge")
+	for _, pool := range cfg.PortPools {
+		writeMetricSample(w, "swsc_port_pool_capacity", []string{"pool"}, []string{formatPortPools([]PortRange{pool})}, float64(portPoolCapacity([]PortRange{pool})))
+	}
+	writeMetricHeader(w, "swsc_port_pool_used", "Number of ports in use in each port pool.", "gauge")
+	for _, pool := range cfg.PortPools {
+		used := 0
+		for _, port := range usedPorts {
+			if isPortInPools(port, []PortRange{pool}) {
+				used++
+			}
+		}
+		writeMetricSample(w, "swsc_port_pool_used", []string{"pool"}, []string{formatPortPools([]PortRange{pool})}, float64(used))
+	}
+
+	// SteamCMD 実行キュー
+	queue := getSteamCmdQueueState() // steamcmd_queue.go
+	running := 0
+	if queue.Running != nil {
+		running = 1
+	}
+	writeMetricHeader(w, "swsc_steamcmd_running", "Whether a SteamCMD run is in progress (1) or not (0).", "gauge")
+	writeMetricSample(w, "swsc_steamcmd_running", nil, nil, float64(running))
+	writeMetricHeader(w, "swsc_steamcmd_queue_length", "Number of SteamCMD runs waiting for the current run to finish.", "gauge")
+	writeMetricSample(w, "swsc_steamcmd_queue_length", nil, nil, float64(len(queue.Waiting)))
+
+	// WebSocket 接続状態
+	connected := 0
+	if isWebSocketConnected() { // websocket_client.go
+		connected = 1
+	}
+	writeMetricHeader(w, "swsc_websocket_connected", "Whether the WebSocket connection to the bot is established (1) or not (0).", "gauge")
+	writeMetricSample(w, "swsc_websocket_connected", nil, nil, float64(connected))
+
+	// WebSocket の往復時間 (最後に計測した値、heartbeat.go)
+	if heartbeat := getHeartbeatStatus(); heartbeat.LatencyMs != nil {
+		writeMetricHeader(w, "swsc_websocket_latency_seconds", "Round-trip time of the last WebSocket heartbeat ping.", "gauge")
+		writeMetricSample(w, "swsc_websocket_latency_seconds", nil, nil, *heartbeat.LatencyMs/1000)
+	}
+
+	// 書き込み用ゴルーチンの送信キュー (ws_writer.go)
+	writeMetricHeader(w, "swsc_websocket_send_queue_messages", "Number of outbound WebSocket messages waiting for the writer goroutine.", "gauge")
+	writeMetricSample(w, "swsc_websocket_send_queue_messages", nil, nil, float64(getWriterStats().Queued))
+
+	// 送信待ちキュー
+	writeMetricHeader(w, "swsc_outbox_messages", "Number of outbound messages waiting to be sent after the WebSocket reconnects.", "gauge")
+	writeMetricSample(w, "swsc_outbox_messages", nil, nil, float64(getOutboxState().Queued)) // outbox.go
+
+	// 処理中の要求
+	writeMetricHeader(w, "swsc_inflight_requests", "Number of requests currently being processed.", "gauge")
+	writeMetricSample(w, "swsc_inflight_requests", nil, nil, float64(len(getInflightRequests()))) // activity.go
+
+	// 記録済みのカウンター / ヒストグラム
+	metricServerCrashes.write(w)
+	metricServerOOMKills.write(w)
+	metricServerRestarts.write(w)
+	metricSteamCmdRuns.write(w)
+	metricSteamCmdDuration.write(w)
+	metricSteamCmdItems.write(w)
+	metricBackups.write(w)
+	metricBackupRestores.write(w)
+	metricScheduledActions.write(w)
+	metricIdleStops.write(w)
+	metricWebSocketConnections.write(w)
+	metricWebSocketReconnects.write(w)
+	metricWebSocketFailures.write(w)
+	metricWebSocketMessages.write(w)
+	metricDuplicateRequests.write(w)
+	metricRejectedMessages.write(w)
+	metricWebSocketSendQueueFull.write(w)
+	metricOutboxDropped.write(w)
+}
+
+// --- テキスト形式の書き出しヘルパー ---
+
+// writeMetricHeader は、メトリクスの HELP 行と TYPE 行を書き出します。
+func writeMetricHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+// writeMetricSample は、サンプル1行を書き出します。
+func writeMetricSample(w io.Writer, name string, labelNames []string, labelValues []string, value float64) {
+	if len(labelNames) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatMetricValue(value))
+		return
+	}
+	labels := make([]string, len(labelNames))
+	for i, labelName := range labelNames {
+		labels[i] = fmt.Sprintf(`%s="%s"`, labelName, escapeMetricLabel(labelValues[i]))
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(labels, ","), formatMetricValue(value))
+}
+
+// escapeMetricLabel は、ラベル値の \ と " と改行をエスケープします。
+func escapeMetricLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatMetricValue は、数値をテキスト形式で書き出せる文字列に変換します。
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+)
+
+// --- ミニダンプ (.dmp) の解析 ---
+// Windows のミニダンプ (MDMP 形式) からクラッシュの調査に必要な情報だけを読み取り、
+// 「どのモジュールで、どの例外により停止したか」を短い文章にまとめます (クラッシュイベントの dumpSummary)。
+// デバッガーを使わずに Bot からクラッシュの原因の見当をつけられるようにするためのもので、外部ツールには依存しません。
+// 読み取る内容:
+//   - ヘッダー: シグネチャ (MDMP)、ストリーム数、作成日時
+//   - 例外ストリーム (ExceptionStream): 例外コード、例外アドレス、例外を起こしたスレッドのID、例外パラメーター
+//   - モジュール一覧 (ModuleListStream): 各モジュールのベースアドレス、サイズ、ファイル名
+//   - プロセス情報 (MiscInfoStream): ダンプを作成したプロセスのPID (複数のサーバーが同じディレクトリにダンプを書く場合の振り分けに使用)
+// ダンプは巨大になり得るため、ファイル全体は読み込まず、必要な範囲だけを io.ReaderAt で読み取ります。
+
+// minidumpSignature は、ミニダンプのヘッダーのシグネチャ ("MDMP") です。
+const minidumpSignature = 0x504d444d
+
+// ミニダンプのストリームの種類 (MINIDUMP_STREAM_TYPE) のうち、読み取るものです。
+const (
+	minidumpModuleListStream = 4
+	minidumpExceptionStream  = 6
+	minidumpMiscInfoStream   = 15
+)
+
+// minidumpMiscProcessID は、MINIDUMP_MISC_INFO の ProcessId が有効であることを示すフラグ (MINIDUMP_MISC1_PROCESS_ID) です。
+const minidumpMiscProcessID = 0x1
+
+// ミニダンプの構造体のサイズ (バイト) です。
+const (
+	minidumpHeaderSize         = 32  // MINIDUMP_HEADER
+	minidumpDirectorySize      = 12  // MINIDUMP_DIRECTORY
+	minidumpExceptionEntrySize = 168 // MINIDUMP_EXCEPTION_STREAM (ThreadContext を含む)
+	minidumpModuleSize         = 108 // MINIDUMP_MODULE
+	minidumpMiscInfoMinSize    = 12  // MINIDUMP_MISC_INFO の SizeOfInfo, Flags1, ProcessId
+)
+
+// minidumpMaxModules と minidumpMaxNameBytes は、壊れたダンプで過大な読み取りをしないための上限です。
+const (
+	minidumpMaxModules   = 4096
+	minidumpMaxNameBytes = 64 * 1024
+)
+
+// errNotMinidump は、ファイルがミニダンプ形式ではないことを示すエラーです。
+var errNotMinidump = errors.New("ミニダンプ形式 (MDMP) ではありません")
+
+// minidumpExceptionNames は、主な例外コードの名前です。
+var minidumpExceptionNames = map[uint32]string{
+	0x80000003: "EXCEPTION_BREAKPOINT",
+	0x80000004: "EXCEPTION_SINGLE_STEP",
+	0xC0000005: "EXCEPTION_ACCESS_VIOLATION",
+	0xC0000006: "EXCEPTION_IN_PAGE_ERROR",
+	0xC0000008: "EXCEPTION_INVALID_HANDLE",
+	0xC000001D: "EXCEPTION_ILLEGAL_INSTRUCTION",
+	0xC0000025: "EXCEPTION_NONCONTINUABLE_EXCEPTION",
+	0xC000008C: "EXCEPTION_ARRAY_BOUNDS_EXCEEDED",
+	0xC000008E: "EXCEPTION_FLT_DIVIDE_BY_ZERO",
+	0xC0000094: "EXCEPTION_INT_DIVIDE_BY_ZERO",
+	0xC0000095: "EXCEPTION_INT_OVERFLOW",
+	0xC0000096: "EXCEPTION_PRIV_INSTRUCTION",
+	0xC00000FD: "EXCEPTION_STACK_OVERFLOW",
+	0xC0000374: "STATUS_HEAP_CORRUPTION",
+	0xC0000409: "STATUS_STACK_BUFFER_OVERRUN",
+	0xE06D7363: "C++ 例外 (0xE06D7363)",
+	0x40000015: "STATUS_FATAL_APP_EXIT",
+}
+
+// minidumpModule は、ダンプに含まれるモジュール (実行ファイルや DLL) 1件の情報です。
+type minidumpModule struct {
+	Name string `json:"name"` // ファイルのフルパス
+	Base uint64 `json:"base"`
+	Size uint32 `json:"size"`
+}
+
+// contains は、アドレスがモジュールの範囲内かどうかを返します。
+func (m minidumpModule) contains(address uint64) bool {
+	return address >= m.Base && address-m.Base < uint64(m.Size)
+}
+
+// baseName は、モジュールのファイル名 (ディレクトリを除く) を返します。
+func (m minidumpModule) baseName() string {
+	name := m.Name
+	if i := strings.LastIndexAny(name, `\/`); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
+
+// minidumpException は、例外ストリームの内容です。
+type minidumpException struct {
+	ThreadID   uint32   `json:"threadId"`
+	Code       uint32   `json:"code"`
+	Flags      uint32   `json:"flags"`
+	Address    uint64   `json:"address"`
+	Parameters []uint64 `json:"parameters,omitempty"`
+}
+
+// minidumpInfo は、ミニダンプから読み取った情報です。
+type minidumpInfo struct {
+	Version     uint32             `json:"version"`
+	StreamCount uint32             `json:"streamCount"`
+	TimeStamp   uint32             `json:"timeStamp"` // ダンプの作成日時 (UNIX 時間)
+	Exception   *minidumpException `json:"exception,omitempty"`
+	Modules     []minidumpModule   `json:"modules,omitempty"`
+	ProcessID   uint32             `json:"processId,omitempty"` // ダンプを作成したプロセスのPID (記録されていない場合は 0)
+}
+
+// faultingModule は、例外アドレスを含むモジュールを返します (見つからなければ nil)。
+func (info *minidumpInfo) faultingModule() *minidumpModule {
+	if info.Exception == nil {
+		return nil
+	}
+	for i := range info.Modules {
+		if info.Modules[i].contains(info.Exception.Address) {
+			return &info.Modules[i]
+		}
+	}
+	return nil
+}
+
+// readMinidumpFile は、ミニダンプファイルを解析します。
+func readMinidumpFile(path string) (*minidumpInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return parseMinidump(file, stat.Size())
+}
+
+// parseMinidump は、ミニダンプのヘッダー、例外ストリーム、モジュール一覧を解析します。
+// 例外ストリームやモジュール一覧が含まれないダンプでは、該当する項目が空になります。
+// Args:
+//
+//	r (io.ReaderAt): ダンプの内容。
+//	size (int64): ダンプのサイズ (バイト)。範囲外を指すオフセットの検出に使います。
+//
+// Returns:
+//
+//	*minidumpInfo: 解析結果。
+//	error: ミニダンプ形式でない場合や、構造が壊れている場合のエラー。
+func parseMinidump(r io.ReaderAt, size int64) (*minidumpInfo, error) {
+	reader := minidumpReader{r: r, size: size}
+	header, err := reader.read(0, minidumpHeaderSize)
+	if err != nil {
+		return nil, errNotMinidump
+	}
+	if binary.LittleEndian.Uint32(header[0:]) != minidumpSignature {
+		return nil, errNotMinidump
+	}
+	info := &minidumpInfo{
+		Version:     binary.LittleEndian.Uint32(header[4:]) & 0xffff,
+		StreamCount: binary.LittleEndian.Uint32(header[8:]),
+		TimeStamp:   binary.LittleEndian.Uint32(header[20:]),
+	}
+	directoryRVA := binary.LittleEndian.Uint32(header[12:])
+
+	directory, err := reader.read(int64(directoryRVA), int64(info.StreamCount)*minidumpDirectorySize)
+	if err != nil {
+		return nil, fmt.Errorf("ストリームの一覧を読み取れません: %w", err)
+	}
+	for i := uint32(0); i < info.StreamCount; i++ {
+		entry := directory[i*minidumpDirectorySize:]
+		streamType := binary.LittleEndian.Uint32(entry[0:])
+		dataSize := binary.LittleEndian.Uint32(entry[4:])
+		rva := binary.LittleEndian.Uint32(entry[8:])
+		switch streamType {
+		case minidumpExceptionStream:
+			if info.Exception != nil {
+				continue // 先頭のものを使う
+			}
+			if info.Exception, err = reader.readException(rva, dataSize); err != nil {
+				return nil, fmt.Errorf("例外ストリームを読み取れません: %w", err)
+			}
+		case minidumpModuleListStream:
+			if info.Modules != nil {
+				continue
+			}
+			if info.Modules, err = reader.readModules(rva, dataSize); err != nil {
+				return nil, fmt.Errorf("モジュール一覧を読み取れません: %w", err)
+			}
+		case minidumpMiscInfoStream:
+			if info.ProcessID != 0 {
+				continue
+			}
+			if info.ProcessID, err = reader.readProcessID(rva, dataSize); err != nil {
+				return nil, fmt.Errorf("プロセス情報を読み取れません: %w", err)
+			}
+		}
+	}
+	return info, nil
+}
+
+// minidumpReader は、範囲を検証しながらミニダンプを読み取るためのヘルパーです。
+type minidumpReader struct {
+	r    io.ReaderAt
+	size int64
+}
+
+// read は、offset から length バイトを読み取ります。ダンプの範囲外を指す場合はエラーを返します。
+func (m minidumpReader) read(offset int64, length int64) ([]byte, error) {
+	if offset < 0 || length < 0 || offset > m.size || length > m.size-offset {
+		return nil, fmt.Errorf("範囲外の参照です (オフセット %d, 長さ %d, ファイルサイズ %d)", offset, length, m.size)
+	}
+	buf := make([]byte, length)
+	if _, err := m.r.ReadAt(buf, offset); err != nil && !(errors.Is(err, io.EOF) && length == 0) {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readException は、例外ストリーム (MINIDUMP_EXCEPTION_STREAM) を読み取ります。
+func (m minidumpReader) readException(rva uint32, dataSize uint32) (*minidumpException, error) {
+	if dataSize < minidumpExceptionEntrySize {
+		return nil, fmt.Errorf("サイズが不足しています (%d バイト)", dataSize)
+	}
+	data, err := m.read(int64(rva), minidumpExceptionEntrySize)
+	if err != nil {
+		return nil, err
+	}
+	// ThreadId(4) + アラインメント(4) に続いて MINIDUMP_EXCEPTION
+	exception := &minidumpException{
+		ThreadID: binary.LittleEndian.Uint32(data[0:]),
+		Code:     binary.LittleEndian.Uint32(data[8:]),
+		Flags:    binary.LittleEndian.Uint32(data[12:]),
+		Address:  binary.LittleEndian.Uint64(data[24:]),
+	}
+	paramCount := binary.LittleEndian.Uint32(data[32:])
+	if paramCount > 15 { // EXCEPTION_MAXIMUM_PARAMETERS
+		paramCount = 15
+	}
+	for i := uint32(0); i < paramCount; i++ {
+		exception.Parameters = append(exception.Parameters, binary.LittleEndian.Uint64(data[40+i*8:]))
+	}
+	return exception, nil
+}
+
+// readProcessID は、プロセス情報 (MINIDUMP_MISC_INFO) から PID を読み取ります。PID が記録されていない場合は 0 を返します。
+func (m minidumpReader) readProcessID(rva uint32, dataSize uint32) (uint32, error) {
+	if dataSize < minidumpMiscInfoMinSize {
+		return 0, fmt.Errorf("サイズが不足しています (%d バイト)", dataSize)
+	}
+	data, err := m.read(int64(rva), minidumpMiscInfoMinSize)
+	if err != nil {
+		return 0, err
+	}
+	if binary.LittleEndian.Uint32(data[4:])&minidumpMiscProcessID == 0 {
+		return 0, nil
+	}
+	return binary.LittleEndian.Uint32(data[8:]), nil
+}
+
+// readModules は、モジュール一覧 (MINIDUMP_MODULE_LIST) を読み取ります。
+func (m minidumpReader) readModules(rva uint32, dataSize uint32) ([]minidumpModule, error) {
+	countData, err := m.read(int64(rva), 4)
+	if err != nil {
+		return nil, err
+	}
+	count := binary.LittleEndian.Uint32(countData)
+	if count > minidumpMaxModules || 4+int64(count)*minidumpModuleSize > int64(dataSize) {
+		return nil, fmt.Errorf("モジュール数 (%d) がストリームのサイズ (%d バイト) と一致しません", count, dataSize)
+	}
+	data, err := m.read(int64(rva)+4, int64(count)*minidumpModuleSize)
+	if err != nil {
+		return nil, err
+	}
+	modules := make([]minidumpModule, 0, count)
+	for i := uint32(0); i < count; i++ {
+		entry := data[i*minidumpModuleSize:]
+		module := minidumpModule{
+			Base: binary.LittleEndian.Uint64(entry[0:]),
+			Size: binary.LittleEndian.Uint32(entry[8:]),
+		}
+		if module.Name, err = m.readString(binary.LittleEndian.Uint32(entry[20:])); err != nil {
+			return nil, fmt.Errorf("モジュール %d の名前を読み取れません: %w", i, err)
+		}
+		modules = append(modules, module)
+	}
+	return modules, nil
+}
+
+// readString は、MINIDUMP_STRING (バイト長 + UTF-16LE) を読み取ります。
+func (m minidumpReader) readString(rva uint32) (string, error) {
+	lengthData, err := m.read(int64(rva), 4)
+	if err != nil {
+		return "", err
+	}
+	length := binary.LittleEndian.Uint32(lengthData)
+	if length > minidumpMaxNameBytes || length%2 != 0 {
+		return "", fmt.Errorf("文字列の長さ (%d バイト) が不正です", length)
+	}
+	data, err := m.read(int64(rva)+4, int64(length))
+	if err != nil {
+		return "", err
+	}
+	units := make([]uint16, length/2)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+// exceptionCodeName は、例外コードの名前を返します (不明なコードは16進数表記)。
+func exceptionCodeName(code uint32) string {
+	if name, ok := minidumpExceptionNames[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%08X", code)
+}
+
+// summarizeMinidump は、ミニダンプの解析結果を Bot に表示できる短い文章にまとめます。
+// 例: "stormworks64.exe+0x1a2b3 で EXCEPTION_ACCESS_VIOLATION (0xC0000005) が発生しました
+// (スレッド 4321, アドレス 0x0 の読み取り)"
+func summarizeMinidump(info *minidumpInfo) string {
+	exception := info.Exception
+	if exception == nil {
+		return fmt.Sprintf("例外情報のないダンプです (モジュール: %d 件)", len(info.Modules))
+	}
+
+	location := fmt.Sprintf("0x%X", exception.Address)
+	if module := info.faultingModule(); module != nil {
+		location = fmt.Sprintf("%s+0x%X", module.baseName(), exception.Address-module.Base)
+	} else if len(info.Modules) > 0 {
+		location += " (どのモジュールにも含まれないアドレス)"
+	}
+
+	name := exceptionCodeName(exception.Code)
+	codeText := name
+	if !strings.Contains(name, "0x") {
+		codeText = fmt.Sprintf("%s (0x%08X)", name, exception.Code)
+	}
+
+	details := []string{fmt.Sprintf("スレッド %d", exception.ThreadID)}
+	// アクセス違反とページエラーでは、パラメーターに操作の種類と対象アドレスが入っている
+	if (exception.Code == 0xC0000005 || exception.Code == 0xC0000006) && len(exception.Parameters) >= 2 {
+		operation := map[uint64]string{0: "読み取り", 1: "書き込み", 8: "実行"}[exception.Parameters[0]]
+		if operation == "" {
+			operation = fmt.Sprintf("操作 %d", exception.Parameters[0])
+		}
+		details = append(details, fmt.Sprintf("アドレス 0x%X の%s", exception.Parameters[1], operation))
+	}
+	return fmt.Sprintf("%s で %s が発生しました (%s)", location, codeText, strings.Join(details, ", "))
+}
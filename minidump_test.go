@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+	"unicode/utf16"
+)
+
+func TestParseMinidumpCrashDump(t *testing.T) {
+	info, err := readMinidumpFile("crash.dmp")
+	if err != nil {
+		t.Fatalf("crash.dmp を解析できません: %v", err)
+	}
+	if info.Exception == nil {
+		t.Fatal("例外ストリームがありません")
+	}
+	if info.Exception.Code != 0xC0000005 {
+		t.Errorf("例外コード = 0x%08X, 期待値 0xC0000005", info.Exception.Code)
+	}
+	if info.Exception.ThreadID != 63176 {
+		t.Errorf("スレッドID = %d, 期待値 63176", info.Exception.ThreadID)
+	}
+	if info.ProcessID != 85328 {
+		t.Errorf("PID = %d, 期待値 85328", info.ProcessID)
+	}
+	if len(info.Modules) != 83 {
+		t.Errorf("モジュール数 = %d, 期待値 83", len(info.Modules))
+	}
+	module := info.faultingModule()
+	if module == nil {
+		t.Fatal("例外アドレスを含むモジュールが見つかりません")
+	}
+	if got := module.baseName(); got != "server64.exe" {
+		t.Errorf("例外を起こしたモジュール = %s, 期待値 server64.exe", got)
+	}
+	if offset := info.Exception.Address - module.Base; offset != 0x173AE8 {
+		t.Errorf("モジュール内のオフセット = 0x%X, 期待値 0x173AE8", offset)
+	}
+
+	want := "server64.exe+0x173AE8 で EXCEPTION_ACCESS_VIOLATION (0xC0000005) が発生しました (スレッド 63176, アドレス 0x10 の読み取り)"
+	if got := summarizeMinidump(info); got != want {
+		t.Errorf("summarizeMinidump =\n  %s\n期待値\n  %s", got, want)
+	}
+}
+
+// testMinidump は、例外ストリームとモジュール1件を含む最小のミニダンプを組み立てるためのテスト用の値です。
+type testMinidump struct {
+	exceptionRVA uint32
+	moduleCount  uint32
+	nameLength   uint32
+}
+
+// 最小のミニダンプのレイアウト: ヘッダー (32) + ストリーム一覧 (2件) + 例外ストリーム + モジュール一覧 + モジュール名
+const (
+	testDirectoryRVA = minidumpHeaderSize
+	testExceptionRVA = testDirectoryRVA + 2*minidumpDirectorySize
+	testModulesRVA   = testExceptionRVA + minidumpExceptionEntrySize
+	testNameRVA      = testModulesRVA + 4 + minidumpModuleSize
+)
+
+// build は、ミニダンプのバイト列を組み立てます。
+func (d testMinidump) build() []byte {
+	var buf bytes.Buffer
+	write := func(values ...any) {
+		for _, value := range values {
+			_ = binary.Write(&buf, binary.LittleEndian, value)
+		}
+	}
+	// MINIDUMP_HEADER
+	write(uint32(minidumpSignature), uint32(0xa793), uint32(2), uint32(testDirectoryRVA), uint32(0), uint32(1700000000), uint64(0))
+	// MINIDUMP_DIRECTORY
+	write(uint32(minidumpExceptionStream), uint32(minidumpExceptionEntrySize), d.exceptionRVA)
+	write(uint32(minidumpModuleListStream), uint32(4+minidumpModuleSize), uint32(testModulesRVA))
+	// MINIDUMP_EXCEPTION_STREAM: ThreadId, アラインメント, ExceptionCode, Flags, Record, Address, NumberParameters, アラインメント, Information[15], ThreadContext
+	exception := make([]byte, minidumpExceptionEntrySize)
+	binary.LittleEndian.PutUint32(exception[0:], 42)
+	binary.LittleEndian.PutUint32(exception[8:], 0xC0000094)
+	binary.LittleEndian.PutUint64(exception[24:], 0x140001010)
+	buf.Write(exception)
+	// MINIDUMP_MODULE_LIST
+	write(d.moduleCount)
+	module := make([]byte, minidumpModuleSize)
+	binary.LittleEndian.PutUint64(module[0:], 0x140000000)
+	binary.LittleEndian.PutUint32(module[8:], 0x10000)
+	binary.LittleEndian.PutUint32(module[20:], testNameRVA)
+	buf.Write(module)
+	// MINIDUMP_STRING
+	name := utf16.Encode([]rune(`C:\game\server64.exe`))
+	write(d.nameLength)
+	write(name)
+	return buf.Bytes()
+}
+
+// validTestMinidump は、正しい構造の最小のミニダンプです。
+var validTestMinidump = testMinidump{exceptionRVA: testExceptionRVA, moduleCount: 1, nameLength: uint32(2 * len(`C:\game\server64.exe`))}
+
+func TestParseMinidumpSynthetic(t *testing.T) {
+	data := validTestMinidump.build()
+	info, err := parseMinidump(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("正しいダンプを解析できません: %v", err)
+	}
+	want := "server64.exe+0x1010 で EXCEPTION_INT_DIVIDE_BY_ZERO (0xC0000094) が発生しました (スレッド 42)"
+	if got := summarizeMinidump(info); got != want {
+		t.Errorf("summarizeMinidump = %s, 期待値 %s", got, want)
+	}
+}
+
+func TestParseMinidumpMalformed(t *testing.T) {
+	valid := validTestMinidump.build()
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"空のファイル", nil},
+		{"ヘッダーの途中で切れている", valid[:minidumpHeaderSize-1]},
+		{"シグネチャが異なる", append([]byte("PAGEDU64"), valid[8:]...)},
+		{"ストリーム一覧の途中で切れている", valid[:testExceptionRVA-1]},
+		{"ストリームの RVA が範囲外", testMinidump{exceptionRVA: 1 << 30, moduleCount: 1, nameLength: validTestMinidump.nameLength}.build()},
+		{"ストリームの RVA がファイル末尾の直前", testMinidump{exceptionRVA: uint32(len(valid)) - 4, moduleCount: 1, nameLength: validTestMinidump.nameLength}.build()},
+		{"モジュール数が過大", testMinidump{exceptionRVA: testExceptionRVA, moduleCount: 0xFFFFFFFF, nameLength: validTestMinidump.nameLength}.build()},
+		{"モジュール数がストリームのサイズを超える", testMinidump{exceptionRVA: testExceptionRVA, moduleCount: 2, nameLength: validTestMinidump.nameLength}.build()},
+		{"文字列の長さが奇数", testMinidump{exceptionRVA: testExceptionRVA, moduleCount: 1, nameLength: 7}.build()},
+		{"文字列の長さが範囲外", testMinidump{exceptionRVA: testExceptionRVA, moduleCount: 1, nameLength: 1 << 20}.build()},
+		{"モジュール名の途中で切れている", valid[:len(valid)-2]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := parseMinidump(bytes.NewReader(tt.data), int64(len(tt.data)))
+			if err == nil {
+				t.Fatalf("エラーになるべきですが、解析できました: %+v", info)
+			}
+		})
+	}
+	// ヘッダーが壊れている場合は errNotMinidump (他の形式のダンプとして扱えるように)
+	if _, err := parseMinidump(bytes.NewReader(valid[:10]), 10); !errors.Is(err, errNotMinidump) {
+		t.Errorf("ヘッダーが不足している場合のエラー = %v, 期待値 errNotMinidump", err)
+	}
+}
+
+func TestParseMinidumpNoPanic(t *testing.T) {
+	full, err := os.ReadFile("crash.dmp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 実際のダンプの先頭部分だけを与えても、パニックせずにエラーか部分的な結果を返す
+	for _, size := range []int{0, 4, 31, 32, 64, 128, 1024, 4096, len(full) / 2, len(full) - 1} {
+		if size > len(full) {
+			continue
+		}
+		_, _ = parseMinidump(bytes.NewReader(full[:size]), int64(size))
+	}
+	// 各フィールドを範囲外を指す値に書き換えても、パニックしない
+	data := validTestMinidump.build()
+	for offset := 0; offset+4 <= len(data); offset += 4 {
+		corrupted := append([]byte(nil), data...)
+		binary.LittleEndian.PutUint32(corrupted[offset:], 0xFFFFFFF0)
+		_, _ = parseMinidump(bytes.NewReader(corrupted), int64(len(corrupted)))
+	}
+}
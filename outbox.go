@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// --- 送信待ちメッセージのキュー (アウトボックス) ---
+// WebSocket の切断中に発生したクラッシュ通知・再起動結果・startServer の最終応答などを失わないよう、
+// 送信できなかったメッセージをキューに保持し、再接続して syncStatus を送信した後に発生順に再送します。
+// キューの上限件数 (OUTBOX_MAX_MESSAGES) を超えた場合は古いものから破棄し、
+// 保持期間 (OUTBOX_MAX_AGE) を過ぎたメッセージは意味がないため再送せずに破棄します。
+// OUTBOX_PATH を指定した場合は、SWSC の再起動後も再送できるようキューの内容をファイルに保存します。
+// キューは常駐モード (WebSocketクライアント) でのみ使用し、CLI では使用しません。
+
+// outboxEntry は、キューに保持しているメッセージ1件です。
+type outboxEntry struct {
+	QueuedAt time.Time `json:"queuedAt"`
+	Message  WsMessage `json:"message"`
+}
+
+var (
+	// outboxEntries は、送信待ちのメッセージです (古い順)。
+	outboxEntries []outboxEntry
+	// outboxFlushing は、再送処理中かどうかです。再送中に発生したメッセージは順序を保つためキューの末尾に追加します。
+	outboxFlushing bool
+	// outboxDropped は、上限超過・期限切れで破棄したメッセージ数です (キー: 理由)。
+	outboxDropped = map[string]int{"overflow": 0, "expired": 0}
+	// outboxMutex は、outboxEntries、outboxFlushing、outboxDropped を保護するためのミューテックスです。
+	outboxMutex sync.Mutex
+)
+
+// outboxEnabled は、アウトボックスを使用するかどうかを返します (常駐モードのみ)。
+func outboxEnabled() bool {
+	return runMode == ownerModeDaemon // server_state.go
+}
+
+// isOutboxMessage は、送信できなかった場合にキューに保持するメッセージかどうかを判定します。
+// syncStatus は再接続時に最新の状態で送り直すため、serverStats は次回の取得で最新の値を送るため (stats.go)、保持しません。
+func isOutboxMessage(msg WsMessage) bool {
+	return msg.Type != "syncStatus" && msg.Type != "serverStats"
+}
+
+// loadOutbox は、OUTBOX_PATH に保存されたキューを読み込みます。常駐モードの起動時に main から呼び出されます。
+// ファイルが存在しない場合は何もしません。
+func loadOutbox() {
+	path := currentConfig().Outbox.Path
+	if path == "" {
+		return
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		wsLog.warnf("送信待ちキューの読み込みに失敗しました (%s): %v", path, err)
+		return
+	}
+	var entries []outboxEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		wsLog.warnf("送信待ちキューの解析に失敗しました (%s): %v", path, err)
+		return
+	}
+
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	outboxEntries = append(entries, outboxEntries...)
+	pruneOutboxLocked(time.Now())
+	wsLog.infof("前回保存された送信待ちメッセージを %d 件読み込みました (%s)", len(outboxEntries), path)
+}
+
+// enqueueOutbox は、メッセージを送信待ちキューの末尾に追加します。
+// Returns:
+//
+//	bool: キューに追加した場合は true (キューの対象外のメッセージ、または CLI の場合は false)。
+func enqueueOutbox(msg WsMessage) bool {
+	if !outboxEnabled() || !isOutboxMessage(msg) {
+		return false
+	}
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	enqueueOutboxLocked(msg)
+	return true
+}
+
+// enqueueOutboxLocked は、メッセージを送信待ちキューの末尾に追加します。
+// outboxMutex をロックした状態で呼び出してください。
+func enqueueOutboxLocked(msg WsMessage) {
+	outboxEntries = append(outboxEntries, outboxEntry{QueuedAt: time.Now(), Message: msg})
+	pruneOutboxLocked(time.Now())
+	saveOutboxLocked()
+	wsLog.infof("メッセージを送信待ちキューに保持しました (Type: %s, ReqID: %s, 待機数: %d)", msg.Type, msg.RequestID, len(outboxEntries))
+}
+
+// enqueueIfOutboxPending は、再送待ちのメッセージがある場合 (または再送中の場合) に、
+// 送信順序を保つためメッセージを直接送信せずにキューの末尾に追加します。
+// Returns:
+//
+//	bool: キューに追加した場合は true。
+func enqueueIfOutboxPending(msg WsMessage) bool {
+	if !outboxEnabled() || !isOutboxMessage(msg) {
+		return false
+	}
+	// 判定と追加の間に再送が完了すると、誰も再送しないキューに残ってしまうため、同じロックの中で行う
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	if len(outboxEntries) == 0 && !outboxFlushing {
+		return false
+	}
+	enqueueOutboxLocked(msg)
+	return true
+}
+
+// flushOutbox は、送信待ちのメッセージを古い順に再送します。
+// 再接続後、syncStatus を送信した直後に ConnectWebSocket から呼び出されます。
+// 送信に失敗した場合は、そのメッセージ以降をキューに残したまま終了します (次回の再接続時に再送)。
+// Args:
+//
+//	currentWriter (*wsWriter): 再送に使用する WebSocket 接続の書き込み用ゴルーチン (ws_writer.go)。
+//
+// Returns:
+//
+//	error: 送信に失敗した場合のエラー。
+func flushOutbox(currentWriter *wsWriter) error {
+	outboxMutex.Lock()
+	if len(outboxEntries) == 0 || outboxFlushing {
+		outboxMutex.Unlock()
+		return nil
+	}
+	outboxFlushing = true
+	wsLog.infof("送信待ちキューのメッセージ %d 件を再送します...", len(outboxEntries))
+	outboxMutex.Unlock()
+
+	sent := 0
+	for {
+		outboxMutex.Lock()
+		pruneOutboxLocked(time.Now())
+		if len(outboxEntries) == 0 {
+			outboxFlushing = false
+			outboxMutex.Unlock()
+			wsLog.infof("送信待ちキューの再送が完了しました (%d 件)", sent)
+			return nil
+		}
+		entry := outboxEntries[0]
+		outboxMutex.Unlock()
+
+		// Bot の対応機能が分かるまで送信できないイベントがある場合は、順序を保つため以降のメッセージも保留し、
+		// connected の受信後に resumeOutboxFlush から再送する (protocol.go)
+		supported, known := botAcceptsMessage(entry.Message)
+		if !known {
+			outboxMutex.Lock()
+			outboxFlushing = false
+			remaining := len(outboxEntries)
+			outboxMutex.Unlock()
+			wsLog.infof("Bot の対応機能の通知 (connected) を待つため、送信待ちメッセージ %d 件の再送を保留します (再送済み: %d 件)", remaining, sent)
+			return nil
+		}
+		if !supported {
+			wsLog.warnf("Bot が未対応のため、送信待ちメッセージを破棄しました (Type: %s)", entry.Message.Type)
+			outboxMutex.Lock()
+			outboxEntries = outboxEntries[1:]
+			saveOutboxLocked()
+			outboxMutex.Unlock()
+			continue
+		}
+
+		if err := writeWsMessage(currentWriter, entry.Message); err != nil { // websocket_client.go
+			outboxMutex.Lock()
+			outboxFlushing = false
+			remaining := len(outboxEntries)
+			outboxMutex.Unlock()
+			wsLog.warnf("送信待ちメッセージの再送に失敗しました (再送済み: %d 件, 残り: %d 件): %v", sent, remaining, err)
+			return err
+		}
+		sent++
+
+		outboxMutex.Lock()
+		outboxEntries = outboxEntries[1:]
+		saveOutboxLocked()
+		outboxMutex.Unlock()
+	}
+}
+
+// resumeOutboxFlush は、Bot から connected を受信した後に、対応機能の通知を待って保留していたメッセージを再送します (protocol.go)。
+// 再送に失敗した場合は、残りのメッセージを次回の再接続時に再送します。
+func resumeOutboxFlush() {
+	connMutex.Lock()
+	currentWriter := connWriter
+	connMutex.Unlock()
+	if currentWriter == nil {
+		return
+	}
+	_ = flushOutbox(currentWriter) // 失敗は flushOutbox 内でログに出力される
+}
+
+// pruneOutboxLocked は、保持期間を過ぎたメッセージと上限件数を超えたメッセージを破棄します。
+// outboxMutex をロックした状態で呼び出してください。
+func pruneOutboxLocked(now time.Time) {
+	settings := currentConfig().Outbox
+	kept := outboxEntries[:0]
+	for _, entry := range outboxEntries {
+		if settings.MaxAge > 0 && now.Sub(entry.QueuedAt) > settings.MaxAge {
+			wsLog.warnf("保持期間を過ぎたため送信待ちメッセージを破棄しました (Type: %s, ReqID: %s, 保持時間: %v)",
+				entry.Message.Type, entry.Message.RequestID, now.Sub(entry.QueuedAt).Round(time.Second))
+			outboxDropped["expired"]++
+			metricOutboxDropped.inc("expired") // metrics.go
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	outboxEntries = kept
+	if overflow := len(outboxEntries) - settings.MaxMessages; settings.MaxMessages > 0 && overflow > 0 {
+		for _, entry := range outboxEntries[:overflow] {
+			wsLog.warnf("送信待ちキューが上限 (%d 件) に達したため、古いメッセージを破棄しました (Type: %s, ReqID: %s)",
+				settings.MaxMessages, entry.Message.Type, entry.Message.RequestID)
+		}
+		outboxDropped["overflow"] += overflow
+		metricOutboxDropped.add(float64(overflow), "overflow")
+		outboxEntries = outboxEntries[overflow:]
+	}
+}
+
+// saveOutboxLocked は、OUTBOX_PATH が指定されている場合にキューの内容をファイルに保存します。
+// 一時ファイルに書き込んでから置き換えるため、書き込み途中で終了しても以前の内容は失われません。
+// outboxMutex をロックした状態で呼び出してください。
+func saveOutboxLocked() {
+	path := currentConfig().Outbox.Path
+	if path == "" {
+		return
+	}
+	entries := outboxEntries
+	if entries == nil {
+		entries = []outboxEntry{}
+	}
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		wsLog.warnf("送信待ちキューのエンコードに失敗しました: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		wsLog.warnf("送信待ちキューの保存先ディレクトリを作成できません (%s): %v", filepath.Dir(path), err)
+		return
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0600); err != nil {
+		wsLog.warnf("送信待ちキューの書き込みに失敗しました (%s): %v", tempPath, err)
+		return
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		wsLog.warnf("送信待ちキューの保存に失敗しました (%s): %v", path, err)
+	}
+}
+
+// outboxState は、送信待ちキューの状態です (管理APIの応答用)。
+type outboxState struct {
+	Queued        int            `json:"queued"`
+	Flushing      bool           `json:"flushing"`
+	OldestAt      *time.Time     `json:"oldestQueuedAt,omitempty"`
+	Dropped       map[string]int `json:"dropped"` // 破棄したメッセージ数 (キー: overflow / expired)
+	Persisted     bool           `json:"persisted"`
+	MaxMessages   int            `json:"maxMessages"`
+	MaxAgeSeconds float64        `json:"maxAgeSeconds"`
+}
+
+// getOutboxState は、送信待ちキューの状態を返します。
+func getOutboxState() outboxState {
+	settings := currentConfig().Outbox
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+	state := outboxState{
+		Queued:        len(outboxEntries),
+		Flushing:      outboxFlushing,
+		Dropped:       make(map[string]int, len(outboxDropped)),
+		Persisted:     settings.Path != "",
+		MaxMessages:   settings.MaxMessages,
+		MaxAgeSeconds: settings.MaxAge.Seconds(),
+	}
+	for reason, count := range outboxDropped {
+		state.Dropped[reason] = count
+	}
+	if len(outboxEntries) > 0 {
+		oldest := outboxEntries[0].QueuedAt
+		state.OldestAt = &oldest
+	}
+	return state
+}
+
+// formatOutboxSettings は、アウトボックスの設定をログ表示用の文字列にします。
+func formatOutboxSettings(settings OutboxSettings) string {
+	storage := "メモリのみ"
+	if settings.Path != "" {
+		storage = settings.Path
+	}
+	return fmt.Sprintf("上限=%d件, 保持期間=%v, 保存先=%s", settings.MaxMessages, settings.MaxAge, storage)
+}
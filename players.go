@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// --- プレイヤー数の追跡と無人時の自動停止 ---
+// ゲームサーバーのコンソール出力 (stdout / stderr) から、プレイヤーの参加・退出を表す行を正規表現で検出し、
+// サーバーごとの現在のプレイヤー数を追跡します。
+//   - stopServer の確認 (confirmed が false の場合) は、このプレイヤー数を使って判定します
+//   - IDLE_STOP_TIMEOUT (servers.<構成名>.idle_timeout で構成名ごとに変更可能) を設定すると、
+//     プレイヤーが0人の状態がその時間続いたサーバーを、stopServer と同じ処理で停止し (設定ファイルの返却とバックアップを含む)、
+//     serverIdleStopped イベントで通知します。空いたサーバーがポートを使い続けないようにするためのものです
+// 参加・退出の行の形式はゲームのバージョンによって異なる可能性があるため、PLAYER_JOIN_PATTERN / PLAYER_LEAVE_PATTERN で変更できます。
+// 正規表現に名前付きグループ (?P<name>...) があれば、プレイヤー名で参加・退出を対応付けます (同じプレイヤーを二重に数えません)。
+
+// idleCheckInterval は、無人のサーバーを確認する間隔です。
+const idleCheckInterval = 30 * time.Second
+
+// playerTracker は、ゲームサーバー1プロセス分のプレイヤー数を追跡します。
+type playerTracker struct {
+	mutex     sync.Mutex
+	join      *regexp.Regexp
+	leave     *regexp.Regexp
+	players   map[string]bool // 名前で識別できるプレイヤー
+	anonymous int             // 名前を取得できなかったプレイヤーの数
+	idleSince time.Time       // プレイヤーが0人になった時刻 (プレイヤーがいる間はゼロ値)
+}
+
+// countLocked は、現在のプレイヤー数を返します。ロックした状態で呼び出してください。
+func (t *playerTracker) countLocked() int {
+	return len(t.players) + t.anonymous
+}
+
+// observe は、コンソール出力の1行を調べ、参加・退出の行であればプレイヤー数を更新します。
+// Returns:
+//
+//	string: 参加した場合は "join"、退出した場合は "leave"、それ以外は空文字列。
+//	string: 正規表現で取得したプレイヤー名 (取得できない場合は空)。
+func (t *playerTracker) observe(line string) (string, string) {
+	event, name := "", ""
+	// 退出を先に調べる ("disconnected" のように参加の語を含む場合があるため)
+	if match := t.leave.FindStringSubmatch(line); match != nil {
+		event, name = "leave", playerNameFromMatch(t.leave, match)
+	} else if match := t.join.FindStringSubmatch(line); match != nil {
+		event, name = "join", playerNameFromMatch(t.join, match)
+	} else {
+		return "", ""
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	switch {
+	case event == "join" && name != "":
+		t.players[name] = true
+	case event == "join":
+		t.anonymous++
+	case name != "" && t.players[name]:
+		delete(t.players, name)
+	case t.anonymous > 0:
+		t.anonymous--
+	}
+	if t.countLocked() == 0 {
+		if t.idleSince.IsZero() {
+			t.idleSince = time.Now()
+		}
+	} else {
+		t.idleSince = time.Time{}
+	}
+	return event, name
+}
+
+// playerNameFromMatch は、正規表現の名前付きグループ "name" に一致した文字列を返します。
+func playerNameFromMatch(pattern *regexp.Regexp, match []string) string {
+	if i := pattern.SubexpIndex("name"); i > 0 && i < len(match) {
+		return match[i]
+	}
+	return ""
+}
+
+var (
+	// playerTrackers は、サーバーごとのプレイヤー数の追跡状態です (キー: サーバー構成名)。
+	// 起動 (再起動を含む) のたびに新しい状態に置き換えられます。
+	playerTrackers = make(map[string]*playerTracker)
+	// idleStopping は、無人のため停止処理中のサーバー構成名です。
+	idleStopping = make(map[string]bool)
+	// playerTrackersMutex は、playerTrackers と idleStopping を保護するためのミューテックスです。
+	playerTrackersMutex sync.Mutex
+)
+
+// startPlayerTracker は、サーバーのプレイヤー数の追跡を開始して返します。startServerProcess から呼び出されます。
+// 起動直後はプレイヤーがいないため、起動時刻から無人の時間を数えます。
+func startPlayerTracker(name string) *playerTracker {
+	settings := currentConfig().Players
+	tracker := &playerTracker{
+		// 設定の読み込み時に検証済みのため、コンパイルに失敗することはない
+		join:      regexp.MustCompile(settings.JoinPattern),
+		leave:     regexp.MustCompile(settings.LeavePattern),
+		players:   make(map[string]bool),
+		idleSince: time.Now(),
+	}
+	playerTrackersMutex.Lock()
+	playerTrackers[name] = tracker
+	playerTrackersMutex.Unlock()
+	return tracker
+}
+
+// observePlayerLine は、ゲームサーバーのコンソール出力の1行をプレイヤー数の追跡に渡し、参加・退出をログに記録します。
+func observePlayerLine(name string, tracker *playerTracker, line string) {
+	event, playerName := tracker.observe(line)
+	if event == "" {
+		return
+	}
+	if playerName == "" {
+		playerName = "(名前不明)"
+	}
+	label := map[string]string{"join": "参加", "leave": "退出"}[event]
+	processLog.withServer(name).infof("プレイヤーが%sしました: %s (現在 %d 人)", label, playerName, getPlayerCount(name))
+}
+
+// getPlayerCount は、サーバーの現在のプレイヤー数を返します (追跡していないサーバーは0人)。
+func getPlayerCount(name string) int {
+	playerTrackersMutex.Lock()
+	tracker := playerTrackers[name]
+	playerTrackersMutex.Unlock()
+	if tracker == nil {
+		return 0
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.countLocked()
+}
+
+// getIdleSince は、サーバーのプレイヤーが0人になった時刻を返します (プレイヤーがいる場合や追跡していない場合はゼロ値)。
+func getIdleSince(name string) time.Time {
+	playerTrackersMutex.Lock()
+	tracker := playerTrackers[name]
+	playerTrackersMutex.Unlock()
+	if tracker == nil {
+		return time.Time{}
+	}
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	return tracker.idleSince
+}
+
+// idleTimeoutFor は、サーバー構成名に適用する無人時の自動停止までの時間を返します (0 は無効)。
+func idleTimeoutFor(name string) time.Duration {
+	cfg := currentConfig()
+	if settings, ok := cfg.ServerOverrides[name]; ok {
+		return settings.IdleTimeout
+	}
+	return cfg.Players.IdleTimeout
+}
+
+// formatIdleTimeout は、無人時の自動停止までの時間をログ表示用の文字列にします。
+func formatIdleTimeout(timeout time.Duration) string {
+	if timeout == 0 {
+		return "無効"
+	}
+	return fmt.Sprintf("プレイヤーが %v いない場合に停止", timeout)
+}
+
+// startIdleMonitor は、無人のサーバーを定期的に確認して停止するゴルーチンを開始します。常駐モードの起動時に main から呼び出されます。
+func startIdleMonitor() {
+	go func() {
+		for {
+			time.Sleep(idleCheckInterval)
+			stopIdleServers(time.Now())
+		}
+	}()
+}
+
+// stopIdleServers は、プレイヤーが0人の状態が自動停止までの時間を超えて続いているサーバーを停止します。
+func stopIdleServers(now time.Time) {
+	for name := range getRunningProcesses() { // process_manager.go
+		timeout := idleTimeoutFor(name)
+		idleSince := getIdleSince(name)
+		if timeout == 0 || idleSince.IsZero() || now.Sub(idleSince) < timeout {
+			continue
+		}
+		// 停止したサーバーの設定ファイルは serverIdleStopped でしか Bot に返せないため、
+		// Bot が受け取れない間 (切断中や connected の受信前、未対応の Bot) は停止しない (protocol.go)
+		if !botCanReceiveEvent("serverIdleStopped") {
+			processLog.withServer(name).debugf("Bot が serverIdleStopped を受信できないため、無人のサーバーの停止を見送ります。")
+			continue
+		}
+		playerTrackersMutex.Lock()
+		if idleStopping[name] {
+			playerTrackersMutex.Unlock()
+			continue
+		}
+		idleStopping[name] = true
+		playerTrackersMutex.Unlock()
+		go stopIdleServer(name, now.Sub(idleSince))
+	}
+}
+
+// stopIdleServer は、無人のサーバーを stopServer と同じ処理で停止し、serverIdleStopped イベントで通知します。
+// 停止の直前にプレイヤーが参加した場合は、stopServer の確認 (needsConfirmation) により停止しません。
+func stopIdleServer(name string, idle time.Duration) {
+	defer func() {
+		playerTrackersMutex.Lock()
+		delete(idleStopping, name)
+		playerTrackersMutex.Unlock()
+	}()
+	srvLog := processLog.withServer(name)
+	srvLog.infof("プレイヤーが %v の間いないため、サーバーを停止します。", idle.Round(time.Second))
+
+	requestID := newLocalRequestID("idle") // local_requests.go
+	final, err := runLocalRequest(requestID, "stopServer", handleStopServerProcess, StopServerPayload{Name: name}, scheduledActionTimeout, nil)
+	if err != nil {
+		srvLog.errorf("無人のサーバーの停止に失敗しました: %v", err)
+		metricIdleStops.inc("failure") // metrics.go
+		return
+	}
+	var response ResponsePayload
+	_ = json.Unmarshal(final.Payload, &response)
+	switch {
+	case response.NeedsConfirmation:
+		srvLog.infof("停止の直前にプレイヤーが参加したため、停止を取り消しました (%d 人)。", response.Players)
+		metricIdleStops.inc("cancelled")
+		return
+	case final.Type == "error" || !response.Success:
+		srvLog.errorf("無人のサーバーの停止に失敗しました: %s", response.Message)
+		metricIdleStops.inc("failure")
+		return
+	}
+
+	metricIdleStops.inc("success")
+	idle = idle.Round(time.Second)
+	sendServerEvent(ServerIdleStoppedPayload{ // process_manager.go
+		EventType:    "serverIdleStopped",
+		ServerName:   name,
+		IdleSeconds:  int(idle / time.Second),
+		Message:      fmt.Sprintf("プレイヤーが %v の間いなかったため、サーバー '%s' を停止しました。%s", idle, name, response.Message),
+		Config:       response.Config,
+		UnknownPaths: response.UnknownPaths,
+		BackupID:     response.BackupID,
+	})
+}
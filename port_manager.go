@@ -2,7 +2,7 @@ package main
 
 import (
 	"fmt"
-	"log"
+	"strings"
 	"sync"
 )
 
@@ -14,56 +14,89 @@ var (
 // ポートマネージャー初期化
 func initializePortManager() {
 	usedPorts = make(map[int]bool)
-	log.Println("[ポート管理] ポートマネージャーを初期化しました。")
+	portLog.infof("ポートマネージャーを初期化しました。")
 }
 
-// 指定範囲内で利用可能なポートを探す
-func findAvailablePort(minPort, maxPort int) (int, error) {
+// 指定されたポートプール内で利用可能なポートを探す (プールの記述順に検索)
+func findAvailablePort(pools []PortRange) (int, error) {
 	usedPortsMutex.Lock()
 	defer usedPortsMutex.Unlock()
 
-	for port := minPort; port <= maxPort; port++ {
-		if !usedPorts[port] { // マップに存在しない = 未使用
-			log.Printf("[ポート管理] 空きポート発見: %d", port)
-			return port, nil
+	for _, pool := range pools {
+		for port := pool.Min; port <= pool.Max; port++ {
+			if !usedPorts[port] { // マップに存在しない = 未使用
+				portLog.infof("空きポート発見: %d", port)
+				return port, nil
+			}
 		}
 	}
-	log.Printf("[ポート管理] エラー: 利用可能なポートが範囲内 (%d-%d) に見つかりません。", minPort, maxPort)
-	return -1, fmt.Errorf("利用可能なポートがありません (%d-%d)", minPort, maxPort)
+	portLog.errorf("利用可能なポートがポートプール内 (%s) に見つかりません。", formatPortPools(pools))
+	return -1, fmt.Errorf("利用可能なポートがありません (%s)", formatPortPools(pools))
+}
+
+// ポートがいずれかのポートプールに含まれているか判定する
+func isPortInPools(port int, pools []PortRange) bool {
+	for _, pool := range pools {
+		if port >= pool.Min && port <= pool.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// ポートプールから同時に起動可能なサーバーの最大数を計算する
+// (各プールの MAX_PORT + 1 はサーバーリスト表示用に使われるため、プールごとに Max - Min 台とする)
+func portPoolCapacity(pools []PortRange) int {
+	capacity := 0
+	for _, pool := range pools {
+		if pool.Max >= pool.Min {
+			capacity += pool.Max - pool.Min
+		}
+	}
+	return capacity
+}
+
+// ポートプールをログ出力用の文字列に変換する (例: "40000-40010, 41000-41005")
+func formatPortPools(pools []PortRange) string {
+	ranges := make([]string, 0, len(pools))
+	for _, pool := range pools {
+		ranges = append(ranges, fmt.Sprintf("%d-%d", pool.Min, pool.Max))
+	}
+	return strings.Join(ranges, ", ")
 }
 
 // ポートを使用中にマークする
 func assignPort(port int) bool {
-	if port < MinPort || port > MaxPort { // config.go の変数を使用
-		log.Printf("[ポート管理] 警告: 範囲外のポート %d を使用中にマークしようとしました。", port)
+	if !isPortInPools(port, currentConfig().PortPools) { // config.go の設定を使用
+		portLog.warnf("範囲外のポート %d を使用中にマークしようとしました。", port)
 		return false
 	}
 	usedPortsMutex.Lock()
 	defer usedPortsMutex.Unlock()
 
 	if usedPorts[port] {
-		log.Printf("[ポート管理] 警告: ポート %d は既に使用中です。", port)
+		portLog.warnf("ポート %d は既に使用中です。", port)
 		return false // すでに使用中
 	}
 	usedPorts[port] = true
-	log.Printf("[ポート管理] ポート %d を使用中にマークしました。", port)
+	portLog.infof("ポート %d を使用中にマークしました。", port)
 	return true
 }
 
 // ポートを解放する
 func releasePort(port int) {
-	if port < MinPort || port > MaxPort {
-		log.Printf("[ポート管理] 警告: 範囲外のポート %d を解放しようとしました。", port)
+	if !isPortInPools(port, currentConfig().PortPools) {
+		portLog.warnf("範囲外のポート %d を解放しようとしました。", port)
 		return
 	}
 	usedPortsMutex.Lock()
 	defer usedPortsMutex.Unlock()
 
 	if !usedPorts[port] {
-		log.Printf("[ポート管理] 警告: 未使用のポート %d を解放しようとしました。", port)
+		portLog.warnf("未使用のポート %d を解放しようとしました。", port)
 	} else {
 		delete(usedPorts, port) // マップから削除
-		log.Printf("[ポート管理] ポート %d を解放しました。", port)
+		portLog.infof("ポート %d を解放しました。", port)
 	}
 }
 
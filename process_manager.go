@@ -3,19 +3,20 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 	"bufio"
 )
 
 // RunningProcessInfo は、実行中のゲームサーバープロセスとそのポート番号を保持する構造体です。
 type RunningProcessInfo struct {
-	Process *os.Process // 実行中のプロセスの情報
-	Port    int         // そのプロセスが使用しているポート番号
+	Process   *os.Process // 実行中のプロセスの情報
+	Port      int         // そのプロセスが使用しているポート番号
+	StartedAt time.Time   // プロセスを起動した時刻 (再起動時は再起動した時刻)
 }
 
 // --- グローバル変数 ---
@@ -27,14 +28,21 @@ var (
 	runningProcs map[string]RunningProcessInfo
 	// procsMutex は、runningProcs マップへの同時アクセスを保護するためのミューテックスです。
 	procsMutex sync.Mutex
+
+	// restartHistory は、再起動ポリシーの判定に使う、サーバー構成名ごとの自動再起動の時刻履歴です。
+	restartHistory map[string][]time.Time
+	// restartHistoryMutex は、restartHistory マップへの同時アクセスを保護するためのミューテックスです。
+	restartHistoryMutex sync.Mutex
 )
 
 // InitializeProcessManager は、プロセスマネージャーを初期化します。
 // runningProcs マップとポートマネージャーを初期化します。
 func InitializeProcessManager() {
 	runningProcs = make(map[string]RunningProcessInfo)
+	restartHistory = make(map[string][]time.Time)
 	initializePortManager() // port_manager.go の初期化関数を呼び出し
-	log.Println("[プロセス管理] プロセスマネージャーを初期化しました。")
+	reserveOrphanedServerPorts() // server_state.go: 別プロセスが管理中のサーバーのポートを予約
+	processLog.infof("プロセスマネージャーを初期化しました。")
 }
 
 // getRunningServerNames は、現在実行中のサーバー構成名のリストを取得します。
@@ -46,64 +54,107 @@ func getRunningServerNames() []string {
 	for name := range runningProcs {
 		names = append(names, name)
 	}
-	log.Printf("[プロセス管理] 現在実行中のサーバーリスト: %v", names)
+	processLog.infof("現在実行中のサーバーリスト: %v", names)
 	return names
 }
 
+// getRunningProcesses は、実行中のサーバープロセスの情報のコピーを返します (キー: サーバー構成名)。
+// 管理API (admin_api.go) などから、ロックを保持せずに参照するために使用します。
+func getRunningProcesses() map[string]RunningProcessInfo {
+	procsMutex.Lock()
+	defer procsMutex.Unlock()
+	procs := make(map[string]RunningProcessInfo, len(runningProcs))
+	for name, info := range runningProcs {
+		procs[name] = info
+	}
+	return procs
+}
+
 // handleStartServerProcess は、WebSocket経由で受信した "startServer" 要求を処理するメイン関数です。
 // ポート割り当て、設定ファイル処理、ワークショップダウンロード、サーバープロセス起動など、一連の処理を行います。
 // 引数:
 //   requestID (string): Botから送信された要求を一意に識別するID。応答や通知で使用します。
 //   payload (json.RawMessage): "startServer" 要求のペイロード部分。StartServerPayload 構造体にデコードされます。
 func handleStartServerProcess(requestID string, payload json.RawMessage) {
+	reqLog := processLog.withRequest(requestID)
 	// --- 1. ペイロード解析 ---
 	// 受信したJSONペイロードを StartServerPayload 構造体にデコードします。
 	var data StartServerPayload
 	if err := json.Unmarshal(payload, &data); err != nil {
 		// デコード失敗はリクエスト形式が不正であることを示すため、エラー応答を返して終了します。
-		log.Printf("[プロセス管理][開始:%s] エラー: startServerペイロードのデコード失敗: %v", requestID, err)
+		reqLog.errorf("startServerペイロードのデコード失敗: %v", err)
 		sendErrorResponse(requestID, fmt.Sprintf("ペイロード解析失敗: %v", err)) // websocket_client.go
 		return
 	}
-	log.Printf("[プロセス管理][開始:%s] 要求受信: 構成名='%s'", requestID, data.Name)
+	reqLog = reqLog.withServer(data.Name)
+	reqLog.infof("要求受信: 構成名='%s'", data.Name)
+	// 処理中に設定が再読み込みされても一貫した値を使うため、現在の設定を取得しておきます。
+	cfg := currentConfig() // config.go
+
+	// 予定 (schedules) が指定された場合は、起動処理を始める前に検証しておきます (schedule.go)。
+	if data.Schedules != nil {
+		if _, _, err := validateSchedules(data.Schedules); err != nil {
+			reqLog.errorf("予定が不正です: %v", err)
+			sendResponse(requestID, false, fmt.Sprintf("予定が不正なため起動しません: %v", err), "")
+			return
+		}
+	}
+
+	// --- 1.5. バックアップからの復元 (backupId / saveName が指定された場合のみ) ---
+	// 設定ファイルを適用する前に、バックアップの整合性を検証して設定ディレクトリに展開します (backup.go)。
+	restoredBackupID, err := restoreBackupForStart(reqLog, requestID, data)
+	if err != nil {
+		reqLog.errorf("バックアップの復元に失敗しました: %v", err)
+		sendResponse(requestID, false, fmt.Sprintf("バックアップの復元に失敗したため起動しません: %v", err), "") // websocket_client.go
+		return
+	}
+	if restoredBackupID != "" && data.Config == "" {
+		// 設定が省略された場合は、バックアップに含まれる設定ファイルで起動します。
+		data.Config, err = loadRestoredServerConfig(reqLog, data.Name)
+		if err != nil {
+			reqLog.errorf("%v", err)
+			sendResponse(requestID, false, fmt.Sprintf("バックアップ '%s' の設定ファイルを使用できません: %v", restoredBackupID, err), "")
+			return
+		}
+	}
 
 	// --- 2. 空きポートの検索 ---
 	// 設定されたポート範囲内で利用可能なポートを探します。
-	log.Printf("[プロセス管理][開始:%s] 空きポートを検索中 (範囲: %d-%d)...", requestID, MinPort, MaxPort) // MinPort, MaxPort は config.go で定義
-	assignedPort, err := findAvailablePort(MinPort, MaxPort) // port_manager.go
+	reqLog.infof("空きポートを検索中 (範囲: %s)...", formatPortPools(cfg.PortPools))
+	assignedPort, err := findAvailablePort(cfg.PortPools) // port_manager.go
 	if err != nil {
 		// 空きポートが見つからない場合はサーバーを起動できないため、エラー応答を返して終了します。
-		log.Printf("[プロセス管理][開始:%s] エラー: 空きポートが見つかりません: %v", requestID, err)
+		reqLog.errorf("空きポートが見つかりません: %v", err)
 		sendResponse(requestID, false, fmt.Sprintf("空きポート確保失敗: %v", err), "") // websocket_client.go
 		return
 	}
-	log.Printf("[プロセス管理][開始:%s] ポート %d を使用予定。", requestID, assignedPort)
+	reqLog.infof("ポート %d を使用予定。", assignedPort)
 
 	// --- 3. 設定ファイル(XML)のポート番号更新 ---
 	// Botから受け取ったXML文字列内のポート番号を、上で割り当てたポート番号に書き換えます。
-	log.Printf("[プロセス管理][開始:%s] 受信したXMLのポートを %d に更新します...", requestID, assignedPort)
+	reqLog.infof("受信したXMLのポートを %d に更新します...", assignedPort)
 	xmlWithPort, err := updateXmlPort(data.Config, assignedPort) // config_manager.go
 	if err != nil {
 		// XMLのパースや更新に失敗した場合、設定ファイルが壊れている可能性があるため、エラー応答を返して終了します。
-		log.Printf("[プロセス管理][開始:%s] エラー: XML内のポート更新失敗: %v", requestID, err)
+		reqLog.errorf("XML内のポート更新失敗: %v", err)
 		sendResponse(requestID, false, fmt.Sprintf("設定ファイルのポート更新失敗: %v", err), "")
 		// この時点ではまだ assignPort() していないのでポート解放は不要です。
 		return
 	}
-	log.Printf("[プロセス管理][開始:%s] XMLポート更新完了。", requestID)
+	reqLog.infof("XMLポート更新完了。")
 
 	// --- 4. Workshop IDの抽出とXMLからの削除 ---
 	// ポート更新後のXMLから、<playlists> および <mods> 内の Workshop ID (<path path="数字"/>) を抽出します。
 	// 同時に、抽出元の <path> 要素をXMLから削除します。
-	log.Printf("[プロセス管理][開始:%s] XMLからワークショップIDを抽出し、該当パスを削除します...", requestID)
+	reqLog.infof("XMLからワークショップIDを抽出し、該当パスを削除します...")
 	playlistIDs, modIDs, xmlWithIdsRemoved, err := extractWorkshopIDsAndModifyXML(xmlWithPort) // xml_manager.go
 	if err != nil {
 		// XMLのパースや操作に失敗した場合、エラー応答を返して終了します。
-		log.Printf("[プロセス管理][開始:%s] エラー: XMLからのワークショップID抽出またはパス削除に失敗: %v", requestID, err)
+		reqLog.errorf("XMLからのワークショップID抽出またはパス削除に失敗: %v", err)
 		sendResponse(requestID, false, fmt.Sprintf("設定ファイルからのワークショップ情報抽出失敗: %v", err), "")
 		return
 	}
-	log.Printf("[プロセス管理][開始:%s] 抽出したプレイリストID数: %d, MOD ID数: %d", requestID, len(playlistIDs), len(modIDs))
+	reqLog.infof("抽出したプレイリストID数: %d, MOD ID数: %d", len(playlistIDs), len(modIDs))
 
 	// --- 5. Workshop アイテムのダウンロード/更新 ---
 	var successfulPlaylistIDs, successfulModIDs, failedItemIDs []string
@@ -111,24 +162,27 @@ func handleStartServerProcess(requestID string, payload json.RawMessage) {
 
 	// プレイリストIDまたはMOD IDが1つ以上抽出された場合のみ、ダウンロード処理を実行します。
 	if len(playlistIDs) > 0 || len(modIDs) > 0 {
-		log.Printf("[プロセス管理][開始:%s] ワークショップアイテムのダウンロード/更新処理を開始します。", requestID)
+		reqLog.infof("ワークショップアイテムのダウンロード/更新処理を開始します。")
 		// Botに進捗状況を通知します (ダウンロード開始)。
 		sendStatusUpdate(requestID, "workshop_download_start", "ワークショップアイテムのダウンロード/更新を開始します...") // websocket_client.go
 
 		// SteamCMDを実行してアイテムをダウンロード/更新し、成功したIDのリストを取得します。
+		// 構成名ごとに SteamCMD プロファイルが選択されていればその設定を使います (config.go)。
 		var steamCmdErr error
+		steamCmd := cfg.steamCmdProfileFor(data.Name)
 		successfulPlaylistIDs, successfulModIDs, steamCmdErr = DownloadWorkshopItems(
-			playlistIDs,                 // 抽出したプレイリストID
-			modIDs,                      // 抽出したMOD ID
-			WorkshopPlaylistsInstallDir, // プレイリストのインストール先 (config.go)
-			WorkshopModsInstallDir,      // MODのインストール先 (config.go)
-			GameAppID,                 // ゲームのApp ID (config.go)
-			SteamCmdPath,              // SteamCMDのパス (config.go)
+			playlistIDs,                   // 抽出したプレイリストID
+			modIDs,                        // 抽出したMOD ID
+			steamCmd.WorkshopPlaylistsDir, // プレイリストのインストール先
+			steamCmd.WorkshopModsDir,      // MODのインストール先
+			steamCmd.AppID,                // ゲームのApp ID
+			steamCmd.Path,                 // SteamCMDのパス
+			steamCmd.Login,                // SteamCMDのログインユーザー名
 		) // steamcmd_manager.go
 
 		// SteamCMDの実行自体にエラーが発生した場合のログ出力 (パス不正、権限不足など)
 		if steamCmdErr != nil {
-			log.Printf("[プロセス管理][開始:%s] エラー: SteamCMDの実行中にエラーが発生しました: %v", requestID, steamCmdErr)
+			reqLog.errorf("SteamCMDの実行中にエラーが発生しました: %v", steamCmdErr)
 			// エラーがあっても一部アイテムは成功している可能性があるため、処理は続行しますが、Botに通知します。
 			sendStatusUpdate(requestID, "workshop_download_error", fmt.Sprintf("SteamCMD実行エラー: %v", steamCmdErr))
 			// 必要であればここで処理を中断し、エラー応答を返すことも可能です。
@@ -138,8 +192,8 @@ func handleStartServerProcess(requestID string, payload json.RawMessage) {
 
 		// 失敗したアイテムIDのリストを計算します。
 		failedItemIDs = calculateFailedIDs(playlistIDs, modIDs, successfulPlaylistIDs, successfulModIDs)
-		log.Printf("[プロセス管理][開始:%s] ワークショップアイテムのダウンロード/更新処理完了。成功: %d/%d, 失敗: %d",
-			requestID, len(successfulPlaylistIDs)+len(successfulModIDs), len(playlistIDs)+len(modIDs), len(failedItemIDs))
+		reqLog.infof("ワークショップアイテムのダウンロード/更新処理完了。成功: %d/%d, 失敗: %d",
+			len(successfulPlaylistIDs)+len(successfulModIDs), len(playlistIDs)+len(modIDs), len(failedItemIDs))
 
 		// Botに進捗状況を通知します (ダウンロード完了)。失敗件数もメッセージに含めます。
 		completionMessage := fmt.Sprintf("ワークショップアイテムの処理完了。(成功: %d/%d)",
@@ -151,13 +205,13 @@ func handleStartServerProcess(requestID string, payload json.RawMessage) {
 
 		// --- 6. 成功したアイテムのパスをXMLに追加 ---
 		// ダウンロード/更新に成功したアイテムのパス情報を、ID除去後のXMLに追加します。
-		log.Printf("[プロセス管理][開始:%s] 成功したワークショップアイテムのパスをXMLに追加します...", requestID)
+		reqLog.infof("成功したワークショップアイテムのパスをXMLに追加します...")
 		// MODの絶対パスを生成するために、設定ディレクトリの絶対パスが必要です。
 		configDir := filepath.Join(configBaseDir, data.Name) // 例: ./config/test
 		configDirAbs, pathErr := filepath.Abs(configDir)     // 例: C:\path\to\project\config\test
 		if pathErr != nil {
 			// 絶対パスの取得に失敗した場合、MODパスを正しく生成できないためエラーとします。
-			log.Printf("[プロセス管理][開始:%s] エラー: 設定ディレクトリの絶対パス取得に失敗: %v", requestID, pathErr)
+			reqLog.errorf("設定ディレクトリの絶対パス取得に失敗: %v", pathErr)
 			sendResponse(requestID, false, fmt.Sprintf("設定ディレクトリのパス解決失敗: %v", pathErr), "")
 			return
 		}
@@ -166,32 +220,32 @@ func handleStartServerProcess(requestID string, payload json.RawMessage) {
 		finalXmlString, xmlAddErr := addWorkshopPathsToXML(xmlWithIdsRemoved, successfulPlaylistIDs, successfulModIDs, configDirAbs) // xml_manager.go
 		if xmlAddErr != nil {
 			// パスの追加に失敗した場合、エラー応答を返して終了します。
-			log.Printf("[プロセス管理][開始:%s] エラー: XMLへのワークショップパス追加に失敗: %v", requestID, xmlAddErr)
+			reqLog.errorf("XMLへのワークショップパス追加に失敗: %v", xmlAddErr)
 			sendResponse(requestID, false, fmt.Sprintf("設定ファイルへのワークショップ情報書き込み失敗: %v", xmlAddErr), "")
 			return
 		}
 		xmlToSave = finalXmlString // 保存対象のXMLを、パスが追加された最終版に更新します。
-		log.Printf("[プロセス管理][開始:%s] XMLへのワークショップパス追加完了。", requestID)
+		reqLog.infof("XMLへのワークショップパス追加完了。")
 
 	} else {
 		// ワークショップアイテムが指定されていなかった場合
-		log.Printf("[プロセス管理][開始:%s] ワークショップアイテムは指定されていません。", requestID)
+		reqLog.infof("ワークショップアイテムは指定されていません。")
 		failedItemIDs = []string{} // 失敗リストは空とします。
 		// xmlToSave は xmlWithIdsRemoved (ポート更新済み、ID除去済みだが元々IDはなかった) のままです。
 	}
 
 	// --- 7. 最終的な設定ファイルの保存 ---
 	// ポート番号が更新され、成功したワークショップアイテムのパスが追加されたXMLをファイルに保存します。
-	log.Printf("[プロセス管理][開始:%s] 最終的な設定ファイル '%s' を保存します...", requestID, data.Name)
+	reqLog.infof("最終的な設定ファイル '%s' を保存します...", data.Name)
 	// デバッグ用に保存内容を確認したい場合は以下のコメントを解除します。
-	// log.Printf("[プロセス管理][開始:%s] 保存するXML:\n%s", requestID, xmlToSave)
+	// reqLog.infof("保存するXML:\n%s", xmlToSave)
 	if err := saveConfigFile(data.Name, xmlToSave); err != nil { // config_manager.go
 		// ファイルの保存に失敗した場合 (権限不足など)、エラー応答を返して終了します。
-		log.Printf("[プロセス管理][開始:%s] エラー: 最終設定ファイルの保存失敗: %v", requestID, err)
+		reqLog.errorf("最終設定ファイルの保存失敗: %v", err)
 		sendResponse(requestID, false, fmt.Sprintf("設定ファイルの保存失敗: %v", err), "")
 		return
 	}
-	log.Printf("[プロセス管理][開始:%s] 最終設定ファイル保存成功。", requestID)
+	reqLog.infof("最終設定ファイル保存成功。")
 
 
 	// --- 8. ポートを使用中にマーク ---
@@ -199,48 +253,54 @@ func handleStartServerProcess(requestID string, payload json.RawMessage) {
 	// ファイル保存後、プロセス起動直前に行うことで、ファイル準備失敗時にポートを無駄に確保しないようにします。
 	if !assignPort(assignedPort) { // port_manager.go
 		// ポートの確保に失敗した場合 (他のプロセスが先に確保したなど)、エラー応答を返します。
-		log.Printf("[プロセス管理][開始:%s] エラー: ポート %d を使用中にマークできませんでした（競合の可能性）。", requestID, assignedPort)
+		reqLog.errorf("ポート %d を使用中にマークできませんでした（競合の可能性）。", assignedPort)
 		sendResponse(requestID, false, fmt.Sprintf("ポート %d の確保に失敗しました（競合発生）。", assignedPort), "")
 		// 既に保存した設定ファイルとディレクトリを削除します。
 		configDir := filepath.Join(configBaseDir, data.Name)
 		_ = os.RemoveAll(configDir) // エラーは無視します（最悪残っても大きな問題ではない）。
-		log.Printf("[プロセス管理][開始:%s] ポート確保失敗のため設定ディレクトリ '%s' を削除しました。", requestID, configDir)
+		reqLog.warnf("ポート確保失敗のため設定ディレクトリ '%s' を削除しました。", configDir)
 		return
 	}
-	log.Printf("[プロセス管理][開始:%s] ポート %d を使用中にマークしました。", requestID, assignedPort)
+	reqLog.infof("ポート %d を使用中にマークしました。", assignedPort)
 
 	// --- 9. 既存プロセスの停止 (念のため) ---
 	// 同じ構成名で古いプロセスが残っている場合に備えて、停止処理を試みます。
-	log.Printf("[プロセス管理][開始:%s] 既存プロセスがあれば停止を試みます: '%s'", requestID, data.Name)
+	reqLog.infof("既存プロセスがあれば停止を試みます: '%s'", data.Name)
 	stopExistingProcess(data.Name) // この関数内でポート解放も行われます (対象プロセスが見つかれば)。
 
 	// --- 10. ゲームサーバープロセスの起動 ---
 	// 準備が整ったので、実際にゲームサーバーの実行ファイルを開始します。
-	log.Printf("[プロセス管理][開始:%s] ゲームサーバープロセス '%s' を起動します...", requestID, data.Name)
+	reqLog.infof("ゲームサーバープロセス '%s' を起動します...", data.Name)
 	configDir := filepath.Join(configBaseDir, data.Name) // プロセスに渡す設定ディレクトリのパス
+	// 起動から管理マップへの登録までの間に、バックアップの復元 (backup.go) が設定ディレクトリを置き換えないようロックします。
+	unlockBackups := lockServerBackups(data.Name)
 	cmd, err := startServerProcess(data.Name, configDir) // ヘルパー関数内で os/exec を実行
 	if err != nil {
+		unlockBackups()
 		// プロセスの起動自体に失敗した場合 (実行ファイルが見つからない、権限不足など)。
-		log.Printf("[プロセス管理][開始:%s] エラー: ゲームサーバープロセス '%s' の起動失敗: %v", requestID, data.Name, err)
+		reqLog.errorf("ゲームサーバープロセス '%s' の起動失敗: %v", data.Name, err)
 		releasePort(assignedPort) // ★ 確保したポートを解放します。
 		// 作成した設定ディレクトリも削除します。
 		_ = os.RemoveAll(configDir)
-		log.Printf("[プロセス管理][開始:%s] 起動失敗したため設定ディレクトリ '%s' を削除しました。", requestID, configDir)
+		reqLog.warnf("起動失敗したため設定ディレクトリ '%s' を削除しました。", configDir)
 		sendResponse(requestID, false, fmt.Sprintf("サーバープロセスの起動失敗: %v", err), "")
 		return
 	}
 	// プロセス起動成功
-	log.Printf("[プロセス管理][開始:%s] プロセス起動成功: '%s' (PID: %d)", requestID, data.Name, cmd.Process.Pid)
+	reqLog.infof("プロセス起動成功: '%s' (PID: %d)", data.Name, cmd.Process.Pid)
 
 	// --- 11. 起動したプロセス情報とポート番号を管理マップに保存 ---
 	// 起動したプロセスを管理対象に追加します。
 	procsMutex.Lock() // マップアクセス保護
 	runningProcs[data.Name] = RunningProcessInfo{
-		Process: cmd.Process,  // プロセス情報
-		Port:    assignedPort, // 使用ポート
+		Process:   cmd.Process,  // プロセス情報
+		Port:      assignedPort, // 使用ポート
+		StartedAt: time.Now(),   // 起動時刻
 	}
 	procsMutex.Unlock()
-	log.Printf("[プロセス管理][開始:%s] 実行中プロセスマップに登録: '%s' (PID: %d, Port: %d)", requestID, data.Name, cmd.Process.Pid, assignedPort)
+	unlockBackups()
+	reqLog.infof("実行中プロセスマップに登録: '%s' (PID: %d, Port: %d)", data.Name, cmd.Process.Pid, assignedPort)
+	writeServerStateFile(data.Name, cmd.Process.Pid, assignedPort) // server_state.go
 
 	// --- 12. Botに成功応答を送信 ---
 	// 全ての処理が完了したことをBotに通知します。
@@ -250,14 +310,26 @@ func handleStartServerProcess(requestID string, payload json.RawMessage) {
 		successMessage += fmt.Sprintf("。%d件のワークショップアイテムのダウンロード/更新に失敗しました。", len(failedItemIDs))
 	}
 	// 失敗リストもペイロードに含めて送信します (websocket_client.go 側で対応済み)。
-	sendStartSuccessResponse(requestID, successMessage, assignedPort, failedItemIDs) // websocket_client.go
+	if restoredBackupID != "" {
+		successMessage += fmt.Sprintf("。バックアップ '%s' から復元しました", restoredBackupID)
+	}
+	// 起動に成功した場合のみ、このサーバーの予定を置き換えます (schedule.go)。
+	if data.Schedules != nil {
+		if schedules, err := setServerSchedules(data.Name, data.Schedules); err != nil {
+			reqLog.errorf("予定の設定に失敗しました: %v", err)
+			successMessage += fmt.Sprintf("。予定の設定に失敗しました: %v", err)
+		} else {
+			successMessage += fmt.Sprintf("。予定を %d 件設定しました", len(schedules))
+		}
+	}
+	sendStartSuccessResponse(requestID, successMessage, assignedPort, failedItemIDs, restoredBackupID) // websocket_client.go
 
 	// --- 13. プロセス終了監視を開始 ---
 	// 起動したプロセスが予期せず終了しないか、別のゴルーチンで監視を開始します。
 	go waitForProcessExit(data.Name, cmd.Process, assignedPort)
 
 	// handleStartServerProcess 関数の処理はここまでで完了です。
-	log.Printf("[プロセス管理][開始:%s] 全ての処理完了: '%s'", requestID, data.Name)
+	reqLog.infof("全ての処理完了: '%s'", data.Name)
 }
 
 // calculateFailedIDs は、要求されたIDリストと成功したIDリストを比較し、
@@ -295,32 +367,34 @@ func calculateFailedIDs(requestedPlaylists, requestedMods, successfulPlaylists,
 //   requestID (string): Botからの要求ID。
 //   payload (json.RawMessage): "stopServer" 要求のペイロード。StopServerPayload にデコードされます。
 func handleStopServerProcess(requestID string, payload json.RawMessage) {
+	reqLog := processLog.withRequest(requestID)
 	// --- ペイロード解析 ---
 	var data StopServerPayload
 	if err := json.Unmarshal(payload, &data); err != nil {
-		log.Printf("[プロセス管理][停止:%s] エラー: stopServerペイロードのデコード失敗: %v", requestID, err)
+		reqLog.errorf("stopServerペイロードのデコード失敗: %v", err)
 		sendErrorResponse(requestID, fmt.Sprintf("不正な停止要求ペイロード: %v", err))
 		return
 	}
-	log.Printf("[プロセス管理][停止:%s] 要求受信: 構成名=%s, 確認済み=%v", requestID, data.Name, data.Confirmed)
+	reqLog = reqLog.withServer(data.Name)
+	reqLog.infof("要求受信: 構成名=%s, 確認済み=%v", data.Name, data.Confirmed)
 
-	// --- プレイヤー数確認 (現状ダミー) ---
-	// confirmed フラグが false の場合、プレイヤー数をチェックする想定 (現在は常に0人とする)
+	// --- プレイヤー数確認 ---
+	// confirmed フラグが false の場合、ゲームサーバーの出力から追跡しているプレイヤー数をチェックします (players.go)
 	if !data.Confirmed {
-		dummyPlayerCount := 0 // ここで実際のプレイヤー数を取得するロジックが必要になる可能性がある
-		log.Printf("[プロセス管理][停止:%s] プレイヤー数確認 (ダミー): %d 人 (構成: %s)", requestID, dummyPlayerCount, data.Name)
+		playerCount := getPlayerCount(data.Name)
+		reqLog.infof("プレイヤー数確認: %d 人 (構成: %s)", playerCount, data.Name)
 
-		if dummyPlayerCount > 0 {
+		if playerCount > 0 {
 			// プレイヤーがいる場合は、確認を求める応答を返し、処理を中断します。
-			log.Printf("[プロセス管理][停止:%s] プレイヤー %d 人のため確認が必要です。応答を返します。", requestID, dummyPlayerCount)
-			sendResponse(requestID, false, fmt.Sprintf("プレイヤーが %d 人います。", dummyPlayerCount), "", true, dummyPlayerCount) // needsConfirmation: true
+			reqLog.infof("プレイヤー %d 人のため確認が必要です。応答を返します。", playerCount)
+			sendResponse(requestID, false, fmt.Sprintf("プレイヤーが %d 人います。", playerCount), "", true, playerCount) // needsConfirmation: true
 			return
 		}
 		// プレイヤーがいない場合は処理を続行します。
-		log.Printf("[プロセス管理][停止:%s] プレイヤーがいないため、停止処理を続行します。", requestID)
+		reqLog.infof("プレイヤーがいないため、停止処理を続行します。")
 	} else {
 		// confirmed フラグが true の場合は確認をスキップします。
-		log.Printf("[プロセス管理][停止:%s] 確認済みフラグのため、プレイヤー数確認をスキップします。", requestID)
+		reqLog.infof("確認済みフラグのため、プレイヤー数確認をスキップします。")
 	}
 
 	// --- プロセス停止処理 ---
@@ -330,7 +404,7 @@ func handleStopServerProcess(requestID string, payload json.RawMessage) {
 	if !ok {
 		// プロセスが実行中でなければ、その旨を応答して終了します。
 		procsMutex.Unlock()
-		log.Printf("[プロセス管理][停止:%s] 停止対象プロセスなし: %s", requestID, data.Name)
+		reqLog.infof("停止対象プロセスなし: %s", data.Name)
 		sendResponse(requestID, false, fmt.Sprintf("サーバー '%s' は実行されていません。", data.Name), "")
 		return
 	}
@@ -341,59 +415,88 @@ func handleStopServerProcess(requestID string, payload json.RawMessage) {
 	processToStop := processInfo.Process // 停止するプロセス
 	assignedPort := processInfo.Port    // 解放するポート
 
-	log.Printf("[プロセス管理][停止:%s] プロセス停止開始: '%s' (PID: %d)", requestID, data.Name, processToStop.Pid)
+	reqLog.infof("プロセス停止開始: '%s' (PID: %d)", data.Name, processToStop.Pid)
 	// プロセスにKillシグナルを送信します。
 	killErr := processToStop.Kill()
 	if killErr != nil {
 		// すでにプロセスが終了している場合などにエラーが発生することがありますが、処理は続行します。
-		log.Printf("[プロセス管理][停止:%s] プロセスKill失敗の可能性 (PID: %d): %v", requestID, processToStop.Pid, killErr)
+		reqLog.warnf("プロセスKill失敗の可能性 (PID: %d): %v", processToStop.Pid, killErr)
 	}
 	// プロセスが完全に終了し、リソースが解放されるのを待ちます。
 	_, waitErr := processToStop.Wait()
 	// 終了ログを出力します (エラー情報を含む)。
 	logProcessExit(processToStop.Pid, waitErr) // ヘルパー関数使用
-	log.Printf("[プロセス管理][停止:%s] プロセス停止完了: '%s' (PID: %d)", requestID, data.Name, processToStop.Pid)
+	reqLog.infof("プロセス停止完了: '%s' (PID: %d)", data.Name, processToStop.Pid)
 
 	// --- ポート解放 ---
 	// プロセスが使用していたポートを解放します。
 	if assignedPort != -1 { // ポート番号が記録されていれば
 		releasePort(assignedPort) // port_manager.go
 	} else {
-		log.Printf("[プロセス管理][停止:%s] 警告: サーバー '%s' のポート番号が不明なため解放できませんでした。", requestID, data.Name)
+		reqLog.warnf("サーバー '%s' のポート番号が不明なため解放できませんでした。", data.Name)
 	}
 
 	// --- 設定ファイルの読み込みと削除 ---
 	// 停止後に最終的な設定ファイルの内容を読み取り、Botに返却します。
-	configDir := filepath.Join(configBaseDir, data.Name)
-	configFilePath := filepath.Join(configDir, "server_config.xml")
-	configContent, readErr := os.ReadFile(configFilePath) // ファイル読み込み
+	responseMsg, responseConfig, unknownPaths := readStoppedServerConfig(reqLog, data.Name)
+	// 削除する前に、ゲームサーバーが書き込んだセーブデータやログを含む設定ディレクトリ全体をバックアップします (backup.go)。
+	// バックアップに失敗した場合は、データを失わないよう設定ディレクトリを削除せずに残します。
+	backupID, backupErr := backupBeforeRemove(reqLog, data.Name)
+	if backupErr != nil {
+		responseMsg += fmt.Sprintf(" バックアップに失敗したため、設定ディレクトリ '%s' を削除せずに残しました: %v", filepath.Join(configBaseDir, data.Name), backupErr)
+	} else {
+		if backupID != "" {
+			responseMsg += fmt.Sprintf(" バックアップ '%s' を作成しました。", backupID)
+		}
+		// 使用済みの設定ディレクトリ全体を削除します。
+		// 応答を受け取った CLI (swsc start) が即座に終了しても削除漏れが起きないよう、応答より先に削除します。
+		removeServerConfigDir(reqLog, data.Name)
+	}
 
-	// Botへの応答メッセージを作成
-	var responseMsg string
-	var responseConfig string
+	// 停止自体は成功しているので success: true で応答します。
+	sendStopSuccessResponse(requestID, responseMsg, responseConfig, unknownPaths, backupID) // websocket_client.go
+	// --- 停止処理ここまで ---
+}
+
+// readStoppedServerConfig は、停止したサーバーの設定ファイルを読み込み、
+// 起動時に展開したワークショップの配置パスを Workshop ID に戻した、ホストに依存しない設定を返します。
+// 引数 logger はログの出力先 (要求IDなどを付与したロガー) です。
+// 戻り値: 応答メッセージ、返却する設定XML (読み込み失敗時は空)、Workshop ID に戻せなかったパスのリスト
+func readStoppedServerConfig(logger *subsystemLogger, name string) (responseMsg string, responseConfig string, unknownPaths []string) {
+	configFilePath := filepath.Join(configBaseDir, name, "server_config.xml")
+	configContent, readErr := os.ReadFile(configFilePath) // ファイル読み込み
 	if readErr != nil {
 		// ファイル読み込みに失敗した場合
-		log.Printf("[プロセス管理][停止:%s] エラー: 設定ファイル読み込み失敗 (%s): %v", requestID, configFilePath, readErr)
-		responseMsg = fmt.Sprintf("サーバー '%s' を停止しましたが、設定ファイル読み込み失敗: %v", data.Name, readErr)
-		responseConfig = "" // 設定内容は空
-	} else {
-		// ファイル読み込みに成功した場合
-		log.Printf("[プロセス管理][停止:%s] 設定ファイル読み込み成功: %s", requestID, configFilePath)
-		responseMsg = fmt.Sprintf("サーバー '%s' を停止し、設定ファイルを読み込みました。", data.Name)
-		responseConfig = string(configContent) // 設定内容を文字列で渡す
+		logger.errorf("設定ファイル読み込み失敗 (%s): %v", configFilePath, readErr)
+		return fmt.Sprintf("サーバー '%s' を停止しましたが、設定ファイル読み込み失敗: %v", name, readErr), "", nil
 	}
-	// 停止自体は成功しているので success: true で応答します。
-	sendResponse(requestID, true, responseMsg, responseConfig) // websocket_client.go
 
-	// 使用済みの設定ディレクトリ全体を削除します。
-	removeAllErr := os.RemoveAll(configDir)
-	if removeAllErr != nil {
-		// ディレクトリ削除失敗はログに記録するのみとします。
-		log.Printf("[プロセス管理][停止:%s] エラー: 設定ディレクトリ削除失敗 (%s): %v", requestID, configDir, removeAllErr)
+	// ファイル読み込みに成功した場合
+	logger.infof("設定ファイル読み込み成功: %s", configFilePath)
+	restoredXml, unknownPaths, restoreErr := restoreWorkshopIDsInXML(string(configContent)) // xml_manager.go
+	if restoreErr != nil {
+		// 変換に失敗した場合は、保存されていた内容をそのまま返します。
+		logger.warnf("ワークショップIDの復元に失敗したため、設定ファイルをそのまま返却します: %v", restoreErr)
+		return fmt.Sprintf("サーバー '%s' を停止しましたが、ワークショップIDの復元に失敗しました: %v", name, restoreErr), string(configContent), nil
+	}
+
+	responseMsg = fmt.Sprintf("サーバー '%s' を停止し、設定ファイルを読み込みました。", name)
+	if len(unknownPaths) > 0 {
+		logger.warnf("Workshop ID に戻せないパスが %d 件あります: %v", len(unknownPaths), unknownPaths)
+		responseMsg += fmt.Sprintf(" %d件のパスはWorkshop IDに戻せませんでした。", len(unknownPaths))
+	}
+	return responseMsg, restoredXml, unknownPaths
+}
+
+// removeServerConfigDir は、停止したサーバーの設定ディレクトリ全体を削除します。
+// 削除失敗はログに記録するのみとします。
+func removeServerConfigDir(logger *subsystemLogger, name string) {
+	configDir := filepath.Join(configBaseDir, name)
+	if err := os.RemoveAll(configDir); err != nil {
+		logger.errorf("設定ディレクトリ削除失敗 (%s): %v", configDir, err)
 	} else {
-		log.Printf("[プロセス管理][停止:%s] 設定ディレクトリ削除成功: %s", requestID, configDir)
+		logger.infof("設定ディレクトリ削除成功: %s", configDir)
 	}
-	// --- 停止処理ここまで ---
 }
 
 
@@ -405,7 +508,7 @@ func stopExistingProcess(name string) {
 	existingInfo, ok := runningProcs[name]
 	if ok {
 		// プロセスが見つかった場合
-		log.Printf("[プロセス管理] 既存プロセス停止試行: '%s' (PID: %d, Port: %d)", name, existingInfo.Process.Pid, existingInfo.Port)
+		processLog.infof("既存プロセス停止試行: '%s' (PID: %d, Port: %d)", name, existingInfo.Process.Pid, existingInfo.Port)
 		// 先にマップから削除
 		delete(runningProcs, name)
 		procsMutex.Unlock() // Mutexを解放してからKill/Wait/Release (ブロック回避)
@@ -415,18 +518,18 @@ func stopExistingProcess(name string) {
 
 		// プロセスをKill
 		if err := processToStop.Kill(); err != nil {
-			log.Printf("[プロセス管理] 既存プロセスKill失敗 (PID: %d): %v", processToStop.Pid, err)
+			processLog.warnf("既存プロセスKill失敗 (PID: %d): %v", processToStop.Pid, err)
 		}
 		// プロセス終了待機
 		_, waitErr := processToStop.Wait()
 		logProcessExit(processToStop.Pid, waitErr) // 終了ログ
-		log.Printf("[プロセス管理] 既存プロセス停止完了 (PID: %d)", processToStop.Pid)
+		processLog.infof("既存プロセス停止完了 (PID: %d)", processToStop.Pid)
 
 		// ポート解放
 		if assignedPort != -1 {
 			releasePort(assignedPort) // port_manager.go
 		} else {
-			log.Printf("[プロセス管理] 警告: 停止した既存プロセス '%s' のポート番号が不明でした。", name)
+			processLog.warnf("停止した既存プロセス '%s' のポート番号が不明でした。", name)
 		}
 
 	} else {
@@ -439,6 +542,8 @@ func stopExistingProcess(name string) {
 // ゲームサーバーの実行ファイル (ServerExePath) を起動します。
 // 戻り値: 起動したプロセスの *exec.Cmd オブジェクト、またはエラー
 func startServerProcess(name, configDir string) (*exec.Cmd, error) {
+	cfg := currentConfig() // config.go
+
 	// ゲームサーバーに渡す設定ディレクトリの絶対パスを取得します。
 	absConfigDir, err := filepath.Abs(configDir)
 	if err != nil {
@@ -446,16 +551,23 @@ func startServerProcess(name, configDir string) (*exec.Cmd, error) {
 	}
 
 	// ゲームサーバーの起動引数を設定します (例: "+server_dir C:\path\to\config\test")
-	args := []string{"+server_dir", absConfigDir}
+	// 設定ディレクトリのパスは、起動方式に応じてゲームが解釈できる形式に変換します (例: Wine では Z:\home\...)。
+	args := []string{"+server_dir", cfg.Launcher.GamePath(absConfigDir)} // launcher.go
 
-	// コマンドオブジェクトを作成します。
-	cmd := exec.Command(ServerExePath, args...) // ServerExePath は config.go で読み込み済み
+	// 起動方式に応じたコマンドオブジェクトを作成します。
+	cmd := cfg.Launcher.BuildCommand(cfg.ServerExePath, args)
 
 	// ゲームサーバーのワーキングディレクトリを実行ファイルのあるディレクトリに設定します。
 	// (サーバーが相対パスでリソースを読み込む場合などに必要)
-	cmd.Dir = filepath.Dir(ServerExePath)
+	cmd.Dir = filepath.Dir(cfg.ServerExePath)
 
-	log.Printf("[プロセス管理] 実行コマンド: \"%s\" %v (作業ディレクトリ: %s)", ServerExePath, args, cmd.Dir)
+	processLog.infof("実行コマンド (%s): %v (作業ディレクトリ: %s)", cfg.Launcher.Name(), cmd.Args, cmd.Dir)
+
+	// リソースの上限が設定されている場合は、専用の cgroup でプロセスを起動します (cgroup.go)
+	cgroup, err := prepareServerCgroup(name, cmd)
+	if err != nil {
+		return nil, fmt.Errorf("リソース制限の準備失敗: %w", err)
+	}
 
 	stdoutPipe, _ := cmd.StdoutPipe() // エラーハンドリング省略
 	stderrPipe, _ := cmd.StderrPipe() // エラーハンドリング省略
@@ -463,21 +575,39 @@ func startServerProcess(name, configDir string) (*exec.Cmd, error) {
 	// 非同期でプロセスを開始します。
 	if err := cmd.Start(); err != nil {
 		// プロセスの開始自体に失敗した場合 (実行ファイルがない、権限不足など)
+		attachServerCgroup(name, cgroup, 0)
 		return nil, fmt.Errorf("プロセス開始失敗: %w", err)
 	}
+	attachServerCgroup(name, cgroup, cmd.Process.Pid)
+
+	// ゲームサーバーの出力は stream フィールドで stdout / stderr を区別して記録
+	outputLog := gameServerLog.withServer(name).with("stream", "stdout")
+	errorLog := gameServerLog.withServer(name).with("stream", "stderr")
+
+	// クラッシュ時に保存するため、出力の末尾を保持します (crash.go)
+	tail := startConsoleTail(name)
+	tail.readers.Add(2)
+	// 出力からプレイヤーの参加・退出を検出し、プレイヤー数を追跡します (players.go)
+	players := startPlayerTracker(name)
 
 	// stdout 監視ゴルーチン
 	go func() {
+		defer tail.readers.Done()
 		scanner := bufio.NewScanner(stdoutPipe)
 		for scanner.Scan() {
-			log.Printf("[GameServer:%s][stdout] %s", name, scanner.Text())
+			outputLog.infof("%s", scanner.Text())
+			tail.add("stdout", scanner.Text())
+			observePlayerLine(name, players, scanner.Text())
 		}
 	}()
 	// stderr 監視ゴルーチン
 	go func() {
+		defer tail.readers.Done()
 		scanner := bufio.NewScanner(stderrPipe)
 		for scanner.Scan() {
-			log.Printf("[GameServer:%s][stderr] %s", name, scanner.Text())
+			errorLog.infof("%s", scanner.Text())
+			tail.add("stderr", scanner.Text())
+			observePlayerLine(name, players, scanner.Text())
 		}
 	}()
 
@@ -489,10 +619,13 @@ func startServerProcess(name, configDir string) (*exec.Cmd, error) {
 // 予期せず終了した場合には再起動処理を試みるゴルーチンです。
 func waitForProcessExit(name string, process *os.Process, assignedPort int) {
 	pid := process.Pid
-	log.Printf("[プロセス管理][監視:%s] サーバー監視開始 (PID: %d, Port: %d)", name, pid, assignedPort)
+	srvLog := processLog.withServer(name)
+	srvLog.infof("サーバー監視開始 (PID: %d, Port: %d)", pid, assignedPort)
 
 	// process.Wait() はプロセスが終了するまでブロックします。
-	_, waitErr := process.Wait() // 終了時のエラー情報 (正常終了ならnil)
+	state, waitErr := process.Wait() // 終了時のエラー情報 (正常終了ならnil)
+	// cgroup で起動した場合は削除し、OOM killer による強制終了かどうかを確認します (cgroup.go)
+	oomKilled, limits := releaseServerCgroup(name, pid)
 
 	// プロセス終了後、管理マップの状態を確認します。
 	procsMutex.Lock() // マップアクセス保護
@@ -503,15 +636,15 @@ func waitForProcessExit(name string, process *os.Process, assignedPort int) {
 	if stillRunning && processInfo.Process.Pid == pid {
 		// 存在する場合 === stopServer などで意図的に停止されていない => 予期せぬ終了(クラッシュ)と判断
 		delete(runningProcs, name) // マップから削除
-		log.Printf("[プロセス管理][監視:%s] 予期せず終了したプロセスをマップから削除 (PID: %d)", name, pid)
+		srvLog.infof("予期せず終了したプロセスをマップから削除 (PID: %d)", pid)
 		shouldRestart = true // 再起動フラグを立てる
 	} else if stillRunning && processInfo.Process.Pid != pid {
         // マップには存在するがPIDが違う === 既に新しいプロセスで再起動されている可能性など (通常発生しにくい)
-        log.Printf("[プロセス管理][監視:%s] 警告: 監視対象のPID(%d)とマップ内のPID(%d)が不一致です。再起動は行いません。", name, pid, processInfo.Process.Pid)
+        srvLog.warnf("監視対象のPID(%d)とマップ内のPID(%d)が不一致です。再起動は行いません。", pid, processInfo.Process.Pid)
 		shouldRestart = false
     } else {
 		// マップに存在しない場合 === 正常な停止処理(stopServer等) または 既に他の要因で削除済み
-		log.Printf("[プロセス管理][監視:%s] プロセスは既にマップから削除されています (PID: %d, 正常停止または処理済み)。再起動は行いません。", name, pid)
+		srvLog.infof("プロセスは既にマップから削除されています (PID: %d, 正常停止または処理済み)。再起動は行いません。", pid)
 		shouldRestart = false
 	}
 	procsMutex.Unlock()
@@ -523,60 +656,123 @@ func waitForProcessExit(name string, process *os.Process, assignedPort int) {
 	// 予期せぬ終了と判断された場合のみ再起動を試みます。
 	// shouldRestart = false
 	if shouldRestart {
-		log.Printf("[プロセス管理][再起動:%s] クラッシュ検出 (PID: %d)。再起動を試みます...", name, pid)
+		srvLog.infof("クラッシュ検出 (PID: %d)。再起動を試みます...", pid)
 
 		// 1. クラッシュ検出イベントをWebSocketで送信します。
+		//    クラッシュダンプとコンソール出力の末尾をクラッシュフォルダにまとめ、その概要をイベントに含めます。
 		var errMsg string
+		exitCode := -1
 		if waitErr != nil {
 			errMsg = waitErr.Error() // エラーがあればメッセージを取得
+		} else if state != nil {
+			exitCode = state.ExitCode()
+			errMsg = state.String() // 例: "exit status 3", "signal: segmentation fault"
 		}
-		sendServerEvent(ServerCrashDetectedPayload{ // websocket_client.go
+		reason := crashReasonCrashed
+		if oomKilled {
+			reason = crashReasonOOMKilled
+			errMsg = fmt.Sprintf("メモリの上限 (%s) を超えたため、OOM killer により強制終了されました (%s)", formatMemoryLimit(limits.MemoryMax), errMsg)
+			srvLog.errorf("%s", errMsg)
+			metricServerOOMKills.inc(name) // metrics.go
+		}
+		report := collectCrashReport(name, pid, processInfo.StartedAt, exitCode, errMsg, reason) // crash.go
+		crashEvent := ServerCrashDetectedPayload{
 			EventType:  "serverCrashDetected",
 			ServerName: name,
 			Pid:        pid,
+			Reason:     reason,
 			Error:      errMsg,
-		})
-
-		// 2. ゲームサーバーの再起動を試みます (startServerProcessを再利用)。
-		configDir := filepath.Join(configBaseDir, name) // 設定ディレクトリはそのまま使います。
-		newCmd, startErr := startServerProcess(name, configDir)
+			ExitCode:   exitCode,
+			CrashDir:   report.Dir,
+			LogTail:    report.LogTail,
+		}
+		if len(report.Dumps) > 0 {
+			crashEvent.DumpPath = report.Dumps[0].Path
+			crashEvent.DumpSize = report.Dumps[0].Size
+			crashEvent.DumpSummary = report.DumpSummary
+		}
+		sendServerEvent(crashEvent) // websocket_client.go
+		metricServerCrashes.inc(name) // metrics.go
 
+		// 2. 再起動ポリシーを確認し、許可されていればゲームサーバーの再起動を試みます (startServerProcessを再利用)。
 		restartSuccess := false // 再起動成功フラグ
 		restartMsg := ""      // 再起動結果メッセージ
 		var newPid int = -1   // 新しいプロセスのPID
 
+		policy := restartPolicyFor(name) // config.go
+		allowed, denyReason := reserveRestartAttempt(name, policy)
+		if allowed && policy.Delay > 0 {
+			srvLog.infof("再起動ポリシーに従い %v 待機します...", policy.Delay)
+			time.Sleep(policy.Delay)
+			// 待機中に別の startServer 要求で起動されていた場合は、二重起動を避けるため再起動しません。
+			procsMutex.Lock()
+			_, startedMeanwhile := runningProcs[name]
+			procsMutex.Unlock()
+			if startedMeanwhile {
+				allowed = false
+				denyReason = "待機中に別の要求でサーバーが起動されたため、再起動を中止しました。"
+			}
+		}
+
+		configDir := filepath.Join(configBaseDir, name) // 設定ディレクトリはそのまま使います。
+		var newCmd *exec.Cmd
+		var startErr error
+		// 起動から管理マップへの登録までの間に、バックアップの復元 (backup.go) が設定ディレクトリを置き換えないようロックします。
+		unlockBackups := lockServerBackups(name)
+		if allowed {
+			newCmd, startErr = startServerProcess(name, configDir)
+		} else {
+			startErr = fmt.Errorf("%s", denyReason)
+		}
+
 		if startErr == nil {
 			// 再起動に成功した場合
 			restartSuccess = true
 			newPid = newCmd.Process.Pid
 			restartMsg = fmt.Sprintf("サーバー '%s' の再起動に成功しました (新しいPID: %d)。", name, newPid)
-			log.Printf("[プロセス管理][再起動:%s] %s", name, restartMsg)
+			srvLog.infof("%s", restartMsg)
 
 			// 新しいプロセス情報を管理マップに登録します (ポートは同じものを再利用)。
 			procsMutex.Lock()
 			runningProcs[name] = RunningProcessInfo{
-				Process: newCmd.Process,
-				Port:    assignedPort, // 同じポートを再利用
+				Process:   newCmd.Process,
+				Port:      assignedPort, // 同じポートを再利用
+				StartedAt: time.Now(),
 			}
 			procsMutex.Unlock()
-			log.Printf("[プロセス管理][再起動:%s] 新プロセス情報をマップに登録 (PID: %d, Port: %d)", name, newPid, assignedPort)
+			unlockBackups()
+			srvLog.infof("新プロセス情報をマップに登録 (PID: %d, Port: %d)", newPid, assignedPort)
+			writeServerStateFile(name, newPid, assignedPort) // server_state.go
 
 			// ★重要: 再起動した新しいプロセスに対しても、終了監視を再帰的に開始します。
 			go waitForProcessExit(name, newCmd.Process, assignedPort)
 
 		} else {
+			unlockBackups()
 			// 再起動に失敗した場合
 			restartSuccess = false
-			restartMsg = fmt.Sprintf("サーバー '%s' の再起動プロセス開始に失敗しました: %v", name, startErr)
-			log.Printf("[プロセス管理][再起動:%s] エラー: %s", name, restartMsg)
+			if allowed {
+				restartMsg = fmt.Sprintf("サーバー '%s' の再起動プロセス開始に失敗しました: %v", name, startErr)
+			} else {
+				restartMsg = fmt.Sprintf("サーバー '%s' は再起動しません: %v", name, startErr)
+			}
+			srvLog.errorf("%s", restartMsg)
 			// 再起動に失敗した場合、クラッシュしたプロセスが掴んでいたポートが解放されないため、
 			// ここで明示的に解放する必要があります。
 			releasePort(assignedPort) // port_manager.go
-			log.Printf("[プロセス管理][再起動:%s] 再起動失敗のためポート %d を解放しました。", name, assignedPort)
+			srvLog.warnf("再起動失敗のためポート %d を解放しました。", assignedPort)
 			// 設定ディレクトリは削除しません（手動での再起動や調査のため）。
 		}
 
-		// 3. 再起動結果イベントをWebSocketで送信します。
+		// 3. 再起動結果をメトリクスに記録し、イベントをWebSocketで送信します。
+		restartResult := "success"
+		if !allowed {
+			restartResult = "skipped" // ポリシーにより再起動しなかった
+		} else if !restartSuccess {
+			restartResult = "failure"
+		}
+		metricServerRestarts.inc(name, restartResult) // metrics.go
+
 		sendServerEvent(ServerRestartResultPayload{ // websocket_client.go
 			EventType:  "serverRestartResult",
 			ServerName: name,
@@ -586,10 +782,37 @@ func waitForProcessExit(name string, process *os.Process, assignedPort int) {
 
 	}
 	// waitForProcessExit ゴルーチンの終了
-	log.Printf("[プロセス管理][監視:%s] 監視ゴルーチン終了 (PID: %d)", name, pid)
+	srvLog.infof("監視ゴルーチン終了 (PID: %d)", pid)
 }
 
 
+// reserveRestartAttempt は、再起動ポリシーに従って再起動が許可されるかを判定し、許可される場合は再起動履歴に記録します。
+// 戻り値: 許可されるかどうか、許可されない場合はその理由
+func reserveRestartAttempt(name string, policy RestartPolicy) (bool, string) {
+	if !policy.Enabled {
+		return false, "再起動ポリシーで自動再起動が無効化されています。"
+	}
+
+	restartHistoryMutex.Lock()
+	defer restartHistoryMutex.Unlock()
+
+	// 期間 (Window) 外の古い履歴を取り除きます。
+	now := time.Now()
+	recent := restartHistory[name][:0]
+	for _, at := range restartHistory[name] {
+		if now.Sub(at) < policy.Window {
+			recent = append(recent, at)
+		}
+	}
+
+	if policy.MaxAttempts > 0 && len(recent) >= policy.MaxAttempts {
+		restartHistory[name] = recent
+		return false, fmt.Sprintf("再起動回数が上限 (%d回/%v) に達しました。", policy.MaxAttempts, policy.Window)
+	}
+	restartHistory[name] = append(recent, now)
+	return true, ""
+}
+
 // logProcessExit は、プロセスの終了コードやエラー情報に基づいて詳細なログを出力します。
 func logProcessExit(pid int, waitErr error) {
 	exitCode := -1 // 不明または取得失敗時のデフォルト値
@@ -612,12 +835,12 @@ func logProcessExit(pid int, waitErr error) {
 			errMsg = fmt.Sprintf("不明なエラーで終了: %v", waitErr)
 		}
 		// エラー終了ログ
-		log.Printf("[プロセス管理] プロセス終了: PID=%d, 状態=%s", pid, errMsg)
+		processLog.infof("プロセス終了: PID=%d, 状態=%s", pid, errMsg)
 	} else {
 		// waitErr が nil の場合は正常終了です。
 		exitCode = 0
 		errMsg = "正常終了"
-		log.Printf("[プロセス管理] プロセス正常終了: PID=%d, ExitCode=%d", pid, exitCode)
+		processLog.infof("プロセス正常終了: PID=%d, ExitCode=%d", pid, exitCode)
 	}
 }
 
@@ -629,14 +852,26 @@ func sendServerEvent(payload interface{}) {
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		// これはプログラム内部のエラー（構造体の定義ミスなど）の可能性が高いです。
-		log.Printf("[プロセス管理][イベント] エラー: ペイロードのJSONエンコードに失敗しました: %v", err)
+		processLog.errorf("ペイロードのJSONエンコードに失敗しました: %v", err)
 		return
 	}
 	// WebSocketメッセージを作成します (Type: "serverEvent")。
 	eventMsg := WsMessage{Type: "serverEvent", Payload: payloadBytes}
 	// イベントの種類をログ出力用に取得します。
 	eventType := getEventType(payload)
-	log.Printf("[プロセス管理][イベント] イベント送信: Type=%s", eventType)
+	processLog.infof("イベント送信: Type=%s", eventType)
+	// 管理APIで参照できるよう、最近のイベントとして記録します (activity.go)。
+	recordServerEvent(eventType, payloadBytes)
+	// 新しい機能のイベントは、その機能に対応していない Bot には送信しません (protocol.go)。
+	// Bot の対応機能がまだ分からない場合は送信待ちキューに保持し、connected の受信後に判定して再送します (outbox.go)。
+	supported, known := botAcceptsEvent(eventType)
+	if !known && enqueueOutbox(eventMsg) {
+		return
+	}
+	if known && !supported {
+		processLog.warnf("Bot が未対応のため、イベントを送信しません: Type=%s", eventType)
+		return
+	}
 	// sendMessage を使って実際に送信します。
 	sendMessage(eventMsg) // websocket_client.go
 }
@@ -650,6 +885,12 @@ func getEventType(payload interface{}) string {
 		return p.EventType // 構造体内の EventType フィールドを返す
 	case ServerRestartResultPayload:
 		return p.EventType // 構造体内の EventType フィールドを返す
+	case ServerScheduleWarningPayload:
+		return p.EventType // schedule.go
+	case ServerScheduledActionPayload:
+		return p.EventType // schedule.go
+	case ServerIdleStoppedPayload:
+		return p.EventType // players.go
 	// 他のイベントタイプがあればここに追加
 	default:
 		// 未知の型の場合は "unknown" を返します。
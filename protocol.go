@@ -0,0 +1,249 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// --- プロトコルバージョンと機能 (capability) のネゴシエーション ---
+// SWSC と Bot を同時に更新しなくても済むよう、互いのプロトコルバージョンと対応機能を交換します。
+// SWSC は syncStatus で自身のバージョンと対応機能を通知し、Bot は "connected" メッセージで同様に通知します。
+// 両者は共通して対応している機能のみを使い、未対応のメッセージタイプには機能エラー
+// (code: "unsupported_message_type") を返すことで、更新途中で新旧のバージョンが混在しても動作を続けられます。
+// 送信するメッセージには、ネゴシエーションしたバージョン (両者が対応している最大のバージョン) を付けます。
+// serverStats や新しいイベントなど、機能の追加で増えたメッセージは、Bot がその機能を通知した場合にのみ送信します。
+// Bot の対応機能が分からない間 (起動直後、connected の受信前、切断中) に発生したイベントは、
+// 送信待ちキュー (outbox.go) に保持し、connected の受信後に対応している場合のみ再送します。
+// Bot が要求する最小バージョンをこの SWSC が満たさない場合は、接続は維持したまま、
+// 新しい機能のメッセージを一切送らずにバージョン 1 の範囲 (従来のメッセージのみ) で動作します。
+
+// protocolVersion は、この SWSC が実装している WebSocket プロトコルのバージョンです。
+// ペイロードに互換性のない変更を加えた場合や、新しいメッセージタイプを追加した場合に上げてください。
+// バージョンフィールドを送らない Bot (バージョン導入前) はバージョン 1 として扱います。
+const protocolVersion = 2
+
+// legacyProtocolVersion は、バージョンを通知しない相手のプロトコルバージョンです。
+const legacyProtocolVersion = 1
+
+// errorCodeUnsupportedMessageType は、未対応のメッセージタイプに対する機能エラーのコードです (ErrorResponsePayload.Code)。
+const errorCodeUnsupportedMessageType = "unsupported_message_type"
+
+// clientCapabilities は、この SWSC が対応している機能の一覧です。
+// 受信できる要求メッセージのタイプと、送受信の振る舞いに関わる機能名を含みます。
+var clientCapabilities = []string{
+	// 受信できる要求
+	"startServer",
+	"stopServer",
+	"reloadConfig",
+	"rotateToken",
+	"listBackups",
+	"restoreBackup",
+	"setSchedules",
+	"listSchedules",
+	// 送信するメッセージ・振る舞い
+	"statusUpdate",
+	"serverEvent",
+	"errorCodes",      // error メッセージの code フィールド
+	"outboxReplay",    // 切断中のメッセージを再接続後に再送する (outbox.go)
+	"heartbeat",       // SWSC から Ping を送信する (heartbeat.go)
+	"messageSigning",  // HMAC 署名付きメッセージの検証と送信 (signing.go)
+	"startFromBackup", // startServer の backupId / saveName でバックアップから復元して起動する (backup.go)
+	"schedules",       // startServer の schedules と、serverScheduleWarning / serverScheduledAction イベント (schedule.go)
+	"idleStop",        // プレイヤーがいないサーバーの自動停止と serverIdleStopped イベント (players.go)
+	"serverStats",     // サーバープロセスのリソース使用量を serverStats メッセージで定期送信する (stats.go)
+}
+
+// eventCapabilities は、serverEvent のイベントタイプと、その送信に必要な機能の対応です。
+// ここにないイベントタイプ (serverCrashDetected など) は、バージョン 1 から存在するため常に送信します。
+var eventCapabilities = map[string]string{
+	"serverScheduleWarning": "schedules",
+	"serverScheduledAction": "schedules",
+	"serverIdleStopped":     "idleStop",
+}
+
+// ConnectedPayload は、Bot から受信する "connected" メッセージのペイロード構造体です。
+type ConnectedPayload struct {
+	// ProtocolVersion は、Bot が実装しているプロトコルバージョンです (省略時はバージョン 1)。
+	ProtocolVersion int `json:"protocolVersion,omitempty"`
+	// MinProtocolVersion は、Bot が SWSC に要求する最小のプロトコルバージョンです (省略時は制限なし)。
+	MinProtocolVersion int `json:"minProtocolVersion,omitempty"`
+	// Capabilities は、Bot が対応している機能の一覧です。
+	Capabilities []string `json:"capabilities,omitempty"`
+	// Message は、Bot からの任意のメッセージです。
+	Message string `json:"message,omitempty"`
+}
+
+// peerProtocol は、接続中の Bot とネゴシエーションしたプロトコルの情報です (管理APIの応答用)。
+type peerProtocol struct {
+	ClientVersion     int      `json:"clientVersion"`     // SWSC のプロトコルバージョン
+	BotVersion        int      `json:"botVersion"`        // Bot のプロトコルバージョン (connected 受信前は 0)
+	NegotiatedVersion int      `json:"negotiatedVersion"` // 両者が対応している最大のバージョン
+	BotCapabilities   []string `json:"botCapabilities"`
+	Compatible        bool     `json:"compatible"` // Bot が要求する最小バージョンを満たしているかどうか
+	Announced         bool     `json:"announced"`  // 現在の接続で connected を受信したかどうか (false の間は Bot の対応機能が不明)
+}
+
+var (
+	// botProtocol は、接続中の Bot とのネゴシエーション結果です。接続と切断のたびにリセットされます。
+	botProtocol = initialBotProtocol()
+	// protocolMutex は、botProtocol を保護するためのミューテックスです。
+	protocolMutex sync.Mutex
+)
+
+// initialBotProtocol は、connected を受信する前のネゴシエーション結果を返します。
+// connected を受信するまでは、Bot をバージョン導入前 (バージョン 1) とみなし、対応機能は不明とします。
+func initialBotProtocol() peerProtocol {
+	return peerProtocol{
+		ClientVersion:     protocolVersion,
+		NegotiatedVersion: legacyProtocolVersion,
+		BotCapabilities:   []string{},
+		Compatible:        true,
+	}
+}
+
+// resetBotProtocol は、接続の開始時と切断時にネゴシエーション結果をリセットします。
+func resetBotProtocol() {
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+	botProtocol = initialBotProtocol()
+}
+
+// handleConnectedMessage は、Bot からの "connected" メッセージを処理し、プロトコルバージョンと機能を記録します。
+// Args:
+//
+//	payload (json.RawMessage): "connected" メッセージのペイロード。
+func handleConnectedMessage(payload json.RawMessage) {
+	var data ConnectedPayload
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &data); err != nil {
+			// 旧バージョンの Bot は任意の内容を送るため、解析できなくても接続は続ける
+			wsLog.infof("サーバーからの接続完了通知を受信 (バージョン情報なし): %s", string(payload))
+		}
+	}
+	botVersion := data.ProtocolVersion
+	if botVersion == 0 {
+		botVersion = legacyProtocolVersion
+	}
+	negotiated := min(botVersion, protocolVersion)
+	compatible := data.MinProtocolVersion <= protocolVersion
+	if !compatible {
+		// Bot の要求を満たせないため、バージョン 1 の範囲で動作する
+		negotiated = legacyProtocolVersion
+	}
+
+	protocolMutex.Lock()
+	botProtocol = peerProtocol{
+		ClientVersion:     protocolVersion,
+		BotVersion:        botVersion,
+		NegotiatedVersion: negotiated,
+		BotCapabilities:   append([]string{}, data.Capabilities...),
+		Compatible:        compatible,
+		Announced:         true,
+	}
+	protocolMutex.Unlock()
+	// 対応機能が分かるまで保留していた送信待ちメッセージを再送する (outbox.go)
+	go resumeOutboxFlush()
+
+	wsLog.infof("サーバーからの接続完了通知を受信: Botのプロトコル=v%d, SWSC=v%d, 使用するバージョン=v%d, Botの機能=%v",
+		botVersion, protocolVersion, negotiated, data.Capabilities)
+	if data.Message != "" {
+		wsLog.infof("Botからのメッセージ: %s", data.Message)
+	}
+	if !compatible {
+		wsLog.warnf("Bot は SWSC のプロトコル v%d 以上を要求しています (この SWSC は v%d)。v%d の範囲で動作し、新しい機能のメッセージは送信しません。SWSC を更新してください。",
+			data.MinProtocolVersion, protocolVersion, legacyProtocolVersion)
+	} else if botVersion > protocolVersion {
+		wsLog.infof("Bot のプロトコルの方が新しいため、v%d の範囲で動作します。", negotiated)
+	}
+}
+
+// botSupports は、接続中の Bot が指定した機能に対応しているかどうかを返します。
+// 新しい機能のメッセージを送る前に確認し、未対応の Bot には送信しないために使用します。
+// Bot の要求する最小バージョンを満たしていない場合と、connected の受信前 (対応機能が不明な間) は false を返します。
+func botSupports(capability string) bool {
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+	return botProtocol.Compatible && slices.Contains(botProtocol.BotCapabilities, capability)
+}
+
+// botAcceptsEvent は、接続中の Bot が指定したイベントタイプの serverEvent を受け取れるかどうかを返します。
+// Returns:
+//
+//	supported (bool): 送信してよい場合は true。
+//	known (bool): Bot の対応機能が分かっている場合は true (connected の受信前と切断中は false)。
+func botAcceptsEvent(eventType string) (supported bool, known bool) {
+	capability, ok := eventCapabilities[eventType]
+	if !ok {
+		return true, true
+	}
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+	if !botProtocol.Announced {
+		return false, false
+	}
+	return botProtocol.Compatible && slices.Contains(botProtocol.BotCapabilities, capability), true
+}
+
+// botAcceptsMessage は、送信待ちキューのメッセージを接続中の Bot に送信できるかどうかを返します (outbox.go)。
+// serverEvent 以外のメッセージは常に送信できます。
+func botAcceptsMessage(msg WsMessage) (supported bool, known bool) {
+	if msg.Type != "serverEvent" {
+		return true, true
+	}
+	var event struct {
+		EventType string `json:"eventType"`
+	}
+	_ = json.Unmarshal(msg.Payload, &event)
+	return botAcceptsEvent(event.EventType)
+}
+
+// botCanReceiveEvent は、イベントを現在接続中の Bot にすぐに届けられるかどうかを返します。
+// 停止したサーバーの設定ファイルなど、イベントでしか Bot に返せない内容を伴う操作の前に確認します。
+func botCanReceiveEvent(eventType string) bool {
+	supported, known := botAcceptsEvent(eventType)
+	return supported && known
+}
+
+// negotiatedProtocolVersion は、送信するメッセージに付けるプロトコルバージョンを返します。
+func negotiatedProtocolVersion() int {
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+	return botProtocol.NegotiatedVersion
+}
+
+// getBotProtocol は、ネゴシエーション結果のコピーを返します。
+func getBotProtocol() peerProtocol {
+	protocolMutex.Lock()
+	defer protocolMutex.Unlock()
+	protocol := botProtocol
+	protocol.BotCapabilities = append([]string{}, botProtocol.BotCapabilities...)
+	return protocol
+}
+
+// sendCapabilityError は、未対応のメッセージタイプを受信した場合に、機能エラーを返します。
+// Bot は code と capabilities を見て、この SWSC が対応している方法で要求し直すことができます。
+// Args:
+//
+//	requestID (string): 受信したメッセージの要求ID。空の場合は応答を送信しません (ログのみ)。
+//	messageType (string): 未対応のメッセージタイプ。
+func sendCapabilityError(requestID string, messageType string) {
+	if requestID == "" {
+		wsLog.warnf("未対応メッセージタイプ (要求IDなしのため応答しません): %s", messageType)
+		return
+	}
+	payload := ErrorResponsePayload{
+		Message:         fmt.Sprintf("未対応のメッセージタイプです: %s (SWSC プロトコル v%d)", messageType, protocolVersion),
+		Code:            errorCodeUnsupportedMessageType,
+		ProtocolVersion: protocolVersion,
+		Capabilities:    clientCapabilities,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		wsLog.errorf("機能エラー応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
+		return
+	}
+	wsLog.warnf("未対応メッセージタイプのため機能エラーを応答: Type=%s, ReqID=%s", messageType, requestID)
+	sendMessage(WsMessage{Type: "error", RequestID: requestID, Payload: payloadBytes})
+}
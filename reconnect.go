@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- WebSocket 再接続の制御 ---
+// Botサーバーが停止した際に全ての SWSC ホストが同じ間隔で再接続を繰り返さないよう、
+// 再接続の待機時間を指数的に延長し (上限 ReconnectMaxDelay)、ランダムな揺らぎ (ジッター) を加えます。
+// 接続が ReconnectStableAfter 以上続いた後に切断された場合は、待機時間を初期値に戻します。
+// 接続失敗の原因 (DNS / TLS / HTTPステータス / タイムアウトなど) を分類し、ログと再接続状態に記録します。
+// 再接続状態はローカル管理API (admin_api.go) から参照されます。
+
+// 接続失敗の分類 (reconnectStatus.LastFailureKind、メトリクスの kind ラベル)
+const (
+	connectFailureDNS          = "dns"          // ホスト名の解決に失敗
+	connectFailureTLS          = "tls"          // TLS ハンドシェイクに失敗 (証明書以外の理由)
+	connectFailureCertificate  = "certificate"  // 証明書の検証に失敗 (再試行しても成功しないため再接続を停止する、tls_config.go)
+	connectFailureHTTPStatus   = "http_status"  // WebSocket へのアップグレードが HTTP ステータスで拒否された
+	connectFailureTimeout      = "timeout"      // 接続・ハンドシェイクがタイムアウトした
+	connectFailureRefused      = "refused"      // 接続が拒否された (Botサーバーが起動していないなど)
+	connectFailureDisconnected = "disconnected" // 接続後に切断された
+	connectFailureHeartbeat    = "heartbeat"    // 接続後、ハートビートの応答がなく切断した (heartbeat.go)
+	connectFailureOther        = "other"        // 上記以外
+)
+
+// connectFailureLabels は、ログに表示する接続失敗の分類名です。
+var connectFailureLabels = map[string]string{
+	connectFailureDNS:          "DNS解決失敗",
+	connectFailureTLS:          "TLSエラー",
+	connectFailureCertificate:  "証明書エラー",
+	connectFailureHTTPStatus:   "HTTPステータスエラー",
+	connectFailureTimeout:      "タイムアウト",
+	connectFailureRefused:      "接続拒否",
+	connectFailureDisconnected: "切断",
+	connectFailureHeartbeat:    "ハートビート応答なし",
+	connectFailureOther:        "その他のエラー",
+}
+
+// wsDialError は、WebSocket サーバーへの接続 (ハンドシェイク) に失敗したことを示すエラーです。
+// ConnectWebSocket が返し、接続後の切断と区別するために使用します。
+type wsDialError struct {
+	StatusCode int // HTTPステータスコード (HTTP応答を受信した場合のみ)
+	Err        error
+}
+
+func (e *wsDialError) Error() string {
+	if e.StatusCode != 0 {
+		return fmt.Sprintf("WebSocket接続エラー (HTTP %d): %v", e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("WebSocket接続エラー: %v", e.Err)
+}
+
+func (e *wsDialError) Unwrap() error {
+	return e.Err
+}
+
+// reconnectStatus は、WebSocket 接続と再接続の状態です。
+type reconnectStatus struct {
+	Connected           bool       `json:"connected"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"` // 安定した接続以降、連続して失敗・切断した回数
+	LastConnectedAt     *time.Time `json:"lastConnectedAt,omitempty"`
+	LastDisconnectedAt  *time.Time `json:"lastDisconnectedAt,omitempty"`
+	LastFailureKind     string     `json:"lastFailureKind,omitempty"` // connectFailure* のいずれか
+	LastError           string     `json:"lastError,omitempty"`
+	NextRetryAt         *time.Time `json:"nextRetryAt,omitempty"` // 再接続待機中のみ
+	NextDelaySeconds    float64    `json:"nextDelaySeconds,omitempty"`
+}
+
+var (
+	// wsReconnectStatus は、現在の WebSocket 接続と再接続の状態です。
+	wsReconnectStatus reconnectStatus
+	// reconnectMutex は、wsReconnectStatus を保護するためのミューテックスです。
+	reconnectMutex sync.Mutex
+)
+
+var (
+	// immediateReconnect は、次回の切断後に待機せずに再接続するかどうかです (トークンの入れ替え時など)。
+	immediateReconnect bool
+	// immediateReconnectMutex は、immediateReconnect を保護するためのミューテックスです。
+	immediateReconnectMutex sync.Mutex
+)
+
+// requestImmediateReconnect は、現在の接続を切断し、待機せずに再接続するよう要求します。
+// 新しい認証トークンで接続し直す場合 (token_store.go) に使用します。
+func requestImmediateReconnect() {
+	immediateReconnectMutex.Lock()
+	immediateReconnect = true
+	immediateReconnectMutex.Unlock()
+
+	connMutex.Lock()
+	currentConn := conn
+	connMutex.Unlock()
+	if currentConn != nil {
+		// 読み取りループがエラーで終了し、ConnectWebSocket が戻る
+		currentConn.Close()
+	}
+}
+
+// consumeImmediateReconnect は、即時の再接続が要求されているかどうかを返し、要求を取り消します。
+// 要求されていた場合は、切断を記録します (連続失敗回数は増やしません)。
+func consumeImmediateReconnect() bool {
+	immediateReconnectMutex.Lock()
+	requested := immediateReconnect
+	immediateReconnect = false
+	immediateReconnectMutex.Unlock()
+	if !requested {
+		return false
+	}
+
+	reconnectMutex.Lock()
+	defer reconnectMutex.Unlock()
+	now := time.Now()
+	if wsReconnectStatus.Connected {
+		wsReconnectStatus.LastDisconnectedAt = &now
+	}
+	wsReconnectStatus.Connected = false
+	return true
+}
+
+// markWebSocketConnected は、WebSocket 接続の確立を記録します。ConnectWebSocket から呼び出されます。
+// 連続失敗回数は、接続が ReconnectStableAfter 以上続いた場合のみ scheduleReconnect でリセットされます。
+func markWebSocketConnected() {
+	reconnectMutex.Lock()
+	defer reconnectMutex.Unlock()
+	now := time.Now()
+	wsReconnectStatus.Connected = true
+	wsReconnectStatus.LastConnectedAt = &now
+	wsReconnectStatus.NextRetryAt = nil
+	wsReconnectStatus.NextDelaySeconds = 0
+}
+
+// scheduleReconnect は、接続失敗・切断を記録し、次の再接続までの待機時間を計算します。
+// Args:
+//
+//	err (error): ConnectWebSocket が返したエラー。
+//
+// Returns:
+//
+//	time.Duration: 再接続までの待機時間 (ジッターを含む)。
+//	string: 接続失敗の分類 (connectFailure* のいずれか)。
+func scheduleReconnect(err error) (time.Duration, string) {
+	kind := classifyConnectError(err)
+
+	reconnectMutex.Lock()
+	defer reconnectMutex.Unlock()
+	now := time.Now()
+	// 前回の接続が十分に続いていた場合は、一時的な切断とみなして待機時間を初期値に戻す
+	if wsReconnectStatus.Connected && wsReconnectStatus.LastConnectedAt != nil &&
+		now.Sub(*wsReconnectStatus.LastConnectedAt) >= ReconnectStableAfter {
+		wsReconnectStatus.ConsecutiveFailures = 0
+	}
+	if wsReconnectStatus.Connected {
+		wsReconnectStatus.LastDisconnectedAt = &now
+	}
+	wsReconnectStatus.Connected = false
+	wsReconnectStatus.ConsecutiveFailures++
+	wsReconnectStatus.LastFailureKind = kind
+	if err != nil {
+		wsReconnectStatus.LastError = err.Error()
+	}
+
+	delay := reconnectBackoff(wsReconnectStatus.ConsecutiveFailures)
+	nextRetryAt := now.Add(delay)
+	wsReconnectStatus.NextRetryAt = &nextRetryAt
+	wsReconnectStatus.NextDelaySeconds = delay.Seconds()
+	metricWebSocketFailures.inc(kind) // metrics.go
+	return delay, kind
+}
+
+// reconnectBackoff は、連続失敗回数から再接続までの待機時間を計算します。
+// 待機時間は ReconnectDelay × 2^(失敗回数-1) (上限 ReconnectMaxDelay) を基準に、
+// 他のホストと再接続のタイミングが揃わないよう、その半分から全体の間でランダムに決めます。
+func reconnectBackoff(failures int) time.Duration {
+	delay := ReconnectMaxDelay
+	if failures < 1 {
+		failures = 1
+	}
+	if shift := failures - 1; shift < 30 { // シフト量が大きすぎる場合のオーバーフローを避ける
+		if backoff := ReconnectDelay << shift; backoff < ReconnectMaxDelay {
+			delay = backoff
+		}
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// classifyConnectError は、ConnectWebSocket が返したエラーを接続失敗の分類に変換します。
+func classifyConnectError(err error) string {
+	if errors.Is(err, errHeartbeatTimeout) {
+		return connectFailureHeartbeat
+	}
+	var dialErr *wsDialError
+	if !errors.As(err, &dialErr) {
+		// 接続確立後の切断 (読み取りエラー、サーバーからの Close など)
+		return connectFailureDisconnected
+	}
+	if dialErr.StatusCode != 0 || errors.Is(err, websocket.ErrBadHandshake) {
+		return connectFailureHTTPStatus
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return connectFailureDNS
+	}
+	if isCertificateError(err) { // tls_config.go
+		return connectFailureCertificate
+	}
+	var (
+		recordErr tls.RecordHeaderError
+		alertErr  tls.AlertError
+	)
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) || strings.Contains(err.Error(), "remote error: tls: ") {
+		return connectFailureTLS
+	}
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+		(errors.As(err, &netErr) && netErr.Timeout()) {
+		return connectFailureTimeout
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return connectFailureRefused
+	}
+	return connectFailureOther
+}
+
+// recordFatalConnectError は、再接続を停止する接続失敗 (証明書エラーなど) を記録します。
+func recordFatalConnectError(err error, kind string) {
+	reconnectMutex.Lock()
+	defer reconnectMutex.Unlock()
+	wsReconnectStatus.Connected = false
+	wsReconnectStatus.ConsecutiveFailures++
+	wsReconnectStatus.LastFailureKind = kind
+	wsReconnectStatus.LastError = err.Error()
+	wsReconnectStatus.NextRetryAt = nil
+	wsReconnectStatus.NextDelaySeconds = 0
+	metricWebSocketFailures.inc(kind) // metrics.go
+}
+
+// getReconnectStatus は、WebSocket 接続と再接続の状態のコピーを返します。
+func getReconnectStatus() reconnectStatus {
+	reconnectMutex.Lock()
+	defer reconnectMutex.Unlock()
+	return wsReconnectStatus
+}
@@ -0,0 +1,688 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- サーバーごとの定期的な再起動・停止・起動 ---
+// 長時間稼働させたサーバーを毎晩再起動する、メンテナンスのために決まった時刻に停止・起動する、といった操作を
+// サーバー構成名ごとの予定 (cron 形式) として登録し、SWSC が自動で実行します。
+//   - 予定は setSchedules 要求、または startServer 要求の schedules で設定し、SCHEDULE_PATH のファイルに保存します
+//     (SWSC を再起動しても予定は残ります)
+//   - 実行の前に、SCHEDULE_WARNINGS (予定ごとに warnings で変更可能) のタイミングで serverScheduleWarning イベントを送信します
+//   - 停止・起動は stopServer / startServer 要求と同じ処理 (handleStopServerProcess / handleStartServerProcess) で行います
+//     再起動は、停止時に作成したバックアップ (backup.go) から復元して起動するため、セーブデータは引き継がれます
+//   - 実行結果は serverScheduledAction イベントで通知します
+// SWSC が停止していた間に過ぎた予定は、後からまとめて実行しません (次の予定時刻から再開します)。
+
+// 予定の操作 (ServerSchedule.Action) です。
+const (
+	scheduleActionRestart = "restart"
+	scheduleActionStop    = "stop"
+	scheduleActionStart   = "start"
+)
+
+// scheduleMaxWait は、予定の確認の最大間隔です。時計の変更や設定の再読み込みがあっても、この間隔で予定を確認し直します。
+const scheduleMaxWait = time.Minute
+
+// scheduledActionTimeout は、予定された停止・起動の応答を待つ最大時間です (ワークショップのダウンロードを含む)。
+const scheduledActionTimeout = 30 * time.Minute
+
+// cronSearchLimit は、次の実行時刻を探す範囲です (2月30日のように実行されない指定を検出するため)。
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// scheduleEntry は、登録された予定1件と、その実行状態です。
+type scheduleEntry struct {
+	schedule ServerSchedule
+	spec     *cronSpec
+	nextRun  time.Time
+	warned   map[time.Duration]bool // 次の実行に対して予告済みのタイミング
+}
+
+var (
+	// serverSchedules は、サーバー構成名ごとの予定です。
+	serverSchedules = make(map[string][]*scheduleEntry)
+	// scheduledActionsActive は、予定された操作を実行中のサーバー構成名です (同じサーバーの操作の重複を防ぎます)。
+	scheduledActionsActive = make(map[string]bool)
+	// schedulesMutex は、serverSchedules と scheduledActionsActive を保護するためのミューテックスです。
+	schedulesMutex sync.Mutex
+	// scheduleWake は、予定が変更されたときにスケジューラーに確認し直させるためのチャネルです。
+	scheduleWake = make(chan struct{}, 1)
+)
+
+// startScheduler は、保存された予定を読み込み、予定を実行するゴルーチンを開始します。常駐モードの起動時に main から呼び出されます。
+func startScheduler() {
+	loadSchedules()
+	go func() {
+		for {
+			wait := runDueSchedules(time.Now())
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-scheduleWake:
+				timer.Stop()
+			}
+		}
+	}()
+}
+
+// wakeScheduler は、予定の変更をスケジューラーに通知します。
+func wakeScheduler() {
+	select {
+	case scheduleWake <- struct{}{}:
+	default: // 既に通知済み
+	}
+}
+
+// runDueSchedules は、予告・実行の時刻になった予定を処理し、次に確認するまでの待ち時間を返します。
+func runDueSchedules(now time.Time) time.Duration {
+	type warning struct {
+		name  string
+		entry ServerSchedule
+		at    time.Time
+	}
+	var warnings []warning
+	var actions []ServerSchedule
+	var actionNames []string
+	wait := scheduleMaxWait
+	defaultLeads := currentConfig().Schedule.Warnings
+
+	schedulesMutex.Lock()
+	for name, entries := range serverSchedules {
+		for _, entry := range entries {
+			if !now.Before(entry.nextRun) {
+				actions = append(actions, entry.schedule)
+				actionNames = append(actionNames, name)
+				entry.nextRun = entry.spec.next(now)
+				entry.warned = make(map[time.Duration]bool)
+			}
+			// 予告のタイミングを過ぎたものは、最も実行に近いもの1件だけを送信する
+			// (予定を登録した直後や、SWSC の停止中にタイミングを過ぎた場合にまとめて送らないため)
+			due := false
+			for _, lead := range scheduleLeadTimes(entry.schedule, defaultLeads) {
+				warnAt := entry.nextRun.Add(-lead)
+				if entry.warned[lead] {
+					continue
+				}
+				if now.Before(warnAt) {
+					if warnAt.Sub(now) < wait {
+						wait = warnAt.Sub(now)
+					}
+					continue
+				}
+				entry.warned[lead] = true
+				due = true
+			}
+			if due {
+				warnings = append(warnings, warning{name: name, entry: entry.schedule, at: entry.nextRun})
+			}
+			if entry.nextRun.Sub(now) < wait {
+				wait = entry.nextRun.Sub(now)
+			}
+		}
+	}
+	schedulesMutex.Unlock()
+
+	for _, w := range warnings {
+		sendScheduleWarning(w.name, w.entry, w.at, now)
+	}
+	for i, schedule := range actions {
+		go runScheduledAction(actionNames[i], schedule)
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	return wait
+}
+
+// scheduleLeadTimes は、予定の予告のタイミングを返します (warnings が省略された場合は SCHEDULE_WARNINGS)。
+// 予定の登録時に検証済みのため、解析できない値は無視します。
+func scheduleLeadTimes(schedule ServerSchedule, defaults []time.Duration) []time.Duration {
+	if schedule.Warnings == nil {
+		return defaults
+	}
+	leads := make([]time.Duration, 0, len(schedule.Warnings))
+	for _, value := range schedule.Warnings {
+		if lead, err := parsePositiveDuration(value); err == nil && lead > 0 {
+			leads = append(leads, lead)
+		}
+	}
+	return leads
+}
+
+// sendScheduleWarning は、予定された操作の予告イベントを送信します。
+func sendScheduleWarning(name string, schedule ServerSchedule, at time.Time, now time.Time) {
+	remaining := at.Sub(now).Round(time.Second)
+	message := fmt.Sprintf("サーバー '%s' は %s 後 (%s) に%sします。", name, formatScheduleDuration(remaining), at.Format("15:04"), scheduleActionLabel(schedule.Action))
+	scheduleLog.withServer(name).infof("予告: %s", message)
+	sendServerEvent(ServerScheduleWarningPayload{ // process_manager.go
+		EventType:   "serverScheduleWarning",
+		ServerName:  name,
+		ScheduleID:  schedule.ID,
+		Action:      schedule.Action,
+		ScheduledAt: at,
+		SecondsLeft: int(remaining / time.Second),
+		Message:     message,
+	})
+}
+
+// runScheduledAction は、予定された操作を実行し、結果を serverScheduledAction イベントで通知します。
+func runScheduledAction(name string, schedule ServerSchedule) {
+	srvLog := scheduleLog.withServer(name)
+	schedulesMutex.Lock()
+	if scheduledActionsActive[name] {
+		schedulesMutex.Unlock()
+		srvLog.warnf("前回の予定された操作が実行中のため、予定 '%s' (%s) を実行しません。", schedule.ID, schedule.Action)
+		sendScheduledActionResult(name, schedule, "skipped", "前回の予定された操作が実行中のため実行しませんでした。", ResponsePayload{})
+		return
+	}
+	scheduledActionsActive[name] = true
+	schedulesMutex.Unlock()
+	defer func() {
+		schedulesMutex.Lock()
+		delete(scheduledActionsActive, name)
+		schedulesMutex.Unlock()
+	}()
+
+	srvLog.infof("予定 '%s' の%sを開始します (cron: %s)", schedule.ID, scheduleActionLabel(schedule.Action), schedule.Cron)
+	_, running := getRunningProcesses()[name] // process_manager.go
+	var message string
+	var err error
+	// stopped は、停止した場合の stopServer の応答です (設定ファイルとバックアップIDをイベントで Bot に返すため)
+	var stopped ResponsePayload
+	result := "success"
+	switch {
+	case schedule.Action == scheduleActionStart && running:
+		result, message = "skipped", fmt.Sprintf("サーバー '%s' は既に実行中のため起動しませんでした。", name)
+	case schedule.Action != scheduleActionStart && !running:
+		result, message = "skipped", fmt.Sprintf("サーバー '%s' は実行されていないため%sしませんでした。", name, scheduleActionLabel(schedule.Action))
+	case schedule.Action != scheduleActionStart && !botCanReceiveEvent("serverScheduledAction"):
+		// 停止したサーバーの設定ファイルは serverScheduledAction でしか Bot に返せないため、Bot が受け取れない間は停止しない (protocol.go)
+		result, message = "skipped", fmt.Sprintf("Bot が結果を受信できない (切断中または未対応) ため、サーバー '%s' を%sしませんでした。", name, scheduleActionLabel(schedule.Action))
+	case schedule.Action == scheduleActionStop:
+		if stopped, err = runScheduledRequest("stopServer", handleStopServerProcess, StopServerPayload{Name: name, Confirmed: true}); err == nil {
+			message = stopped.Message
+		}
+	case schedule.Action == scheduleActionStart:
+		payload := StartServerPayload{Name: name, Config: schedule.Config}
+		if payload.Config == "" {
+			payload.SaveName = name // 最新のバックアップから復元して起動する
+		}
+		var started ResponsePayload
+		if started, err = runScheduledRequest("startServer", handleStartServerProcess, payload); err == nil {
+			message = started.Message
+		}
+	case schedule.Action == scheduleActionRestart:
+		stopped, message, err = restartScheduledServer(name)
+	}
+	if err != nil {
+		result, message = "failure", err.Error()
+	}
+
+	switch result {
+	case "failure":
+		srvLog.errorf("予定 '%s' の%sに失敗しました: %s", schedule.ID, scheduleActionLabel(schedule.Action), message)
+	default:
+		srvLog.infof("予定 '%s' の%s: %s (%s)", schedule.ID, scheduleActionLabel(schedule.Action), result, message)
+	}
+	sendScheduledActionResult(name, schedule, result, message, stopped)
+}
+
+// restartScheduledServer は、サーバーを停止してから、停止時に作成したバックアップから復元して起動します。
+// バックアップが無効な場合は、停止時に読み込んだ設定ファイルで起動します (セーブデータは引き継がれません)。
+// 停止に成功した場合は、起動に失敗しても Bot に設定ファイルを返せるよう、stopServer の応答を返します。
+func restartScheduledServer(name string) (ResponsePayload, string, error) {
+	stopped, err := runScheduledRequest("stopServer", handleStopServerProcess, StopServerPayload{Name: name, Confirmed: true})
+	if err != nil {
+		return ResponsePayload{}, "", fmt.Errorf("停止に失敗しました: %w", err)
+	}
+	payload := StartServerPayload{Name: name, BackupID: stopped.BackupID}
+	if stopped.BackupID == "" {
+		if stopped.Config == "" {
+			return stopped, "", fmt.Errorf("サーバーを停止しましたが、バックアップも設定ファイルもないため起動できません: %s", stopped.Message)
+		}
+		payload.Config = stopped.Config
+	}
+	started, err := runScheduledRequest("startServer", handleStartServerProcess, payload)
+	if err != nil {
+		return stopped, "", fmt.Errorf("サーバーを停止しましたが、起動に失敗しました: %w", err)
+	}
+	return stopped, started.Message, nil
+}
+
+// runScheduledRequest は、要求ハンドラをローカル要求として実行し (local_requests.go)、成功応答のペイロードを返します。
+func runScheduledRequest(requestType string, handler func(string, json.RawMessage), payload interface{}) (ResponsePayload, error) {
+	requestID := newLocalRequestID("schedule")
+	final, err := runLocalRequest(requestID, requestType, handler, payload, scheduledActionTimeout, nil)
+	if err != nil {
+		return ResponsePayload{}, err
+	}
+	var response ResponsePayload
+	_ = json.Unmarshal(final.Payload, &response)
+	if final.Type == "error" {
+		var errorPayload ErrorResponsePayload
+		_ = json.Unmarshal(final.Payload, &errorPayload)
+		return response, errors.New(errorPayload.Message)
+	}
+	if !response.Success {
+		return response, errors.New(response.Message)
+	}
+	return response, nil
+}
+
+// sendScheduledActionResult は、予定された操作の実行結果をイベントで通知し、メトリクスに記録します。
+// stopped は、停止・再起動でサーバーを停止した場合の stopServer の応答です (停止していない場合は空)。
+func sendScheduledActionResult(name string, schedule ServerSchedule, result string, message string, stopped ResponsePayload) {
+	metricScheduledActions.inc(schedule.Action, result) // metrics.go
+	sendServerEvent(ServerScheduledActionPayload{       // process_manager.go
+		EventType:    "serverScheduledAction",
+		ServerName:   name,
+		ScheduleID:   schedule.ID,
+		Action:       schedule.Action,
+		Result:       result,
+		Message:      message,
+		Config:       stopped.Config,
+		UnknownPaths: stopped.UnknownPaths,
+		BackupID:     stopped.BackupID,
+	})
+}
+
+// scheduleActionLabel は、操作の表示名を返します。
+func scheduleActionLabel(action string) string {
+	switch action {
+	case scheduleActionRestart:
+		return "再起動"
+	case scheduleActionStop:
+		return "停止"
+	case scheduleActionStart:
+		return "起動"
+	}
+	return action
+}
+
+// --- 予定の登録と保存 ---
+
+// validateSchedules は、予定の一覧を検証し、省略された ID を割り当てた一覧と解析済みの cron 式を返します。
+func validateSchedules(schedules []ServerSchedule) ([]ServerSchedule, []*cronSpec, error) {
+	validated := make([]ServerSchedule, 0, len(schedules))
+	specs := make([]*cronSpec, 0, len(schedules))
+	seen := make(map[string]bool)
+	for i, schedule := range schedules {
+		switch schedule.Action {
+		case scheduleActionRestart, scheduleActionStop, scheduleActionStart:
+		default:
+			return nil, nil, fmt.Errorf("予定 %d の action '%s' が不正です (restart / stop / start)", i+1, schedule.Action)
+		}
+		if schedule.Config != "" && schedule.Action != scheduleActionStart {
+			return nil, nil, fmt.Errorf("予定 %d: config は action が start の場合のみ指定できます", i+1)
+		}
+		spec, err := parseCronSpec(schedule.Cron)
+		if err != nil {
+			return nil, nil, fmt.Errorf("予定 %d の cron '%s' が不正です: %w", i+1, schedule.Cron, err)
+		}
+		for _, value := range schedule.Warnings {
+			if lead, err := parsePositiveDuration(value); err != nil || lead == 0 {
+				return nil, nil, fmt.Errorf("予定 %d の warnings '%s' が不正です (例: 10m)", i+1, value)
+			}
+		}
+		if schedule.ID == "" {
+			for n := 1; schedule.ID == "" || seen[schedule.ID]; n++ {
+				schedule.ID = fmt.Sprintf("%s-%d", schedule.Action, n)
+			}
+		}
+		if seen[schedule.ID] {
+			return nil, nil, fmt.Errorf("予定の ID '%s' が重複しています", schedule.ID)
+		}
+		seen[schedule.ID] = true
+		validated = append(validated, schedule)
+		specs = append(specs, spec)
+	}
+	return validated, specs, nil
+}
+
+// setServerSchedules は、サーバー構成名の予定をすべて置き換え、ファイルに保存します (空の一覧の場合は全削除)。
+// Returns:
+//
+//	[]ScheduleInfo: 置き換えた後の予定の一覧。
+//	error: 予定が不正な場合のエラー (その場合は何も変更しません)。
+func setServerSchedules(name string, schedules []ServerSchedule) ([]ScheduleInfo, error) {
+	validated, specs, err := validateSchedules(schedules)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	entries := make([]*scheduleEntry, 0, len(validated))
+	for i, schedule := range validated {
+		entries = append(entries, &scheduleEntry{schedule: schedule, spec: specs[i], nextRun: specs[i].next(now), warned: make(map[time.Duration]bool)})
+	}
+
+	schedulesMutex.Lock()
+	if len(entries) == 0 {
+		delete(serverSchedules, name)
+	} else {
+		serverSchedules[name] = entries
+	}
+	saveSchedulesLocked()
+	schedulesMutex.Unlock()
+	wakeScheduler()
+
+	scheduleLog.withServer(name).infof("予定を %d 件設定しました", len(entries))
+	return listSchedules(name), nil
+}
+
+// listSchedules は、予定の一覧を次の実行時刻の早い順に返します (name が空の場合は全サーバー構成名)。
+func listSchedules(name string) []ScheduleInfo {
+	defaultLeads := currentConfig().Schedule.Warnings
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	infos := []ScheduleInfo{}
+	for serverName, entries := range serverSchedules {
+		if name != "" && serverName != name {
+			continue
+		}
+		for _, entry := range entries {
+			warnings := []string{}
+			for _, lead := range scheduleLeadTimes(entry.schedule, defaultLeads) {
+				warnings = append(warnings, formatScheduleDuration(lead))
+			}
+			infos = append(infos, ScheduleInfo{
+				ServerName: serverName,
+				ID:         entry.schedule.ID,
+				Action:     entry.schedule.Action,
+				Cron:       entry.schedule.Cron,
+				Warnings:   warnings,
+				HasConfig:  entry.schedule.Config != "",
+				NextRun:    entry.nextRun,
+			})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].NextRun.Before(infos[j].NextRun) })
+	return infos
+}
+
+// loadSchedules は、SCHEDULE_PATH に保存された予定を読み込みます。ファイルが存在しない場合は何もしません。
+// 不正な予定はログに記録して読み飛ばします。
+func loadSchedules() {
+	path := currentConfig().Schedule.Path
+	if path == "" {
+		return
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		scheduleLog.warnf("予定の読み込みに失敗しました (%s): %v", path, err)
+		return
+	}
+	var saved map[string][]ServerSchedule
+	if err := json.Unmarshal(content, &saved); err != nil {
+		scheduleLog.warnf("予定の解析に失敗しました (%s): %v", path, err)
+		return
+	}
+
+	now := time.Now()
+	count := 0
+	schedulesMutex.Lock()
+	defer schedulesMutex.Unlock()
+	for name, schedules := range saved {
+		validated, specs, err := validateSchedules(schedules)
+		if err != nil {
+			scheduleLog.withServer(name).warnf("保存された予定が不正なため読み込みません: %v", err)
+			continue
+		}
+		entries := make([]*scheduleEntry, 0, len(validated))
+		for i, schedule := range validated {
+			entries = append(entries, &scheduleEntry{schedule: schedule, spec: specs[i], nextRun: specs[i].next(now), warned: make(map[time.Duration]bool)})
+		}
+		if len(entries) > 0 {
+			serverSchedules[name] = entries
+			count += len(entries)
+		}
+	}
+	scheduleLog.infof("保存された予定を %d 件読み込みました (%s)", count, path)
+}
+
+// saveSchedulesLocked は、予定をファイルに保存します。schedulesMutex をロックした状態で呼び出してください。
+// 一時ファイルに書き込んでから置き換えるため、書き込み途中で終了しても以前の内容は失われません。
+func saveSchedulesLocked() {
+	path := currentConfig().Schedule.Path
+	if path == "" {
+		return
+	}
+	saved := make(map[string][]ServerSchedule, len(serverSchedules))
+	for name, entries := range serverSchedules {
+		for _, entry := range entries {
+			saved[name] = append(saved[name], entry.schedule)
+		}
+	}
+	content, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		scheduleLog.warnf("予定のエンコードに失敗しました: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		scheduleLog.warnf("予定の保存先ディレクトリを作成できません (%s): %v", filepath.Dir(path), err)
+		return
+	}
+	// 起動用の設定ファイルを含むため、所有者のみ読み書きできるようにする
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0600); err != nil {
+		scheduleLog.warnf("予定の書き込みに失敗しました (%s): %v", tempPath, err)
+		return
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		scheduleLog.warnf("予定の保存に失敗しました (%s): %v", path, err)
+	}
+}
+
+// formatScheduleDuration は、時間を "1h30m" や "10m" のような短い表記にします。
+func formatScheduleDuration(d time.Duration) string {
+	text := d.String()
+	if strings.HasSuffix(text, "m0s") {
+		text = strings.TrimSuffix(text, "0s")
+	}
+	if strings.HasSuffix(text, "h0m") {
+		text = strings.TrimSuffix(text, "0m")
+	}
+	return text
+}
+
+// formatScheduleSettings は、予定の設定をログ表示用の文字列にします。
+func formatScheduleSettings(settings ScheduleSettings) string {
+	path := settings.Path
+	if path == "" {
+		path = "保存しない"
+	}
+	warnings := make([]string, 0, len(settings.Warnings))
+	for _, lead := range settings.Warnings {
+		warnings = append(warnings, formatScheduleDuration(lead))
+	}
+	if len(warnings) == 0 {
+		warnings = append(warnings, "なし")
+	}
+	return fmt.Sprintf("保存先=%s, 予告=%s", path, strings.Join(warnings, ","))
+}
+
+// --- setSchedules / listSchedules 要求 ---
+
+// handleSetSchedulesRequest は、Bot からの setSchedules 要求を処理し、サーバーの予定を置き換えます。
+// Args:
+//
+//	requestID (string): 要求ID。
+//	payload (json.RawMessage): SetSchedulesPayload の JSON。
+func handleSetSchedulesRequest(requestID string, payload json.RawMessage) {
+	reqLog := scheduleLog.withRequest(requestID)
+	var data SetSchedulesPayload
+	if err := json.Unmarshal(payload, &data); err != nil {
+		reqLog.errorf("setSchedulesペイロードのデコード失敗: %v", err)
+		sendErrorResponse(requestID, fmt.Sprintf("不正な予定設定要求ペイロード: %v", err)) // websocket_client.go
+		return
+	}
+	reqLog = reqLog.withServer(data.Name)
+	if err := validateBackupPathComponent("構成名", data.Name); err != nil { // backup.go
+		reqLog.errorf("%v", err)
+		sendResponse(requestID, false, err.Error(), "")
+		return
+	}
+	schedules, err := setServerSchedules(data.Name, data.Schedules)
+	if err != nil {
+		reqLog.errorf("予定の設定に失敗しました: %v", err)
+		sendResponse(requestID, false, fmt.Sprintf("予定の設定に失敗しました: %v", err), "")
+		return
+	}
+	sendScheduleListResponse(requestID, fmt.Sprintf("サーバー '%s' の予定を %d 件設定しました。", data.Name, len(schedules)), schedules) // websocket_client.go
+}
+
+// handleListSchedulesRequest は、Bot からの listSchedules 要求を処理し、予定の一覧を応答します。
+// Args:
+//
+//	requestID (string): 要求ID。
+//	payload (json.RawMessage): ListSchedulesPayload の JSON (name を省略すると全サーバー構成名)。
+func handleListSchedulesRequest(requestID string, payload json.RawMessage) {
+	reqLog := scheduleLog.withRequest(requestID)
+	var data ListSchedulesPayload
+	if len(payload) > 0 && string(payload) != "null" {
+		if err := json.Unmarshal(payload, &data); err != nil {
+			reqLog.errorf("listSchedulesペイロードのデコード失敗: %v", err)
+			sendErrorResponse(requestID, fmt.Sprintf("不正な予定一覧要求ペイロード: %v", err))
+			return
+		}
+	}
+	schedules := listSchedules(data.Name)
+	reqLog.infof("予定の一覧を応答します (%d 件)", len(schedules))
+	sendScheduleListResponse(requestID, fmt.Sprintf("予定は %d 件あります。", len(schedules)), schedules)
+}
+
+// --- cron 形式の解析 ---
+
+// cronSpec は、解析済みの cron 式 (分 時 日 月 曜日) です。各フィールドは一致する値のビット集合です。
+type cronSpec struct {
+	minutes, hours, days, months, weekdays uint64
+	// daysAny と weekdaysAny は、日・曜日が "*" で指定されたかどうかです。
+	// 両方とも制限されている場合は、cron と同じくどちらかに一致すれば実行します。
+	daysAny, weekdaysAny bool
+}
+
+// cronMacros は、よく使う cron 式の別名です。
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// parseCronSpec は、cron 形式の文字列 (例: "0 4 * * *"、"*/15 * * * *"、"30 3 * * 1-5") を解析します。
+func parseCronSpec(expr string) (*cronSpec, error) {
+	expr = strings.TrimSpace(expr)
+	if macro, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = macro
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("5つのフィールド (分 時 日 月 曜日) が必要です")
+	}
+	spec := &cronSpec{daysAny: fields[2] == "*", weekdaysAny: fields[4] == "*"}
+	var err error
+	if spec.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("分: %w", err)
+	}
+	if spec.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("時: %w", err)
+	}
+	if spec.days, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("日: %w", err)
+	}
+	if spec.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("月: %w", err)
+	}
+	if spec.weekdays, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("曜日: %w", err)
+	}
+	if spec.weekdays&(1<<7) != 0 {
+		spec.weekdays |= 1 // 7 は日曜日 (0) と同じ
+	}
+	if spec.next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("実行される日時がありません")
+	}
+	return spec, nil
+}
+
+// parseCronField は、cron 式の1フィールド ("*"、"5"、"1-5"、"*/15"、"0-30/10"、"1,15" の組み合わせ) を解析します。
+func parseCronField(field string, minValue int, maxValue int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			rangePart = part[:i]
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("間隔 '%s' が不正です", part[i+1:])
+			}
+		}
+		low, high := minValue, maxValue
+		if rangePart != "*" {
+			bounds := strings.SplitN(rangePart, "-", 2)
+			var err error
+			if low, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("値 '%s' が不正です", bounds[0])
+			}
+			high = low
+			if len(bounds) == 2 {
+				if high, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("値 '%s' が不正です", bounds[1])
+				}
+			} else if step > 1 {
+				high = maxValue // "5/15" は "5-最大値/15" と同じ
+			}
+			if low < minValue || high > maxValue || low > high {
+				return 0, fmt.Errorf("'%s' は %d から %d の範囲で指定してください", rangePart, minValue, maxValue)
+			}
+		}
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay は、日付が日・曜日の指定に一致するかどうかを返します。
+func (spec *cronSpec) matchesDay(t time.Time) bool {
+	dayMatch := spec.days&(1<<uint(t.Day())) != 0
+	weekdayMatch := spec.weekdays&(1<<uint(t.Weekday())) != 0
+	if spec.daysAny || spec.weekdaysAny {
+		return dayMatch && weekdayMatch
+	}
+	return dayMatch || weekdayMatch
+}
+
+// next は、after より後で cron 式に一致する最初の時刻 (分単位) を返します。見つからない場合はゼロ値を返します。
+func (spec *cronSpec) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(cronSearchLimit)
+	for t.Before(limit) {
+		switch {
+		case spec.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !spec.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case spec.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case spec.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
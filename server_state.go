@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// --- サーバー状態ファイル ---
+// 起動したサーバーのPIDとポート番号を、設定ディレクトリ (./config/<構成名>/) 内の状態ファイルに記録します。
+// 別プロセスで動く CLI (swsc stop / swsc list) からサーバーを操作したり、
+// 前回の SWSC が残したサーバーのポートと衝突しないようにするために使用します。
+// 状態ファイルは停止時に設定ディレクトリごと削除されます。
+
+// serverStateFileName は、設定ディレクトリ内の状態ファイル名です。
+const serverStateFileName = "swsc_state.json"
+
+// サーバーを管理している SWSC の動作モード (serverState.OwnerMode)
+const (
+	ownerModeDaemon = "daemon" // WebSocketクライアントとして常駐している SWSC
+	ownerModeCLI    = "cli"    // swsc start でフォアグラウンド起動した SWSC
+)
+
+// runMode は、このプロセスの動作モード (ownerModeDaemon / ownerModeCLI) です。main で設定されます。
+var runMode = ownerModeDaemon
+
+// serverState は、状態ファイルに記録するサーバーの情報です。
+type serverState struct {
+	Name      string    `json:"name"`      // サーバー構成名
+	Pid       int       `json:"pid"`       // ゲームサーバープロセスのPID
+	Port      int       `json:"port"`      // 割り当てたポート番号
+	OwnerPid  int       `json:"ownerPid"`  // サーバーを起動・監視している SWSC のPID
+	OwnerMode string    `json:"ownerMode"` // サーバーを起動・監視している SWSC の動作モード
+	StartedAt time.Time `json:"startedAt"` // プロセスを起動した時刻
+}
+
+// writeServerStateFile は、起動したサーバーの状態ファイルを書き込みます。
+// 書き込み失敗はサーバーの動作に影響しないため、ログに記録するのみとします。
+func writeServerStateFile(name string, pid int, port int) {
+	state := serverState{
+		Name:      name,
+		Pid:       pid,
+		Port:      port,
+		OwnerPid:  os.Getpid(),
+		OwnerMode: runMode,
+		StartedAt: time.Now(),
+	}
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		processLog.warnf("状態ファイルのエンコードに失敗しました ('%s'): %v", name, err)
+		return
+	}
+	statePath := filepath.Join(configBaseDir, name, serverStateFileName)
+	if err := os.WriteFile(statePath, content, 0644); err != nil {
+		processLog.warnf("状態ファイルの書き込みに失敗しました (%s): %v", statePath, err)
+	}
+}
+
+// readServerStateFile は、指定された構成名の状態ファイルを読み込みます。
+func readServerStateFile(name string) (*serverState, error) {
+	statePath := filepath.Join(configBaseDir, name, serverStateFileName)
+	content, err := os.ReadFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("状態ファイル '%s' の読み込み失敗: %w", statePath, err)
+	}
+	var state serverState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("状態ファイル '%s' の解析失敗: %w", statePath, err)
+	}
+	return &state, nil
+}
+
+// listServerStateFiles は、設定ベースディレクトリ内の全ての状態ファイルを読み込みます。
+// 状態ファイルがないディレクトリや読み込めない状態ファイルは無視します。
+func listServerStateFiles() []serverState {
+	entries, err := os.ReadDir(configBaseDir)
+	if err != nil {
+		return nil // 設定ベースディレクトリがまだ存在しない場合など
+	}
+	var states []serverState
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		state, err := readServerStateFile(entry.Name())
+		if err != nil {
+			continue
+		}
+		states = append(states, *state)
+	}
+	return states
+}
+
+// isProcessAlive は、指定されたPIDのプロセスが存在するかどうかを判定します。
+func isProcessAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// Windows では FindProcess がプロセスを開けた時点で存在している
+		return true
+	}
+	// Unix 系ではシグナル 0 の送信可否で存在を確認する
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+// reserveOrphanedServerPorts は、前回の SWSC や CLI が起動してまだ動いているサーバーのポートを使用中にマークします。
+// 別プロセスが管理しているサーバーとポートが衝突するのを防ぐため、プロセスマネージャーの初期化時に呼び出されます。
+func reserveOrphanedServerPorts() {
+	for _, state := range listServerStateFiles() {
+		if state.OwnerPid == os.Getpid() || !isProcessAlive(state.Pid) {
+			continue
+		}
+		if assignPort(state.Port) { // port_manager.go
+			processLog.infof("他のプロセスが管理するサーバー '%s' (PID: %d) が使用中のポート %d を予約しました。", state.Name, state.Pid, state.Port)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- メッセージ署名とリプレイ防止 ---
+// 接続時の Bearer トークンだけでは、WebSocket の経路に割り込んだプロセスや誤動作したプロキシが
+// stopServer などの要求を送れてしまいます。SIGNING_KEY を設定すると、各メッセージに
+// HMAC-SHA256 署名・タイムスタンプ・ノンスを付けて送受信し、Bot からの要求 (startServer / stopServer / reloadConfig / rotateToken / restoreBackup) は
+// 処理を実行する前に署名を検証します。次の要求は区別できるエラーコードで拒否します。
+//   - signature_missing:  署名・タイムスタンプ・ノンスのいずれかがない
+//   - signature_invalid:  署名が一致しない (鍵が違う、または改ざんされている)
+//   - signature_stale:    タイムスタンプが現在時刻から SIGNING_MAX_SKEW 以上ずれている
+//   - signature_replayed: 有効期間内に同じノンスのメッセージを既に受信している
+//
+// 署名対象は次の文字列です (各項目を改行で連結し、ペイロードは受信したバイト列の SHA-256 の16進数)。
+//
+//	type \n requestId \n timestamp (UNIX ミリ秒) \n nonce \n hex(sha256(payload))
+//
+// 署名は HMAC-SHA256(SIGNING_KEY, 署名対象) の16進数です。SWSC が送信するメッセージにも同じ形式で署名します。
+
+// 署名検証エラーのコード (ErrorResponsePayload.Code)
+const (
+	errorCodeSignatureMissing  = "signature_missing"
+	errorCodeSignatureInvalid  = "signature_invalid"
+	errorCodeSignatureStale    = "signature_stale"
+	errorCodeSignatureReplayed = "signature_replayed"
+)
+
+// signatureError は、署名検証に失敗した理由を示すエラーです。
+type signatureError struct {
+	Code    string // エラーコード (errorCodeSignature*)
+	Message string
+}
+
+func (e *signatureError) Error() string {
+	return e.Message
+}
+
+// signedRequestTypes は、SIGNING_KEY が設定されている場合に署名の検証が必要な要求のタイプです。
+var signedRequestTypes = map[string]bool{
+	"startServer":   true,
+	"stopServer":    true,
+	"reloadConfig":  true,
+	"rotateToken":   true,
+	"restoreBackup": true,
+	"setSchedules":  true,
+}
+
+var (
+	// seenNonces は、有効期間内に受信したノンスと、記録を破棄してよい時刻です (キー: ノンス)。
+	seenNonces = make(map[string]time.Time)
+	// nonceMutex は、seenNonces を保護するためのミューテックスです。
+	nonceMutex sync.Mutex
+)
+
+// signingEnabled は、メッセージ署名が有効かどうか (SIGNING_KEY が設定されているかどうか) を返します。
+func signingEnabled() bool {
+	return currentConfig().Signing.Key != ""
+}
+
+// messageSignature は、メッセージの署名を計算します。
+// Args:
+//
+//	key (string): 署名鍵 (SIGNING_KEY)。
+//	msg (WsMessage): 署名するメッセージ (Timestamp と Nonce を設定済みであること)。
+//
+// Returns:
+//
+//	string: HMAC-SHA256 署名の16進数。
+func messageSignature(key string, msg WsMessage) string {
+	payloadHash := sha256.Sum256(msg.Payload)
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s\n%s", msg.Type, msg.RequestID, msg.Timestamp, msg.Nonce, hex.EncodeToString(payloadHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signOutgoingMessage は、SIGNING_KEY が設定されている場合に、送信するメッセージにタイムスタンプ・ノンス・署名を設定します。
+// writeWsMessage から送信の直前に呼び出されます (送信待ちキューから再送する場合も、再送時刻で署名し直します)。
+func signOutgoingMessage(msg *WsMessage) {
+	key := currentConfig().Signing.Key
+	if key == "" {
+		return
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		// crypto/rand が失敗することは通常ないが、失敗した場合は時刻から生成する
+		nonce = []byte(strconv.FormatInt(time.Now().UnixNano(), 16))
+	}
+	// 受信側はワイヤー上のペイロードのバイト列でハッシュを計算するため、エンコード後と同じ形に揃えてから署名する
+	// (ファイルから読み込んだ送信待ちメッセージはインデントを含む場合がある)
+	if encoded, err := json.Marshal(msg.Payload); err == nil {
+		msg.Payload = encoded
+	}
+	msg.Timestamp = time.Now().UnixMilli()
+	msg.Nonce = hex.EncodeToString(nonce)
+	msg.Signature = messageSignature(key, *msg)
+}
+
+// verifyMessageSignature は、Bot からの要求の署名・タイムスタンプ・ノンスを検証します。
+// SIGNING_KEY が設定されていない場合、または署名の検証が不要なメッセージタイプの場合は常に nil を返します。
+// Args:
+//
+//	msg (WsMessage): 受信したメッセージ。
+//
+// Returns:
+//
+//	error: 検証に失敗した場合は *signatureError。
+func verifyMessageSignature(msg WsMessage) error {
+	settings := currentConfig().Signing
+	if settings.Key == "" || !signedRequestTypes[msg.Type] {
+		return nil
+	}
+	if msg.Signature == "" || msg.Nonce == "" || msg.Timestamp == 0 {
+		return &signatureError{Code: errorCodeSignatureMissing, Message: "メッセージに署名がありません (SWSC はメッセージ署名が必須に設定されています)"}
+	}
+
+	// 署名の比較は、タイムスタンプやノンスの検証より先に行う (署名のない値を信用しない)
+	expected := messageSignature(settings.Key, msg)
+	if !hmac.Equal([]byte(expected), []byte(msg.Signature)) {
+		return &signatureError{Code: errorCodeSignatureInvalid, Message: "メッセージの署名が一致しません"}
+	}
+
+	now := time.Now()
+	sentAt := time.UnixMilli(msg.Timestamp)
+	if skew := now.Sub(sentAt); skew > settings.MaxSkew || skew < -settings.MaxSkew {
+		return &signatureError{
+			Code:    errorCodeSignatureStale,
+			Message: fmt.Sprintf("メッセージのタイムスタンプが古すぎるか未来の時刻です (ずれ: %v, 許容: %v)", skew.Round(time.Millisecond), settings.MaxSkew),
+		}
+	}
+
+	nonceMutex.Lock()
+	defer nonceMutex.Unlock()
+	for nonce, expiresAt := range seenNonces {
+		if now.After(expiresAt) {
+			delete(seenNonces, nonce)
+		}
+	}
+	if _, seen := seenNonces[msg.Nonce]; seen {
+		return &signatureError{Code: errorCodeSignatureReplayed, Message: "同じノンスのメッセージを既に受信しています (リプレイの可能性があります)"}
+	}
+	// 許容範囲外のタイムスタンプは拒否されるため、ノンスはタイムスタンプから許容範囲が過ぎるまで記録すれば十分
+	seenNonces[msg.Nonce] = sentAt.Add(settings.MaxSkew)
+	return nil
+}
+
+// rejectUnverifiedMessage は、署名の検証に失敗した要求を実行せずに、エラーコード付きのエラーを返します。
+// Args:
+//
+//	msg (WsMessage): 受信したメッセージ。
+//	err (error): verifyMessageSignature が返したエラー。
+func rejectUnverifiedMessage(msg WsMessage, err error) {
+	code := errorCodeSignatureInvalid
+	var sigErr *signatureError
+	if errors.As(err, &sigErr) {
+		code = sigErr.Code
+	}
+	metricRejectedMessages.inc(code) // metrics.go
+	wsLog.withRequest(msg.RequestID).warnf("署名の検証に失敗したため要求を拒否しました (Type: %s, 理由: %s): %v", msg.Type, code, err)
+	recordEvent("messageRejected", "", fmt.Sprintf("%s (%s): %v", msg.Type, code, err)) // activity.go
+
+	if msg.RequestID == "" {
+		return
+	}
+	payloadBytes, marshalErr := json.Marshal(ErrorResponsePayload{Message: err.Error(), Code: code})
+	if marshalErr != nil {
+		wsLog.errorf("署名エラー応答ペイロードエンコード失敗 (ReqID: %s): %v", msg.RequestID, marshalErr)
+		return
+	}
+	// 同じ要求IDの正規の要求が処理中の場合があるため、要求の記録 (activity.go / idempotency.go) を経由せずに送信する
+	sendUntrackedMessage(WsMessage{Type: "error", RequestID: msg.RequestID, Payload: payloadBytes})
+}
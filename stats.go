@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- サーバープロセスのリソース使用量 ---
+// 実行中の各サーバープロセス (runningProcs) の CPU 使用率・常駐メモリ・スレッド数・開いているファイルの数を
+// STATS_INTERVAL ごとに取得し、"serverStats" メッセージでBotに送信します。
+// 最新の値は管理API (/api/servers, /api/stats) と Prometheus メトリクスでも参照できます。
+// 取得方法は OS ごとの processStatsBackend で切り替えます。
+//   - Linux: /proc を読み取ります (stats_linux.go)。Wine / Proton 経由の起動に備え、子孫プロセスの使用量も合算します
+//   - Windows: Win32 API を使用します (stats_windows.go)
+//   - その他の OS: 未対応です (稼働時間とプレイヤー数のみ通知します、stats_other.go)
+// serverStats は最新の状態を通知するためのものなので、切断中は送信せず、送信待ちキュー (outbox.go) にも保持しません。
+
+// statsDisabledCheckInterval は、STATS_INTERVAL が 0 (無効) の場合に、設定の再読み込みで有効になったかを確認する間隔です。
+const statsDisabledCheckInterval = 30 * time.Second
+
+// errStatsUnsupported は、この OS ではリソース使用量を取得できないことを示すエラーです。
+var errStatsUnsupported = errors.New("この OS ではリソース使用量を取得できません")
+
+// processSample は、ある時点でのプロセスのリソース使用量です。
+type processSample struct {
+	CPUTime   time.Duration // 起動からの累計CPU時間 (ユーザー + カーネル)
+	Memory    uint64        // 常駐メモリ (バイト)
+	Threads   int
+	OpenFiles int // 取得できない場合は -1
+	Processes int // 合算したプロセスの数
+}
+
+// processStatsBackend は、OS ごとのリソース使用量の取得方法です。
+type processStatsBackend interface {
+	// Name は、取得方法の名前です (serverStats の backend に使用)。
+	Name() string
+	// Sample は、プロセスの現在のリソース使用量を取得します。
+	Sample(pid int) (processSample, error)
+}
+
+// cpuReading は、CPU 使用率の計算に使う前回の累計CPU時間です。
+type cpuReading struct {
+	Pid     int
+	CPUTime time.Duration
+	At      time.Time
+}
+
+var (
+	// statsBackend は、この OS のリソース使用量の取得方法です (newProcessStatsBackend は OS ごとのファイルで定義)。
+	statsBackend = newProcessStatsBackend()
+	// latestStats は、最後に取得したサーバーごとのリソース使用量です (キー: サーバー構成名)。
+	latestStats = make(map[string]ServerStats)
+	// lastCPUReadings は、サーバーごとの前回の累計CPU時間です (キー: サーバー構成名)。
+	lastCPUReadings = make(map[string]cpuReading)
+	// statsSentServers は、前回の serverStats で通知したサーバーがあったかどうかです。
+	// サーバーがなくなった直後に一度だけ空の一覧を送信し、その後は送信を止めるために使用します。
+	statsSentServers bool
+	// statsMutex は、latestStats、lastCPUReadings、statsSentServers を保護するためのミューテックスです。
+	statsMutex sync.Mutex
+)
+
+// startStatsSampler は、リソース使用量を定期的に取得してBotに送信するゴルーチンを開始します。常駐モードの起動時に main から呼び出されます。
+// 間隔は毎回現在の設定から読み直すため、設定の再読み込み (config_reload.go) で変更できます。
+func startStatsSampler() {
+	go func() {
+		for {
+			interval := currentConfig().Stats.Interval
+			if interval <= 0 {
+				time.Sleep(statsDisabledCheckInterval)
+				continue
+			}
+			time.Sleep(interval)
+			if currentConfig().Stats.Interval <= 0 {
+				continue
+			}
+			sendServerStats(sampleServerStats(time.Now()), interval)
+		}
+	}()
+}
+
+// sampleServerStats は、実行中の全サーバーのリソース使用量を取得し、最新の値として記録します。
+// CPU 使用率は前回の取得からの累計CPU時間の増分から計算します (初回はプロセスの起動時刻からの平均)。
+// Returns:
+//
+//	[]ServerStats: サーバーごとのリソース使用量 (構成名順)。
+func sampleServerStats(now time.Time) []ServerStats {
+	procs := getRunningProcesses() // process_manager.go
+	names := make([]string, 0, len(procs))
+	for name := range procs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	stats := make([]ServerStats, 0, len(names))
+	latest := make(map[string]ServerStats, len(names))
+	readings := make(map[string]cpuReading, len(names))
+	for _, name := range names {
+		info := procs[name]
+		entry := ServerStats{
+			ServerName:    name,
+			Pid:           info.Process.Pid,
+			Port:          info.Port,
+			UptimeSeconds: int64(now.Sub(info.StartedAt).Seconds()),
+			Players:       getPlayerCount(name), // players.go
+		}
+		sample, err := statsBackend.Sample(info.Process.Pid)
+		if err != nil {
+			entry.Error = err.Error()
+		} else {
+			previous, ok := lastCPUReadings[name]
+			if !ok || previous.Pid != entry.Pid {
+				previous = cpuReading{Pid: entry.Pid, At: info.StartedAt}
+			}
+			if elapsed := now.Sub(previous.At); elapsed > 0 && sample.CPUTime >= previous.CPUTime {
+				entry.CPUPercent = roundStat(float64(sample.CPUTime-previous.CPUTime) / float64(elapsed) * 100)
+			}
+			readings[name] = cpuReading{Pid: entry.Pid, CPUTime: sample.CPUTime, At: now}
+			entry.MemoryBytes = sample.Memory
+			entry.Threads = sample.Threads
+			entry.OpenFiles = sample.OpenFiles
+			entry.Processes = sample.Processes
+		}
+		stats = append(stats, entry)
+		latest[name] = entry
+	}
+	// 停止したサーバーの値は破棄する
+	latestStats = latest
+	lastCPUReadings = readings
+	return stats
+}
+
+// roundStat は、CPU 使用率を小数点以下1桁に丸めます。
+func roundStat(value float64) float64 {
+	return float64(int64(value*10+0.5)) / 10
+}
+
+// getServerStats は、実行中のサーバーの最新のリソース使用量を返します (管理API、メトリクス用)。
+// STATS_INTERVAL が 0 (無効) の場合は、呼び出し時に取得します。
+// 停止または再起動したサーバーの古い値は含めません。
+func getServerStats() map[string]ServerStats {
+	if currentConfig().Stats.Interval <= 0 {
+		sampleServerStats(time.Now())
+	}
+	procs := getRunningProcesses() // process_manager.go
+	statsMutex.Lock()
+	defer statsMutex.Unlock()
+	stats := make(map[string]ServerStats, len(latestStats))
+	for name, entry := range latestStats {
+		if info, ok := procs[name]; ok && info.Process.Pid == entry.Pid {
+			stats[name] = entry
+		}
+	}
+	return stats
+}
+
+// sendServerStats は、リソース使用量を "serverStats" メッセージでBotに送信します。
+// 切断中と、Bot が serverStats に対応していない場合は送信しません。実行中のサーバーがない場合は、サーバーがなくなった直後の1回だけ空の一覧を送信します。
+func sendServerStats(stats []ServerStats, interval time.Duration) {
+	if !isWebSocketConnected() { // websocket_client.go
+		return
+	}
+	// serverStats に対応していない Bot には送信しない (protocol.go)
+	if !botSupports("serverStats") {
+		return
+	}
+	statsMutex.Lock()
+	skip := len(stats) == 0 && !statsSentServers
+	statsSentServers = len(stats) > 0
+	statsMutex.Unlock()
+	if skip {
+		return
+	}
+
+	payload := ServerStatsPayload{
+		SampledAt:       time.Now(),
+		IntervalSeconds: interval.Seconds(),
+		Backend:         statsBackend.Name(),
+		Servers:         stats,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		wsLog.warnf("リソース使用量ペイロードエンコード失敗: %v", err)
+		return
+	}
+	wsLog.debugf("リソース使用量送信: Servers=%d", len(stats))
+	sendMessage(WsMessage{Type: "serverStats", Payload: payloadBytes}) // websocket_client.go
+}
+
+// formatStatsSettings は、リソース使用量の取得の設定をログ表示用の文字列にします。
+func formatStatsSettings(settings StatsSettings) string {
+	if settings.Interval <= 0 {
+		return fmt.Sprintf("serverStats の送信は無効 (取得方法: %s)", statsBackend.Name())
+	}
+	return fmt.Sprintf("%v ごとに serverStats を送信 (取得方法: %s)", settings.Interval, statsBackend.Name())
+}
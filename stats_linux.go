@@ -0,0 +1,153 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Linux の /proc によるリソース使用量の取得 ---
+// /proc/<pid>/stat から CPU 時間・スレッド数・常駐メモリを、/proc/<pid>/fd から開いているファイルの数を読み取ります。
+// Wine / Proton 経由で起動した場合は、実際のゲームサーバーが子孫プロセスとして動作するため、
+// 起動したプロセスとその子孫プロセスの使用量を合算します。
+
+// procClockTicks は、/proc/<pid>/stat の CPU 時間の単位 (USER_HZ) です。Linux では実質的に常に 100 です。
+const procClockTicks = 100
+
+// procfsStatsBackend は、/proc からリソース使用量を取得します。
+type procfsStatsBackend struct {
+	root string // 通常は "/proc"
+}
+
+// newProcessStatsBackend は、この OS のリソース使用量の取得方法を返します。
+func newProcessStatsBackend() processStatsBackend {
+	return procfsStatsBackend{root: "/proc"}
+}
+
+func (b procfsStatsBackend) Name() string {
+	return "procfs"
+}
+
+// Sample は、プロセスとその子孫プロセスのリソース使用量を合算して返します。
+// 子孫プロセスが取得中に終了した場合は、そのプロセスを除いて合算します。
+func (b procfsStatsBackend) Sample(pid int) (processSample, error) {
+	root, err := b.readStat(pid)
+	if err != nil {
+		return processSample{}, err
+	}
+	sample := processSample{}
+	pageSize := uint64(os.Getpagesize())
+	for i, current := range append([]int{pid}, b.descendants(pid)...) {
+		stat := root
+		if i > 0 {
+			if stat, err = b.readStat(current); err != nil {
+				continue
+			}
+		}
+		sample.CPUTime += time.Duration(stat.utime+stat.stime) * time.Second / procClockTicks
+		if stat.rss > 0 {
+			sample.Memory += uint64(stat.rss) * pageSize
+		}
+		sample.Threads += stat.threads
+		sample.Processes++
+		if sample.OpenFiles >= 0 {
+			fds, err := os.ReadDir(filepath.Join(b.root, strconv.Itoa(current), "fd"))
+			if err != nil {
+				sample.OpenFiles = -1 // 権限がないなど
+			} else {
+				sample.OpenFiles += len(fds)
+			}
+		}
+	}
+	return sample, nil
+}
+
+// procStat は、/proc/<pid>/stat から読み取った値です。
+type procStat struct {
+	ppid    int
+	utime   uint64 // ユーザーモードの CPU 時間 (clock ticks)
+	stime   uint64 // カーネルモードの CPU 時間 (clock ticks)
+	threads int
+	rss     int64 // 常駐メモリ (ページ数)
+}
+
+// readStat は、/proc/<pid>/stat を読み取ります。
+func (b procfsStatsBackend) readStat(pid int) (procStat, error) {
+	content, err := os.ReadFile(filepath.Join(b.root, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, fmt.Errorf("プロセス %d の情報を読み取れません: %w", pid, err)
+	}
+	stat, err := parseProcStat(string(content))
+	if err != nil {
+		return procStat{}, fmt.Errorf("プロセス %d の情報を解析できません: %w", pid, err)
+	}
+	return stat, nil
+}
+
+// parseProcStat は、/proc/<pid>/stat の内容を解析します。
+// 2番目のフィールド (コマンド名) は括弧で囲まれ、空白や括弧を含む可能性があるため、最後の ')' より後を空白で分割します。
+func parseProcStat(content string) (procStat, error) {
+	end := strings.LastIndexByte(content, ')')
+	if end < 0 {
+		return procStat{}, fmt.Errorf("コマンド名の終わりが見つかりません")
+	}
+	// fields[0] が3番目のフィールド (state) にあたる
+	fields := strings.Fields(content[end+1:])
+	if len(fields) < 22 {
+		return procStat{}, fmt.Errorf("フィールドが不足しています (%d 個)", len(fields))
+	}
+	var stat procStat
+	var errs [5]error
+	stat.ppid, errs[0] = strconv.Atoi(fields[1])
+	stat.utime, errs[1] = strconv.ParseUint(fields[11], 10, 64)
+	stat.stime, errs[2] = strconv.ParseUint(fields[12], 10, 64)
+	stat.threads, errs[3] = strconv.Atoi(fields[17])
+	stat.rss, errs[4] = strconv.ParseInt(fields[21], 10, 64)
+	for _, err := range errs {
+		if err != nil {
+			return procStat{}, err
+		}
+	}
+	return stat, nil
+}
+
+// descendants は、プロセスの子孫プロセスの PID を返します。
+// /proc の全プロセスの親 PID から親子関係を組み立てます。
+func (b procfsStatsBackend) descendants(pid int) []int {
+	entries, err := os.ReadDir(b.root)
+	if err != nil {
+		return nil
+	}
+	children := make(map[int][]int)
+	for _, entry := range entries {
+		child, err := strconv.Atoi(entry.Name())
+		if err != nil || !entry.IsDir() {
+			continue
+		}
+		stat, err := b.readStat(child)
+		if err != nil {
+			continue // 列挙中に終了したプロセス
+		}
+		children[stat.ppid] = append(children[stat.ppid], child)
+	}
+
+	var result []int
+	queue := children[pid]
+	seen := map[int]bool{pid: true}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if seen[current] {
+			continue
+		}
+		seen[current] = true
+		result = append(result, current)
+		queue = append(queue, children[current]...)
+	}
+	return result
+}
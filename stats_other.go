@@ -0,0 +1,22 @@
+//go:build !linux && !windows
+
+package main
+
+// --- リソース使用量の取得 (未対応の OS) ---
+// Linux と Windows 以外では、リソース使用量を取得できません。serverStats には稼働時間とプレイヤー数のみを含めます。
+
+// unsupportedStatsBackend は、リソース使用量を取得できない OS の取得方法です。
+type unsupportedStatsBackend struct{}
+
+// newProcessStatsBackend は、この OS のリソース使用量の取得方法を返します。
+func newProcessStatsBackend() processStatsBackend {
+	return unsupportedStatsBackend{}
+}
+
+func (unsupportedStatsBackend) Name() string {
+	return "unsupported"
+}
+
+func (unsupportedStatsBackend) Sample(pid int) (processSample, error) {
+	return processSample{}, errStatsUnsupported
+}
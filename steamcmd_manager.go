@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"io/fs" // filepath.WalkDir で使うため
-	"log"
 	"os"            // ファイル操作 (削除、情報取得、ディレクトリ作成) のため
 	"os/exec"       // SteamCMD を外部プロセスとして実行するため
 	"path/filepath" // OSに依存しないパス操作のため
@@ -32,32 +31,43 @@ var steamCmdSuccessRegex = regexp.MustCompile(`Success. Downloaded item (\d+)`)
 //	modDir (string): MODの最終的な配置先ディレクトリパス (設定値)。
 //	gameAppID (string): 対象ゲームのSteam App ID (設定値)。
 //	steamCmdPath (string): steamcmd.exe 実行ファイルへのフルパス (設定値)。
+//	steamCmdLogin (string): SteamCMD のログインユーザー名 (設定値、通常は anonymous)。
 //
 // Returns:
 //
 //	successfulPlaylistIDs ([]string): 正常に処理(ダウンロード/削除/コピー)が完了したプレイリストIDのリスト。
 //	successfulModIDs ([]string): 正常に処理(ダウンロード/削除/コピー)が完了したMOD IDのリスト。
 //	err (error): SteamCMDの起動失敗や出力読み取りエラーなど、処理を続行できない致命的なエラーが発生した場合のエラーオブジェクト。個別のアイテム処理失敗はエラーとして返さない。
-func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir string, modDir string, gameAppID string, steamCmdPath string) (successfulPlaylistIDs []string, successfulModIDs []string, err error) {
+func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir string, modDir string, gameAppID string, steamCmdPath string, steamCmdLogin string) (successfulPlaylistIDs []string, successfulModIDs []string, err error) {
 
 	// --- 初期チェック ---
 	if len(playlistIDs) == 0 && len(modIDs) == 0 {
-		log.Println("[SteamCMD] ダウンロード対象のワークショップアイテムはありません。")
+		steamCmdLog.infof("ダウンロード対象のワークショップアイテムはありません。")
 		return []string{}, []string{}, nil // 対象がなければ正常終了
 	}
 
+	// --- 実行順の待機 ---
+	// SteamCMD の同時実行はダウンロード先が競合するため、1件ずつ順番に実行する (steamcmd_queue.go)
+	job := acquireSteamCmd(playlistIDs, modIDs)
+	defer func() {
+		releaseSteamCmd(job, err)
+		recordSteamCmdItemMetrics(playlistIDs, modIDs, successfulPlaylistIDs, successfulModIDs) // metrics.go
+	}()
+
 	// --- 処理開始ログ ---
-	log.Printf("[SteamCMD] ワークショップアイテムのダウンロード/更新を開始します...")
-	log.Printf("[SteamCMD]   対象プレイリストID数: %d", len(playlistIDs))
-	log.Printf("[SteamCMD]   対象MOD ID数: %d", len(modIDs))
-	log.Printf("[SteamCMD]   ターゲット プレイリスト ディレクトリ: %s", playlistDir)
-	log.Printf("[SteamCMD]   ターゲット MOD ディレクトリ: %s", modDir)
-	log.Printf("[SteamCMD]   ゲーム App ID: %s", gameAppID)
-	log.Printf("[SteamCMD]   SteamCMD パス: %s", steamCmdPath)
+	steamCmdLog.infof("ワークショップアイテムのダウンロード/更新を開始します...")
+	steamCmdLog.infof("対象プレイリストID数: %d", len(playlistIDs))
+	steamCmdLog.infof("対象MOD ID数: %d", len(modIDs))
+	steamCmdLog.infof("ターゲット プレイリスト ディレクトリ: %s", playlistDir)
+	steamCmdLog.infof("ターゲット MOD ディレクトリ: %s", modDir)
+	steamCmdLog.infof("ゲーム App ID: %s", gameAppID)
+	steamCmdLog.infof("SteamCMD パス: %s", steamCmdPath)
+	steamCmdLog.infof("SteamCMD ログイン: %s", steamCmdLogin)
 
 	// --- SteamCMDコマンド引数の構築 ---
 	// force_install_dir を使わず、SteamCMDのデフォルト場所にダウンロードさせる
-	args := []string{"+login", "anonymous"} // 匿名ログインを使用
+	// 通常は匿名ログインを使用 (anonymous 以外は SteamCMD にキャッシュされた資格情報でログインする)
+	args := []string{"+login", steamCmdLogin}
 
 	// ダウンロード対象の全IDを管理するマップ (後でプレイリストかMODか判定するため)
 	allItems := make(map[string]string) // Key: ID, Value: "playlist" or "mod"
@@ -75,7 +85,7 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 
 	args = append(args, "+quit") // 全てのダウンロードコマンドの後、SteamCMDを終了
 
-	log.Printf("[SteamCMD] 実行コマンド: %s %s", steamCmdPath, strings.Join(args, " "))
+	steamCmdLog.infof("実行コマンド: %s %s", steamCmdPath, strings.Join(args, " "))
 
 	// --- SteamCMDの実行準備 ---
 	cmd := exec.Command(steamCmdPath, args...)
@@ -83,12 +93,12 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 	// 標準出力と標準エラー出力をパイプで取得
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		log.Printf("[SteamCMD] エラー: 標準出力パイプの取得に失敗しました: %v", err)
+		steamCmdLog.errorf("標準出力パイプの取得に失敗しました: %v", err)
 		return nil, nil, fmt.Errorf("SteamCMDの標準出力パイプ取得エラー: %w", err)
 	}
 	stderrPipe, err := cmd.StderrPipe()
 	if err != nil {
-		log.Printf("[SteamCMD] エラー: 標準エラー出力パイプの取得に失敗しました: %v", err)
+		steamCmdLog.errorf("標準エラー出力パイプの取得に失敗しました: %v", err)
 		return nil, nil, fmt.Errorf("SteamCMDの標準エラー出力パイプ取得エラー: %w", err)
 	}
 
@@ -104,7 +114,7 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 		scanner := bufio.NewScanner(stdoutPipe)
 		for scanner.Scan() { // 1行ずつ読み取る
 			line := scanner.Text()
-			log.Printf("[SteamCMD][出力] %s", line) // SteamCMDの出力をログに記録
+			steamCmdLog.with("stream", "stdout").debugf("%s", line) // SteamCMDの出力をログに記録 (デバッグレベル)
 
 			// 成功メッセージを示す正規表現にマッチするか確認
 			matches := steamCmdSuccessRegex.FindStringSubmatch(line)
@@ -113,13 +123,13 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 				// 同じIDで複数回成功ログが出る場合があるので、初回のみ記録
 				if _, exists := downloadSuccessMap[successfulID]; !exists {
 					downloadSuccessMap[successfulID] = true // 成功マップに記録
-					log.Printf("[SteamCMD] アイテム ID %s のダウンロード/更新成功をSteamCMDログから確認しました。", successfulID)
+					steamCmdLog.infof("アイテム ID %s のダウンロード/更新成功をSteamCMDログから確認しました。", successfulID)
 				}
 			}
 		}
 		// スキャナーのエラーチェック (EOF以外)
 		if err := scanner.Err(); err != nil && err != io.EOF {
-			log.Printf("[SteamCMD] エラー: 標準出力の読み取り中にエラーが発生しました: %v", err)
+			steamCmdLog.errorf("標準出力の読み取り中にエラーが発生しました: %v", err)
 			readErr = err // 読み取りエラーを記録
 		}
 	}()
@@ -130,11 +140,11 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 		scanner := bufio.NewScanner(stderrPipe)
 		for scanner.Scan() { // 1行ずつ読み取る
 			line := scanner.Text()
-			log.Printf("[SteamCMD][エラー] %s", line) // エラー出力は常にログに記録
+			steamCmdLog.with("stream", "stderr").warnf("%s", line) // エラー出力は警告レベルで記録
 		}
 		// スキャナーのエラーチェック (EOF以外)
 		if err := scanner.Err(); err != nil && err != io.EOF {
-			log.Printf("[SteamCMD] エラー: 標準エラー出力の読み取り中にエラーが発生しました: %v", err)
+			steamCmdLog.errorf("標準エラー出力の読み取り中にエラーが発生しました: %v", err)
 			// stdout側でエラーが発生していなければ、こちらのエラーを記録
 			if readErr == nil {
 				readErr = err
@@ -144,10 +154,10 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 
 	// --- SteamCMDプロセスの開始と終了待機 ---
 	if err := cmd.Start(); err != nil {
-		log.Printf("[SteamCMD] エラー: SteamCMDプロセスの開始に失敗しました: %v", err)
+		steamCmdLog.errorf("SteamCMDプロセスの開始に失敗しました: %v", err)
 		return nil, nil, fmt.Errorf("SteamCMDプロセスの開始エラー: %w", err)
 	}
-	log.Println("[SteamCMD] SteamCMDプロセスを開始しました。ダウンロード/更新処理の完了を待ちます...")
+	steamCmdLog.infof("SteamCMDプロセスを開始しました。ダウンロード/更新処理の完了を待ちます...")
 
 	waitErr := cmd.Wait() // SteamCMDプロセスが終了するまで待機
 
@@ -156,31 +166,31 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 	// --- SteamCMD実行後のエラーチェック ---
 	if readErr != nil {
 		// 出力パイプの読み取りでエラーが発生した場合、成功したかの判断が不確実なため、処理を中断
-		log.Printf("[SteamCMD] エラー: SteamCMDの出力読み取り中にエラーが発生したため、後続の処理を中断します: %v", readErr)
+		steamCmdLog.errorf("SteamCMDの出力読み取り中にエラーが発生したため、後続の処理を中断します: %v", readErr)
 		return nil, nil, fmt.Errorf("SteamCMD出力読み取りエラー: %w", readErr)
 	}
 	if waitErr != nil {
 		// SteamCMD自体がエラーコードで終了した場合 (例: ネットワークエラー、ディスク容量不足など)
 		// ログには警告として記録するが、一部成功している可能性もあるため、後続のコピー処理は試行する
-		log.Printf("[SteamCMD] 警告: SteamCMDプロセスがエラーで終了しました: %v。コピー処理を試行します。", waitErr)
+		steamCmdLog.errorf("SteamCMDプロセスがエラーで終了しました: %v。コピー処理を試行します。", waitErr)
 	}
-	log.Printf("[SteamCMD] SteamCMDプロセス終了。")
+	steamCmdLog.infof("SteamCMDプロセス終了。")
 
 	// --- SteamCMDデフォルトダウンロードパスの決定 ---
 	// steamcmd.exe と同じディレクトリにある steamapps/workshop/content/<AppID> を想定
 	steamCmdDir := filepath.Dir(steamCmdPath)
 	steamCmdContentBase := filepath.Join(steamCmdDir, "steamapps", "workshop", "content", gameAppID)
-	log.Printf("[SteamCMD] デフォルトのSteamCMDコンテンツ基底パスを '%s' と判断しました。", steamCmdContentBase)
+	steamCmdLog.infof("デフォルトのSteamCMDコンテンツ基底パスを '%s' と判断しました。", steamCmdContentBase)
 	// 実際にこのディレクトリが存在するか確認 (オプション)
 	if _, statErr := os.Stat(steamCmdContentBase); os.IsNotExist(statErr) {
-		log.Printf("[SteamCMD] 警告: SteamCMDのコンテンツ基底パス '%s' が見つかりません。SteamCMDが正常にアイテムをダウンロードできなかった可能性があります。", steamCmdContentBase)
+		steamCmdLog.warnf("SteamCMDのコンテンツ基底パス '%s' が見つかりません。SteamCMDが正常にアイテムをダウンロードできなかった可能性があります。", steamCmdContentBase)
 		// 存在しない場合、コピー元がないため、成功リストは空で返る
 	}
 
 	// --- 削除＆コピー処理 ---
 	// finalSuccessMap: 削除(該当する場合)とコピーの両方に成功したIDを記録
 	finalSuccessMap := make(map[string]bool)
-	log.Printf("[SteamCMD] ダウンロードされたアイテムの削除＆コピー処理を開始します...")
+	steamCmdLog.infof("ダウンロードされたアイテムの削除＆コピー処理を開始します...")
 
 	// SteamCMDログで成功が確認されたIDのみを対象に処理
 	for id, downloaded := range downloadSuccessMap {
@@ -191,9 +201,10 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 		// アイテムタイプ ("playlist" or "mod") を取得
 		itemType, ok := allItems[id]
 		if !ok {
-			log.Printf("[SteamCMD] 警告: 内部エラー。ダウンロード成功マップにあるID %s が元のアイテムリストに存在しません。", id)
+			steamCmdLog.errorf("内部エラー。ダウンロード成功マップにあるID %s が元のアイテムリストに存在しません。", id)
 			continue // 念のためスキップ
 		}
+		itemLog := steamCmdLog.with("itemType", itemType, "itemId", id)
 
 		// コピー元パスとコピー先パスを決定
 		var sourcePath string         // 実際にコピーするファイル/ディレクトリのパス
@@ -205,16 +216,16 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 			sourcePath = filepath.Join(steamCmdContentBase, id, "playlist")
 			// ★ コピー先は <PlaylistTargetDir>/<ID> ディレクトリ
 			targetPath = filepath.Join(playlistDir, id)
-			log.Printf("[SteamCMD][%s:%s] 処理開始 (プレイリスト)...", itemType, id)
-			log.Printf("[SteamCMD][%s:%s]   ソース(プレイリスト内容): %s", itemType, id, sourcePath)
-			log.Printf("[SteamCMD][%s:%s]   ターゲット(ID名ディレクトリ): %s", itemType, id, targetPath)
+			itemLog.infof("処理開始 (プレイリスト)...")
+			itemLog.infof("ソース(プレイリスト内容): %s", sourcePath)
+			itemLog.infof("ターゲット(ID名ディレクトリ): %s", targetPath)
 
 			// ★ プレイリストのコピー元 (<ID>/playlist) が存在するか確認
 			if _, statErr := os.Stat(sourcePath); os.IsNotExist(statErr) {
-				log.Printf("[SteamCMD][%s:%s] エラー: 期待されるコピー元ディレクトリ '%s' が見つかりません。プレイリスト形式でないか、ダウンロードに失敗した可能性があります。スキップします。", itemType, id, sourcePath)
+				itemLog.errorf("期待されるコピー元ディレクトリ '%s' が見つかりません。プレイリスト形式でないか、ダウンロードに失敗した可能性があります。スキップします。", sourcePath)
 				continue // このIDは失敗扱い
 			} else if statErr != nil {
-				log.Printf("[SteamCMD][%s:%s] エラー: コピー元ディレクトリ '%s' の状態確認中にエラー: %v", itemType, id, sourcePath, statErr)
+				itemLog.errorf("コピー元ディレクトリ '%s' の状態確認中にエラー: %v", sourcePath, statErr)
 				continue // このIDは失敗扱い
 			} else {
 				sourceExists = true // コピー元が存在することを確認
@@ -225,23 +236,23 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 			sourcePath = filepath.Join(steamCmdContentBase, id)
 			// ★ コピー先は <ModTargetDir>/<ID> ディレクトリ
 			targetPath = filepath.Join(modDir, id)
-			log.Printf("[SteamCMD][%s:%s] 処理開始 (MOD)...", itemType, id)
-			log.Printf("[SteamCMD][%s:%s]   ソース(ID名ディレクトリ): %s", itemType, id, sourcePath)
-			log.Printf("[SteamCMD][%s:%s]   ターゲット(ID名ディレクトリ): %s", itemType, id, targetPath)
+			itemLog.infof("処理開始 (MOD)...")
+			itemLog.infof("ソース(ID名ディレクトリ): %s", sourcePath)
+			itemLog.infof("ターゲット(ID名ディレクトリ): %s", targetPath)
 
 			// ★ MODのコピー元 (<ID> ディレクトリ) が存在するか確認
 			if _, statErr := os.Stat(sourcePath); os.IsNotExist(statErr) {
-				log.Printf("[SteamCMD][%s:%s] エラー: 期待されるコピー元ディレクトリ '%s' が見つかりません。ダウンロードに失敗した可能性があります。スキップします。", itemType, id, sourcePath)
+				itemLog.errorf("期待されるコピー元ディレクトリ '%s' が見つかりません。ダウンロードに失敗した可能性があります。スキップします。", sourcePath)
 				continue // このIDは失敗扱い
 			} else if statErr != nil {
-				log.Printf("[SteamCMD][%s:%s] エラー: コピー元ディレクトリ '%s' の状態確認中にエラー: %v", itemType, id, sourcePath, statErr)
+				itemLog.errorf("コピー元ディレクトリ '%s' の状態確認中にエラー: %v", sourcePath, statErr)
 				continue // このIDは失敗扱い
 			} else {
 				sourceExists = true // コピー元が存在することを確認
 			}
 		} else {
 			// allItems マップのキーは "playlist" か "mod" のはずなので、ここには到達しない想定
-			log.Printf("[SteamCMD] 警告: 不明なアイテムタイプです。ID: %s", id)
+			steamCmdLog.warnf("不明なアイテムタイプです。ID: %s", id)
 			continue
 		}
 
@@ -249,41 +260,41 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 		if sourceExists {
 			// 1. 既存ターゲットディレクトリ削除
 			//    コピー先 (<TargetDir>/<ID>) をまず削除する
-			log.Printf("[SteamCMD][%s:%s]   既存ターゲットディレクトリ削除試行: %s", itemType, id, targetPath)
+			itemLog.infof("既存ターゲットディレクトリ削除試行: %s", targetPath)
 			removeErr := os.RemoveAll(targetPath)
 			if removeErr != nil && !os.IsNotExist(removeErr) {
 				// ディレクトリが存在しないエラー(os.IsNotExist)以外は問題あり (例: アクセス権限不足)
-				log.Printf("[SteamCMD][%s:%s] エラー: 既存ターゲットディレクトリ '%s' の削除に失敗しました: %v", itemType, id, targetPath, removeErr)
+				itemLog.errorf("既存ターゲットディレクトリ '%s' の削除に失敗しました: %v", targetPath, removeErr)
 				// 削除に失敗したらコピーに進めないため、このIDは失敗扱い
 				continue // 次のIDへ
 			}
 			// 削除成功または元々存在しなかった場合のログ
 			if removeErr == nil {
-				log.Printf("[SteamCMD][%s:%s]   既存ターゲットディレクトリを削除しました。", itemType, id)
+				itemLog.infof("既存ターゲットディレクトリを削除しました。")
 			} else {
-				log.Printf("[SteamCMD][%s:%s]   既存ターゲットディレクトリは存在しませんでした。", itemType, id)
+				itemLog.infof("既存ターゲットディレクトリは存在しませんでした。")
 			}
 
 			// 2. ディレクトリコピー
 			//    copyDir ヘルパー関数を呼び出す
-			log.Printf("[SteamCMD][%s:%s]   ディレクトリコピー試行 ('%s' -> '%s')...", itemType, id, sourcePath, targetPath)
+			itemLog.infof("ディレクトリコピー試行 ('%s' -> '%s')...", sourcePath, targetPath)
 			copyErr := copyDir(sourcePath, targetPath) // copyDir は変更不要
 			if copyErr != nil {
-				log.Printf("[SteamCMD][%s:%s] エラー: ディレクトリ '%s' から '%s' へのコピーに失敗しました: %v", itemType, id, sourcePath, targetPath, copyErr)
+				itemLog.errorf("ディレクトリ '%s' から '%s' へのコピーに失敗しました: %v", sourcePath, targetPath, copyErr)
 				// コピー失敗もこのIDは失敗扱い
 				continue // 次のIDへ
 			}
-			log.Printf("[SteamCMD][%s:%s]   ディレクトリコピー成功。", itemType, id)
+			itemLog.infof("ディレクトリコピー成功。")
 
 			// 削除（または不要）とコピーの両方が成功した場合のみ、最終成功マップに記録
 			finalSuccessMap[id] = true
-			log.Printf("[SteamCMD][%s:%s] 処理成功。", itemType, id)
+			itemLog.infof("処理成功。")
 		}
 		// コピー元が存在しなかった場合は、ループの先頭で continue しているのでここには到達しない
 
 	} // --- 削除＆コピー処理ループ終了 ---
 
-	log.Printf("[SteamCMD] 削除＆コピー処理完了。")
+	steamCmdLog.infof("削除＆コピー処理完了。")
 
 	// --- 最終結果の集計 ---
 	// 最終成功マップを基に、成功したプレイリストIDとMOD IDのリストを作成
@@ -301,10 +312,10 @@ func DownloadWorkshopItems(playlistIDs []string, modIDs []string, playlistDir st
 	}
 
 	// --- 最終結果ログ ---
-	log.Printf("[SteamCMD] 最終結果:")
-	log.Printf("[SteamCMD]   最終的に成功したプレイリストID数: %d / %d", len(successfulPlaylistIDs), len(playlistIDs))
-	log.Printf("[SteamCMD]   最終的に成功したMOD ID数: %d / %d", len(successfulModIDs), len(modIDs))
-	log.Println("[SteamCMD] ワークショップアイテム処理完了。")
+	steamCmdLog.infof("最終結果:")
+	steamCmdLog.infof("最終的に成功したプレイリストID数: %d / %d", len(successfulPlaylistIDs), len(playlistIDs))
+	steamCmdLog.infof("最終的に成功したMOD ID数: %d / %d", len(successfulModIDs), len(modIDs))
+	steamCmdLog.infof("ワークショップアイテム処理完了。")
 
 	// 個別の削除/コピー失敗はエラーとして返さず、成功リストの差分で判断させる
 	return successfulPlaylistIDs, successfulModIDs, nil
@@ -366,7 +377,7 @@ func copyDir(src, dst string) error {
 			if err := os.MkdirAll(dstPath, srcInfo.Mode()); err != nil {
 				return fmt.Errorf("コピー先サブディレクトリ '%s' の作成エラー: %w", dstPath, err)
 			}
-			// log.Printf("[コピー] ディレクトリ作成: %s", dstPath) // 詳細ログ
+			// steamCmdLog.debugf("ディレクトリ作成: %s", dstPath) // 詳細ログ
 		} else {
 			// ファイルの場合: 内容をコピーする
 			// コピー元ファイルを開く
@@ -396,7 +407,7 @@ func copyDir(src, dst string) error {
 				_ = dstFile.Close()
 				return fmt.Errorf("ファイル '%s' から '%s' へのコピーエラー: %w", path, dstPath, err)
 			}
-			// log.Printf("[コピー] ファイルコピー: %s (%d バイト)", dstPath, bytesCopied) // 詳細ログ
+			// steamCmdLog.debugf("ファイルコピー: %s (%d バイト)", dstPath, bytesCopied) // 詳細ログ
 		}
 		return nil // この要素の処理が成功したら nil を返す
 	}) // --- WalkDir 終了 ---
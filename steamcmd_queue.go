@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// --- SteamCMD 実行キュー ---
+// 複数のサーバーを同時に起動すると SteamCMD が並行して実行され、同じダウンロード先 (steamapps/workshop) を
+// 奪い合ってしまうため、DownloadWorkshopItems の実行を1件ずつ、要求された順 (FIFO) に行います。
+// 実行中・待機中のジョブは管理API (admin_api.go) から参照できます。
+
+// steamCmdJob は、SteamCMD の実行1回分 (DownloadWorkshopItems の呼び出し1回) の情報です。
+type steamCmdJob struct {
+	ID          int        `json:"id"`
+	PlaylistIDs []string   `json:"playlistIds"`
+	ModIDs      []string   `json:"modIds"`
+	QueuedAt    time.Time  `json:"queuedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"` // 実行開始前は nil
+}
+
+// steamCmdQueueState は、SteamCMD 実行キューの状態のスナップショットです。
+type steamCmdQueueState struct {
+	Running        *steamCmdJob  `json:"running"` // 実行中のジョブ (なければ nil)
+	Waiting        []steamCmdJob `json:"waiting"` // 実行待ちのジョブ (古い順)
+	CompletedJobs  int           `json:"completedJobs"`
+	FailedJobs     int           `json:"failedJobs"`
+	LastFinishedAt *time.Time    `json:"lastFinishedAt,omitempty"`
+	LastError      string        `json:"lastError,omitempty"`
+}
+
+// steamCmdWaiter は、実行順を待っているジョブと、実行順が回ってきたことを通知するチャネルです。
+type steamCmdWaiter struct {
+	job   *steamCmdJob
+	ready chan struct{}
+}
+
+var (
+	// steamCmdQueue は、SteamCMD 実行キューの現在の状態です。
+	steamCmdQueue = steamCmdQueueState{Waiting: []steamCmdJob{}}
+	// steamCmdWaiters は、実行順を待っているジョブです (steamCmdQueue.Waiting と同じ順)。
+	// 実行中のジョブが終わると、先頭のジョブに実行順を渡します。
+	steamCmdWaiters []steamCmdWaiter
+	// steamCmdNextJobID は、次に割り当てるジョブIDです。
+	steamCmdNextJobID = 1
+	// steamCmdQueueMutex は、steamCmdQueue、steamCmdWaiters、steamCmdNextJobID を保護するためのミューテックスです。
+	steamCmdQueueMutex sync.Mutex
+)
+
+// acquireSteamCmd は、SteamCMD の実行順が回ってくるまで待機し、実行中のジョブとして登録します。
+// 実行順は要求した順で、管理APIの待機中のジョブの並び (steamCmdQueueState.Waiting) と一致します。
+// 実行が終わったら、返されたジョブを releaseSteamCmd に渡してください。
+func acquireSteamCmd(playlistIDs []string, modIDs []string) *steamCmdJob {
+	steamCmdQueueMutex.Lock()
+	job := &steamCmdJob{ID: steamCmdNextJobID, PlaylistIDs: playlistIDs, ModIDs: modIDs, QueuedAt: time.Now()}
+	steamCmdNextJobID++
+	if steamCmdQueue.Running == nil {
+		// 実行中のジョブがなければすぐに実行する (待機中のジョブは、実行中のジョブがある間しか存在しない)
+		startSteamCmdJobLocked(job)
+		steamCmdQueueMutex.Unlock()
+		return job
+	}
+	waiter := steamCmdWaiter{job: job, ready: make(chan struct{})}
+	steamCmdWaiters = append(steamCmdWaiters, waiter)
+	steamCmdQueue.Waiting = append(steamCmdQueue.Waiting, *job)
+	waiting := len(steamCmdWaiters)
+	steamCmdQueueMutex.Unlock()
+
+	steamCmdLog.infof("他のダウンロードが実行中のため、順番を待ちます (ジョブID: %d, 待機数: %d)", job.ID, waiting)
+	<-waiter.ready // releaseSteamCmd が実行中のジョブとして登録してから通知する
+	return job
+}
+
+// startSteamCmdJobLocked は、ジョブを実行中のジョブとして登録します。
+// steamCmdQueueMutex をロックした状態で呼び出してください。
+func startSteamCmdJobLocked(job *steamCmdJob) {
+	now := time.Now()
+	job.StartedAt = &now
+	steamCmdQueue.Running = job
+}
+
+// releaseSteamCmd は、SteamCMD の実行完了を記録し、次のジョブに実行順を渡します。
+// err は DownloadWorkshopItems が返したエラーです (nil なら成功として数えます)。
+func releaseSteamCmd(job *steamCmdJob, err error) {
+	steamCmdQueueMutex.Lock()
+	now := time.Now()
+	if job.StartedAt != nil {
+		metricSteamCmdDuration.observe(now.Sub(*job.StartedAt).Seconds()) // metrics.go
+	}
+	steamCmdQueue.LastFinishedAt = &now
+	if err != nil {
+		metricSteamCmdRuns.inc("error")
+		steamCmdQueue.FailedJobs++
+		steamCmdQueue.LastError = err.Error()
+	} else {
+		metricSteamCmdRuns.inc("success")
+		steamCmdQueue.CompletedJobs++
+	}
+	if steamCmdQueue.Running == job {
+		steamCmdQueue.Running = nil
+	}
+	// 待機中の先頭のジョブに実行順を渡す
+	if len(steamCmdWaiters) > 0 {
+		next := steamCmdWaiters[0]
+		steamCmdWaiters = steamCmdWaiters[1:]
+		steamCmdQueue.Waiting = steamCmdQueue.Waiting[1:]
+		startSteamCmdJobLocked(next.job)
+		close(next.ready)
+	}
+	steamCmdQueueMutex.Unlock()
+}
+
+// getSteamCmdQueueState は、SteamCMD 実行キューの状態のコピーを返します。
+func getSteamCmdQueueState() steamCmdQueueState {
+	steamCmdQueueMutex.Lock()
+	defer steamCmdQueueMutex.Unlock()
+	state := steamCmdQueue
+	state.Waiting = append([]steamCmdJob{}, steamCmdQueue.Waiting...)
+	if steamCmdQueue.Running != nil {
+		running := *steamCmdQueue.Running
+		state.Running = &running
+	}
+	return state
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// --- WebSocket 接続の TLS 設定 ---
+// Bot サーバーへの wss:// 接続で、次の TLS 設定を使用できるようにします。
+//   - 独自の CA 証明書 (WS_TLS_CA_FILE): プライベート CA が発行したサーバー証明書を信頼する
+//   - クライアント証明書 (WS_TLS_CERT_FILE / WS_TLS_KEY_FILE): 相互 TLS (mTLS) で SWSC を認証する
+//   - 公開鍵のピン留め (WS_TLS_PINNED_SPKI): サーバー証明書チェーンの公開鍵 (SPKI) の SHA-256 ハッシュが一致しなければ接続しない
+//   - 最小 TLS バージョン (WS_TLS_MIN_VERSION)
+// 証明書ファイルは接続のたびに読み込むため、証明書を更新した場合は次回の再接続から有効になります。
+// 証明書の検証に失敗した場合は、再試行しても成功しないため、トークン拒否と同様に再接続を停止します (reconnect.go)。
+
+// errTLSConfig は、TLS 設定 (証明書ファイルなど) を読み込めなかったことを示すエラーです。
+var errTLSConfig = errors.New("TLS 設定エラー")
+
+// errCertificatePinMismatch は、サーバー証明書の公開鍵がピン留めしたハッシュと一致しなかったことを示すエラーです。
+var errCertificatePinMismatch = errors.New("サーバー証明書の公開鍵がピン留めしたハッシュと一致しません")
+
+// tlsVersions は、WS_TLS_MIN_VERSION に指定できる値と TLS バージョンの対応です。
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion は、"1.2" などの文字列を TLS バージョンに変換します。
+func parseTLSVersion(value string) (uint16, error) {
+	version, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(strings.TrimSpace(value)), "tls")]
+	if !ok {
+		return 0, fmt.Errorf("'%s' は TLS バージョンとして不正です (1.0 / 1.1 / 1.2 / 1.3)", value)
+	}
+	return version, nil
+}
+
+// parseSPKIPin は、ピン留めする公開鍵のハッシュ ("sha256/<Base64>" または Base64) を検証し、Base64 文字列を返します。
+func parseSPKIPin(value string) (string, error) {
+	pin := strings.TrimPrefix(strings.TrimSpace(value), "sha256/")
+	hash, err := base64.StdEncoding.DecodeString(pin)
+	if err != nil || len(hash) != sha256.Size {
+		return "", fmt.Errorf("'%s' は SPKI の SHA-256 ハッシュ (Base64) として不正です", value)
+	}
+	return pin, nil
+}
+
+// spkiHash は、証明書の公開鍵 (SubjectPublicKeyInfo) の SHA-256 ハッシュを Base64 で返します。
+func spkiHash(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+// buildWebSocketTLSConfig は、TLS 設定から WebSocket ダイアラー用の *tls.Config を作成します。
+// ConnectWebSocket で接続のたびに呼び出されます (設定の検証時にも buildTLSSettings から呼び出されます)。
+// Args:
+//
+//	settings (TLSSettings): TLS 設定。
+//
+// Returns:
+//
+//	*tls.Config: ダイアラーに設定する TLS 設定。
+//	error: 証明書ファイルを読み込めない場合は errTLSConfig をラップしたエラー。
+func buildWebSocketTLSConfig(settings TLSSettings) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: settings.MinVersion}
+
+	if settings.CAFile != "" {
+		pem, err := os.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: CA 証明書を読み込めません (%s): %v", errTLSConfig, settings.CAFile, err)
+		}
+		// システムの CA に加えて独自の CA を信頼する
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%w: CA 証明書ファイルに PEM 形式の証明書がありません (%s)", errTLSConfig, settings.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if settings.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: クライアント証明書を読み込めません (%s / %s): %v", errTLSConfig, settings.CertFile, settings.KeyFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if len(settings.PinnedSPKI) > 0 {
+		pins := settings.PinnedSPKI
+		// 通常の証明書検証 (CA・ホスト名・有効期限) に加えて、検証済みの証明書チェーンのいずれかの公開鍵がピンと一致することを確認する
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyPinnedChains(state, pins)
+		}
+	}
+	return tlsConfig, nil
+}
+
+// verifyPinnedChains は、検証済みの証明書チェーン (VerifiedChains) のいずれかに、ピン留めした公開鍵の証明書が含まれることを確認します。
+// サーバーが送信した証明書の一覧 (PeerCertificates) は検証されていないため使用しません。
+// (CA が誤発行した証明書のチェーンに、公開されている正規の証明書を余分に付け加えてピンを通過する攻撃を防ぐため)
+func verifyPinnedChains(state tls.ConnectionState, pins []string) error {
+	for _, chain := range state.VerifiedChains {
+		for _, cert := range chain {
+			hash := spkiHash(cert)
+			for _, pin := range pins {
+				if hash == pin {
+					return nil
+				}
+			}
+		}
+	}
+	if len(state.PeerCertificates) > 0 {
+		return fmt.Errorf("%w (サーバー証明書の SPKI: sha256/%s)", errCertificatePinMismatch, spkiHash(state.PeerCertificates[0]))
+	}
+	return errCertificatePinMismatch
+}
+
+// certificateAlerts は、接続先が証明書を理由に拒否したことを示す TLS アラートのメッセージです。
+// (crypto/tls はアラートを非公開の型で返すため、メッセージで判定する)
+var certificateAlerts = []string{
+	"tls: bad certificate",
+	"tls: unsupported certificate",
+	"tls: revoked certificate",
+	"tls: expired certificate",
+	"tls: unknown certificate",
+	"tls: unknown certificate authority",
+	"tls: certificate required",
+}
+
+// isCertificateError は、接続エラーが証明書の検証失敗 (サーバー証明書の検証・ピン留め・クライアント証明書の拒否・
+// 証明書ファイルの読み込み失敗) によるものかどうかを判定します。
+func isCertificateError(err error) bool {
+	var (
+		certVerifyErr  *tls.CertificateVerificationError
+		unknownAuthErr x509.UnknownAuthorityError
+		hostnameErr    x509.HostnameError
+		certInvalidErr x509.CertificateInvalidError
+	)
+	if errors.Is(err, errTLSConfig) || errors.Is(err, errCertificatePinMismatch) ||
+		errors.As(err, &certVerifyErr) || errors.As(err, &unknownAuthErr) ||
+		errors.As(err, &hostnameErr) || errors.As(err, &certInvalidErr) {
+		return true
+	}
+	message := err.Error()
+	for _, alert := range certificateAlerts {
+		if strings.Contains(message, "remote error: "+alert) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatTLSSettings は、TLS 設定をログ表示用の文字列にします。
+func formatTLSSettings(settings TLSSettings) string {
+	var parts []string
+	for name, version := range tlsVersions {
+		if version == settings.MinVersion {
+			parts = append(parts, "最小バージョン=TLS "+name)
+		}
+	}
+	if settings.CAFile != "" {
+		parts = append(parts, "CA="+settings.CAFile)
+	}
+	if settings.CertFile != "" {
+		parts = append(parts, "クライアント証明書="+settings.CertFile)
+	}
+	if len(settings.PinnedSPKI) > 0 {
+		parts = append(parts, fmt.Sprintf("ピン留め=%d 件", len(settings.PinnedSPKI)))
+	}
+	return strings.Join(parts, ", ")
+}
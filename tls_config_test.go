@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCertificate は、テスト用に発行した証明書と秘密鍵です。
+type testCertificate struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// issueTestCertificate は、テスト用の証明書を発行します (parent が nil の場合は自己署名の CA)。
+func issueTestCertificate(t *testing.T, commonName string, parent *testCertificate) testCertificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{commonName}
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return testCertificate{cert: cert, der: der, key: key}
+}
+
+// handshakeWithChain は、chain を提示するサーバーに buildWebSocketTLSConfig の設定で接続し、ハンドシェイクの結果を返します。
+func handshakeWithChain(t *testing.T, ca testCertificate, chain [][]byte, key *ecdsa.PrivateKey, pins []string) error {
+	t.Helper()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	clientConfig, err := buildWebSocketTLSConfig(TLSSettings{CAFile: caFile, PinnedSPKI: pins, MinVersion: tls.VersionTLS12})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig.ServerName = "bot.swsc.test"
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{{Certificate: chain, PrivateKey: key}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_ = conn.(*tls.Conn).Handshake()
+	}()
+
+	clientConn, err := net.DialTimeout("tcp", listener.Addr().String(), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	_ = clientConn.SetDeadline(time.Now().Add(5 * time.Second))
+	return tls.Client(clientConn, clientConfig).Handshake()
+}
+
+func TestCertificatePinning(t *testing.T) {
+	ca := issueTestCertificate(t, "SWSC Test CA", nil)
+	leaf := issueTestCertificate(t, "bot.swsc.test", &ca)
+	// 正規のサーバーの証明書 (公開されているため、攻撃者も入手できる)
+	pinnedCA := issueTestCertificate(t, "Pinned CA", nil)
+	pinned := issueTestCertificate(t, "bot.swsc.test", &pinnedCA)
+
+	tests := []struct {
+		name    string
+		chain   [][]byte
+		pins    []string
+		wantErr bool
+	}{
+		{"リーフ証明書のピンと一致", [][]byte{leaf.der}, []string{spkiHash(leaf.cert)}, false},
+		{"CA 証明書のピンと一致", [][]byte{leaf.der}, []string{spkiHash(ca.cert)}, false},
+		{"ピンと一致しない", [][]byte{leaf.der}, []string{spkiHash(pinned.cert)}, true},
+		// 検証済みのチェーンに含まれない証明書を余分に付け加えても、ピンは通過しない
+		{"ピン留めした証明書を余分に付加", [][]byte{leaf.der, pinned.der}, []string{spkiHash(pinned.cert)}, true},
+		{"ピン留めした CA 証明書を余分に付加", [][]byte{leaf.der, pinnedCA.der}, []string{spkiHash(pinnedCA.cert)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := handshakeWithChain(t, ca, tt.chain, leaf.key, tt.pins)
+			if tt.wantErr {
+				if !errors.Is(err, errCertificatePinMismatch) {
+					t.Fatalf("ピンの不一致で拒否されるべきですが、結果は %v でした", err)
+				}
+				if !isCertificateError(err) {
+					t.Errorf("isCertificateError(%v) = false", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("接続できるべきですが、エラーになりました: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// --- macOS キーチェーン / Linux Secret Service のキーリング ---
+// 認証トークンを、macOS では security コマンドでキーチェーンに、
+// Linux などでは secret-tool コマンド (libsecret) で Secret Service (GNOME Keyring / KWallet など) に保管します。
+// コマンドがない環境 (ヘッドレスのサーバーなど) では errKeyringUnavailable を返し、ローカルファイルに保管します。
+
+// commandKeyring は、OS のコマンドを使用するキーリングです。
+type commandKeyring struct {
+	command string // security または secret-tool のパス
+}
+
+// newOSKeyring は、OS のキーリングを返します。対応するコマンドがない場合は errKeyringUnavailable を返します。
+func newOSKeyring() (tokenKeyring, error) {
+	name := "secret-tool"
+	if runtime.GOOS == "darwin" {
+		name = "security"
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s が見つかりません", errKeyringUnavailable, name)
+	}
+	return commandKeyring{command: path}, nil
+}
+
+func (k commandKeyring) Name() string {
+	if runtime.GOOS == "darwin" {
+		return "macOS キーチェーン"
+	}
+	return "Secret Service (secret-tool)"
+}
+
+func (k commandKeyring) Get(account string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command(k.command, "find-generic-password", "-s", keyringService, "-a", account, "-w")
+	} else {
+		cmd = exec.Command(k.command, "lookup", "service", keyringService, "account", account)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if strings.Contains(stderr.String(), "could not be found") {
+		return "", errKeyringNotFound // security は見つからない場合にこのメッセージを出力する
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && strings.TrimSpace(stderr.String()) == "" {
+			return "", errKeyringNotFound // secret-tool は見つからない場合に何も出力せず終了コード 1 で終了する
+		}
+		return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	token := strings.TrimSpace(string(output))
+	if token == "" {
+		return "", errKeyringNotFound
+	}
+	return token, nil
+}
+
+func (k commandKeyring) Set(account string, token string) error {
+	if runtime.GOOS == "darwin" {
+		return k.setKeychain(account, token)
+	}
+	cmd := exec.Command(k.command, "store", "--label=SWSC 認証トークン ("+account+")", "service", keyringService, "account", account)
+	cmd.Stdin = strings.NewReader(token)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// securityMaxCommandLength は、security の対話モード (-i) が1行で受け付けるコマンドの最大長です。
+const securityMaxCommandLength = 4096
+
+// setKeychain は、macOS のキーチェーンにトークンを保管します。
+// add-generic-password はパスワードを引数でしか受け取らず、引数は ps などで他のユーザーからも見えるため、
+// security の対話モード (-i) を起動してコマンドを標準入力から渡します (-X: パスワードを16進数で指定、-U: 既存の項目を更新)。
+// 対話モードはコマンドが失敗しても終了コードで通知しないため、保管後に読み出して確認します。
+func (k commandKeyring) setKeychain(account string, token string) error {
+	if strings.ContainsAny(account, "'\r\n") {
+		return fmt.Errorf("アカウント名に使用できない文字が含まれています: %q", account)
+	}
+	command := fmt.Sprintf("add-generic-password -U -s '%s' -a '%s' -X %s\n", keyringService, account, hex.EncodeToString([]byte(token)))
+	if len(command) > securityMaxCommandLength {
+		return fmt.Errorf("トークンが長すぎるため、キーチェーンに保管できません (%d バイト)", len(token))
+	}
+	cmd := exec.Command(k.command, "-i")
+	cmd.Stdin = strings.NewReader(command)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+	}
+	stored, err := k.Get(account)
+	if err != nil {
+		return fmt.Errorf("キーチェーンへの保管を確認できません: %w", err)
+	}
+	if stored != token {
+		return fmt.Errorf("キーチェーンへの保管を確認できません: 読み出したトークンが一致しません")
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// --- Windows 資格情報マネージャーのキーリング ---
+// 認証トークンを Windows 資格情報マネージャーの汎用資格情報 (ターゲット名: swsc:<アカウント>) として保管します。
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead  = advapi32.NewProc("CredReadW")
+	procCredWrite = advapi32.NewProc("CredWriteW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1    // CRED_TYPE_GENERIC
+	credPersistLocalMachine = 2    // CRED_PERSIST_LOCAL_MACHINE (ログオンセッションをまたいで保持)
+	errorNotFound           = 1168 // ERROR_NOT_FOUND
+)
+
+// winCredential は、Win32 の CREDENTIALW 構造体です。
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// windowsCredentialKeyring は、Windows 資格情報マネージャーを使用するキーリングです。
+type windowsCredentialKeyring struct{}
+
+// newOSKeyring は、Windows 資格情報マネージャーのキーリングを返します。
+func newOSKeyring() (tokenKeyring, error) {
+	if err := procCredRead.Find(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errKeyringUnavailable, err)
+	}
+	return windowsCredentialKeyring{}, nil
+}
+
+func (windowsCredentialKeyring) Name() string {
+	return "Windows 資格情報マネージャー"
+}
+
+func (windowsCredentialKeyring) Get(account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(keyringService + ":" + account)
+	if err != nil {
+		return "", err
+	}
+	var credential *winCredential
+	ret, _, callErr := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&credential)))
+	if ret == 0 {
+		var errno syscall.Errno
+		if errors.As(callErr, &errno) && errno == errorNotFound {
+			return "", errKeyringNotFound
+		}
+		return "", fmt.Errorf("CredReadW: %v", callErr)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(credential)))
+	if credential.CredentialBlobSize == 0 || credential.CredentialBlob == nil {
+		return "", errKeyringNotFound
+	}
+	return string(unsafe.Slice(credential.CredentialBlob, credential.CredentialBlobSize)), nil
+}
+
+func (windowsCredentialKeyring) Set(account string, token string) error {
+	target, err := syscall.UTF16PtrFromString(keyringService + ":" + account)
+	if err != nil {
+		return err
+	}
+	userName, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	blob := []byte(token)
+	credential := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           userName,
+	}
+	ret, _, callErr := procCredWrite.Call(uintptr(unsafe.Pointer(&credential)), 0)
+	if ret == 0 {
+		return fmt.Errorf("CredWriteW: %v", callErr)
+	}
+	return nil
+}
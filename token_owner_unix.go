@@ -0,0 +1,22 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkSecretFileOwner は、トークンを保管するファイルの所有者が SWSC を実行しているユーザーであることを確認します。
+// 他のユーザーが作成したファイルは、所有者のみに制限されていても内容を書き換えられるおそれがあるため使用しません。
+func checkSecretFileOwner(path string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if uid := os.Geteuid(); int(stat.Uid) != uid {
+		return fmt.Errorf("'%s' の所有者 (UID %d) が SWSC の実行ユーザー (UID %d) と異なるため使用できません", path, stat.Uid, uid)
+	}
+	return nil
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// --- 認証トークンの保管とローテーション ---
+// 実行ファイルの隣にある .env の平文の TOKEN 以外に、次の方法で認証トークンを読み込めるようにします (上から優先)。
+//   - OS のキーリング (TOKEN_KEYRING=true): Windows 資格情報マネージャー / macOS キーチェーン / Linux の Secret Service。
+//     キーリングを使用できない環境では、パーミッションを制限したローカルファイル (TOKEN_FILE、省略時は ./.swsc_token) に保管します。
+//   - ファイル (TOKEN_FILE): 所有者以外が読み書きできるパーミッションの場合と、所有者が実行ユーザーと異なる場合は読み込みを拒否します (Unix 系のみ)。
+//   - ファイルディスクリプタ (TOKEN_FD): systemd の LoadCredential や親プロセスから渡されたパイプなど。起動時に一度だけ読み込みます。
+//   - 環境変数・.env・設定ファイルの TOKEN (従来どおり)
+// Bot から "rotateToken" 要求を受信した場合は、新しいトークンを保管先に書き込んでから設定を入れ替え、
+// 新しいトークンで再接続します。
+
+// トークンの読み込み元 (TokenSourceSettings.Kind)
+const (
+	tokenSourceEnv     = "env"     // 環境変数・.env・設定ファイルの TOKEN
+	tokenSourceFile    = "file"    // TOKEN_FILE
+	tokenSourceFD      = "fd"      // TOKEN_FD
+	tokenSourceKeyring = "keyring" // OS のキーリング (使用できない場合はファイル)
+)
+
+// defaultTokenFallbackFile は、キーリングを使用できない場合にトークンを保管するファイルの既定のパスです。
+const defaultTokenFallbackFile = "./.swsc_token"
+
+// keyringService は、キーリングにトークンを保管する際のサービス名です。
+const keyringService = "swsc"
+
+// errKeyringUnavailable は、OS のキーリングを使用できない (対応するコマンドや API がない) ことを示すエラーです。
+var errKeyringUnavailable = errors.New("OS のキーリングを使用できません")
+
+// errKeyringNotFound は、キーリングにトークンが保管されていないことを示すエラーです。
+var errKeyringNotFound = errors.New("キーリングにトークンが保管されていません")
+
+// tokenKeyring は、OS のキーリングへのアクセスを抽象化したインターフェースです。
+// OS ごとの実装は token_keyring_windows.go / token_keyring_unix.go にあります。
+type tokenKeyring interface {
+	// Name は、ログ表示用のキーリングの名前を返します。
+	Name() string
+	// Get は、アカウントのトークンを返します。保管されていない場合は errKeyringNotFound を返します。
+	Get(account string) (string, error)
+	// Set は、アカウントのトークンを保管 (上書き) します。
+	Set(account string, token string) error
+}
+
+// fileTokenKeyring は、トークンをパーミッションを制限したローカルファイルに保管するキーリングです (OS のキーリングが使用できない場合)。
+// ファイルには、アカウント名をキーとしたトークンの JSON オブジェクトを保存します。
+type fileTokenKeyring struct {
+	path string
+}
+
+func (k fileTokenKeyring) Name() string {
+	return "ローカルファイル (" + k.path + ")"
+}
+
+func (k fileTokenKeyring) Get(account string) (string, error) {
+	tokens, err := k.load()
+	if err != nil {
+		return "", err
+	}
+	token, ok := tokens[account]
+	if !ok || token == "" {
+		return "", errKeyringNotFound
+	}
+	return token, nil
+}
+
+func (k fileTokenKeyring) Set(account string, token string) error {
+	tokens, err := k.load()
+	if err != nil {
+		return err
+	}
+	tokens[account] = token
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(k.path, content)
+}
+
+// load は、保管ファイルを読み込みます。ファイルが存在しない場合は空のマップを返します。
+func (k fileTokenKeyring) load() (map[string]string, error) {
+	tokens := make(map[string]string)
+	content, err := readSecretFile(k.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return tokens, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, fmt.Errorf("トークン保管ファイル '%s' の解析に失敗しました: %w", k.path, err)
+	}
+	return tokens, nil
+}
+
+var (
+	// fdTokens は、TOKEN_FD から読み込んだトークンです (キー: FD 番号)。FD は一度しか読めないため、設定の再読み込みでは再利用します。
+	fdTokens = make(map[int]string)
+	// rotatedToken は、保管先に書き込めない読み込み元 (環境変数・FD) の場合に、rotateToken で受け取ったトークンです。
+	// 設定の再読み込みで古いトークンに戻らないよう、SWSC の終了まで優先して使用します。
+	rotatedToken string
+	// tokenMutex は、fdTokens と rotatedToken を保護するためのミューテックスです。
+	tokenMutex sync.Mutex
+)
+
+// checkSecretFilePermissions は、トークンを保管するファイルのパーミッションを検証します。
+// Unix 系では、所有者以外に読み書きの権限がある場合 (例: 0644) と、所有者が実行ユーザーと異なる場合はエラーにします。
+// Windows ではファイルのパーミッションを表現できないため (ACL で管理する)、通常のファイルであることのみ確認します。
+func checkSecretFilePermissions(path string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("'%s' は通常のファイルではありません", path)
+	}
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("'%s' のパーミッション (%04o) は所有者以外もアクセスできるため使用できません。chmod 600 で所有者のみに制限してください", path, perm)
+	}
+	return checkSecretFileOwner(path, info) // token_owner_*.go
+}
+
+// readSecretFile は、パーミッションを検証してからトークンを保管するファイルを読み込みます。
+// 検証と読み込みの間にファイルを差し替えられないよう、一度開いたファイルに対して検証と読み込みを行います。
+// シンボリックリンクの場合は、リンク先のファイルを検証します。
+func readSecretFile(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSecretFilePermissions(path, info); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(file)
+}
+
+// writeSecretFile は、所有者のみが読み書きできるパーミッション (0600) でファイルを置き換えます。
+// 一時ファイルに書き込んでから置き換えるため、書き込み途中で終了しても以前の内容は失われません。
+func writeSecretFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0600); err != nil {
+		return err
+	}
+	if err := os.Chmod(tempPath, 0600); err != nil { // 既存の一時ファイルが残っていた場合に備える
+		return err
+	}
+	return os.Rename(tempPath, path)
+}
+
+// readTokenFile は、TOKEN_FILE からトークンを読み込みます (前後の空白・改行は取り除きます)。
+func readTokenFile(path string) (string, error) {
+	content, err := readSecretFile(path)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("'%s' にトークンが書かれていません", path)
+	}
+	return token, nil
+}
+
+// readTokenFD は、TOKEN_FD で指定されたファイルディスクリプタからトークンを読み込みます。
+// FD は一度しか読めないため、読み込んだトークンを記録し、2回目以降 (設定の再読み込み) は記録した値を返します。
+func readTokenFD(fd int) (string, error) {
+	tokenMutex.Lock()
+	defer tokenMutex.Unlock()
+	if token, ok := fdTokens[fd]; ok {
+		return token, nil
+	}
+	file := os.NewFile(uintptr(fd), "token-fd-"+strconv.Itoa(fd))
+	if file == nil {
+		return "", fmt.Errorf("ファイルディスクリプタ %d を開けません", fd)
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, 64*1024))
+	if err != nil {
+		return "", fmt.Errorf("ファイルディスクリプタ %d の読み込みに失敗しました: %w", fd, err)
+	}
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return "", fmt.Errorf("ファイルディスクリプタ %d からトークンを読み込めませんでした", fd)
+	}
+	fdTokens[fd] = token
+	return token, nil
+}
+
+// openTokenKeyring は、OS のキーリングを返します。使用できない場合はローカルファイルのキーリングを返します。
+func openTokenKeyring(settings TokenSourceSettings) tokenKeyring {
+	fallback := fileTokenKeyring{path: settings.Path}
+	keyring, err := newOSKeyring() // token_keyring_windows.go / token_keyring_unix.go
+	if err != nil {
+		configLog.debugf("%v。トークンはローカルファイル (%s) に保管します", err, settings.Path)
+		return fallback
+	}
+	return fallbackTokenKeyring{primary: keyring, fallback: fallback}
+}
+
+// fallbackTokenKeyring は、OS のキーリングにアクセスできない場合 (D-Bus のセッションがないなど) に、
+// ローカルファイルのキーリングを使用するキーリングです。
+type fallbackTokenKeyring struct {
+	primary  tokenKeyring
+	fallback fileTokenKeyring
+}
+
+func (k fallbackTokenKeyring) Name() string {
+	return k.primary.Name()
+}
+
+func (k fallbackTokenKeyring) Get(account string) (string, error) {
+	token, err := k.primary.Get(account)
+	if err == nil || errors.Is(err, errKeyringNotFound) {
+		if err != nil {
+			// 以前にローカルファイルへ保管したトークンがあればそれを使う
+			if fallbackToken, fallbackErr := k.fallback.Get(account); fallbackErr == nil {
+				return fallbackToken, nil
+			}
+		}
+		return token, err
+	}
+	configLog.warnf("%s にアクセスできないため、%s を使用します: %v", k.primary.Name(), k.fallback.Name(), err)
+	return k.fallback.Get(account)
+}
+
+func (k fallbackTokenKeyring) Set(account string, token string) error {
+	err := k.primary.Set(account, token)
+	if err == nil {
+		return nil
+	}
+	configLog.warnf("%s に保管できないため、%s に保管します: %v", k.primary.Name(), k.fallback.Name(), err)
+	return k.fallback.Set(account, token)
+}
+
+// loadAuthToken は、トークンの読み込み元の設定に従って認証トークンを読み込みます。buildConfig から呼び出されます。
+// Args:
+//
+//	settings (TokenSourceSettings): トークンの読み込み元。
+//	plainToken (string): 環境変数・.env・設定ファイルの TOKEN の値。キーリングが空の場合はこの値をキーリングに移します。
+//
+// Returns:
+//
+//	string: 認証トークン。
+//	error: 読み込みに失敗した場合のエラー。
+func loadAuthToken(settings TokenSourceSettings, plainToken string) (string, error) {
+	tokenMutex.Lock()
+	override := rotatedToken
+	tokenMutex.Unlock()
+	if override != "" && (settings.Kind == tokenSourceEnv || settings.Kind == tokenSourceFD) {
+		return override, nil
+	}
+
+	switch settings.Kind {
+	case tokenSourceFile:
+		return readTokenFile(settings.Path)
+	case tokenSourceFD:
+		return readTokenFD(settings.FD)
+	case tokenSourceKeyring:
+		keyring := openTokenKeyring(settings)
+		token, err := keyring.Get(settings.Account)
+		if errors.Is(err, errKeyringNotFound) && plainToken != "" {
+			// 初回: 平文の TOKEN をキーリングに移す
+			if err := keyring.Set(settings.Account, plainToken); err != nil {
+				return "", fmt.Errorf("トークンを %s に保管できません: %w", keyring.Name(), err)
+			}
+			configLog.warnf("'%s' のトークンを %s に保管しました。.env や設定ファイルから TOKEN を削除してください。", tokenEnvKey, keyring.Name())
+			return plainToken, nil
+		}
+		if err != nil {
+			return "", fmt.Errorf("%s からトークンを読み込めません: %w", keyring.Name(), err)
+		}
+		return token, nil
+	default:
+		return plainToken, nil
+	}
+}
+
+// storeRotatedToken は、新しいトークンをトークンの読み込み元に書き込みます。
+// 書き込めない読み込み元 (環境変数・FD) の場合は、SWSC の終了までメモリ上でのみ保持します。
+// Returns:
+//
+//	bool: 読み込み元に保存した場合は true (SWSC の再起動後も新しいトークンが使われる)。
+//	error: 書き込みに失敗した場合のエラー (この場合はトークンを入れ替えない)。
+func storeRotatedToken(settings TokenSourceSettings, token string) (bool, error) {
+	switch settings.Kind {
+	case tokenSourceFile:
+		if err := writeSecretFile(settings.Path, []byte(token+"\n")); err != nil {
+			return false, fmt.Errorf("'%s' に書き込めません: %w", settings.Path, err)
+		}
+		return true, nil
+	case tokenSourceKeyring:
+		keyring := openTokenKeyring(settings)
+		if err := keyring.Set(settings.Account, token); err != nil {
+			return false, fmt.Errorf("%s に保管できません: %w", keyring.Name(), err)
+		}
+		return true, nil
+	default:
+		tokenMutex.Lock()
+		rotatedToken = token
+		tokenMutex.Unlock()
+		return false, nil
+	}
+}
+
+// formatTokenSource は、トークンの読み込み元をログ表示用の文字列にします。
+func formatTokenSource(settings TokenSourceSettings) string {
+	switch settings.Kind {
+	case tokenSourceFile:
+		return fmt.Sprintf("ファイル (%s: %s)", tokenFileEnvKey, settings.Path)
+	case tokenSourceFD:
+		return fmt.Sprintf("ファイルディスクリプタ (%s: %d)", tokenFDEnvKey, settings.FD)
+	case tokenSourceKeyring:
+		return fmt.Sprintf("キーリング (%s, アカウント: %s)", tokenKeyringEnvKey, settings.Account)
+	default:
+		return tokenEnvKey
+	}
+}
+
+// --- rotateToken 要求 ---
+
+// RotateTokenPayload は、Bot から受信する "rotateToken" 要求のペイロード構造体です。
+type RotateTokenPayload struct {
+	// Token は、新しい認証トークンです。
+	Token string `json:"token"`
+}
+
+// handleRotateTokenRequest は、Bot からの rotateToken 要求を処理します。
+// 新しいトークンを読み込み元に書き込み、設定を入れ替えてから応答を送信し、新しいトークンで再接続します。
+// Args:
+//
+//	requestID (string): 要求ID。
+//	payload (json.RawMessage): RotateTokenPayload の JSON。
+func handleRotateTokenRequest(requestID string, payload json.RawMessage) {
+	reqLog := configLog.withRequest(requestID)
+	reqLog.infof("トークンのローテーション要求を受信")
+
+	var data RotateTokenPayload
+	if err := json.Unmarshal(payload, &data); err != nil {
+		sendResponse(requestID, false, fmt.Sprintf("ペイロードの解析に失敗しました: %v", err), "") // websocket_client.go
+		return
+	}
+	token := strings.TrimSpace(data.Token)
+	if token == "" {
+		sendResponse(requestID, false, "新しいトークンが指定されていません。", "")
+		return
+	}
+
+	// 設定の再読み込みと同時に入れ替えないよう、再読み込みと同じロックで保護する (config_reload.go)
+	reloadMutex.Lock()
+	cfg := currentConfig()
+	if token == cfg.AuthToken {
+		reloadMutex.Unlock()
+		sendResponse(requestID, true, "新しいトークンは現在のトークンと同じです。変更はありません。", "")
+		return
+	}
+	persisted, err := storeRotatedToken(cfg.TokenSource, token)
+	if err != nil {
+		reloadMutex.Unlock()
+		reqLog.errorf("新しいトークンを保存できませんでした: %v", err)
+		sendResponse(requestID, false, fmt.Sprintf("新しいトークンを保存できませんでした (トークンは変更していません): %v", err), "")
+		return
+	}
+	rotated := *cfg
+	rotated.AuthToken = token
+	applyConfig(&rotated) // config.go
+	reloadMutex.Unlock()
+
+	message := fmt.Sprintf("トークンを入れ替えました (保存先: %s)。新しいトークンで再接続します。", formatTokenSource(cfg.TokenSource))
+	if !persisted {
+		message = fmt.Sprintf("トークンを入れ替えました。新しいトークンで再接続します。%s から読み込んだトークンは書き換えられないため、SWSC を再起動する前に更新してください。",
+			formatTokenSource(cfg.TokenSource))
+		reqLog.warnf("%s", message)
+	} else {
+		reqLog.infof("%s", message)
+	}
+	recordEvent("tokenRotated", "", message) // activity.go
+
+	// 応答を送信してから切断し、新しいトークンで直ちに再接続する (reconnect.go)
+	sendResponse(requestID, true, message, "")
+	requestImmediateReconnect()
+}
@@ -1,6 +1,9 @@
 package main
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 // --- WebSocket通信で使用するJSONメッセージ構造体定義 ---
 // SWSC (Goクライアント) と Bot/WebSocketサーバー間で送受信されるデータ型を定義します。
@@ -19,6 +22,17 @@ type WsMessage struct {
 	// 型は json.RawMessage であり、具体的な内容は Type によって異なります。
 	// 受信側で適切な構造体にアンマーシャルして使用します。
 	Payload json.RawMessage `json:"payload"`
+
+	// Version は、送信側のプロトコルバージョンです (protocol.go)。
+	// バージョン導入前の相手は送信しないため、省略されている場合はバージョン 1 として扱います。
+	Version int `json:"version,omitempty"`
+
+	// Timestamp、Nonce、Signature は、メッセージ署名 (signing.go) が有効な場合に設定されます。
+	// Timestamp は送信時刻 (UNIX ミリ秒)、Nonce はメッセージごとに一意なランダム文字列、
+	// Signature は type・requestId・timestamp・nonce・ペイロードのハッシュに対する HMAC-SHA256 署名です。
+	Timestamp int64  `json:"timestamp,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 // StartServerPayload は、"startServer" 要求メッセージのペイロード構造体です。
@@ -31,7 +45,20 @@ type StartServerPayload struct {
 	// Config は、サーバーの設定ファイル (server_config.xml) の内容を含むXML文字列です。
 	// この時点では、<playlists> と <mods> タグにはWorkshop IDのみが含まれている想定です。
 	// SWSC側でポート番号の割り当てと、Workshopアイテムのダウンロード/パス解決が行われます。
+	// BackupID または SaveName を指定した場合は省略でき、その場合はバックアップ内の設定ファイルを使用します。
 	Config string `json:"config"`
+
+	// BackupID は、起動前に設定ディレクトリへ復元するバックアップのID (listBackups の backupId) です (backup.go)。
+	// 省略した場合は復元しません (SaveName のみ指定した場合は、その構成名の最新のバックアップを復元します)。
+	BackupID string `json:"backupId,omitempty"`
+
+	// SaveName は、復元するバックアップを作成したサーバー構成名です。省略した場合は Name と同じ構成名のバックアップを探します。
+	// 別の構成名で保存したセーブデータから起動する場合に指定します。
+	SaveName string `json:"saveName,omitempty"`
+
+	// Schedules は、このサーバーの定期的な再起動・停止・起動の予定です (schedule.go)。
+	// 指定した場合は、起動に成功した時点でこのサーバーの予定をすべて置き換えます ([] で全削除、省略時は変更しません)。
+	Schedules []ServerSchedule `json:"schedules,omitempty"`
 }
 
 // StopServerPayload は、"stopServer" 要求メッセージのペイロード構造体です。
@@ -42,7 +69,7 @@ type StopServerPayload struct {
 
 	// Confirmed は、プレイヤーがサーバーに接続している可能性がある場合に、停止を確認済みかどうかを示すフラグです。
 	// true であれば、プレイヤー数に関わらず停止処理を進めます。
-	// false の場合、SWSCはプレイヤー数を確認し、0人でなければ確認要求応答を返すことがあります (※現在の実装ではプレイヤー確認はダミー)。
+	// false の場合、SWSCはゲームサーバーの出力から追跡しているプレイヤー数を確認し (players.go)、0人でなければ確認要求応答を返します。
 	Confirmed bool `json:"confirmed"`
 }
 
@@ -54,6 +81,15 @@ type SyncStatusPayload struct {
 
 	// MaxServers は、SWSCの設定 (ポート範囲など) から計算された、同時に起動可能なサーバーの最大数です。
 	MaxServers int `json:"maxServers"`
+
+	// ProtocolVersion は、SWSCが実装しているプロトコルバージョンです (protocol.go)。
+	ProtocolVersion int `json:"protocolVersion"`
+
+	// Capabilities は、SWSCが対応している機能 (受信できる要求タイプなど) の一覧です。
+	Capabilities []string `json:"capabilities"`
+
+	// SignatureRequired は、SWSC が要求メッセージの署名を必須としているかどうかです (signing.go)。
+	SignatureRequired bool `json:"signatureRequired"`
 }
 
 // ResponsePayload は、"response" メッセージのペイロード構造体です。
@@ -77,7 +113,21 @@ type ResponsePayload struct {
 	// 失敗がなかった場合は省略されます (omitempty)。Botはこの情報を使ってユーザーに通知できます。
 	FailedItemIDs []string `json:"failedItemIDs,omitempty"` // ★ ステップ2で追加
 
-	// --- stopServer時のプレイヤー確認用フィールド (現在はダミー実装) ---
+	// UnknownPaths は、stopServer で返却する設定ファイル内に、Workshop ID に戻せなかったホスト依存の可能性があるパスが
+	// 含まれていた場合に、そのパスのリストを示します。問題がなければ省略されます (omitempty)。
+	UnknownPaths []string `json:"unknownPaths,omitempty"`
+
+	// BackupID は、stopServer で設定ディレクトリを削除する前に作成したバックアップのID、
+	// または startServer で起動前に復元したバックアップのIDです (backup.go)。該当しない場合は省略されます (omitempty)。
+	BackupID string `json:"backupId,omitempty"`
+
+	// Backups は、listBackups 要求に対するバックアップの一覧です (新しい順)。
+	Backups []BackupInfo `json:"backups,omitempty"`
+
+	// Schedules は、setSchedules / listSchedules 要求に対する予定の一覧です (schedule.go)。
+	Schedules []ScheduleInfo `json:"schedules,omitempty"`
+
+	// --- stopServer時のプレイヤー確認用フィールド (プレイヤー数は players.go で追跡) ---
 	// NeedsConfirmation は、stopServer 要求時に Confirmed=false であり、かつプレイヤーが存在する場合に true となり、Botに追加確認を促します。
 	NeedsConfirmation bool `json:"needsConfirmation,omitempty"`
 	// Players は、NeedsConfirmation が true の場合に、検出されたプレイヤー数を示します。
@@ -85,6 +135,89 @@ type ResponsePayload struct {
 	// -------------------------------------------------------------
 }
 
+// ListBackupsPayload は、"listBackups" 要求メッセージのペイロード構造体です。
+// Botがバックアップの一覧を要求する際に使用します (backup.go)。
+type ListBackupsPayload struct {
+	// Name は、一覧を取得するサーバー構成名です。省略した場合は全ての構成名のバックアップを返します。
+	Name string `json:"name,omitempty"`
+}
+
+// RestoreBackupPayload は、"restoreBackup" 要求メッセージのペイロード構造体です。
+// Botが停止中のサーバーの設定ディレクトリをバックアップから復元する際に使用します (backup.go)。
+type RestoreBackupPayload struct {
+	// Name は、復元するサーバーの構成名です。
+	Name string `json:"name"`
+
+	// BackupID は、復元するバックアップのID (listBackups で取得した backupId) です。
+	BackupID string `json:"backupId"`
+}
+
+// BackupInfo は、バックアップ1件の情報です。listBackups 要求の応答に含まれます。
+type BackupInfo struct {
+	// BackupID は、バックアップの一意なID (例: "20261018-123456-stop") です。
+	BackupID string `json:"backupId"`
+	// ServerName は、バックアップしたサーバーの構成名です。
+	ServerName string `json:"serverName"`
+	// Reason は、バックアップの作成理由 (stop / scheduled / prerestore) です。
+	Reason string `json:"reason"`
+	// CreatedAt は、バックアップの作成時刻です。
+	CreatedAt time.Time `json:"createdAt"`
+	// Size は、アーカイブのサイズ (バイト) です。
+	Size int64 `json:"size"`
+	// Files は、アーカイブに含まれるファイル数です。
+	Files int `json:"files"`
+}
+
+// ServerSchedule は、サーバーの定期的な操作 (再起動・停止・起動) の予定1件です (schedule.go)。
+type ServerSchedule struct {
+	// ID は、サーバー構成名ごとに一意な予定のIDです。省略した場合は "<action>-<番号>" が割り当てられます。
+	ID string `json:"id,omitempty"`
+	// Action は、実行する操作 ("restart" / "stop" / "start") です。
+	Action string `json:"action"`
+	// Cron は、実行する日時を表す cron 形式 (分 時 日 月 曜日) の文字列です (例: "0 4 * * *" は毎日 4:00)。
+	// @hourly / @daily / @weekly / @monthly も指定できます。時刻は SWSC を実行しているホストのタイムゾーンです。
+	Cron string `json:"cron"`
+	// Warnings は、実行前に serverScheduleWarning イベントを送信するタイミング (実行の何分前か、例: ["10m", "1m"]) です。
+	// 省略 (null) した場合は SCHEDULE_WARNINGS の設定を使用し、[] の場合は予告しません。
+	Warnings []string `json:"warnings"`
+	// Config は、action が "start" の場合に使用する設定ファイル (server_config.xml) の内容です。
+	// 省略した場合は、このサーバー構成名の最新のバックアップから復元して起動します (backup.go)。
+	Config string `json:"config,omitempty"`
+}
+
+// SetSchedulesPayload は、"setSchedules" 要求メッセージのペイロード構造体です。
+// 指定したサーバー構成名の予定をすべて置き換えます (Schedules が空の場合は全削除)。
+type SetSchedulesPayload struct {
+	// Name は、予定を設定するサーバーの構成名です。
+	Name string `json:"name"`
+	// Schedules は、新しい予定の一覧です。
+	Schedules []ServerSchedule `json:"schedules"`
+}
+
+// ListSchedulesPayload は、"listSchedules" 要求メッセージのペイロード構造体です。
+type ListSchedulesPayload struct {
+	// Name は、予定を一覧するサーバーの構成名です。省略した場合は全サーバー構成名の予定を返します。
+	Name string `json:"name,omitempty"`
+}
+
+// ScheduleInfo は、予定1件の情報です。setSchedules / listSchedules 要求の応答に含まれます。
+type ScheduleInfo struct {
+	// ServerName は、予定を設定したサーバーの構成名です。
+	ServerName string `json:"serverName"`
+	// ID は、予定のIDです。
+	ID string `json:"id"`
+	// Action は、実行する操作 ("restart" / "stop" / "start") です。
+	Action string `json:"action"`
+	// Cron は、実行する日時を表す cron 形式の文字列です。
+	Cron string `json:"cron"`
+	// Warnings は、実行前に予告するタイミングです (SCHEDULE_WARNINGS の設定を反映した値)。
+	Warnings []string `json:"warnings"`
+	// HasConfig は、起動用の設定ファイルが保存されているかどうかです (設定ファイルの内容は応答に含めません)。
+	HasConfig bool `json:"hasConfig,omitempty"`
+	// NextRun は、次に実行する時刻です。
+	NextRun time.Time `json:"nextRun"`
+}
+
 // StatusUpdatePayload は、"statusUpdate" メッセージのペイロード構造体です。 // ★ ステップ2で追加
 // startServer 中のワークショップダウンロードなど、時間のかかる処理の進捗状況をBotに通知するために使用します。
 type StatusUpdatePayload struct {
@@ -96,11 +229,52 @@ type StatusUpdatePayload struct {
 	Message string `json:"message"`
 }
 
+// ServerStatsPayload は、"serverStats" メッセージのペイロード構造体です。
+// STATS_INTERVAL ごとに、実行中の各サーバープロセスのリソース使用量をBotに通知するために使用します (stats.go)。
+type ServerStatsPayload struct {
+	// SampledAt は、リソース使用量を取得した時刻です。
+	SampledAt time.Time `json:"sampledAt"`
+
+	// IntervalSeconds は、取得の間隔 (秒) です。
+	IntervalSeconds float64 `json:"intervalSeconds"`
+
+	// Backend は、リソース使用量の取得方法です (例: "procfs", "windows")。
+	Backend string `json:"backend"`
+
+	// Servers は、実行中のサーバーごとのリソース使用量です (構成名順)。
+	Servers []ServerStats `json:"servers"`
+}
+
+// ServerStats は、ゲームサーバープロセス1つ分のリソース使用量です。
+type ServerStats struct {
+	ServerName    string  `json:"serverName"`
+	Pid           int     `json:"pid"`
+	Port          int     `json:"port"`
+	UptimeSeconds int64   `json:"uptimeSeconds"`
+	Players       int     `json:"players"`     // ゲームサーバーの出力から追跡しているプレイヤー数 (players.go)
+	CPUPercent    float64 `json:"cpuPercent"`  // 前回の取得からのCPU使用率 (1コアを使い切ると 100)
+	MemoryBytes   uint64  `json:"memoryBytes"` // 常駐メモリ (RSS / ワーキングセット)
+	Threads       int     `json:"threads"`
+	OpenFiles     int     `json:"openFiles"`           // 開いているファイル (Windows ではハンドル) の数。取得できない場合は -1
+	Processes     int     `json:"processes,omitempty"` // 集計したプロセスの数 (Wine などの子プロセスを含む)
+
+	// Error は、リソース使用量を取得できなかった場合の理由です (この場合、使用量の各値は 0 です)。
+	Error string `json:"error,omitempty"`
+}
+
 // ErrorResponsePayload は、"error" メッセージのペイロード構造体です。
 // 特定のリクエストに対応しない一般的なエラー (例: 不正なメッセージ形式) をBotに通知するために使用します。
 type ErrorResponsePayload struct {
 	// Message は、発生したエラーの内容を示すメッセージです。
 	Message string `json:"message"`
+
+	// Code は、エラーの種類を示すコードです (例: "unsupported_message_type")。
+	// 一般的なエラーでは省略されます (omitempty)。
+	Code string `json:"code,omitempty"`
+
+	// ProtocolVersion と Capabilities は、機能エラーの場合に SWSC の対応状況を Bot に伝えるために設定されます。
+	ProtocolVersion int      `json:"protocolVersion,omitempty"`
+	Capabilities    []string `json:"capabilities,omitempty"`
 }
 
 // --- サーバーイベント送信用ペイロード ---
@@ -115,8 +289,23 @@ type ServerCrashDetectedPayload struct {
 	ServerName string `json:"serverName"`
 	// Pid は、クラッシュしたプロセスのプロセスIDです。
 	Pid int `json:"pid"`
+	// Reason は、終了の理由です。"crashed" (予期せぬ終了) または "oomKilled" (cgroup のメモリの上限を超えて OOM killer により強制終了された、cgroup.go)。
+	Reason string `json:"reason"`
 	// Error は、プロセス終了時に取得されたエラーメッセージ (空の場合もあり) です。
 	Error string `json:"error"`
+	// ExitCode は、プロセスの終了コードです (シグナルで終了した場合などは -1)。
+	ExitCode int `json:"exitCode"`
+	// CrashDir は、クラッシュダンプやログを保存したクラッシュフォルダのパスです (crash.go)。
+	CrashDir string `json:"crashDir,omitempty"`
+	// DumpPath は、収集したクラッシュダンプ (複数ある場合は最新のもの) のパスです。ダンプがない場合は空です。
+	DumpPath string `json:"dumpPath,omitempty"`
+	// DumpSize は、DumpPath のクラッシュダンプのサイズ (バイト) です。
+	DumpSize int64 `json:"dumpSize,omitempty"`
+	// DumpSummary は、クラッシュダンプの解析結果の要約です (例: "server64.exe+0x173AE8 で EXCEPTION_ACCESS_VIOLATION (0xC0000005) が発生しました (スレッド 63176, アドレス 0x10 の読み取り)")。
+	// 解析できなかった場合は空です (minidump.go)。
+	DumpSummary string `json:"dumpSummary,omitempty"`
+	// LogTail は、終了直前のゲームサーバーのコンソール出力 (stdout / stderr) の末尾です。
+	LogTail []string `json:"logTail,omitempty"`
 }
 
 // ServerRestartResultPayload は、クラッシュしたサーバーの自動再起動試行結果を通知する際のイベントペイロードです。
@@ -131,4 +320,65 @@ type ServerRestartResultPayload struct {
 	Message string `json:"message"`
 }
 
+// ServerIdleStoppedPayload は、プレイヤーがいない状態が続いたサーバーを自動停止した際のイベントペイロードです (players.go)。
+// stopServer の応答と同様に、停止したサーバーの設定ファイルを含みます。
+type ServerIdleStoppedPayload struct {
+	// EventType は、イベントの種類を示す固定文字列 "serverIdleStopped" です。
+	EventType string `json:"eventType"`
+	// ServerName は、停止したサーバーの構成名です。
+	ServerName string `json:"serverName"`
+	// IdleSeconds は、停止するまでにプレイヤーが0人だった時間 (秒) です。
+	IdleSeconds int `json:"idleSeconds"`
+	// Message は、Bot に表示するためのメッセージです。
+	Message string `json:"message"`
+	// Config は、停止したサーバーの設定ファイルの内容です (Workshop ID に戻したもの、読み込みに失敗した場合は空)。
+	Config string `json:"config,omitempty"`
+	// UnknownPaths は、Workshop ID に戻せなかったパスのリストです。
+	UnknownPaths []string `json:"unknownPaths,omitempty"`
+	// BackupID は、停止時に作成したバックアップのIDです (backup.go)。
+	BackupID string `json:"backupId,omitempty"`
+}
+
+// ServerScheduleWarningPayload は、予定された操作 (schedule.go) の実行前に、プレイヤーへの周知のために送信するイベントペイロードです。
+type ServerScheduleWarningPayload struct {
+	// EventType は、イベントの種類を示す固定文字列 "serverScheduleWarning" です。
+	EventType string `json:"eventType"`
+	// ServerName は、操作の対象となるサーバーの構成名です。
+	ServerName string `json:"serverName"`
+	// ScheduleID は、予定のIDです。
+	ScheduleID string `json:"scheduleId"`
+	// Action は、実行する操作 ("restart" / "stop" / "start") です。
+	Action string `json:"action"`
+	// ScheduledAt は、操作を実行する時刻です。
+	ScheduledAt time.Time `json:"scheduledAt"`
+	// SecondsLeft は、実行までの残り時間 (秒) です。
+	SecondsLeft int `json:"secondsLeft"`
+	// Message は、Bot に表示するためのメッセージです。
+	Message string `json:"message"`
+}
+
+// ServerScheduledActionPayload は、予定された操作 (schedule.go) を実行した結果を通知するイベントペイロードです。
+type ServerScheduledActionPayload struct {
+	// EventType は、イベントの種類を示す固定文字列 "serverScheduledAction" です。
+	EventType string `json:"eventType"`
+	// ServerName は、操作の対象となったサーバーの構成名です。
+	ServerName string `json:"serverName"`
+	// ScheduleID は、予定のIDです。
+	ScheduleID string `json:"scheduleId"`
+	// Action は、実行した操作 ("restart" / "stop" / "start") です。
+	Action string `json:"action"`
+	// Result は、実行結果 ("success" / "failure" / "skipped") です。
+	// サーバーが既に停止している場合の stop など、操作が不要だった場合は "skipped" になります。
+	Result string `json:"result"`
+	// Message は、実行結果に関するメッセージです。
+	Message string `json:"message"`
+	// Config は、停止・再起動で停止したサーバーの設定ファイルの内容です (Workshop ID に戻したもの、停止していない場合は空)。
+	// stopServer の応答と同様に、Bot はこの内容を次回の起動に使用できます。
+	Config string `json:"config,omitempty"`
+	// UnknownPaths は、Workshop ID に戻せなかったパスのリストです。
+	UnknownPaths []string `json:"unknownPaths,omitempty"`
+	// BackupID は、停止時に作成したバックアップのIDです (backup.go)。
+	BackupID string `json:"backupId,omitempty"`
+}
+
 // ---------------------------------------------
\ No newline at end of file
@@ -2,8 +2,8 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"sync"
 	"time"
@@ -14,10 +14,12 @@ import (
 // --- グローバル変数 ---
 
 // conn は現在アクティブなWebSocket接続を保持します。
-// connMutex は conn 変数へのアクセスを保護するためのミューテックスです。
+// connWriter は conn への書き込みを担当するゴルーチンです (ws_writer.go)。接続への書き込みは必ず connWriter を経由します。
+// connMutex は conn と connWriter 変数へのアクセスを保護するためのミューテックスです。
 var (
-	conn      *websocket.Conn
-	connMutex sync.Mutex
+	conn       *websocket.Conn
+	connWriter *wsWriter
+	connMutex  sync.Mutex
 )
 
 // --- 主要関数 ---
@@ -32,64 +34,100 @@ var (
 func ConnectWebSocket() error {
 	// HTTPヘッダーに認証トークンを設定
 	header := http.Header{}
-	header.Add("Authorization", "Bearer "+AuthToken) // config.go の AuthToken を使用
+	cfg := currentConfig() // config.go
+	header.Add("Authorization", "Bearer "+cfg.AuthToken) // config.go の AuthToken を使用
+
+	// TLS 設定 (独自の CA・クライアント証明書・公開鍵のピン留め・最小バージョン、tls_config.go)
+	// 証明書ファイルは接続のたびに読み込む (更新した証明書を次回の再接続から使用する)
+	tlsConfig, err := buildWebSocketTLSConfig(cfg.TLS)
+	if err != nil {
+		return &wsDialError{Err: err}
+	}
 
 	// WebSocketダイアラーの設定
 	dialer := websocket.Dialer{
 		Proxy:            http.ProxyFromEnvironment, // 環境変数のプロキシ設定を使用
 		HandshakeTimeout: 45 * time.Second,          // 接続タイムアウト
+		TLSClientConfig:  tlsConfig,
 	}
 
-	log.Printf("[WebSocket] 接続試行中: %s", WsURL) // config.go の WsURL を使用
-	connAttempt, resp, err := dialer.Dial(WsURL, header)
+	wsLog.infof("接続試行中: %s", cfg.WsURL) // config.go の WsURL を使用
+	connAttempt, resp, err := dialer.Dial(cfg.WsURL, header)
 
 	// 接続エラーハンドリング
 	if err != nil {
 		// HTTPレスポンスがある場合 (認証失敗など)
 		if resp != nil {
-			log.Printf("[WebSocket] HTTPエラー応答: %d %s", resp.StatusCode, resp.Status)
+			wsLog.errorf("HTTPエラー応答: %d %s", resp.StatusCode, resp.Status)
 			// 認証失敗 (401 Unauthorized) の場合
 			if resp.StatusCode == http.StatusUnauthorized {
-				log.Println("[WebSocket] 認証失敗。トークンを確認してください。")
+				wsLog.warnf("認証失敗。トークンを確認してください。")
 				// 認証失敗を示す特別なエラーを返す (リトライ停止のため)
 				return &websocket.CloseError{Code: TokenRejectedCode, Text: "認証失敗"}
 			}
+			return &wsDialError{StatusCode: resp.StatusCode, Err: err} // reconnect.go
 		}
-		// その他の接続エラー
-		return fmt.Errorf("WebSocket接続エラー: %w", err)
+		// その他の接続エラー (DNS / TLS / タイムアウトなどは reconnect.go で分類)
+		return &wsDialError{Err: err}
 	}
 
 	// 接続成功
-	log.Println("[WebSocket] 接続成功！")
+	wsLog.infof("接続成功！")
+	recordEvent("websocketConnected", "", cfg.WsURL) // activity.go
+	metricWebSocketConnections.inc()                 // metrics.go
+	markWebSocketConnected()                         // reconnect.go
+	resetBotProtocol()                               // protocol.go (connected を受信するまでは旧バージョンの Bot とみなす)
+
+	// 接続への書き込みを1つのゴルーチンに直列化する (ws_writer.go)
+	writer := newWsWriter(connAttempt)
 
 	// グローバル変数に接続を保存 (ミューテックスで保護)
 	connMutex.Lock()
 	conn = connAttempt
+	connWriter = writer
 	connMutex.Unlock()
 
 	// CloseハンドラとPingハンドラを設定
 	conn.SetCloseHandler(handleClose)
 	conn.SetPingHandler(handlePing)
+	// SWSC からの Ping 送信と読み取り期限の設定 (heartbeat.go)
+	stopHeartbeat := startHeartbeat(connAttempt, writer)
+	defer close(stopHeartbeat)
 
 	// 接続確立後、現在のサーバー状態を通知する syncStatus を送信
 	err = sendSyncStatus()
 	if err != nil {
-		log.Printf("[WebSocket] エラー: syncStatus 送信失敗: %v", err)
+		wsLog.errorf("syncStatus 送信失敗: %v", err)
 		connAttempt.Close() // 送信失敗なら接続を切る
+		clearConnection(writer)
+		return err
+	}
+	// 切断中に送信できなかったメッセージを発生順に再送 (outbox.go)
+	if err := flushOutbox(writer); err != nil {
+		connAttempt.Close() // 再送失敗なら接続を切り、次回の再接続時に再送する
+		clearConnection(writer)
 		return err
 	}
 
 	// メッセージ読み取りループを開始 (この関数はここでブロックされる)
-	readMessages(connAttempt)
+	readErr := readMessages(connAttempt)
 
 	// --- readMessages ループが終了した場合 (接続切断時) ---
 	connMutex.Lock()
 	conn = nil // グローバル変数をクリア
+	connWriter = nil
 	connMutex.Unlock()
-	log.Println("[WebSocket] 接続が切断されました。")
+	writer.close() // 書き込み待ちのメッセージは送信エラーとなり、送信待ちキューに保持される (outbox.go)
+	resetBotProtocol() // protocol.go (切断中は Bot の対応機能が不明なため、新しい機能のイベントは再接続後に判定する)
+	wsLog.infof("接続が切断されました。")
+	recordEvent("websocketDisconnected", "", cfg.WsURL)
 
 	// 接続終了時の後処理とエラー返却
 	closeErr := connAttempt.Close() // 念のため閉じる試行
+	if errors.Is(readErr, errHeartbeatTimeout) {
+		// ハートビートの応答がなく切断した場合は、その旨を返す (reconnect.go で分類)
+		return readErr
+	}
 	if closeErr == nil {
 		// readMessagesが正常終了し、Closeもエラーなしの場合
 		return fmt.Errorf("接続が正常に閉じられました")
@@ -98,6 +136,22 @@ func ConnectWebSocket() error {
 	return closeErr
 }
 
+// clearConnection は、接続の確立処理中に失敗した場合に、グローバル変数をクリアして書き込み用ゴルーチンを停止します。
+func clearConnection(writer *wsWriter) {
+	connMutex.Lock()
+	conn = nil
+	connWriter = nil
+	connMutex.Unlock()
+	writer.close()
+}
+
+// isWebSocketConnected は、現在 WebSocket サーバーに接続しているかどうかを返します。
+func isWebSocketConnected() bool {
+	connMutex.Lock()
+	defer connMutex.Unlock()
+	return conn != nil
+}
+
 // --- WebSocketイベントハンドラ ---
 
 // handleClose はWebSocket接続が閉じたときに呼び出されるハンドラです。
@@ -111,10 +165,10 @@ func ConnectWebSocket() error {
 //	error: トークン拒否の場合はリトライ停止のため *websocket.CloseError を返します。
 //	       それ以外は一般的なエラーを返します。
 func handleClose(code int, text string) error {
-	log.Printf("[WebSocket] 接続 Close: Code=%d, Reason=%s", code, text)
+	wsLog.infof("接続 Close: Code=%d, Reason=%s", code, text)
 	// トークン拒否コード (1008) の場合
 	if code == TokenRejectedCode {
-		log.Println("[WebSocket] 認証トークンがサーバーに拒否されました。接続リトライを停止します。")
+		wsLog.infof("認証トークンがサーバーに拒否されました。接続リトライを停止します。")
 		// リトライを停止させるために、特定のCloseErrorを返す
 		return &websocket.CloseError{Code: code, Text: text}
 	}
@@ -135,19 +189,21 @@ func handlePing(appData string) error {
 	// 現在の接続を取得 (ミューテックスで保護)
 	connMutex.Lock()
 	currentConn := conn
+	currentWriter := connWriter
 	connMutex.Unlock()
-	if currentConn == nil {
+	if currentConn == nil || currentWriter == nil {
 		return fmt.Errorf("Ping受信時に接続が存在しません")
 	}
 
-	// Pongメッセージを送信 (WriteControlを使用)
+	// Pongメッセージを送信 (書き込み用ゴルーチンがメッセージより優先して書き込む、ws_writer.go)
 	// Pongには受信したPingと同じデータを含める
-	err := currentConn.WriteControl(websocket.PongMessage, []byte(appData), time.Now().Add(5*time.Second)) // タイムアウト設定
-	if err != nil {
-		log.Printf("[WebSocket] Pong送信エラー: %v", err)
-		return err
+	// このハンドラは読み取りループ内で呼び出されるため、書き込みの完了は待たない
+	if !currentWriter.writeControl(websocket.PongMessage, []byte(appData), "pong") {
+		wsLog.errorf("Pong送信エラー: 送信キューに追加できません")
+		return nil // Pong を返せなくても読み取りは続ける (接続が失われていればハートビートで検出される)
 	}
-	log.Println("[WebSocket] Ping受信、Pong送信完了。") // Pong送信ログ
+	extendReadDeadline(currentConn) // サーバーからの Ping も接続が生きている証拠として扱う (heartbeat.go)
+	wsLog.debugf("Ping受信、Pong送信を予約しました。") // Pong送信ログ
 	return nil
 }
 
@@ -158,52 +214,115 @@ func handlePing(appData string) error {
 // Args:
 //
 //	currentConn (*websocket.Conn): メッセージを読み取る対象のWebSocket接続。
-func readMessages(currentConn *websocket.Conn) {
-	defer log.Println("[WebSocket] メッセージ読み取りループ終了。")
+//
+// Returns:
+//
+//	error: ループを終了した原因の読み取りエラー。ハートビートの応答がなかった場合は errHeartbeatTimeout をラップしたエラー。
+func readMessages(currentConn *websocket.Conn) error {
+	defer wsLog.infof("メッセージ読み取りループ終了。")
 
 	for {
 		// メッセージの読み取り (ブロックする)
 		messageType, message, err := currentConn.ReadMessage()
 		if err != nil {
+			// 読み取り期限の超過は、Pong もメッセージも届かない (接続が失われた) ことを示す (heartbeat.go)
+			if isHeartbeatTimeout(err) {
+				wsLog.warnf("ハートビートの応答がないため、接続が失われたとみなして切断します: %v", err)
+				return fmt.Errorf("%w: %v", errHeartbeatTimeout, err)
+			}
 			// 読み取りエラー (接続切断など) が発生したらループを抜ける
-			log.Printf("[WebSocket] メッセージ読み取りエラー: %v", err)
+			wsLog.errorf("メッセージ読み取りエラー: %v", err)
 			// エラー発生時は ConnectWebSocket() 関数側で後処理される
-			return
+			return err
 		}
+		extendReadDeadline(currentConn) // メッセージを受信できたので読み取り期限を延長 (heartbeat.go)
 
 		// テキストメッセージの場合のみ処理
 		if messageType == websocket.TextMessage {
 			var msg WsMessage // 汎用メッセージ構造体
 			// JSONデコード試行
 			if err := json.Unmarshal(message, &msg); err != nil {
-				log.Printf("[WebSocket] JSONデコード失敗: %v, 受信メッセージ: %s", err, string(message))
+				wsLog.warnf("JSONデコード失敗: %v, 受信メッセージ: %s", err, string(message))
 				// 不正なメッセージに対するエラー応答を試みる
 				sendErrorResponse(msg.RequestID, fmt.Sprintf("不正なJSON形式です: %v", err))
 				continue // 次のメッセージへ
 			}
 
-			log.Printf("[WebSocket] メッセージ受信: Type=%s, RequestID=%s", msg.Type, msg.RequestID)
+			wsLog.infof("メッセージ受信: Type=%s, RequestID=%s", msg.Type, msg.RequestID)
+			metricWebSocketMessages.inc("received", msg.Type) // metrics.go
+
+			// SIGNING_KEY が設定されている場合は、要求を実行する前に署名・タイムスタンプ・ノンスを検証する (signing.go)
+			// 重複要求の記録 (idempotency.go) より先に検証し、偽造された要求が記録に残らないようにする
+			if err := verifyMessageSignature(msg); err != nil {
+				rejectUnverifiedMessage(msg, err)
+				continue
+			}
 
 			// メッセージタイプに応じて処理を振り分け (各処理はゴルーチンで非同期実行)
 			switch msg.Type {
 			case "startServer":
 				// ゲームサーバー起動要求 -> process_manager へ処理委譲
+				// 同じ要求IDの要求を既に受信している場合は再実行しない (idempotency.go)
+				if !claimRequest(msg.RequestID, msg.Type) {
+					continue
+				}
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload) // activity.go
 				go handleStartServerProcess(msg.RequestID, msg.Payload) // process_manager.go の関数
 			case "stopServer":
 				// ゲームサーバー停止要求 -> process_manager へ処理委譲
+				if !claimRequest(msg.RequestID, msg.Type) {
+					continue
+				}
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload)
 				go handleStopServerProcess(msg.RequestID, msg.Payload) // process_manager.go の関数
+			case "reloadConfig":
+				// 設定の再読み込み要求 -> config_reload へ処理委譲
+				if !claimRequest(msg.RequestID, msg.Type) {
+					continue
+				}
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload)
+				go handleReloadConfigRequest(msg.RequestID, msg.Payload) // config_reload.go の関数
+			case "rotateToken":
+				// 認証トークンの入れ替え要求 -> token_store へ処理委譲 (応答後に新しいトークンで再接続する)
+				if !claimRequest(msg.RequestID, msg.Type) {
+					continue
+				}
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload)
+				go handleRotateTokenRequest(msg.RequestID, msg.Payload) // token_store.go の関数
+			case "listBackups":
+				// バックアップ一覧の要求 -> backup へ処理委譲 (参照のみのため重複チェックは不要)
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload)
+				go handleListBackupsRequest(msg.RequestID, msg.Payload) // backup.go の関数
+			case "restoreBackup":
+				// バックアップの復元要求 -> backup へ処理委譲
+				if !claimRequest(msg.RequestID, msg.Type) {
+					continue
+				}
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload)
+				go handleRestoreBackupRequest(msg.RequestID, msg.Payload) // backup.go の関数
+			case "setSchedules":
+				// 定期的な再起動・停止・起動の予定の設定要求 -> schedule へ処理委譲
+				if !claimRequest(msg.RequestID, msg.Type) {
+					continue
+				}
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload)
+				go handleSetSchedulesRequest(msg.RequestID, msg.Payload) // schedule.go の関数
+			case "listSchedules":
+				// 予定の一覧の要求 -> schedule へ処理委譲 (参照のみのため重複チェックは不要)
+				beginRequest(msg.RequestID, msg.Type, requestSourceWebSocket, msg.Payload)
+				go handleListSchedulesRequest(msg.RequestID, msg.Payload) // schedule.go の関数
 			case "connected":
-				// サーバーからの接続完了通知など (必要に応じて処理)
-				log.Printf("[WebSocket] サーバーからの接続完了通知を受信: %s", string(msg.Payload))
+				// サーバーからの接続完了通知 (Bot のプロトコルバージョンと対応機能を記録する)
+				handleConnectedMessage(msg.Payload) // protocol.go
 			// 他にサーバーから受信するメッセージタイプがあればここに追加
 			default:
 				// 未知のメッセージタイプ
-				log.Printf("[WebSocket] 未対応メッセージタイプ: %s", msg.Type)
-				sendErrorResponse(msg.RequestID, fmt.Sprintf("未対応のメッセージタイプです: %s", msg.Type))
+				// 新しいバージョンの Bot が送る要求の可能性があるため、対応機能の一覧を付けて機能エラーを返す
+				sendCapabilityError(msg.RequestID, msg.Type) // protocol.go
 			}
 		} else {
 			// テキスト以外のメッセージ (バイナリなど) は現在未対応
-			log.Printf("[WebSocket] 未対応メッセージフォーマット(バイナリ等): %d", messageType)
+			wsLog.infof("未対応メッセージフォーマット(バイナリ等): %d", messageType)
 		}
 	}
 }
@@ -218,29 +337,31 @@ func readMessages(currentConn *websocket.Conn) {
 func sendSyncStatus() error {
 	runningServers := getRunningServerNames() // process_manager からリスト取得
 
-	// 設定されたポート範囲から最大同時起動可能数を計算
-	maxServers := 0
-	if MaxPort >= MinPort { // ポート範囲が有効か確認 (config.go の値)
-		maxServers = MaxPort - MinPort
-	} else {
-		log.Printf("[WebSocket] 警告: ポート範囲が無効なため (Min:%d, Max:%d)、最大サーバー数を0として送信します。", MinPort, MaxPort)
+	// 設定されたポートプールから最大同時起動可能数を計算 (config.go の値)
+	portPools := currentConfig().PortPools
+	maxServers := portPoolCapacity(portPools) // port_manager.go
+	if maxServers == 0 {
+		wsLog.warnf("ポート範囲が無効なため (%s)、最大サーバー数を0として送信します。", formatPortPools(portPools))
 	}
 
 	// 送信するペイロードを作成
 	payload := SyncStatusPayload{
-		RunningServers: runningServers,
-		MaxServers:     maxServers,
+		RunningServers:    runningServers,
+		MaxServers:        maxServers,
+		ProtocolVersion:   protocolVersion,
+		Capabilities:      clientCapabilities,
+		SignatureRequired: signingEnabled(),
 	}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
 		// ペイロード構造体の問題である可能性が高い
-		log.Printf("[WebSocket] 致命的エラー: syncStatus ペイロードのエンコードに失敗しました: %v", err)
+		wsLog.errorf("syncStatus ペイロードのエンコードに失敗しました: %v", err)
 		return fmt.Errorf("syncStatus ペイロードのエンコード失敗: %w", err)
 	}
 
 	// WsMessage 構造体を作成して送信
 	syncMsg := WsMessage{Type: "syncStatus", Payload: payloadBytes}
-	log.Printf("[WebSocket] syncStatus 送信: 実行中=%d件, 最大数=%d", len(runningServers), maxServers)
+	wsLog.infof("syncStatus 送信: 実行中=%d件, 最大数=%d", len(runningServers), maxServers)
 	return sendMessage(syncMsg) // 汎用送信関数を呼び出し
 }
 
@@ -255,36 +376,89 @@ func sendSyncStatus() error {
 //
 //	error: 接続が存在しない、エンコード失敗、または送信失敗の場合のエラー。
 func sendMessage(msg WsMessage) error {
-	// 現在の接続を安全に取得
+	// 処理中の要求の進捗と完了を記録する (activity.go)
+	observeOutgoingMessage(msg)
+	// 重複要求に再送できるよう、要求の最終応答を記録する (idempotency.go)
+	recordRequestResult(msg)
+
+	// CLI などのローカル要求宛てのメッセージは、WebSocketではなく呼び出し元に配送する (local_requests.go)
+	if deliverLocalMessage(msg) {
+		return nil
+	}
+
+	// 再送待ちのメッセージがある場合は、送信順序を保つためキューの末尾に追加する (outbox.go)
+	if enqueueIfOutboxPending(msg) {
+		return nil
+	}
+
+	// 現在の接続の書き込み用ゴルーチンを安全に取得
 	connMutex.Lock()
-	currentConn := conn
+	currentWriter := connWriter
 	connMutex.Unlock()
 
-	// 接続が存在しない場合はエラー
-	if currentConn == nil {
-		log.Printf("[WebSocket] 送信エラー: 接続が存在しません。メッセージタイプ: %s", msg.Type)
+	// 接続が存在しない場合は、再接続後に再送するためキューに保持する (CLI の場合はエラー)
+	if currentWriter == nil {
+		if enqueueOutbox(msg) {
+			return nil
+		}
+		wsLog.errorf("送信エラー: 接続が存在しません。メッセージタイプ: %s", msg.Type)
 		return fmt.Errorf("接続が存在しません")
 	}
 
+	err := writeWsMessage(currentWriter, msg)
+	if err != nil && !errors.Is(err, errWsMessageEncode) && enqueueOutbox(msg) {
+		// 送信エラーは接続が切れている可能性を示唆するため、再接続後に再送する
+		return nil
+	}
+	return err
+}
+
+// sendUntrackedMessage は、要求の進捗・完了の記録と送信待ちキューを経由せずに、メッセージを直接送信します。
+// 署名の検証に失敗した要求への応答 (signing.go) など、同じ要求IDの正規の要求の記録を上書きしてはいけない場合に使用します。
+// 接続が存在しない場合は送信しません。
+func sendUntrackedMessage(msg WsMessage) error {
+	connMutex.Lock()
+	currentWriter := connWriter
+	connMutex.Unlock()
+	if currentWriter == nil {
+		return fmt.Errorf("接続が存在しません")
+	}
+	return writeWsMessage(currentWriter, msg)
+}
+
+// errWsMessageEncode は、送信メッセージのエンコードに失敗したことを示すエラーです (再送しても成功しないため、キューに保持しない)。
+var errWsMessageEncode = errors.New("送信メッセージのエンコード失敗")
+
+// writeWsMessage は、メッセージをJSONにエンコードし、接続の書き込み用ゴルーチン (ws_writer.go) を通じて書き込みます。
+// sendMessage と、送信待ちキューの再送 (outbox.go) から呼び出されます。
+func writeWsMessage(currentWriter *wsWriter, msg WsMessage) error {
+	// 送信するすべてのメッセージに、Bot とネゴシエーションしたプロトコルバージョンを付ける (protocol.go)
+	if msg.Version == 0 {
+		msg.Version = negotiatedProtocolVersion()
+	}
+	// SIGNING_KEY が設定されている場合は、タイムスタンプ・ノンス・署名を付ける (signing.go)
+	signOutgoingMessage(&msg)
 	// メッセージをJSONバイト列にエンコード
 	messageBytes, err := json.Marshal(msg)
 	if err != nil {
 		// メッセージ構造体側の問題
-		log.Printf("[WebSocket] 送信メッセージのエンコード失敗 (Type: %s): %v", msg.Type, err)
-		return fmt.Errorf("送信メッセージのエンコード失敗: %w", err)
+		wsLog.warnf("送信メッセージのエンコード失敗 (Type: %s): %v", msg.Type, err)
+		return fmt.Errorf("%w: %v", errWsMessageEncode, err)
 	}
 
 	// WebSocket接続にメッセージを書き込む
-	// WriteMessage はスレッドセーフ（内部でロック）なので、ここでは connMutex 不要
-	err = currentConn.WriteMessage(websocket.TextMessage, messageBytes)
+	// gorilla/websocket は同時書き込みに対応していないため、書き込み用ゴルーチンに渡して完了を待つ
+	err = currentWriter.writeMessage(messageBytes, msg.Type)
 	if err != nil {
-		log.Printf("[WebSocket] メッセージ送信エラー (Type: %s): %v", msg.Type, err)
+		wsLog.errorf("メッセージ送信エラー (Type: %s): %v", msg.Type, err)
 		// 送信エラーは接続が切れている可能性を示唆する
 		return fmt.Errorf("メッセージ送信エラー: %w", err)
 	}
 
+	metricWebSocketMessages.inc("sent", msg.Type) // metrics.go
+
 	// 送信成功ログ (デバッグ時以外はコメントアウト推奨)
-	// log.Printf("[WebSocket] メッセージ送信成功: Type=%s, RequestID=%s", msg.Type, msg.RequestID)
+	// wsLog.infof("メッセージ送信成功: Type=%s, RequestID=%s", msg.Type, msg.RequestID)
 	return nil
 }
 
@@ -318,13 +492,13 @@ func sendResponse(requestID string, success bool, message string, configData str
 	// ペイロードをJSONにエンコード
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("[WebSocket] 応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
+		wsLog.warnf("応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
 		return // エラーログのみで復帰
 	}
 
 	// WsMessage を作成して送信
 	respMsg := WsMessage{Type: "response", RequestID: requestID, Payload: payloadBytes}
-	log.Printf("[WebSocket] 応答送信: ReqID=%s, Success=%v", requestID, success)
+	wsLog.infof("応答送信: ReqID=%s, Success=%v", requestID, success)
 	sendMessage(respMsg)
 }
 
@@ -336,7 +510,8 @@ func sendResponse(requestID string, success bool, message string, configData str
 //	message (string): 成功メッセージ。
 //	assignedPort (int): ゲームサーバーに割り当てられたポート番号。
 //	failedItemIDs ([]string): ワークショップダウンロードに失敗したアイテムIDのリスト (失敗がなければ空)。
-func sendStartSuccessResponse(requestID string, message string, assignedPort int, failedItemIDs []string) {
+//	restoredBackupID (string): 起動前に復元したバックアップのID (復元しなかった場合は空、backup.go)。
+func sendStartSuccessResponse(requestID string, message string, assignedPort int, failedItemIDs []string, restoredBackupID string) {
 	// 応答ペイロードを作成
 	payload := ResponsePayload{
 		Success:      true,         // 成功フラグ
@@ -344,18 +519,91 @@ func sendStartSuccessResponse(requestID string, message string, assignedPort int
 		AssignedPort: assignedPort, // 割り当てポート
 		// ★ ダウンロード失敗リストを設定 (空の場合 omitempty で省略される)
 		FailedItemIDs: failedItemIDs,
+		// 起動前にバックアップから復元した場合はそのID (backup.go)
+		BackupID: restoredBackupID,
 	}
 
 	// ペイロードをJSONにエンコード
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("[WebSocket] 起動成功応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
+		wsLog.warnf("起動成功応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
 		return
 	}
 
 	// WsMessage を作成して送信
 	respMsg := WsMessage{Type: "response", RequestID: requestID, Payload: payloadBytes}
-	log.Printf("[WebSocket] 起動成功応答送信: ReqID=%s, Port=%d, FailedItems=%d", requestID, assignedPort, len(failedItemIDs))
+	wsLog.infof("起動成功応答送信: ReqID=%s, Port=%d, FailedItems=%d", requestID, assignedPort, len(failedItemIDs))
+	sendMessage(respMsg)
+}
+
+// sendStopSuccessResponse は stopServer 要求が正常に完了した場合の応答を送信します。
+// Workshop ID に戻された設定ファイルの内容と、戻せなかったパスのリストを含みます。
+// Args:
+//
+//	requestID (string): 応答対象の元のリクエストID。
+//	message (string): 結果メッセージ。
+//	configData (string): Workshop ID に戻されたサーバー設定XML文字列。
+//	unknownPaths ([]string): Workshop ID に戻せなかったパスのリスト (なければ空)。
+//	backupID (string): 設定ディレクトリを削除する前に作成したバックアップのID (なければ空、backup.go)。
+func sendStopSuccessResponse(requestID string, message string, configData string, unknownPaths []string, backupID string) {
+	// 応答ペイロードを作成
+	payload := ResponsePayload{
+		Success:      true,
+		Message:      message,
+		Config:       configData,
+		UnknownPaths: unknownPaths, // 空の場合 omitempty で省略される
+		BackupID:     backupID,     // バックアップが無効な場合は省略される
+	}
+
+	// ペイロードをJSONにエンコード
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		wsLog.warnf("停止成功応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
+		return
+	}
+
+	// WsMessage を作成して送信
+	respMsg := WsMessage{Type: "response", RequestID: requestID, Payload: payloadBytes}
+	wsLog.infof("停止成功応答送信: ReqID=%s, UnknownPaths=%d", requestID, len(unknownPaths))
+	sendMessage(respMsg)
+}
+
+// sendBackupListResponse は、listBackups 要求に対してバックアップの一覧を応答します (backup.go)。
+// Args:
+//
+//	requestID (string): 応答対象の元のリクエストID。
+//	message (string): Botに表示するためのメッセージ。
+//	backups ([]BackupInfo): バックアップの一覧 (新しい順)。
+func sendBackupListResponse(requestID string, message string, backups []BackupInfo) {
+	payload := ResponsePayload{
+		Success: true,
+		Message: message,
+		Backups: backups,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		wsLog.warnf("バックアップ一覧応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
+		return
+	}
+	respMsg := WsMessage{Type: "response", RequestID: requestID, Payload: payloadBytes}
+	wsLog.infof("バックアップ一覧応答送信: ReqID=%s, Backups=%d", requestID, len(backups))
+	sendMessage(respMsg)
+}
+
+// sendScheduleListResponse は、setSchedules / listSchedules 要求に対して、予定の一覧を含む成功応答を送信します (schedule.go)。
+func sendScheduleListResponse(requestID string, message string, schedules []ScheduleInfo) {
+	payload := ResponsePayload{
+		Success:   true,
+		Message:   message,
+		Schedules: schedules,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		wsLog.warnf("予定一覧応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
+		return
+	}
+	respMsg := WsMessage{Type: "response", RequestID: requestID, Payload: payloadBytes}
+	wsLog.infof("予定一覧応答送信: ReqID=%s, Schedules=%d", requestID, len(schedules))
 	sendMessage(respMsg)
 }
 
@@ -375,13 +623,13 @@ func sendStatusUpdate(requestID string, status string, message string) {
 	// ペイロードをJSONにエンコード
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("[WebSocket] ステータス更新ペイロードエンコード失敗 (ReqID: %s, Status: %s): %v", requestID, status, err)
+		wsLog.warnf("ステータス更新ペイロードエンコード失敗 (ReqID: %s, Status: %s): %v", requestID, status, err)
 		return
 	}
 
 	// WsMessage を作成して送信
 	statusMsg := WsMessage{Type: "statusUpdate", RequestID: requestID, Payload: payloadBytes}
-	log.Printf("[WebSocket] ステータス更新送信: ReqID=%s, Status=%s", requestID, status)
+	wsLog.infof("ステータス更新送信: ReqID=%s, Status=%s", requestID, status)
 	sendMessage(statusMsg)
 }
 
@@ -394,7 +642,7 @@ func sendStatusUpdate(requestID string, status string, message string) {
 func sendErrorResponse(requestID string, errorMessage string) {
 	// requestID がない場合 (どのリクエストに対するエラーか不明な場合) は送信しない
 	if requestID == "" {
-		log.Printf("[WebSocket] エラー応答送信試行 (RequestIDなし): %s", errorMessage)
+		wsLog.errorf("エラー応答送信試行 (RequestIDなし): %s", errorMessage)
 		return
 	}
 
@@ -402,12 +650,12 @@ func sendErrorResponse(requestID string, errorMessage string) {
 	payload := ErrorResponsePayload{Message: errorMessage}
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		log.Printf("[WebSocket] エラー応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
+		wsLog.errorf("エラー応答ペイロードエンコード失敗 (ReqID: %s): %v", requestID, err)
 		return
 	}
 
 	// WsMessage を作成して送信
 	respMsg := WsMessage{Type: "error", RequestID: requestID, Payload: payloadBytes}
-	log.Printf("[WebSocket] エラー応答送信: ReqID=%s", requestID)
+	wsLog.warnf("エラー応答送信: ReqID=%s", requestID)
 	sendMessage(respMsg)
 }
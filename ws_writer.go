@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// --- WebSocket 送信の直列化 ---
+// gorilla/websocket の接続は、複数のゴルーチンからの同時書き込みに対応していません
+// (要求ハンドラ・waitForProcessExit・statusUpdate・Pong・ハートビートが同時に書き込むと panic や
+// フレームの破損が起きる可能性があります)。
+// そのため、接続ごとに1つの書き込み専用ゴルーチン (wsWriter) を起動し、全ての送信をチャネル経由で行います。
+//   - 制御フレーム (Ping / Pong) は専用のチャネルで受け付け、メッセージより優先して書き込みます。
+//   - メッセージのキューが混雑した場合や満杯の場合は警告ログとメトリクスで通知し (バックプレッシャー)、
+//     満杯のまま wsWriteTimeout を過ぎた場合は送信エラーとして呼び出し元に返します (outbox.go でキューに保持)。
+
+const (
+	// wsSendQueueSize は、書き込み待ちメッセージのキューの容量です。
+	wsSendQueueSize = 256
+	// wsControlQueueSize は、書き込み待ち制御フレームのキューの容量です。
+	wsControlQueueSize = 16
+	// wsWriteTimeout は、1回の書き込みと、満杯のキューに空きができるのを待つ時間の上限です。
+	wsWriteTimeout = 10 * time.Second
+)
+
+var (
+	// errWsWriterClosed は、接続が切断され書き込み用ゴルーチンが終了していることを示すエラーです。
+	errWsWriterClosed = errors.New("WebSocket 接続は切断されています")
+	// errWsSendQueueFull は、送信キューが満杯のまま空かなかったことを示すエラーです。
+	errWsSendQueueFull = errors.New("WebSocket 送信キューが満杯です")
+)
+
+// wsWriteRequest は、書き込み用ゴルーチンに渡す書き込み要求1件です。
+type wsWriteRequest struct {
+	frameType int    // websocket.TextMessage / PingMessage / PongMessage
+	data      []byte // 書き込むデータ
+	label     string // ログ表示用の名前 (メッセージタイプ、"ping" など)
+	queuedAt  time.Time
+	result    chan error // 書き込み結果 (制御フレームの場合は nil)
+}
+
+// wsWriter は、1つの WebSocket 接続への書き込みを担当するゴルーチンです。
+type wsWriter struct {
+	conn     *websocket.Conn
+	messages chan *wsWriteRequest
+	control  chan *wsWriteRequest
+	stop     chan struct{} // close で停止を要求する
+	done     chan struct{} // ゴルーチン終了時に閉じられる
+	stopOnce sync.Once
+	err      error // 終了の原因となった書き込みエラー (done が閉じられた後に参照可能)
+}
+
+// wsWriterStats は、送信キューの統計です (管理APIの応答用)。
+type wsWriterStats struct {
+	Queued       int     `json:"queued"`       // 現在の書き込み待ちメッセージ数
+	Capacity     int     `json:"capacity"`     // キューの容量
+	Congested    bool    `json:"congested"`    // キューが混雑しているかどうか
+	MaxWaitMs    float64 `json:"maxWaitMs"`    // キューに入ってから書き込まれるまでの最大待ち時間 (ミリ秒)
+	FullCount    int     `json:"fullCount"`    // キューが満杯で送信を待たされた回数
+	DroppedCount int     `json:"droppedCount"` // キューが満杯で送信できなかったメッセージ・制御フレームの数
+}
+
+var (
+	// writerStats は、送信キューの統計です (接続をまたいで累積)。
+	writerStats wsWriterStats
+	// writerStatsMutex は、writerStats を保護するためのミューテックスです。
+	writerStatsMutex sync.Mutex
+)
+
+// newWsWriter は、接続の書き込み用ゴルーチンを開始します。ConnectWebSocket で接続確立直後に呼び出されます。
+// Args:
+//
+//	currentConn (*websocket.Conn): 書き込み先の WebSocket 接続。
+//
+// Returns:
+//
+//	*wsWriter: 開始した書き込み用ゴルーチン。接続終了時に close を呼び出してください。
+func newWsWriter(currentConn *websocket.Conn) *wsWriter {
+	writer := &wsWriter{
+		conn:     currentConn,
+		messages: make(chan *wsWriteRequest, wsSendQueueSize),
+		control:  make(chan *wsWriteRequest, wsControlQueueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	writerStatsMutex.Lock()
+	writerStats.Queued = 0
+	writerStats.Congested = false
+	writerStatsMutex.Unlock()
+	go writer.run()
+	return writer
+}
+
+// run は、書き込み要求を1件ずつ接続に書き込みます。制御フレームはメッセージより優先します。
+// 書き込みに失敗した場合は接続を閉じて (読み取りループを終了させ、再接続する) 終了します。
+func (writer *wsWriter) run() {
+	defer close(writer.done)
+	for {
+		// 制御フレームが待っていれば、メッセージより先に書き込む
+		select {
+		case request := <-writer.control:
+			if !writer.write(request) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case <-writer.stop:
+			writer.err = errWsWriterClosed
+			return
+		case request := <-writer.control:
+			if !writer.write(request) {
+				return
+			}
+		case request := <-writer.messages:
+			if !writer.write(request) {
+				return
+			}
+		}
+	}
+}
+
+// write は、書き込み要求1件を接続に書き込み、結果を要求元に返します。
+// Returns:
+//
+//	bool: 書き込みに成功した場合は true。失敗した場合は接続を閉じて false を返します。
+func (writer *wsWriter) write(request *wsWriteRequest) bool {
+	wait := time.Since(request.queuedAt)
+	deadline := time.Now().Add(wsWriteTimeout)
+	var err error
+	if request.frameType == websocket.TextMessage {
+		writer.conn.SetWriteDeadline(deadline)
+		err = writer.conn.WriteMessage(request.frameType, request.data)
+		recordWriterProgress(len(writer.messages), wait)
+	} else {
+		err = writer.conn.WriteControl(request.frameType, request.data, deadline)
+	}
+	if request.result != nil {
+		request.result <- err
+	}
+	if err != nil {
+		wsLog.errorf("書き込みエラーのため接続を切断します (%s): %v", request.label, err)
+		writer.err = err
+		writer.conn.Close() // 読み取りループを終了させ、再接続する
+		return false
+	}
+	return true
+}
+
+// close は、書き込み用ゴルーチンを停止し、終了を待ちます。書き込み待ちのメッセージは送信エラーになります。
+func (writer *wsWriter) close() {
+	writer.stopOnce.Do(func() { close(writer.stop) })
+	<-writer.done
+	writerStatsMutex.Lock()
+	writerStats.Queued = 0
+	writerStats.Congested = false
+	writerStatsMutex.Unlock()
+}
+
+// closedError は、書き込み用ゴルーチンが終了している場合の送信エラーを返します。
+func (writer *wsWriter) closedError() error {
+	if writer.err != nil && !errors.Is(writer.err, errWsWriterClosed) {
+		return fmt.Errorf("%w: %v", errWsWriterClosed, writer.err)
+	}
+	return errWsWriterClosed
+}
+
+// writeMessage は、テキストメッセージをキューに追加し、書き込みが完了するまで待ちます。
+// キューが満杯の場合は空きができるまで最大 wsWriteTimeout 待ち、空かなければ errWsSendQueueFull を返します。
+// Args:
+//
+//	data ([]byte): 書き込む JSON データ。
+//	label (string): ログ表示用のメッセージタイプ。
+//
+// Returns:
+//
+//	error: 書き込みに失敗した場合、キューが満杯の場合、または接続が切断されている場合のエラー。
+func (writer *wsWriter) writeMessage(data []byte, label string) error {
+	request := &wsWriteRequest{
+		frameType: websocket.TextMessage,
+		data:      data,
+		label:     label,
+		queuedAt:  time.Now(),
+		result:    make(chan error, 1),
+	}
+
+	select {
+	case writer.messages <- request:
+	case <-writer.done:
+		return writer.closedError()
+	default:
+		// キューが満杯: 書き込み用ゴルーチンが追いつくまで待つ (バックプレッシャー)
+		recordWriterFull(label)
+		timer := time.NewTimer(wsWriteTimeout)
+		defer timer.Stop()
+		select {
+		case writer.messages <- request:
+		case <-writer.done:
+			return writer.closedError()
+		case <-timer.C:
+			recordWriterDropped()
+			return fmt.Errorf("%w (容量: %d 件, Type: %s)", errWsSendQueueFull, wsSendQueueSize, label)
+		}
+	}
+	recordWriterQueued(len(writer.messages))
+
+	select {
+	case err := <-request.result:
+		return err
+	case <-writer.done:
+		// 書き込み直前に終了した場合に備え、結果が届いていればそちらを優先する
+		select {
+		case err := <-request.result:
+			return err
+		default:
+			return writer.closedError()
+		}
+	}
+}
+
+// writeControl は、制御フレーム (Ping / Pong) をキューに追加します。書き込みの完了は待ちません。
+// 読み取りループ内 (Ping ハンドラ) からも呼び出されるため、キューが満杯の場合は待たずに破棄します。
+// Returns:
+//
+//	bool: キューに追加した場合は true。
+func (writer *wsWriter) writeControl(frameType int, data []byte, label string) bool {
+	request := &wsWriteRequest{frameType: frameType, data: data, label: label, queuedAt: time.Now()}
+	select {
+	case writer.control <- request:
+		return true
+	case <-writer.done:
+		return false
+	default:
+		wsLog.warnf("制御フレームの送信キューが満杯のため破棄しました (%s)", label)
+		recordWriterDropped()
+		return false
+	}
+}
+
+// recordWriterQueued は、メッセージをキューに追加した後のキューの長さを記録し、混雑し始めた場合に警告します。
+func recordWriterQueued(queued int) {
+	writerStatsMutex.Lock()
+	defer writerStatsMutex.Unlock()
+	writerStats.Queued = queued
+	if !writerStats.Congested && queued >= wsSendQueueSize*3/4 {
+		writerStats.Congested = true
+		wsLog.warnf("WebSocket 送信キューが混雑しています (%d/%d 件)。回線が遅いか、Bot の受信が追いついていない可能性があります。", queued, wsSendQueueSize)
+	}
+}
+
+// recordWriterProgress は、メッセージを書き込んだ後のキューの長さと待ち時間を記録し、混雑が解消した場合に通知します。
+func recordWriterProgress(queued int, wait time.Duration) {
+	writerStatsMutex.Lock()
+	defer writerStatsMutex.Unlock()
+	writerStats.Queued = queued
+	if waitMs := float64(wait.Microseconds()) / 1000; waitMs > writerStats.MaxWaitMs {
+		writerStats.MaxWaitMs = waitMs
+	}
+	if writerStats.Congested && queued <= wsSendQueueSize/4 {
+		writerStats.Congested = false
+		wsLog.infof("WebSocket 送信キューの混雑が解消しました (%d/%d 件)", queued, wsSendQueueSize)
+	}
+}
+
+// recordWriterFull は、キューが満杯で送信を待たされたことを記録します。
+func recordWriterFull(label string) {
+	writerStatsMutex.Lock()
+	writerStats.FullCount++
+	writerStatsMutex.Unlock()
+	metricWebSocketSendQueueFull.inc() // metrics.go
+	wsLog.warnf("WebSocket 送信キューが満杯です (容量: %d 件)。空きができるまで送信を待ちます (Type: %s)", wsSendQueueSize, label)
+}
+
+// recordWriterDropped は、キューが満杯で送信できなかったことを記録します。
+func recordWriterDropped() {
+	writerStatsMutex.Lock()
+	writerStats.DroppedCount++
+	writerStatsMutex.Unlock()
+}
+
+// getWriterStats は、送信キューの統計のコピーを返します。
+func getWriterStats() wsWriterStats {
+	writerStatsMutex.Lock()
+	defer writerStatsMutex.Unlock()
+	stats := writerStats
+	stats.Capacity = wsSendQueueSize
+	return stats
+}
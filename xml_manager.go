@@ -5,7 +5,6 @@ import (
 	"encoding/xml"
 	"fmt"
 	"io"
-	"log"
 	"path/filepath" // パス結合用
 	"regexp"        // Workshop IDの検証用
 	"strings"       // 文字列操作用
@@ -36,7 +35,7 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 	var skipElement bool         // 現在の要素 (<path>...</path>) をスキップするかどうか
 	var depth int                // XMLの階層深度 (要素スキップ判定用)
 
-	log.Println("[XML管理] ワークショップIDの抽出と該当<path>要素の削除を開始します...")
+	xmlLog.infof("ワークショップIDの抽出と該当<path>要素の削除を開始します...")
 
 	for {
 		token, tokenErr := decoder.Token()
@@ -44,7 +43,7 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 			break // XML終端
 		}
 		if tokenErr != nil {
-			log.Printf("[XML管理] エラー: XMLトークンの読み取りに失敗しました: %v", tokenErr)
+			xmlLog.errorf("XMLトークンの読み取りに失敗しました: %v", tokenErr)
 			return nil, nil, "", fmt.Errorf("XMLトークンの読み取りエラー: %w", tokenErr)
 		}
 
@@ -58,7 +57,7 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 			}
 			if depth == 0 {
 				skipElement = false // スキップ対象要素の終了タグに到達
-				// log.Printf("[XML管理] 要素スキップ終了") // デバッグ用
+				// xmlLog.infof("要素スキップ終了") // デバッグ用
 			}
 			continue // このトークンは出力しない
 		}
@@ -85,15 +84,15 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 							id := attr.Value
 							if inPlaylists {
 								playlistIDs = append(playlistIDs, id)
-								log.Printf("[XML管理] プレイリストID抽出: %s", id)
+								xmlLog.infof("プレイリストID抽出: %s", id)
 							} else { // inMods == true
 								modIDs = append(modIDs, id)
-								log.Printf("[XML管理] MOD ID抽出: %s", id)
+								xmlLog.infof("MOD ID抽出: %s", id)
 							}
 							break // path属性を見つけたらループを抜ける
 						} else {
 							// path属性が数字のみでない場合は、通常のパスとして扱う（削除しない）
-							log.Printf("[XML管理] 通常パス検出（削除対象外）: %s 内の path=\"%s\"", currentTagName, attr.Value)
+							xmlLog.infof("通常パス検出（削除対象外）: %s 内の path=\"%s\"", currentTagName, attr.Value)
 						}
 					}
 				}
@@ -103,11 +102,11 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 				// この <path> 要素はワークショップIDを含むため、全体をスキップする
 				skipElement = true
 				depth = 1 // スキップ開始
-				// log.Printf("[XML管理] ワークショップ<path>要素スキップ開始") // デバッグ用
+				// xmlLog.infof("ワークショップ<path>要素スキップ開始") // デバッグ用
 			} else {
 				// スキップ対象外の開始タグは通常通りエンコード
 				if err := encoder.EncodeToken(token); err != nil {
-					log.Printf("[XML管理] エラー: XMLトークン '%s' のエンコードに失敗しました: %v", currentTagName, err)
+					xmlLog.errorf("XMLトークン '%s' のエンコードに失敗しました: %v", currentTagName, err)
 					return nil, nil, "", fmt.Errorf("XMLトークンのエンコードエラー: %w", err)
 				}
 			}
@@ -116,7 +115,7 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 			currentTagName := se.Name.Local
 			// 通常の終了タグはエンコード
 			if err := encoder.EncodeToken(token); err != nil {
-				log.Printf("[XML管理] エラー: XMLトークン '</%s>' のエンコードに失敗しました: %v", currentTagName, err)
+				xmlLog.errorf("XMLトークン '</%s>' のエンコードに失敗しました: %v", currentTagName, err)
 				return nil, nil, "", fmt.Errorf("XMLトークンのエンコードエラー: %w", err)
 			}
 
@@ -131,7 +130,7 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 			// その他のトークン (コメント、テキストデータなど) はそのままエンコード
 			if err := encoder.EncodeToken(token); err != nil {
 				// CharDataなど、特定のトークンタイプのエラーハンドリングが必要な場合がある
-				log.Printf("[XML管理] エラー: XMLトークン (%T) のエンコードに失敗しました: %v", token, err)
+				xmlLog.errorf("XMLトークン (%T) のエンコードに失敗しました: %v", token, err)
 				return nil, nil, "", fmt.Errorf("XMLトークン (%T) のエンコードエラー: %w", token, err)
 			}
 		}
@@ -139,13 +138,13 @@ func extractWorkshopIDsAndModifyXML(xmlString string) (playlistIDs []string, mod
 
 	// エンコーダーのバッファをフラッシュして書き込みを完了
 	if err := encoder.Flush(); err != nil {
-		log.Printf("[XML管理] エラー: XMLエンコーダーのフラッシュに失敗しました: %v", err)
+		xmlLog.errorf("XMLエンコーダーのフラッシュに失敗しました: %v", err)
 		return nil, nil, "", fmt.Errorf("XMLエンコーダーのフラッシュエラー: %w", err)
 	}
 
 	modifiedXmlString = output.String()
-	log.Printf("[XML管理] ID抽出と要素削除完了。抽出プレイリストID数: %d, 抽出MOD ID数: %d", len(playlistIDs), len(modIDs))
-	// log.Printf("[XML管理] 変更後XML(一部):\n%s", modifiedXmlString[:min(500, len(modifiedXmlString))]) // デバッグ用
+	xmlLog.infof("ID抽出と要素削除完了。抽出プレイリストID数: %d, 抽出MOD ID数: %d", len(playlistIDs), len(modIDs))
+	// xmlLog.infof("変更後XML(一部):\n%s", modifiedXmlString[:min(500, len(modifiedXmlString))]) // デバッグ用
 
 	return playlistIDs, modIDs, modifiedXmlString, nil
 }
@@ -168,10 +167,10 @@ func addWorkshopPathsToXML(xmlString string, successfulPlaylistIDs []string, suc
 	encoder := xml.NewEncoder(&output)
 	encoder.Indent("", "  ") // ※ インデント設定
 
-	log.Println("[XML管理] ダウンロード成功したアイテムの<path>要素をXMLに追加します...")
-	log.Printf("[XML管理]   成功プレイリストID数: %d", len(successfulPlaylistIDs))
-	log.Printf("[XML管理]   成功MOD ID数: %d", len(successfulModIDs))
-	log.Printf("[XML管理]   設定ディレクトリ絶対パス: %s", configDirAbsPath)
+	xmlLog.infof("ダウンロード成功したアイテムの<path>要素をXMLに追加します...")
+	xmlLog.infof("成功プレイリストID数: %d", len(successfulPlaylistIDs))
+	xmlLog.infof("成功MOD ID数: %d", len(successfulModIDs))
+	xmlLog.infof("設定ディレクトリ絶対パス: %s", configDirAbsPath)
 
 
 	for {
@@ -180,14 +179,14 @@ func addWorkshopPathsToXML(xmlString string, successfulPlaylistIDs []string, suc
 			break
 		}
 		if tokenErr != nil {
-			log.Printf("[XML管理] エラー: XMLトークンの読み取りに失敗しました: %v", tokenErr)
+			xmlLog.errorf("XMLトークンの読み取りに失敗しました: %v", tokenErr)
 			return "", fmt.Errorf("XMLトークンの読み取りエラー: %w", tokenErr)
 		}
 
 		// 終了タグ </playlists> または </mods> の *直前* に新しい <path> 要素を挿入する
 		if et, ok := token.(xml.EndElement); ok {
 			if et.Name.Local == "playlists" && len(successfulPlaylistIDs) > 0 {
-				log.Printf("[XML管理] </playlists> を検出。成功したプレイリストパス %d 件を追加します。", len(successfulPlaylistIDs))
+				xmlLog.infof("</playlists> を検出。成功したプレイリストパス %d 件を追加します。", len(successfulPlaylistIDs))
 				// プレイリストの <path> 要素を追加
 				for _, id := range successfulPlaylistIDs {
 					// パス形式: /rom/data/workshop_missions/ID (スラッシュ区切り)
@@ -198,29 +197,25 @@ func addWorkshopPathsToXML(xmlString string, successfulPlaylistIDs []string, suc
 					}
 					// <path> 開始タグ
 					if err := encoder.EncodeToken(pathElement); err != nil {
-						log.Printf("[XML管理] エラー: プレイリスト<path>開始タグのエンコードに失敗 (ID: %s): %v", id, err)
+						xmlLog.errorf("プレイリスト<path>開始タグのエンコードに失敗 (ID: %s): %v", id, err)
 						return "", fmt.Errorf("プレイリスト<path>開始タグのエンコードエラー: %w", err)
 					}
 					// </path> 終了タグ (中身はないので即座に閉じる)
 					if err := encoder.EncodeToken(pathElement.End()); err != nil {
-						log.Printf("[XML管理] エラー: プレイリスト<path>終了タグのエンコードに失敗 (ID: %s): %v", id, err)
+						xmlLog.errorf("プレイリスト<path>終了タグのエンコードに失敗 (ID: %s): %v", id, err)
 						return "", fmt.Errorf("プレイリスト<path>終了タグのエンコードエラー: %w", err)
 					}
-					log.Printf("[XML管理]   プレイリストパス追加: %s", playlistPath)
+					xmlLog.infof("プレイリストパス追加: %s", playlistPath)
 				}
 			} else if et.Name.Local == "mods" && len(successfulModIDs) > 0 {
-				log.Printf("[XML管理] </mods> を検出。成功したMODパス %d 件を追加します。", len(successfulModIDs))
+				xmlLog.infof("</mods> を検出。成功したMODパス %d 件を追加します。", len(successfulModIDs))
 				// MODの <path> 要素を追加
 				for _, id := range successfulModIDs {
-					// パス形式: <設定ディレクトリ絶対パス>\rom\data\workshop_mods\ID (バックスラッシュ区切り)
-					// 注意: filepath.JoinはOS依存の区切り文字を使うため、最終的に置換が必要
+					// パス形式: <設定ディレクトリ絶対パス>/rom/data/workshop_mods/ID をホスト上で組み立て、
+					// 起動方式 (native / wine / custom) に応じてゲームが解釈できる形式に変換する
+					// (例: Windows では C:\...\workshop_mods\ID、Wine では Z:\home\...\workshop_mods\ID)。
 					modPathTemp := filepath.Join(configDirAbsPath, "rom", "data", "workshop_mods", id)
-					// Goのパス区切り文字('/')をWindowsのパス区切り文字('\')に置換
-					modPathFinal := strings.ReplaceAll(modPathTemp, "/", "\\")
-					// もしLinux環境などで実行していて、configDirAbsPath自体に'\'が含まれていない場合は
-					// filepath.ToSlashで一度'/'に正規化してから置換するなどの工夫が必要かもしれない。
-					// または、最初から文字列として結合する。
-					// modPathFinal := configDirAbsPath + "\\rom\\data\\workshop_mods\\" + id // 文字列結合例
+					modPathFinal := currentConfig().Launcher.GamePath(modPathTemp) // launcher.go
 
 					pathElement := xml.StartElement{
 						Name: xml.Name{Local: "path"},
@@ -228,38 +223,155 @@ func addWorkshopPathsToXML(xmlString string, successfulPlaylistIDs []string, suc
 					}
 					// <path> 開始タグ
 					if err := encoder.EncodeToken(pathElement); err != nil {
-						log.Printf("[XML管理] エラー: MOD<path>開始タグのエンコードに失敗 (ID: %s): %v", id, err)
+						xmlLog.errorf("MOD<path>開始タグのエンコードに失敗 (ID: %s): %v", id, err)
 						return "", fmt.Errorf("MOD<path>開始タグのエンコードエラー: %w", err)
 					}
 					// </path> 終了タグ
 					if err := encoder.EncodeToken(pathElement.End()); err != nil {
-						log.Printf("[XML管理] エラー: MOD<path>終了タグのエンコードに失敗 (ID: %s): %v", id, err)
+						xmlLog.errorf("MOD<path>終了タグのエンコードに失敗 (ID: %s): %v", id, err)
 						return "", fmt.Errorf("MOD<path>終了タグのエンコードエラー: %w", err)
 					}
-					log.Printf("[XML管理]   MODパス追加: %s", modPathFinal)
+					xmlLog.infof("MODパス追加: %s", modPathFinal)
 				}
 			}
 		}
 
 		// 現在のトークンをエンコード
 		if err := encoder.EncodeToken(token); err != nil {
-			log.Printf("[XML管理] エラー: XMLトークン (%T) のエンコードに失敗しました: %v", token, err)
+			xmlLog.errorf("XMLトークン (%T) のエンコードに失敗しました: %v", token, err)
 			return "", fmt.Errorf("XMLトークン (%T) のエンコードエラー: %w", token, err)
 		}
 	}
 
 	if err := encoder.Flush(); err != nil {
-		log.Printf("[XML管理] エラー: XMLエンコーダーのフラッシュに失敗しました: %v", err)
+		xmlLog.errorf("XMLエンコーダーのフラッシュに失敗しました: %v", err)
 		return "", fmt.Errorf("XMLエンコーダーのフラッシュエラー: %w", err)
 	}
 
 	finalXmlString = output.String()
-	log.Println("[XML管理] <path>要素の追加完了。")
-	// log.Printf("[XML管理] 最終XML(一部):\n%s", finalXmlString[:min(500, len(finalXmlString))]) // デバッグ用
+	xmlLog.infof("<path>要素の追加完了。")
+	// xmlLog.infof("最終XML(一部):\n%s", finalXmlString[:min(500, len(finalXmlString))]) // デバッグ用
 
 	return finalXmlString, nil
 }
 
+// --- 停止時: 配置済みのワークショップパスを Workshop ID に戻す ---
+
+// 配置済みワークショップアイテムのパス (区切り文字は '/' に正規化済み) から Workshop ID を取り出すための正規表現。
+// addWorkshopPathsToXML が生成する形式 (/rom/data/workshop_missions/ID, <設定ディレクトリ>\rom\data\workshop_mods\ID) に対応します。
+var (
+	workshopPlaylistPathRegex = regexp.MustCompile(`(?:^|/)rom/data/workshop_missions/(\d+)/?$`)
+	workshopModPathRegex      = regexp.MustCompile(`(?:^|/)rom/data/workshop_mods/(\d+)/?$`)
+)
+
+// restoreWorkshopIDsInXML は、addWorkshopPathsToXML の逆変換を行います。
+// <playlists> および <mods> 内の <path> 要素のうち、ワークショップアイテムの配置パスを指すものを
+// 元の Workshop ID に置き換え、ホストに依存しない設定XMLを生成します。
+// Workshop ID でもゲーム同梱の相対パス (rom/...) でもない、認識できないパスはそのまま残し、unknownPaths として返します。
+// Args:
+//   xmlString (string): 停止したサーバーの設定ファイル (server_config.xml) の内容。
+// Returns:
+//   restoredXmlString (string): ワークショップパスが Workshop ID に戻されたXML文字列。
+//   unknownPaths ([]string): 変換できなかったホスト依存の可能性があるパスのリスト。
+//   err (error): 処理中にエラーが発生した場合のエラーオブジェクト。
+func restoreWorkshopIDsInXML(xmlString string) (restoredXmlString string, unknownPaths []string, err error) {
+	decoder := xml.NewDecoder(strings.NewReader(xmlString))
+	var output bytes.Buffer
+	// Botに返却した設定は次回の起動時に再び送られてくるため、ここではインデントを追加しない
+	// (往復のたびに空白が増えるのを防ぐ)。
+	encoder := xml.NewEncoder(&output)
+
+	var inPlaylists, inMods bool // 現在 <playlists> または <mods> タグ内にいるかを示すフラグ
+	restoredCount := 0
+
+	xmlLog.infof("ワークショップパスを Workshop ID に戻します...")
+
+	for {
+		token, tokenErr := decoder.Token()
+		if tokenErr == io.EOF {
+			break
+		}
+		if tokenErr != nil {
+			xmlLog.errorf("XMLトークンの読み取りに失敗しました: %v", tokenErr)
+			return "", nil, fmt.Errorf("XMLトークンの読み取りエラー: %w", tokenErr)
+		}
+
+		switch se := token.(type) {
+		case xml.StartElement:
+			currentTagName := se.Name.Local
+			if currentTagName == "playlists" {
+				inPlaylists = true
+				inMods = false
+			} else if currentTagName == "mods" {
+				inPlaylists = false
+				inMods = true
+			} else if (inPlaylists || inMods) && currentTagName == "path" {
+				// 属性スライスは元のトークンと共有されているため、コピーしてから書き換える
+				attrs := make([]xml.Attr, len(se.Attr))
+				copy(attrs, se.Attr)
+				for i, attr := range attrs {
+					if attr.Name.Local != "path" {
+						continue
+					}
+					id, ok := workshopIDFromPath(attr.Value, inPlaylists)
+					if ok {
+						xmlLog.infof("ワークショップパスをIDに変換: %s -> %s", attr.Value, id)
+						attrs[i].Value = id
+						restoredCount++
+					} else if !workshopIDRegex.MatchString(attr.Value) && !isBuiltinGamePath(attr.Value) {
+						xmlLog.warnf("認識できないパスです (そのまま返却します): %s 内の path=\"%s\"", currentTagName, attr.Value)
+						unknownPaths = append(unknownPaths, attr.Value)
+					}
+				}
+				token = xml.StartElement{Name: se.Name, Attr: attrs}
+			}
+		case xml.EndElement:
+			if se.Name.Local == "playlists" {
+				inPlaylists = false
+			} else if se.Name.Local == "mods" {
+				inMods = false
+			}
+		}
+
+		if err := encoder.EncodeToken(token); err != nil {
+			xmlLog.errorf("XMLトークン (%T) のエンコードに失敗しました: %v", token, err)
+			return "", nil, fmt.Errorf("XMLトークン (%T) のエンコードエラー: %w", token, err)
+		}
+	}
+
+	if err := encoder.Flush(); err != nil {
+		xmlLog.errorf("XMLエンコーダーのフラッシュに失敗しました: %v", err)
+		return "", nil, fmt.Errorf("XMLエンコーダーのフラッシュエラー: %w", err)
+	}
+
+	restoredXmlString = output.String()
+	xmlLog.infof("ワークショップID復元完了。変換数: %d, 認識できないパス数: %d", restoredCount, len(unknownPaths))
+	return restoredXmlString, unknownPaths, nil
+}
+
+// workshopIDFromPath は、配置済みワークショップアイテムのパスから Workshop ID を取り出します。
+// Windows形式 (バックスラッシュ区切り) とスラッシュ区切りの両方を受け付けます。
+// isPlaylist が true の場合はプレイリスト、false の場合はMODの配置パスとして判定します。
+func workshopIDFromPath(path string, isPlaylist bool) (string, bool) {
+	normalized := strings.ReplaceAll(path, "\\", "/")
+	pattern := workshopModPathRegex
+	if isPlaylist {
+		pattern = workshopPlaylistPathRegex
+	}
+	matches := pattern.FindStringSubmatch(normalized)
+	if len(matches) < 2 {
+		return "", false
+	}
+	return matches[1], true
+}
+
+// isBuiltinGamePath は、パスがゲーム同梱データを指す相対パス (例: rom/data/missions/default_ai) かどうかを判定します。
+// このようなパスはホストに依存しないため、停止時にそのまま返却しても問題ありません。
+func isBuiltinGamePath(path string) bool {
+	normalized := strings.ReplaceAll(path, "\\", "/")
+	return strings.HasPrefix(normalized, "rom/")
+}
+
 // min 関数の定義 (Go 1.21 未満の場合) - デバッグログ用
 // func min(a, b int) int {
 // 	if a < b {